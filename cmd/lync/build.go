@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/loader"
+)
+
+// buildCommand compiles a program. The wasm target is a WASI command, and the bytecode target is
+// a package object that other programs can import.
+func buildCommand(args []string) error {
+	var c compileFlags
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	c.register(flags)
+	target := flags.String("target", "wasm", "what to build: wasm or bytecode")
+	out := flags.String("o", "", "write the output to this file")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one input file")
+	}
+	file := flags.Arg(0)
+
+	var res []byte
+	var ext string
+	switch *target {
+	case "wasm":
+		cmd, err := c.command(file)
+		if err != nil {
+			return err
+		}
+		res, ext = cmd, ".wasm"
+
+	case "bytecode":
+		pkgs, err := c.load(file)
+		if err != nil {
+			return err
+		}
+		obj, err := loader.EncodePackage(pkgs[len(pkgs)-1])
+		if err != nil {
+			return err
+		}
+		res, ext = obj, loader.ObjectExt
+
+	default:
+		return fmt.Errorf("unknown target %q", *target)
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(file, loader.SourceExt) + ext
+	}
+	return os.WriteFile(*out, res, 0666)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// checkCommand compiles programs without building anything, to report any problems with them.
+func checkCommand(args []string) error {
+	var c compileFlags
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	c.register(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("expected input files")
+	}
+
+	var errs []error
+	for _, file := range flags.Args() {
+		if _, err := c.load(file); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/compiler/asm"
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/loader"
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/runtime"
+)
+
+// compileFlags are shared by the commands that compile programs.
+type compileFlags struct {
+	path       string
+	trampoline bool
+}
+
+func (c *compileFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.path, "path", "", "a list of directories to search for imported packages")
+	flags.BoolVar(&c.trampoline, "trampoline", false, "make tail calls without the wasm tail call instructions")
+}
+
+func (c *compileFlags) options() asm.Options {
+	if c.trampoline {
+		return asm.Options{TailCalls: runtime.Trampoline}
+	}
+	return asm.Options{TailCalls: runtime.ReturnCall}
+}
+
+// load compiles a source file along with the packages that it imports, which are looked for next
+// to the file and then on the search path. The packages are given with the file's last.
+func (c *compileFlags) load(file string) ([]*lync.Package, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []fs.FS{os.DirFS(filepath.Dir(file))}
+	if c.path != "" {
+		for _, dir := range filepath.SplitList(c.path) {
+			roots = append(roots, os.DirFS(dir))
+		}
+	}
+	l := &loader.Loader{
+		Path:    roots,
+		Builtin: runtime.WASIPackages,
+		Options: c.options(),
+	}
+
+	main := packagePath(file)
+	pkgs, err := l.LoadSource(main, src)
+	if err != nil {
+		return nil, diagnose(err, main, file)
+	}
+	return pkgs, nil
+}
+
+// parse reads a source file into a syntax tree.
+func parse(file string) (ast.Program, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return ast.Program{}, err
+	}
+	return parseSource(file, src)
+}
+
+func parseSource(file string, src []byte) (ast.Program, error) {
+	p, err := parser.Parse(src)
+	if err != nil {
+		main := packagePath(file)
+		return ast.Program{}, diagnose(&loader.Error{Path: main, Source: src, Err: err}, main, file)
+	}
+	return p, nil
+}
+
+// packagePath gives the package path for a source file named on the command line.
+func packagePath(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), loader.SourceExt)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/check"
+	"github.com/bobappleyard/lync/compiler/loader"
+	"github.com/bobappleyard/lync/compiler/parser"
+)
+
+// diagnostic is a problem with a source file. Where the problem has a position, the line it is on
+// is shown with a caret under the position.
+type diagnostic struct {
+	file      string
+	line, col int
+	text      string
+	message   string
+}
+
+func (d *diagnostic) Error() string {
+	if d.line == 0 {
+		return fmt.Sprintf("%s: %s", d.file, d.message)
+	}
+
+	// keep tabs so that the caret lines up with the text above it
+	caret := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, d.text[:d.col-1])
+
+	return fmt.Sprintf("%s:%d:%d: %s\n\t%s\n\t%s^", d.file, d.line, d.col, d.message, d.text, caret)
+}
+
+// newDiagnostic describes a problem at an offset into a source file.
+func newDiagnostic(file string, src []byte, offset int, message string) *diagnostic {
+	offset = min(max(offset, 0), len(src))
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	end := bytes.IndexByte(src[offset:], '\n')
+	if end == -1 {
+		end = len(src)
+	} else {
+		end += offset
+	}
+	return &diagnostic{
+		file:    file,
+		line:    bytes.Count(src[:start], []byte("\n")) + 1,
+		col:     offset - start + 1,
+		text:    string(src[start:end]),
+		message: message,
+	}
+}
+
+// diagnose turns errors from compiling a package into diagnostics. The file names the source
+// given on the command line, which is compiled as the package main. Other errors are returned as
+// they are.
+func diagnose(err error, main, file string) error {
+	var lerr *loader.Error
+	if !errors.As(err, &lerr) {
+		return err
+	}
+	if lerr.Path != main {
+		file = lerr.Path + loader.SourceExt
+	}
+	var perr *parser.Error
+	if errors.As(lerr.Err, &perr) {
+		return newDiagnostic(file, lerr.Source, perr.Offset, perr.Message)
+	}
+	if diags := checkDiagnostics(lerr.Err, file, lerr.Source); diags != nil {
+		return diags
+	}
+	return &diagnostic{file: file, message: lerr.Err.Error()}
+}
+
+// checkDiagnostics gives a diagnostic for each of the problems found by checking a program, or nil
+// if the error did not come from checking it.
+func checkDiagnostics(err error, file string, src []byte) error {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	var diags []error
+	for _, err := range errs {
+		var cerr *check.Error
+		if !errors.As(err, &cerr) {
+			return nil
+		}
+		diags = append(diags, newDiagnostic(file, src, cerr.Offset, cerr.Err.Error()))
+	}
+	return errors.Join(diags...)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The number of unchanged lines shown around each change.
+const diffContext = 3
+
+type diffLine struct {
+	op   byte
+	text string
+
+	// where the line is in each version, counting from 0
+	a, b int
+}
+
+// unifiedDiff compares two versions of a file line by line, giving the differences in the format
+// of diff -u.
+func unifiedDiff(name string, a, b []byte) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", name, name)
+	for start := 0; start < len(lines); {
+		first := nextChange(lines, start)
+		if first == len(lines) {
+			break
+		}
+
+		// changes close enough together share a hunk
+		end := first
+		for {
+			next := nextChange(lines, end+1)
+			if next == len(lines) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		from := max(first-diffContext, 0)
+		to := min(end+diffContext+1, len(lines))
+		writeHunk(&out, lines[from:to])
+		start = to
+	}
+	return out.String()
+}
+
+func nextChange(lines []diffLine, from int) int {
+	for i := from; i < len(lines); i++ {
+		if lines[i].op != ' ' {
+			return i
+		}
+	}
+	return len(lines)
+}
+
+func writeHunk(out *strings.Builder, lines []diffLine) {
+	aLen, bLen := 0, 0
+	for _, l := range lines {
+		if l.op != '+' {
+			aLen++
+		}
+		if l.op != '-' {
+			bLen++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(lines[0].a, aLen), hunkRange(lines[0].b, bLen))
+	for _, l := range lines {
+		out.WriteByte(l.op)
+		out.WriteString(l.text)
+		if !strings.HasSuffix(l.text, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Ranges count lines from 1, except that an empty range gives the line before it.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// diffLines finds the longest common subsequence of the lines, and gives the lines that are not
+// in it as removed or added.
+func diffLines(a, b []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var res []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			res = append(res, diffLine{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			res = append(res, diffLine{'-', a[i], i, j})
+			i++
+		default:
+			res = append(res, diffLine{'+', b[j], i, j})
+			j++
+		}
+	}
+	return res
+}
+
+// splitLines splits text into lines, keeping the line endings.
+func splitLines(s []byte) []string {
+	var res []string
+	for len(s) != 0 {
+		n := bytes.IndexByte(s, '\n') + 1
+		if n == 0 {
+			n = len(s)
+		}
+		res = append(res, string(s[:n]))
+		s = s[n:]
+	}
+	return res
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		a, b string
+		out  string
+	}{
+		{
+			name: "Same",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			out:  "--- f\n+++ f\n",
+		},
+		{
+			name: "Change",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			out: `--- f
++++ f
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`,
+		},
+		{
+			name: "SeparateHunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:    "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			out: `--- f
++++ f
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -7,4 +8,3 @@
+ 7
+ 8
+ 9
+-10
+`,
+		},
+		{
+			name: "NoNewline",
+			a:    "a",
+			b:    "a\n",
+			out: `--- f
++++ f
+@@ -1,1 +1,1 @@
+-a
+\ No newline at end of file
++a
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, unifiedDiff("f", []byte(test.a), []byte(test.b)), test.out)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/loader"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// disasmCommand prints compiled code as text. It accepts package objects and wasm modules.
+func disasmCommand(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one input file")
+	}
+
+	src, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(src, []byte("\x00asm")) {
+		return disasmModule(os.Stdout, src)
+	}
+
+	p, err := loader.DecodePackage(src)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(";; package %s\n", p.Path)
+	if len(p.Imports) != 0 {
+		fmt.Printf(";; imports %s\n", strings.Join(p.Imports, " "))
+	}
+	if len(p.Exports) != 0 {
+		fmt.Printf(";; exports %s\n", strings.Join(p.Exports, " "))
+	}
+	fmt.Printf(";; registers %d\n", p.Unit.Registers)
+	for i, s := range p.Unit.Symbols {
+		fmt.Printf(";; symbol %d %s\n", i, s)
+	}
+	return disasmModule(os.Stdout, p.Unit.Code)
+}
+
+func disasmModule(w io.Writer, code []byte) error {
+	m, err := wasm.Decode(code)
+	if err != nil {
+		return err
+	}
+	return m.WriteWat(w)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bobappleyard/lync/compiler/printer"
+)
+
+// fmtCommand lays out source files in the canonical way. Without any files, it formats standard
+// input.
+func fmtCommand(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result back to the file rather than to standard output")
+	diff := flags.Bool("d", false, "print a diff of the changes rather than the result")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			return fmt.Errorf("cannot write the result back to standard input")
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return formatFile("<standard input>", src, false, *diff)
+	}
+
+	var errs []error
+	for _, file := range flags.Args() {
+		src, err := os.ReadFile(file)
+		if err == nil {
+			err = formatFile(file, src, *write, *diff)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func formatFile(file string, src []byte, write, diff bool) error {
+	p, err := parseSource(file, src)
+	if err != nil {
+		return err
+	}
+	res, err := printer.Format(p)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	switch {
+	case diff:
+		if !bytes.Equal(src, res) {
+			fmt.Print(unifiedDiff(file, src, res))
+		}
+		return nil
+	case write:
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		return os.WriteFile(file, res, 0666)
+	default:
+		_, err := os.Stdout.Write(res)
+		return err
+	}
+}
@@ -0,0 +1,66 @@
+// Command lync is the driver for the Lync toolchain.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"wat":    {"wat [-o out] file", watCommand},
+	"repl":   {"repl [-history file] [args...]", replCommand},
+	"run":    {"run [-path dirs] [-trampoline] file.lync [args...]", runCommand},
+	"build":  {"build [-path dirs] [-target wasm|bytecode] [-trampoline] [-o out] file.lync", buildCommand},
+	"check":  {"check [-path dirs] file.lync...", checkCommand},
+	"disasm": {"disasm file", disasmCommand},
+	"fmt":    {"fmt [-w] [-d] [file.lync...]", fmtCommand},
+	"ast":    {"ast file.lync", astCommand},
+	"lower":  {"lower [-until pass] [-trace] [-tree] file.lync", lowerCommand},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	err := cmd.run(os.Args[2:])
+
+	var status exitStatus
+	var diag *diagnostic
+	switch {
+	case err == nil:
+	case errors.As(err, &status):
+		os.Exit(int(status))
+	case errors.As(err, &diag):
+		// diagnostics already say where they come from
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "lync %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "\tlync %s\n", commands[name].usage)
+	}
+	os.Exit(2)
+}
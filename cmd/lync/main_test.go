@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func TestDiagnostics(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "Syntax",
+			files: map[string]string{
+				"main.lync": "var x = 1\n\tvar y = (x\n",
+			},
+			err: "main.lync:2:10: unexpected \"(\"\n\t\tvar y = (x\n\t\t        ^",
+		},
+		{
+			name: "EndOfInput",
+			files: map[string]string{
+				"main.lync": "func f() {",
+			},
+			err: "main.lync:1:11: unexpected end of input\n\tfunc f() {\n\t          ^",
+		},
+		{
+			name: "Imported",
+			files: map[string]string{
+				"main.lync": "import \"lib\"\nlib.f()\n",
+				"lib.lync":  "func f() {\n  return 1 +\n}\n",
+			},
+			err: "lib.lync:2:12: unrecognised input\n\t  return 1 +\n\t           ^",
+		},
+		{
+			name: "Check",
+			files: map[string]string{
+				"main.lync": "func f(a) {\n\treturn b\n}\nf()\n",
+			},
+			err: "main.lync:2:9: undefined: b\n\t\treturn b\n\t\t       ^\n" +
+				"main.lync:4:1: wrong number of arguments to f: have 0, want 1\n\tf()\n\t^",
+		},
+		{
+			name: "Types",
+			files: map[string]string{
+				"main.lync": "func f(a: Int) -> Int {\n\treturn a\n}\nf(\"a\")\n",
+			},
+			err: "main.lync:4:3: type mismatch: cannot use Str as Int\n\tf(\"a\")\n\t  ^",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, src := range test.files {
+				assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0666))
+			}
+			var c compileFlags
+			_, err := c.load(filepath.Join(dir, "main.lync"))
+			var d *diagnostic
+			assert.True(t, errors.As(err, &d))
+			assert.Equal(t, strings.ReplaceAll(err.Error(), dir+"/", ""), test.err)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.lync")
+	src := `import "sys"
+	sys.write("hello, ")
+	sys.write(sys.arg(1))
+	sys.exit(3)`
+	assert.Nil(t, os.WriteFile(file, []byte(src), 0666))
+
+	var c compileFlags
+	cmd, err := c.command(file)
+	assert.Nil(t, err)
+	m, err := wasm.Decode(cmd)
+	assert.Nil(t, err)
+
+	var stdout strings.Builder
+	h := &wasiHost{args: []string{file, "world"}, stdout: &stdout}
+	var status exitStatus
+	assert.True(t, errors.As(h.run(m), &status))
+	assert.Equal(t, status, 3)
+	assert.Equal(t, stdout.String(), "hello, world")
+}
+
+func TestRunImports(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"main.lync": `import "sys"
+		import "greet"
+		sys.write(greet.greeting)
+		greet.hello("world")`,
+		"greet.lync": `import "sys"
+		var greeting = "greetings\n"
+		func hello(who) {
+			sys.write("hello, ")
+			sys.write(who)
+			return sys.write("\n")
+		}`,
+	} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0666))
+	}
+
+	var c compileFlags
+	cmd, err := c.command(filepath.Join(dir, "main.lync"))
+	assert.Nil(t, err)
+	m, err := wasm.Decode(cmd)
+	assert.Nil(t, err)
+
+	var stdout strings.Builder
+	h := &wasiHost{stdout: &stdout}
+	assert.Nil(t, h.run(m))
+	assert.Equal(t, stdout.String(), "greetings\nhello, world\n")
+}
+
+func TestRunImportsSameName(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "b"), 0777))
+	for name, src := range map[string]string{
+		"main.lync": `import "sys"
+		import "a"
+		import "b/c"
+		var name = "main\n"
+		a.say()
+		c.say()
+		sys.write(a.name)
+		sys.write(c.name)
+		sys.write(name)`,
+		"a.lync": `import "sys"
+		var name = "a\n"
+		func say() { return sys.write(name) }`,
+		"b/c.lync": `import "sys"
+		var name = "c\n"
+		func say() { return sys.write(name) }`,
+	} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0666))
+	}
+
+	var c compileFlags
+	cmd, err := c.command(filepath.Join(dir, "main.lync"))
+	assert.Nil(t, err)
+	m, err := wasm.Decode(cmd)
+	assert.Nil(t, err)
+
+	var stdout strings.Builder
+	h := &wasiHost{stdout: &stdout}
+	assert.Nil(t, h.run(m))
+	assert.Equal(t, stdout.String(), "a\nc\na\nc\nmain\n")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/asm"
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/check"
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/compiler/transform"
+	"github.com/bobappleyard/lync/runtime"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// The most symbols that a session can use between all of its entries.
+const sessionSymbols = 4096
+
+// replCommand reads programs an entry at a time and runs them, printing the values of
+// expressions. Globals defined by one entry can be used by later ones. Entries from earlier
+// sessions are read from the history file, so that they can be recalled.
+func replCommand(args []string) error {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	history := flags.String("history", defaultHistory(), "keep a history of entries in this file, and recall them from it")
+	flags.Parse(args)
+
+	s, err := newSession(&wasiHost{
+		args:   append([]string{"lync"}, flags.Args()...),
+		env:    os.Environ(),
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+
+	var hist io.Writer = io.Discard
+	if *history != "" {
+		f, err := os.OpenFile(*history, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if s.history, err = readEntries(f); err != nil {
+			return err
+		}
+		hist = f
+	}
+
+	return s.repl(os.Stdin, os.Stdout, os.Stderr, hist)
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".lync_history")
+}
+
+// session is a runtime that entries are run against one after another.
+type session struct {
+	host    *wasiHost
+	runtime map[string]wasm.Extern
+	sp      *wasm.GlobalInstance
+	symbols []string
+	entries int
+
+	// globals are those defined by earlier entries
+	globals []string
+
+	// history holds the entries that can be recalled, oldest first
+	history []string
+}
+
+func newSession(host *wasiHost) (*session, error) {
+	m, symbols, err := runtime.WASISession(sessionSymbols)
+	if err != nil {
+		return nil, err
+	}
+
+	// the runtime's own unit is never run
+	main := wasm.HostFunction(
+		wasm.FuncType{In: []wasm.Type{wasm.Int32, wasm.Int32}, Out: []wasm.Type{wasm.Int64}},
+		func(args []uint64) ([]uint64, error) { return []uint64{0}, nil },
+	)
+	imports := wasm.Imports{
+		"wasi_snapshot_preview1": host.imports(),
+		"unit":                   {"main": main},
+	}
+	inst, err := wasm.Instantiate(m, imports)
+	if err != nil {
+		return nil, err
+	}
+	mem, _ := inst.Export("memory")
+	sp, _ := inst.Export("sp")
+	host.mem = mem.(*wasm.MemoryInstance)
+
+	// every entry uses the globals of the session's package
+	globals, err := inst.Func("globals")
+	if err != nil {
+		return nil, err
+	}
+	at, err := globals.Call()
+	if err != nil {
+		return nil, err
+	}
+	exports := maps.Clone(inst.Exports())
+	exports["globals"] = wasm.HostFunction(
+		wasm.FuncType{Out: []wasm.Type{wasm.Int32}},
+		func(args []uint64) ([]uint64, error) { return at, nil },
+	)
+
+	return &session{
+		host:    host,
+		runtime: exports,
+		sp:      sp.(*wasm.GlobalInstance),
+		symbols: symbols,
+	}, nil
+}
+
+// repl reads entries until the input runs out. An entry continues over as many lines as it takes
+// to close its brackets. Entries are written to the history as they are read.
+//
+// An entry of !! runs the last entry in the history again, and one of !text runs the last entry
+// that starts with text. The entry that is recalled is shown before it runs.
+func (s *session) repl(in io.Reader, out, errs, history io.Writer) error {
+	lines := bufio.NewScanner(in)
+	var entry bytes.Buffer
+	for {
+		if entry.Len() == 0 {
+			fmt.Fprint(out, "> ")
+		} else {
+			fmt.Fprint(out, "... ")
+		}
+		if !lines.Scan() {
+			fmt.Fprintln(out)
+			return lines.Err()
+		}
+		entry.Write(lines.Bytes())
+		entry.WriteByte('\n')
+		if parser.Incomplete(entry.Bytes()) {
+			continue
+		}
+
+		src := entry.Bytes()
+		entry.Reset()
+		if len(bytes.TrimSpace(src)) == 0 {
+			continue
+		}
+		if prefix, ok := bytes.CutPrefix(bytes.TrimSpace(src), []byte("!")); ok {
+			recalled, ok := s.recall(string(prefix))
+			if !ok {
+				fmt.Fprintf(errs, "no entry in the history matches %s\n", bytes.TrimSpace(src))
+				continue
+			}
+			fmt.Fprint(out, recalled)
+			src = []byte(recalled)
+		}
+		history.Write(src)
+		s.history = append(s.history, string(src))
+
+		res, err := s.eval(src)
+		var status exitStatus
+		switch {
+		case err == nil:
+			if res != "" {
+				fmt.Fprintln(out, res)
+			}
+		case errors.As(err, &status):
+			// the runtime has already reported whatever went wrong
+			if status == 0 {
+				return nil
+			}
+		default:
+			fmt.Fprintln(errs, err)
+		}
+	}
+}
+
+// recall finds the last entry in the history that starts with a prefix. The prefix ! stands for
+// any entry.
+func (s *session) recall(prefix string) (string, bool) {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if prefix == "!" || strings.HasPrefix(s.history[i], prefix) {
+			return s.history[i], true
+		}
+	}
+	return "", false
+}
+
+// readEntries splits what has been written to a history into entries, in the same way as repl.
+func readEntries(in io.Reader) ([]string, error) {
+	lines := bufio.NewScanner(in)
+	var entry bytes.Buffer
+	var res []string
+	for lines.Scan() {
+		entry.Write(lines.Bytes())
+		entry.WriteByte('\n')
+		if parser.Incomplete(entry.Bytes()) {
+			continue
+		}
+		if len(bytes.TrimSpace(entry.Bytes())) != 0 {
+			res = append(res, entry.String())
+		}
+		entry.Reset()
+	}
+	return res, lines.Err()
+}
+
+// eval runs an entry. If the entry ends with an expression, its value is described.
+func (s *session) eval(src []byte) (string, error) {
+	s.entries++
+	name := fmt.Sprintf("entry%d", s.entries)
+
+	p, err := parser.Parse(src)
+	if err != nil {
+		var perr *parser.Error
+		if errors.As(err, &perr) {
+			return "", newDiagnostic(name, src, perr.Offset, perr.Message)
+		}
+		return "", err
+	}
+	if err := (check.Config{Globals: s.globals}).Program(p); err != nil {
+		if diags := checkDiagnostics(err, name, src); diags != nil {
+			return "", diags
+		}
+		return "", err
+	}
+	unit, err := asm.AssembleProgram(name, transform.Program(ast.Erase(p)), asm.Options{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, sym := range unit.Symbols {
+		if !slices.Contains(s.symbols, sym) {
+			s.symbols = append(s.symbols, sym)
+		}
+	}
+	if len(s.symbols) > sessionSymbols {
+		return "", fmt.Errorf("the session has used more than %d symbols", sessionSymbols)
+	}
+	unit, err = asm.RemapSymbols(unit, s.symbols)
+	if err != nil {
+		return "", err
+	}
+
+	m, err := wasm.Decode(unit.Code)
+	if err != nil {
+		return "", err
+	}
+	inst, err := wasm.Instantiate(m, wasm.Imports{"runtime": s.runtime})
+	if err != nil {
+		return "", err
+	}
+	main, err := inst.Func("main")
+	if err != nil {
+		return "", err
+	}
+
+	// a failed entry can leave arguments on the stack
+	sp := s.sp.Value
+	defer func() { s.sp.Value = sp }()
+
+	res, err := main.Call(sp, 0)
+	if err != nil {
+		return "", err
+	}
+
+	// an entry that failed might not have defined its globals
+	for _, g := range check.Declared(p) {
+		if !slices.Contains(s.globals, g) {
+			s.globals = append(s.globals, g)
+		}
+	}
+	if !endsWithExpr(p) {
+		return "", nil
+	}
+	return runtime.FormatWASIValue(res[0], s.host.mem.Bytes, s.symbols), nil
+}
+
+// endsWithExpr reports whether a program ends with an expression, rather than a declaration or
+// some other kind of statement.
+func endsWithExpr(p ast.Program) bool {
+	if len(p.Stmts) == 0 {
+		return false
+	}
+	switch s := p.Stmts[len(p.Stmts)-1].(type) {
+	case ast.Function:
+		return s.Name == ""
+	case ast.Class:
+		return s.Name == ""
+	case ast.Expr:
+		return true
+	}
+	return false
+}
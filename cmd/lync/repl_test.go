@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestREPL(t *testing.T) {
+	var stdout, stderr, out, errs, history strings.Builder
+	s, err := newSession(&wasiHost{stdout: &stdout, stderr: &stderr})
+	assert.Nil(t, err)
+
+	in := strings.NewReader(`import "sys"
+var greeting = "hello"
+greeting
+func greet(who) {
+	sys.write(greeting)
+	return who
+}
+greet(42)
+sys.frobnicate()
+greet(nobody)
+greet(
+	"again"
+)
+var y = (
+`)
+	assert.Nil(t, s.repl(in, &out, &errs, &history))
+
+	assert.Equal(t, out.String(), `> > > "hello"
+> ... ... ... > 42
+> > > ... ... "again"
+> ... 
+`)
+	assert.Equal(t, stdout.String(), "hellohello")
+	assert.Equal(t, stderr.String(), "lync: message not understood\n")
+	assert.Equal(t, errs.String(), "entry7:1:7: undefined: nobody\n\tgreet(nobody)\n\t      ^\n")
+	assert.Equal(t, history.String(), `import "sys"
+var greeting = "hello"
+greeting
+func greet(who) {
+	sys.write(greeting)
+	return who
+}
+greet(42)
+sys.frobnicate()
+greet(nobody)
+greet(
+	"again"
+)
+`)
+}
+
+func TestREPLHistory(t *testing.T) {
+	var stdout, stderr, out, errs, history strings.Builder
+	s, err := newSession(&wasiHost{stdout: &stdout, stderr: &stderr})
+	assert.Nil(t, err)
+
+	s.history, err = readEntries(strings.NewReader(`import "sys"
+func greet(
+	who
+) {
+	return who
+}
+"earlier"
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(s.history), 3)
+
+	in := strings.NewReader(`!import
+!func
+!"
+greet(1)
+!!
+!nothing
+`)
+	assert.Nil(t, s.repl(in, &out, &errs, &history))
+
+	assert.Equal(t, out.String(), `> import "sys"
+> func greet(
+	who
+) {
+	return who
+}
+> "earlier"
+"earlier"
+> 1
+> greet(1)
+1
+> > 
+`)
+	assert.Equal(t, errs.String(), "no entry in the history matches !nothing\n")
+	assert.Equal(t, history.String(), `import "sys"
+func greet(
+	who
+) {
+	return who
+}
+"earlier"
+greet(1)
+greet(1)
+`)
+}
+
+func TestREPLFailedEntry(t *testing.T) {
+	var stdout, stderr, out, errs, history strings.Builder
+	s, err := newSession(&wasiHost{stdout: &stdout, stderr: &stderr})
+	assert.Nil(t, err)
+
+	in := strings.NewReader(`import "sys"
+var x = sys.frobnicate()
+x
+`)
+	assert.Nil(t, s.repl(in, &out, &errs, &history))
+
+	assert.Equal(t, stderr.String(), "lync: message not understood\n")
+	assert.Equal(t, errs.String(), "entry3:1:1: undefined: x\n\tx\n\t^\n")
+}
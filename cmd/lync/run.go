@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/compiler/asm"
+	"github.com/bobappleyard/lync/compiler/loader"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// exitStatus is the status that a program exited with.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// runCommand compiles a program as a WASI command and runs it in the interpreter.
+func runCommand(args []string) error {
+	var c compileFlags
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	c.register(flags)
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		return fmt.Errorf("expected an input file")
+	}
+
+	cmd, err := c.command(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	m, err := wasm.Decode(cmd)
+	if err != nil {
+		return err
+	}
+
+	host := &wasiHost{
+		args:   flags.Args(),
+		env:    os.Environ(),
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	return host.run(m)
+}
+
+// command compiles a source file into a WASI command, linked together with the packages that it
+// imports.
+func (c *compileFlags) command(file string) ([]byte, error) {
+	pkgs, err := c.load(file)
+	if err != nil {
+		return nil, err
+	}
+	units, err := loader.Link(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	linked := make([]*lync.Package, len(pkgs))
+	for i, p := range pkgs {
+		linked[i] = &lync.Package{Path: p.Path, Imports: p.Imports, Exports: p.Exports, Unit: units[i]}
+	}
+	return asm.Command(linked, c.options())
+}
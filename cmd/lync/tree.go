@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/printer"
+	"github.com/bobappleyard/lync/compiler/transform"
+)
+
+// astCommand prints the syntax tree of a source file.
+func astCommand(args []string) error {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one input file")
+	}
+
+	p, err := parse(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return writeTree(os.Stdout, p)
+}
+
+// lowerCommand shows how a source file is lowered by the transforms that prepare it for assembly.
+// It can stop after any of the passes, and can show the program after each of them.
+func lowerCommand(args []string) error {
+	flags := flag.NewFlagSet("lower", flag.ExitOnError)
+	until := flags.String("until", "", "stop after this pass")
+	trace := flags.Bool("trace", false, "show the program after each pass")
+	tree := flags.Bool("tree", false, "show the syntax tree rather than Lync-like source")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one input file")
+	}
+
+	p, err := parse(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	show := func(p ast.Program) error {
+		if *tree {
+			return writeTree(os.Stdout, p)
+		}
+		return printer.Config{Lowered: true}.Fprint(os.Stdout, p)
+	}
+
+	opts := transform.Options{Until: *until}
+	if *trace {
+		opts.Trace = func(pass string, p ast.Program) {
+			fmt.Printf("// after %s\n", pass)
+			if err == nil {
+				err = show(p)
+			}
+		}
+	}
+	p, lowerErr := transform.Lower(ast.Erase(p), opts)
+	if lowerErr != nil || *trace {
+		return errors.Join(lowerErr, err)
+	}
+	return show(p)
+}
+
+// writeTree prints a syntax tree in the manner of a Go composite literal. Positions and fields
+// with zero values are left out.
+func writeTree(w io.Writer, tree any) error {
+	var b strings.Builder
+	writeValue(&b, reflect.ValueOf(tree), 0)
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeValue(b *strings.Builder, v reflect.Value, depth int) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		writeValue(b, v.Elem(), depth)
+
+	case reflect.Struct:
+		b.WriteString(v.Type().String())
+		b.WriteByte('{')
+		empty := true
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() || v.Field(i).IsZero() {
+				continue
+			}
+			writeIndent(b, depth+1)
+			b.WriteString(f.Name)
+			b.WriteString(": ")
+			writeValue(b, v.Field(i), depth+1)
+			b.WriteByte(',')
+			empty = false
+		}
+		if !empty {
+			writeIndent(b, depth)
+		}
+		b.WriteByte('}')
+
+	case reflect.Slice:
+		b.WriteString(v.Type().String())
+		b.WriteByte('{')
+		for i := 0; i < v.Len(); i++ {
+			writeIndent(b, depth+1)
+			writeValue(b, v.Index(i), depth+1)
+			b.WriteByte(',')
+		}
+		if v.Len() != 0 {
+			writeIndent(b, depth)
+		}
+		b.WriteByte('}')
+
+	case reflect.String:
+		b.WriteString(strconv.Quote(v.String()))
+
+	default:
+		fmt.Fprint(b, v.Interface())
+	}
+}
+
+func writeIndent(b *strings.Builder, depth int) {
+	b.WriteByte('\n')
+	b.WriteString(strings.Repeat("\t", depth))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// wasiHost provides the parts of WASI that the runtime imports, so that commands can be run in
+// the interpreter.
+type wasiHost struct {
+	args   []string
+	env    []string
+	stdout io.Writer
+	stderr io.Writer
+	mem    *wasm.MemoryInstance
+}
+
+// WASI error numbers.
+const (
+	errnoSuccess = 0
+	errnoBadf    = 8
+	errnoIO      = 29
+)
+
+// run starts a command. A program that exits with a nonzero status gives an exitStatus.
+func (h *wasiHost) run(m *wasm.Module) error {
+	inst, err := wasm.Instantiate(m, wasm.Imports{"wasi_snapshot_preview1": h.imports()})
+	if err != nil {
+		return err
+	}
+	mem, ok := inst.Export("memory")
+	if !ok {
+		return errors.New("command does not export its memory")
+	}
+	h.mem = mem.(*wasm.MemoryInstance)
+
+	start, err := inst.Func("_start")
+	if err != nil {
+		return err
+	}
+	_, err = start.Call()
+
+	var status exitStatus
+	if errors.As(err, &status) && status == 0 {
+		return nil
+	}
+	return err
+}
+
+func (h *wasiHost) imports() map[string]wasm.Extern {
+	i32 := wasm.Int32
+	return map[string]wasm.Extern{
+		"fd_write": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32, i32, i32}, Out: []wasm.Type{i32}},
+			h.fdWrite,
+		),
+		"args_sizes_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return h.sizes(h.args, args) },
+		),
+		"args_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return h.strings(h.args, args) },
+		),
+		"environ_sizes_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return h.sizes(h.env, args) },
+		),
+		"environ_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return h.strings(h.env, args) },
+		),
+		"proc_exit": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return nil, exitStatus(uint32(args[0])) },
+		),
+		"clock_time_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, wasm.Int64, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) {
+				now := uint64(time.Now().UnixNano())
+				binary.LittleEndian.PutUint64(h.mem.Bytes[uint32(args[2]):], now)
+				return []uint64{errnoSuccess}, nil
+			},
+		),
+	}
+}
+
+func (h *wasiHost) fdWrite(args []uint64) ([]uint64, error) {
+	fd, iovs, n, written := uint32(args[0]), uint32(args[1]), uint32(args[2]), uint32(args[3])
+	var out io.Writer
+	switch fd {
+	case 1:
+		out = h.stdout
+	case 2:
+		out = h.stderr
+	default:
+		return []uint64{errnoBadf}, nil
+	}
+
+	total := uint32(0)
+	for i := uint32(0); i < n; i++ {
+		addr := binary.LittleEndian.Uint32(h.mem.Bytes[iovs+8*i:])
+		size := binary.LittleEndian.Uint32(h.mem.Bytes[iovs+8*i+4:])
+		k, err := out.Write(h.mem.Bytes[addr : addr+size])
+		total += uint32(k)
+		if err != nil {
+			binary.LittleEndian.PutUint32(h.mem.Bytes[written:], total)
+			return []uint64{errnoIO}, nil
+		}
+	}
+	binary.LittleEndian.PutUint32(h.mem.Bytes[written:], total)
+	return []uint64{errnoSuccess}, nil
+}
+
+// sizes gives the number of strings in a list and the space that they take up.
+func (h *wasiHost) sizes(xs []string, args []uint64) ([]uint64, error) {
+	size := 0
+	for _, x := range xs {
+		size += len(x) + 1
+	}
+	binary.LittleEndian.PutUint32(h.mem.Bytes[uint32(args[0]):], uint32(len(xs)))
+	binary.LittleEndian.PutUint32(h.mem.Bytes[uint32(args[1]):], uint32(size))
+	return []uint64{errnoSuccess}, nil
+}
+
+// strings copies a list of strings into memory as C strings.
+func (h *wasiHost) strings(xs []string, args []uint64) ([]uint64, error) {
+	ptrs, buf := uint32(args[0]), uint32(args[1])
+	for i, x := range xs {
+		binary.LittleEndian.PutUint32(h.mem.Bytes[ptrs+4*uint32(i):], buf)
+		buf += uint32(copy(h.mem.Bytes[buf:], x+"\x00"))
+	}
+	return []uint64{errnoSuccess}, nil
+}
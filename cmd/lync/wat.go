@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// watCommand converts between the binary and text formats for WebAssembly. Binary modules are
+// printed as text and text modules are assembled into binary.
+func watCommand(args []string) error {
+	flags := flag.NewFlagSet("wat", flag.ExitOnError)
+	out := flags.String("o", "", "write the output to this file instead of standard output")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one input file")
+	}
+
+	src, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if bytes.HasPrefix(src, []byte("\x00asm")) {
+		m, err := wasm.Decode(src)
+		if err != nil {
+			return err
+		}
+		return m.WriteWat(w)
+	}
+
+	m, err := wasm.ParseWat(src)
+	if err != nil {
+		return err
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	_, err = w.Write(m.AppendWasm(nil))
+	return err
+}
@@ -3,9 +3,11 @@ package asm
 import (
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/bobappleyard/lync"
 	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/runtime"
 	"github.com/bobappleyard/lync/util/data"
 )
 
@@ -13,12 +15,23 @@ var (
 	ErrUnsupported = errors.New("unsupported")
 )
 
-func AssembleProgram(p ast.Program) (lync.Unit, error) {
+// Options control how a program is assembled.
+type Options struct {
+	// TailCalls is how calls in tail position are made. The runtime that the unit is linked with
+	// must make them in the same way.
+	TailCalls runtime.TailCalls
+}
+
+// AssembleProgram compiles a program that has been through transform.Program. The name identifies
+// the unit in debugging information.
+func AssembleProgram(name string, p ast.Program, opts Options) (lync.Unit, error) {
 	a := assembler{
-		enc: &wasmEncoder{},
+		enc: new(wasmEncoder).init(name, opts),
 	}
 
-	a.assembleBlock(block{stmts: p.Stmts, enc: a.enc.Block()})
+	entry := block{stmts: p.Stmts, vars: bindings(p.Stmts), regc: requiredRegisters(p.Stmts)}
+	entry.enc = a.enc.Block("<main>", entry.frame(), 0)
+	a.assembleBlock(entry)
 
 	for a.pending.Ready() {
 		b := a.pending.Dequeue()
@@ -29,7 +42,9 @@ func AssembleProgram(p ast.Program) (lync.Unit, error) {
 }
 
 type moduleEncoder interface {
-	Block() blockEncoder
+	// Block starts a new block. The frame names the block's registers, with the arguments last.
+	Block(name string, frame []string, argc int) blockEncoder
+	Validate() error
 	Bytes() []byte
 }
 
@@ -46,9 +61,16 @@ type blockEncoder interface {
 	Load(from lync.Register)
 	Store(into lync.Register)
 
-	Call(method lync.Symbol, argc byte)
-	CallTail(method lync.Symbol, argc byte)
+	// Calls take their arguments from consecutive registers, starting at args.
+	Call(method lync.Symbol, args lync.Register, argc byte)
+	CallTail(method lync.Symbol, args lync.Register, argc byte)
 	Return()
+
+	// If runs what follows it, up to Else, when the accumulator holds a true value, and what
+	// follows Else, up to End, when it does not.
+	If()
+	Else()
+	End()
 }
 
 type assembler struct {
@@ -64,12 +86,23 @@ type block struct {
 	args  []string
 	regc  int
 	stmts []ast.Stmt
+
+	// the variables in scope, as positions in vars
+	scope []int
+
+	// the first register that is free for holding arguments
+	base int
 }
 
 func (a *assembler) result(regs byte) (lync.Unit, error) {
 	if a.err != nil {
 		return lync.Unit{}, a.err
 	}
+	if debug {
+		if err := a.enc.Validate(); err != nil {
+			return lync.Unit{}, err
+		}
+	}
 	return lync.Unit{
 		Registers: regs,
 		Code:      a.enc.Bytes(),
@@ -78,14 +111,46 @@ func (a *assembler) result(regs byte) (lync.Unit, error) {
 }
 
 func (a *assembler) assembleBlock(b block) {
-	for _, stmt := range b.stmts {
-		a.assembleStmt(b, stmt)
+	a.assembleStmts(b, b.stmts, 0)
+}
+
+// assembleStmts assembles a list of statements, the first variable of which is at the given
+// position in the block's vars. The variables that the branches of an if declare follow those
+// declared before the if, then branch first.
+func (a *assembler) assembleStmts(b block, stmts []ast.Stmt, first int) {
+	b.scope = append(slices.Clip(b.scope), declared(stmts, first)...)
+	next := first
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case ast.Variable:
+			a.assembleStmt(b, s)
+			next++
+
+		case ast.If:
+			a.assembleIf(b, s, next)
+			next += len(bindings(s.Then)) + len(bindings(s.Else))
+
+		default:
+			a.assembleStmt(b, s)
+		}
 		if _, ok := stmt.(ast.Return); ok {
 			return
 		}
 	}
 }
 
+func (a *assembler) assembleIf(b block, s ast.If, first int) {
+	a.assembleExpr(b, s.Cond)
+	if a.err != nil {
+		return
+	}
+	b.enc.If()
+	a.assembleStmts(b, s.Then, first)
+	b.enc.Else()
+	a.assembleStmts(b, s.Else, first+len(bindings(s.Then)))
+	b.enc.End()
+}
+
 func (a *assembler) assembleStmt(b block, s ast.Stmt) {
 	if a.err != nil {
 		return
@@ -110,6 +175,18 @@ func (a *assembler) assembleStmt(b block, s ast.Stmt) {
 		}
 		a.assembleDefineVariable(b, s.Name)
 
+	case ast.Assign:
+		// assignments to properties have become calls by now
+		if s.Object != nil {
+			a.err = fmt.Errorf("%T: %w", s, ErrUnsupported)
+			return
+		}
+		a.assembleExpr(b, s.Value)
+		if a.err != nil {
+			return
+		}
+		a.assembleDefineVariable(b, s.Name)
+
 	case ast.Expr:
 		a.assembleExpr(b, s)
 
@@ -160,31 +237,30 @@ func (a *assembler) assembleExpr(b block, e ast.Expr) {
 		a.assembleCall(b, e, blockEncoder.Call)
 
 	case ast.Function:
-		args := getArgs(e.Args)
-		vars := bindings(e.Body)
-		regc := requiredRegisters(e.Body)
-		enc := a.enc.Block()
-		a.pending.Enqueue(block{
-			enc:   enc,
-			args:  args,
-			vars:  vars,
-			regc:  regc,
+		inner := block{
+			args:  getArgs(e.Args),
+			vars:  bindings(e.Body),
+			regc:  requiredRegisters(e.Body),
 			stmts: e.Body,
-		})
-		b.enc.Block(byte(len(args)), byte(len(vars)+regc), enc.ID())
+		}
+		inner.enc = a.enc.Block(functionName(e), inner.frame(), len(inner.args))
+		a.pending.Enqueue(inner)
+		b.enc.Block(byte(len(inner.args)), byte(len(inner.vars)+inner.regc), inner.enc.ID())
 
 	default:
 		a.err = fmt.Errorf("%T: %w", e, ErrUnsupported)
 	}
 }
 
-func (a *assembler) assembleCall(b block, e ast.Call, write func(blockEncoder, lync.Symbol, byte)) {
+// assembleCall places the arguments in registers from the block's base. Anything evaluated while
+// they are held, including the receiver, uses the registers that follow.
+func (a *assembler) assembleCall(b block, e ast.Call, write func(blockEncoder, lync.Symbol, lync.Register, byte)) {
 	for i, x := range e.Args {
-		a.assembleExpr(b, x)
+		a.assembleExpr(b.from(b.base+i), x)
 		if a.err != nil {
 			return
 		}
-		b.enc.Store(lync.Register(i))
+		b.enc.Store(lync.Register(b.base + i))
 	}
 
 	m, ok := e.Method.(ast.MemberAccess)
@@ -193,19 +269,25 @@ func (a *assembler) assembleCall(b block, e ast.Call, write func(blockEncoder, l
 		return
 	}
 
-	a.assembleExpr(b, m.Object)
+	a.assembleExpr(b.from(b.base+len(e.Args)), m.Object)
 	if a.err != nil {
 		return
 	}
-	write(b.enc, a.methodID(m.Member), byte(len(e.Args)))
+	write(b.enc, a.methodID(m.Member), lync.Register(b.base), byte(len(e.Args)))
+}
+
+func (b block) from(base int) block {
+	b.base = base
+	return b
 }
 
 const frameWidth = 2
 
 func (b block) variableOffset(name string) int {
-	for i, v := range b.vars {
-		if name == v {
-			return i + b.regc
+	// inner variables shadow outer ones
+	for i := len(b.scope) - 1; i >= 0; i-- {
+		if name == b.vars[b.scope[i]] {
+			return b.scope[i] + b.regc
 		}
 	}
 	for i, v := range b.args {
@@ -216,6 +298,23 @@ func (b block) variableOffset(name string) int {
 	return -1
 }
 
+// frame names each of the block's registers, following the layout used by variableOffset.
+// Registers that hold temporaries or frame data are unnamed.
+func (b block) frame() []string {
+	res := make([]string, b.regc, b.regc+len(b.vars)+frameWidth+len(b.args))
+	res = append(res, b.vars...)
+	res = append(res, make([]string, frameWidth)...)
+	return append(res, b.args...)
+}
+
+// Anonymous functions are named after where they appear in the source.
+func functionName(f ast.Function) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return fmt.Sprintf("<anon@%d>", f.Start())
+}
+
 func (a *assembler) methodID(name string) lync.Symbol {
 	for i, m := range a.methods {
 		if name == m {
@@ -227,18 +326,41 @@ func (a *assembler) methodID(name string) lync.Symbol {
 	return lync.Symbol(ret)
 }
 
+// bindings names the variables that a list of statements declares, including those declared in
+// the branches of ifs, in the order that assembleStmts numbers them.
 func bindings(stmts []ast.Stmt) []string {
 	var names []string
 
 	for _, s := range stmts {
-		if s, ok := s.(ast.Variable); ok {
+		switch s := s.(type) {
+		case ast.Variable:
 			names = append(names, s.Name)
+		case ast.If:
+			names = append(names, bindings(s.Then)...)
+			names = append(names, bindings(s.Else)...)
 		}
 	}
 
 	return names
 }
 
+// declared gives the positions of the variables that a list of statements declares itself, given
+// the position of the first of them.
+func declared(stmts []ast.Stmt, first int) []int {
+	var res []int
+	next := first
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case ast.Variable:
+			res = append(res, next)
+			next++
+		case ast.If:
+			next += len(bindings(s.Then)) + len(bindings(s.Else))
+		}
+	}
+	return res
+}
+
 func getArgs(args []ast.Arg) []string {
 	res := make([]string, len(args))
 	for i, a := range args {
@@ -261,6 +383,9 @@ func requiredRegisters(stmts []ast.Stmt) int {
 		case ast.Variable:
 			regs = max(regs, requiredRegistersInExpr(s.Value))
 
+		case ast.Assign:
+			regs = max(regs, requiredRegistersInExpr(s.Value))
+
 		case ast.If:
 			regs = max(regs, requiredRegistersInExpr(s.Cond))
 			regs = max(regs, requiredRegisters(s.Then))
@@ -278,9 +403,9 @@ func requiredRegistersInExpr(e ast.Expr) int {
 
 	case ast.Call:
 		regs := len(e.Args)
-		regs = max(regs, requiredRegistersInExpr(e.Method))
-		for _, x := range e.Args {
-			regs = max(regs, requiredRegistersInExpr(x))
+		regs = max(regs, len(e.Args)+requiredRegistersInExpr(e.Method))
+		for i, x := range e.Args {
+			regs = max(regs, i+requiredRegistersInExpr(x))
 		}
 		return regs
 	}
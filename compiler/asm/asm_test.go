@@ -1 +1,40 @@
 package asm
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/compiler/transform"
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func TestNames(t *testing.T) {
+	p, err := parser.Parse([]byte(`func f(x) {
+		var y = x.double()
+		return y.plus(func(z) { return z })
+	}`))
+	assert.Nil(t, err)
+
+	unit, err := AssembleProgram("demo", transform.Program(p), Options{})
+	assert.Nil(t, err)
+
+	m, err := wasm.Decode(unit.Code)
+	assert.Nil(t, err)
+	assert.Nil(t, m.Validate())
+
+	names, err := m.Names()
+	assert.Nil(t, err)
+	assert.Equal(t, names.Module, "demo")
+
+	// functions are numbered after the runtime imports
+	main := wasm.Index(importTruth + 1)
+	assert.Equal(t, names.Funcs[main], "<main>")
+	assert.Equal(t, names.Funcs[main+1], "f")
+	assert.Equal(t, names.Funcs[main+2], "<anon@49>")
+	assert.Equal(t, names.Funcs[main+3], "<start>")
+
+	// registers follow the argument pointer and count, with arguments last
+	assert.Equal(t, names.Locals[main+1], map[uint32]string{3: "y", 6: "x"})
+	assert.Equal(t, names.Locals[main+2], map[uint32]string{4: "z"})
+}
@@ -0,0 +1,6 @@
+//go:build lyncdebug
+
+package asm
+
+// Debug builds check the assembler's output before returning it.
+const debug = true
@@ -0,0 +1,5 @@
+//go:build !lyncdebug
+
+package asm
+
+const debug = false
@@ -0,0 +1,62 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+var (
+	ErrUnknownSymbol = errors.New("unknown symbol")
+)
+
+// RemapSymbols renumbers the symbols that a unit refers to according to another symbol table,
+// which must contain all of the unit's symbols. This lets units that were assembled separately
+// share a table.
+func RemapSymbols(u lync.Unit, symbols []string) (lync.Unit, error) {
+	index := map[string]lync.Symbol{}
+	for i, s := range symbols {
+		index[s] = lync.Symbol(i)
+	}
+	ids := make([]lync.Symbol, len(u.Symbols))
+	for i, s := range u.Symbols {
+		id, ok := index[s]
+		if !ok {
+			return lync.Unit{}, fmt.Errorf("%s: %w", s, ErrUnknownSymbol)
+		}
+		ids[i] = id
+	}
+
+	m, err := wasm.Decode(u.Code)
+	if err != nil {
+		return lync.Unit{}, err
+	}
+	for _, c := range m.Codes {
+		instrs, err := c.Decode()
+		if err != nil {
+			return lync.Unit{}, err
+		}
+		c.Instructions = nil
+		for i, x := range instrs {
+			if x.Op == wasm.OpI64Const && i+1 < len(instrs) && takesSymbol(instrs[i+1]) {
+				sym := x.Args[0]
+				if sym >= uint64(len(ids)) {
+					return lync.Unit{}, fmt.Errorf("symbol %d: %w", sym, ErrUnknownSymbol)
+				}
+				x = wasm.Instruction{Op: x.Op, Args: []uint64{uint64(ids[sym])}}
+			}
+			c.Emit(x)
+		}
+	}
+
+	u.Code = m.AppendWasm(nil)
+	u.Symbols = symbols
+	return u, nil
+}
+
+// The encoder only ever passes symbols to the runtime as a constant immediately before the call.
+func takesSymbol(x wasm.Instruction) bool {
+	return x.Op == wasm.OpCall && (x.Args[0] == uint64(importLookup) || x.Args[0] == uint64(importName))
+}
@@ -0,0 +1,80 @@
+package asm
+
+import (
+	"fmt"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/runtime"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// AssembleCommand compiles a program that has been through transform.Program into a standalone
+// WASI command module, linked together with the runtime that it needs.
+func AssembleCommand(name string, p ast.Program, opts Options) ([]byte, error) {
+	unit, err := AssembleProgram(name, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	return Command([]*lync.Package{{Path: name, Unit: unit}}, opts)
+}
+
+// Command links packages that have already been assembled with the WASI runtime. Their units must
+// share a symbol table, as those given by loader.Link do, and the options must be the ones that
+// they were assembled with. The packages run in order, so each must come after those it imports.
+func Command(pkgs []*lync.Package, opts Options) ([]byte, error) {
+	mods := []wasm.LinkModule{{Name: "unit", Module: sequence(len(pkgs))}}
+	for i, p := range pkgs {
+		code, err := wasm.Decode(p.Unit.Code)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Path, err)
+		}
+		mods = append(mods, wasm.LinkModule{Name: unitName(i), Module: code})
+	}
+	rt, err := runtime.WASI(pkgs[len(pkgs)-1].Unit.Symbols, pkgs, opts.TailCalls)
+	if err != nil {
+		return nil, err
+	}
+
+	// the runtime comes first, so that it starts before the units and its exports are kept
+	m, err := wasm.Link(append([]wasm.LinkModule{{Name: "runtime", Module: rt}}, mods...)...)
+	if err != nil {
+		return nil, err
+	}
+	if debug {
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return m.AppendWasm(nil), nil
+}
+
+// sequence makes the unit that the runtime starts when a command is made of several units. It runs
+// each of them in turn, waiting for one to finish before starting the next, and gives the result
+// of the last.
+func sequence(n int) *wasm.Module {
+	m := &wasm.Module{}
+	main := m.EnsureType(wasm.FuncType{In: []wasm.Type{wasm.Int32, wasm.Int32}, Out: []wasm.Type{wasm.Int64}})
+	run := m.EnsureType(wasm.FuncType{In: []wasm.Type{wasm.Int64}, Out: []wasm.Type{wasm.Int64}})
+	m.Imports = append(m.Imports, wasm.FuncImport{Module: "runtime", Name: "run", Type: run})
+	for i := 0; i < n; i++ {
+		m.Imports = append(m.Imports, wasm.FuncImport{Module: unitName(i), Name: "main", Type: main})
+	}
+
+	c := m.AddExportedFunc("main", []wasm.Type{wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64})
+	for i := 0; i < n; i++ {
+		c.LocalGet(argvParam)
+		c.LocalGet(argcParam)
+		c.Call(uint32(i + 1))
+		if i < n-1 {
+			c.Call(0)
+			c.Drop()
+		}
+	}
+	c.End()
+	return m
+}
+
+func unitName(i int) string {
+	return fmt.Sprintf("unit%d", i)
+}
@@ -0,0 +1,515 @@
+package asm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/compiler/transform"
+	"github.com/bobappleyard/lync/runtime"
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func TestAssembleCommand(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		src    string
+		args   []string
+		env    []string
+		stdout string
+		stderr string
+		code   int
+
+		// if set, the host exits after this many writes
+		writes int
+
+		// if set, the test only runs with tail calls, as trampolines use up the host's stack first
+		tailCallsOnly bool
+	}{
+		{
+			name: "Write",
+			src: `import "sys"
+			sys.write("hello\n")`,
+			stdout: "hello\n",
+		},
+		{
+			name: "Functions",
+			src: `import "sys"
+			func greet(who) {
+				sys.write("hello, ")
+				sys.write(who)
+				return sys.write("\n")
+			}
+			greet("world")
+			sys.exit(3)
+			sys.write("unreachable")`,
+			stdout: "hello, world\n",
+			code:   3,
+		},
+		{
+			name: "Closures",
+			src: `import "sys"
+			func twice(f) {
+				return func(x) { return f(f(x)) }
+			}
+			func show(x) {
+				sys.write(x)
+				sys.write(" ")
+				return x
+			}
+			var g = twice(show)
+			g(42)`,
+			stdout: "42 42 ",
+		},
+		{
+			name: "CalledLocalClosure",
+			src: `import "sys"
+			func id(x) { return x }
+			func f() {
+				var x = "before"
+				var show = func() { return sys.write(x) }
+				x = id("after")
+				return show()
+			}
+			f()`,
+			stdout: "after",
+		},
+		{
+			name: "Args",
+			src: `import "sys"
+			sys.write(sys.arg_count())
+			sys.write(sys.arg(1))`,
+			args:   []string{"prog", "first"},
+			stdout: "2first",
+		},
+		{
+			name: "Env",
+			src: `import "sys"
+			sys.write(sys.env("B"))`,
+			env:    []string{"A=1", "BB=2", "B=3"},
+			stdout: "3",
+		},
+		{
+			name: "TailRecursion",
+			src: `import "sys"
+			func loop() {
+				sys.write(".")
+				return loop()
+			}
+			loop()`,
+			writes: 20000,
+			stdout: strings.Repeat(".", 20000),
+		},
+		{
+			name: "Classes",
+			src: `import "sys"
+			class Counter {
+				init(n) {
+					this.n = n
+				}
+				show() {
+					sys.write(this.n)
+					return sys.write("\n")
+				}
+				set(n) {
+					this.n = n
+					return this
+				}
+			}
+			var c = Counter(1)
+			c.show()
+			c.set(5).show()`,
+			stdout: "1\n5\n",
+		},
+		{
+			name: "Fields",
+			src: `import "sys"
+			var y = "outer "
+			class A {
+				var f = y
+				init(y) {
+					this.g = y
+				}
+				show() {
+					sys.write(this.f)
+					return sys.write(this.g)
+				}
+			}
+			A("inner").show()`,
+			stdout: "outer inner",
+		},
+		{
+			name: "Inheritance",
+			src: `import "sys"
+			class Animal {
+				var legs = "4"
+				init(name) {
+					this.name = name
+				}
+				describe() {
+					sys.write(this.name)
+					return sys.write(" ")
+				}
+				speak() {
+					return sys.write("...")
+				}
+			}
+			class Dog extends Animal {
+				var sound = "woof"
+				speak() {
+					super.speak()
+					sys.write(this.legs)
+					sys.write(" ")
+					return sys.write(this.sound)
+				}
+			}
+			var d = Dog("rex")
+			d.describe()
+			d.speak()`,
+			stdout: "rex ...4 woof",
+		},
+		{
+			name: "Properties",
+			src: `import "sys"
+			class Point {}
+			var p = Point()
+			p.x = 1
+			p.y = 2
+			p.x = 3
+			sys.write(p.x)
+			sys.write(p.y)
+			sys.write(p.z)`,
+			stdout: "32",
+			stderr: "lync: message not understood\n",
+			code:   1,
+		},
+		{
+			name: "MethodsDefinedLater",
+			src: `import "sys"
+			class A {
+				a() {
+					return sys.write("a")
+				}
+			}
+			class B extends A {
+				b() {
+					return sys.write("b")
+				}
+			}
+			var x = B()
+			A.c = func(this) {
+				return sys.write("c")
+			}
+			x.a()
+			x.b()
+			x.c()
+			A().c()
+			x.d()`,
+			stdout: "abcc",
+			stderr: "lync: message not understood\n",
+			code:   1,
+		},
+		{
+			name: "ManyClasses",
+			src: `import "sys"
+			func make(s) {
+				return class {
+					show() {
+						return sys.write(s)
+					}
+				}
+			}
+			func twice(f) {
+				f()
+				return f()
+			}
+			func one() {
+				return make(".")().show()
+			}
+			func four() {
+				return twice(func() { return twice(one) })
+			}
+			twice(func() { return twice(func() { return twice(four) }) })`,
+			stdout: strings.Repeat(".", 32),
+		},
+		{
+			name: "If",
+			src: `import "sys"
+			func choose(x) {
+				var y = "outer "
+				if x {
+					var y = "inner "
+					sys.write(y)
+				}
+				sys.write(y)
+				if x {
+					return "early\n"
+				}
+				return "late\n"
+			}
+			sys.write(choose(1))
+			sys.write(choose(0))`,
+			stdout: "inner outer early\nouter late\n",
+		},
+		{
+			name: "NotUnderstood",
+			src: `import "sys"
+			sys.frobnicate()`,
+			stdout: "",
+			stderr: "lync: message not understood\n",
+			code:   1,
+		},
+		{
+			name: "Globals",
+			src: `import "sys"
+			var x = "a"
+			sys.write(x)
+			x = "b"
+			sys.write(x)`,
+			stdout: "ab",
+		},
+		{
+			name: "UndefinedGlobal",
+			src: `import "sys"
+			sys.write("a")
+			x = 1
+			sys.write("b")`,
+			stdout: "a",
+			stderr: "lync: undefined variable\n",
+			code:   1,
+		},
+		{
+			name: "StackOverflow",
+			src: `import "sys"
+			func f(a, b, c, d, e, g, h) {
+				f(a, b, c, d, e, g, h)
+				return a
+			}
+			f(1, 2, 3, 4, 5, 6, 7)`,
+			stderr:        "lync: stack overflow\n",
+			code:          1,
+			tailCallsOnly: true,
+		},
+	} {
+		for _, mode := range []struct {
+			name string
+			opts Options
+		}{
+			{"ReturnCall", Options{TailCalls: runtime.ReturnCall}},
+			{"Trampoline", Options{TailCalls: runtime.Trampoline}},
+		} {
+			if test.tailCallsOnly && mode.opts.TailCalls != runtime.ReturnCall {
+				continue
+			}
+			t.Run(test.name+"/"+mode.name, func(t *testing.T) {
+				p, err := parser.Parse([]byte(test.src))
+				assert.Nil(t, err)
+				bin, err := AssembleCommand(test.name, transform.Program(p), mode.opts)
+				assert.Nil(t, err)
+
+				m, err := wasm.Decode(bin)
+				assert.Nil(t, err)
+				assert.Nil(t, m.Validate())
+
+				if mode.opts.TailCalls == runtime.Trampoline {
+					assertNoTailCalls(t, m)
+				}
+
+				w := &fakeWASI{args: test.args, env: test.env, writes: test.writes}
+				stdout, code := w.run(t, m)
+				assert.Equal(t, stdout, test.stdout)
+				assert.Equal(t, w.stderr.String(), test.stderr)
+				assert.Equal(t, code, test.code)
+			})
+		}
+	}
+}
+
+// TestFoldedIf checks that the optimizer folds ifs whose conditions are constants in the same way
+// as the runtime decides them.
+func TestFoldedIf(t *testing.T) {
+	for _, cond := range []string{
+		"1", "0", "4294967296", "0.5", "0.0", `"a"`, `""`, "func() {}",
+	} {
+		t.Run(cond, func(t *testing.T) {
+			folded := runIf(t, cond, true)
+			unfolded := runIf(t, cond, false)
+			assert.Equal(t, folded, unfolded)
+		})
+	}
+}
+
+// runIf runs an if on a condition, either as a constant that the optimizer can fold or by way of a
+// global variable, which it cannot. It gives what the program writes.
+func runIf(t *testing.T, cond string, fold bool) string {
+	t.Helper()
+	src := fmt.Sprintf(`import "sys"
+	var c = %s
+	if c { sys.write("true") }`, cond)
+	if fold {
+		src = fmt.Sprintf(`import "sys"
+		if %s { sys.write("true") }`, cond)
+	}
+
+	p, err := parser.Parse([]byte(src))
+	assert.Nil(t, err)
+	lowered := transform.Program(p)
+	ifs := 0
+	for _, s := range lowered.Stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			if _, ok := n.(ast.If); ok {
+				ifs++
+			}
+			return true
+		})
+	}
+	if fold {
+		assert.Equal(t, ifs, 0)
+	} else {
+		assert.Equal(t, ifs, 1)
+	}
+
+	bin, err := AssembleCommand("if", lowered, Options{})
+	assert.Nil(t, err)
+	m, err := wasm.Decode(bin)
+	assert.Nil(t, err)
+
+	w := &fakeWASI{}
+	stdout, code := w.run(t, m)
+	assert.Equal(t, code, 0)
+	return stdout
+}
+
+func assertNoTailCalls(t *testing.T, m *wasm.Module) {
+	t.Helper()
+	for _, c := range m.Codes {
+		instrs, err := c.Decode()
+		assert.Nil(t, err)
+		for _, x := range instrs {
+			if x.Op == wasm.OpReturnCall || x.Op == wasm.OpReturnCallIndirect {
+				t.Fatalf("function %d uses %s", c.Func, x.Op)
+			}
+		}
+	}
+}
+
+// fakeWASI stands in for a WASI host, providing just what the runtime imports.
+type fakeWASI struct {
+	args   []string
+	env    []string
+	writes int
+	mem    *wasm.MemoryInstance
+	stdout strings.Builder
+	stderr strings.Builder
+}
+
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit %d", int(e))
+}
+
+func (w *fakeWASI) run(t *testing.T, m *wasm.Module) (string, int) {
+	t.Helper()
+
+	inst, err := wasm.Instantiate(m, wasm.Imports{"wasi_snapshot_preview1": w.imports()})
+	assert.Nil(t, err)
+	mem, ok := inst.Export("memory")
+	assert.True(t, ok)
+	w.mem = mem.(*wasm.MemoryInstance)
+
+	start, err := inst.Func("_start")
+	assert.Nil(t, err)
+	_, err = start.Call()
+
+	var code exitCode
+	if err != nil && !errors.As(err, &code) {
+		t.Fatalf("%v: %s", err, w.stderr.String())
+	}
+	return w.stdout.String(), int(code)
+}
+
+func (w *fakeWASI) imports() map[string]wasm.Extern {
+	i32 := wasm.Int32
+	return map[string]wasm.Extern{
+		"fd_write": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32, i32, i32}, Out: []wasm.Type{i32}},
+			w.fdWrite,
+		),
+		"args_sizes_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return w.sizes(w.args, args) },
+		),
+		"args_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return w.strings(w.args, args) },
+		),
+		"environ_sizes_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return w.sizes(w.env, args) },
+		),
+		"environ_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return w.strings(w.env, args) },
+		),
+		"proc_exit": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) { return nil, exitCode(uint32(args[0])) },
+		),
+		"clock_time_get": wasm.HostFunction(
+			wasm.FuncType{In: []wasm.Type{i32, wasm.Int64, i32}, Out: []wasm.Type{i32}},
+			func(args []uint64) ([]uint64, error) {
+				binary.LittleEndian.PutUint64(w.mem.Bytes[uint32(args[2]):], 1_500_000_000)
+				return []uint64{0}, nil
+			},
+		),
+	}
+}
+
+func (w *fakeWASI) fdWrite(args []uint64) ([]uint64, error) {
+	fd, iovs, n, written := uint32(args[0]), uint32(args[1]), uint32(args[2]), uint32(args[3])
+	out := &w.stdout
+	if fd == 2 {
+		out = &w.stderr
+	}
+	total := uint32(0)
+	for i := uint32(0); i < n; i++ {
+		addr := binary.LittleEndian.Uint32(w.mem.Bytes[iovs+8*i:])
+		size := binary.LittleEndian.Uint32(w.mem.Bytes[iovs+8*i+4:])
+		out.Write(w.mem.Bytes[addr : addr+size])
+		total += size
+	}
+	binary.LittleEndian.PutUint32(w.mem.Bytes[written:], total)
+
+	if w.writes != 0 {
+		w.writes--
+		if w.writes == 0 {
+			return nil, exitCode(0)
+		}
+	}
+	return []uint64{0}, nil
+}
+
+func (w *fakeWASI) sizes(xs []string, args []uint64) ([]uint64, error) {
+	size := 0
+	for _, x := range xs {
+		size += len(x) + 1
+	}
+	binary.LittleEndian.PutUint32(w.mem.Bytes[uint32(args[0]):], uint32(len(xs)))
+	binary.LittleEndian.PutUint32(w.mem.Bytes[uint32(args[1]):], uint32(size))
+	return []uint64{0}, nil
+}
+
+func (w *fakeWASI) strings(xs []string, args []uint64) ([]uint64, error) {
+	ptrs, buf := uint32(args[0]), uint32(args[1])
+	for i, x := range xs {
+		binary.LittleEndian.PutUint32(w.mem.Bytes[ptrs+4*uint32(i):], buf)
+		buf += uint32(copy(w.mem.Bytes[buf:], x+"\x00"))
+	}
+	return []uint64{0}, nil
+}
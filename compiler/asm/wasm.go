@@ -1,31 +1,400 @@
 package asm
 
 import (
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/runtime"
 	"github.com/bobappleyard/lync/util/wasm"
 )
 
+// The wasm encoder turns each block into a function that returns the block's result. All values are
+// i64. Registers live in locals, alongside an accumulator that holds the value most recently
+// produced.
+//
+// Arguments are passed on a stack in memory, pointed to by the runtime's sp global, which grows
+// downwards. Every function takes the address of its arguments and how many there are, so that
+// the runtime can implement methods that take any number of arguments.
+//
+// The runtime creates values and finds methods. Methods are called through the runtime's function
+// table, with the receiver followed by the arguments. When a unit is instantiated its start
+// function appends the unit's blocks to that table, and asks the runtime where the global variables
+// of its package are. The unit's own unit value carries that address, so that wherever the unit's
+// code runs its global variables are its package's.
+//
+// Calls in tail position use return_call_indirect, unless the encoder is making trampolines. Then
+// tail calls are scheduled with the runtime and the block returns, and every other call runs what
+// has been scheduled before continuing.
 type wasmEncoder struct {
-	m wasm.Module
+	m          wasm.Module
+	trampoline bool
+	names      wasm.Names
+	blocks     []*wasmBlockEncoder
+	strings    map[string]uint32
+	base       uint32
+	globals    uint32
+	sp         uint32
+	done       bool
 }
 
 type wasmBlockEncoder struct {
-	id wasm.Index
+	e    *wasmEncoder
+	id   uint32
+	c    *wasm.Code
+	argc int
+	regs int
+	acc  uint32
 }
 
-func (e *wasmEncoder) init() *wasmEncoder {
+// Functions imported from the runtime, in order.
+const (
+	// lookup(object, selector i64) i64 gives the function table slot of a method
+	importLookup uint32 = iota
+	// unit(globals i32) i64 gives the unit of the package whose global variables are at the address
+	importUnit
+	// name(symbol i64) i64
+	importName
+	// int(value i64) i64
+	importInt
+	// float(value f64) i64
+	importFloat
+	// string(address, length i32) i64 wraps a string held in memory
+	importString
+	// block(slot, argc i32) i64
+	importBlock
+	// alloc(size i32) i32 reserves space in memory
+	importAlloc
+	// tail(argv, argc, slot i32) i64 schedules a call
+	importTail
+	// run(result i64) i64 makes scheduled calls, giving the result of the last one
+	importRun
+	// stack_overflow() reports that the argument stack is full and stops the program
+	importStackOverflow
+	// globals() i32 gives the address of the global variables of the next package to start
+	importGlobals
+	// truth(value i64) i32 gives whether a value counts as true
+	importTruth
+)
+
+// Globals imported from the runtime, in order.
+const (
+	// sp i32 is the top of the argument stack
+	importSP uint32 = iota
+	// stack_limit i32 is the lowest address that the argument stack can reach
+	importStackLimit
+)
+
+func (e *wasmEncoder) init(name string, opts Options) *wasmEncoder {
+	e.trampoline = opts.TailCalls == runtime.Trampoline
+
 	e.m.Types = []wasm.Type{
 		wasm.FuncType{In: []wasm.Type{wasm.Int64, wasm.Int64}, Out: []wasm.Type{wasm.Int64}},
 	}
 	e.m.Imports = []wasm.Import{
 		wasm.FuncImport{Module: "runtime", Name: "lookup", Type: 0},
+		e.runtimeFunc("unit", []wasm.Type{wasm.Int32}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("name", []wasm.Type{wasm.Int64}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("int", []wasm.Type{wasm.Int64}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("float", []wasm.Type{wasm.Float64}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("string", []wasm.Type{wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("block", []wasm.Type{wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("alloc", []wasm.Type{wasm.Int32}, []wasm.Type{wasm.Int32}),
+		e.runtimeFunc("tail", []wasm.Type{wasm.Int32, wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("run", []wasm.Type{wasm.Int64}, []wasm.Type{wasm.Int64}),
+		e.runtimeFunc("stack_overflow", nil, nil),
+		e.runtimeFunc("globals", nil, []wasm.Type{wasm.Int32}),
+		e.runtimeFunc("truth", []wasm.Type{wasm.Int64}, []wasm.Type{wasm.Int32}),
+		wasm.TableImport{Module: "runtime", Name: "table"},
+		wasm.MemoryImport{Module: "runtime", Name: "memory", Type: wasm.MinMemory{}},
+		wasm.GlobalImport{Module: "runtime", Name: "sp", Type: wasm.Int32, Mutable: true},
+		wasm.GlobalImport{Module: "runtime", Name: "stack_limit", Type: wasm.Int32},
+	}
+	e.sp = importSP
+
+	var zero wasm.Code
+	zero.I32Const(0)
+	zero.End()
+	e.base = uint32(e.m.AddGlobal(wasm.Global{Type: wasm.Int32, Mutable: true, Init: zero}))
+	e.globals = uint32(e.m.AddGlobal(wasm.Global{Type: wasm.Int32, Mutable: true, Init: zero}))
+
+	e.strings = map[string]uint32{}
+	e.names = wasm.Names{
+		Module: name,
+		Funcs:  map[wasm.Index]string{},
+		Locals: map[wasm.Index]map[uint32]string{},
 	}
 	return e
 }
 
-func (e *wasmEncoder) Block() blockEncoder {
-	panic("unsupported")
+func (e *wasmEncoder) runtimeFunc(name string, in, out []wasm.Type) wasm.Import {
+	t := e.m.EnsureType(wasm.FuncType{In: in, Out: out})
+	return wasm.FuncImport{Module: "runtime", Name: name, Type: t}
+}
+
+// The parameters of every block and method.
+const (
+	argvParam = iota
+	argcParam
+	firstRegister
+)
+
+func (e *wasmEncoder) Block(name string, frame []string, argc int) blockEncoder {
+	b := &wasmBlockEncoder{
+		e:    e,
+		id:   uint32(len(e.blocks)),
+		c:    e.m.AddFunc([]wasm.Type{wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64}),
+		argc: argc,
+		regs: len(frame),
+	}
+	for range frame {
+		b.c.AddLocal(wasm.Int64)
+	}
+	b.acc = b.c.AddLocal(wasm.Int64)
+	e.blocks = append(e.blocks, b)
+	b.prologue()
+
+	locals := map[uint32]string{}
+	for r, v := range frame {
+		if v != "" {
+			locals[b.local(lync.Register(r))] = v
+		}
+	}
+	e.names.Funcs[b.c.Func] = name
+	e.names.Locals[b.c.Func] = locals
+
+	return b
+}
+
+func (e *wasmEncoder) Validate() error {
+	e.finish()
+	return e.m.Validate()
 }
 
 func (e *wasmEncoder) Bytes() []byte {
-	panic("unsupported")
+	e.finish()
+	return e.m.AppendWasm(nil)
+}
+
+// finish completes the module once every block has been assembled. The entry block is exported as
+// "main".
+func (e *wasmEncoder) finish() {
+	if e.done {
+		return
+	}
+	e.done = true
+
+	elem := &wasm.FuncElement{}
+	for _, b := range e.blocks {
+		// blocks that do not return give the last value they produced
+		b.c.LocalGet(b.acc)
+		b.c.End()
+		elem.Funcs = append(elem.Funcs, b.c.Func)
+	}
+	e.m.Elements = []wasm.Element{elem}
+	if len(e.blocks) != 0 {
+		e.m.Exports = append(e.m.Exports, wasm.FuncExport{Name: "main", Func: e.blocks[0].c.Func})
+	}
+
+	start := e.m.AddFunc(nil, nil)
+	start.NullFunc()
+	start.I32Const(int32(len(e.blocks)))
+	start.TableGrow(0)
+	start.GlobalSet(e.base)
+	start.GlobalGet(e.base)
+	start.I32Const(0)
+	start.I32Const(int32(len(e.blocks)))
+	start.TableInit(0, 0)
+	start.Call(importGlobals)
+	start.GlobalSet(e.globals)
+	start.End()
+	e.m.Start = &start.Func
+	e.names.Funcs[start.Func] = "<start>"
+
+	e.m.SetNames(&e.names)
+}
+
+func (e *wasmEncoder) methodType() uint32 {
+	return uint32(e.m.EnsureType(wasm.FuncType{
+		In:  []wasm.Type{wasm.Int32, wasm.Int32},
+		Out: []wasm.Type{wasm.Int64},
+	}))
+}
+
+func (b *wasmBlockEncoder) local(r lync.Register) uint32 {
+	return firstRegister + uint32(r)
+}
+
+// prologue checks that the block was given the right number of arguments and copies them into
+// the registers at the end of the frame.
+func (b *wasmBlockEncoder) prologue() {
+	b.c.LocalGet(argcParam)
+	b.c.I32Const(int32(b.argc))
+	b.c.I32Ne()
+	b.c.If(wasm.Void)
+	b.c.Unreachable()
+	b.c.End()
+
+	for i := 0; i < b.argc; i++ {
+		b.c.LocalGet(argvParam)
+		b.c.I64Load(3, uint32(8*i))
+		b.c.LocalSet(b.local(lync.Register(b.regs - b.argc + i)))
+	}
+}
+
+func (b *wasmBlockEncoder) ID() uint32 {
+	return b.id
+}
+
+func (b *wasmBlockEncoder) Unit() {
+	b.c.GlobalGet(b.e.globals)
+	b.c.Call(importUnit)
+	b.c.LocalSet(b.acc)
+}
+
+func (b *wasmBlockEncoder) Name(value lync.Symbol) {
+	b.c.I64Const(int64(value))
+	b.c.Call(importName)
+	b.c.LocalSet(b.acc)
+}
+
+// String copies the string into memory from a passive data segment before passing it to the
+// runtime.
+func (b *wasmBlockEncoder) String(value string) {
+	data, ok := b.e.strings[value]
+	if !ok {
+		data = uint32(len(b.e.m.Data))
+		b.e.m.Data = append(b.e.m.Data, wasm.PassiveData{Bytes: []byte(value)})
+		b.e.strings[value] = data
+	}
+	addr := b.c.AllocLocal(wasm.Int32)
+	defer b.c.FreeLocal(addr)
+
+	b.c.I32Const(int32(len(value)))
+	b.c.Call(importAlloc)
+	b.c.LocalTee(addr)
+	b.c.I32Const(0)
+	b.c.I32Const(int32(len(value)))
+	b.c.MemoryInit(data)
+
+	b.c.LocalGet(addr)
+	b.c.I32Const(int32(len(value)))
+	b.c.Call(importString)
+	b.c.LocalSet(b.acc)
+}
+
+func (b *wasmBlockEncoder) Int(value int) {
+	b.c.I64Const(int64(value))
+	b.c.Call(importInt)
+	b.c.LocalSet(b.acc)
+}
+
+func (b *wasmBlockEncoder) Float(value float64) {
+	b.c.F64Const(value)
+	b.c.Call(importFloat)
+	b.c.LocalSet(b.acc)
+}
+
+func (b *wasmBlockEncoder) Block(argc, varc byte, id uint32) {
+	b.c.GlobalGet(b.e.base)
+	b.c.I32Const(int32(id))
+	b.c.I32Add()
+	b.c.I32Const(int32(argc))
+	b.c.Call(importBlock)
+	b.c.LocalSet(b.acc)
+}
+
+func (b *wasmBlockEncoder) Load(from lync.Register) {
+	b.c.LocalGet(b.local(from))
+	b.c.LocalSet(b.acc)
+}
+
+func (b *wasmBlockEncoder) Store(into lync.Register) {
+	b.c.LocalGet(b.acc)
+	b.c.LocalSet(b.local(into))
+}
+
+func (b *wasmBlockEncoder) Call(method lync.Symbol, args lync.Register, argc byte) {
+	b.push(args, argc)
+	b.c.GlobalGet(b.e.sp)
+	b.c.I32Const(int32(argc) + 1)
+	b.lookup(method)
+	b.c.CallIndirect(b.e.methodType())
+	b.pop(argc)
+	if b.e.trampoline {
+		b.c.Call(importRun)
+	}
+	b.c.LocalSet(b.acc)
+}
+
+// CallTail releases the arguments before making the call. The callee copies them out of memory
+// before anything else can overwrite them.
+func (b *wasmBlockEncoder) CallTail(method lync.Symbol, args lync.Register, argc byte) {
+	b.push(args, argc)
+	b.c.GlobalGet(b.e.sp)
+	b.c.I32Const(int32(argc) + 1)
+	b.pop(argc)
+	b.lookup(method)
+	if b.e.trampoline {
+		b.c.Call(importTail)
+		b.c.Return()
+		return
+	}
+	b.c.ReturnCallIndirect(b.e.methodType())
+}
+
+// push places the object in the accumulator and the arguments on the stack, stopping the program
+// if there is no room for them.
+func (b *wasmBlockEncoder) push(args lync.Register, argc byte) {
+	b.c.GlobalGet(b.e.sp)
+	b.c.I32Const(8 * (int32(argc) + 1))
+	b.c.I32Sub()
+	b.c.GlobalSet(b.e.sp)
+
+	b.c.GlobalGet(b.e.sp)
+	b.c.GlobalGet(importStackLimit)
+	b.c.I32LtU()
+	b.c.If(wasm.Void)
+	b.c.Call(importStackOverflow)
+	b.c.End()
+
+	b.c.GlobalGet(b.e.sp)
+	b.c.LocalGet(b.acc)
+	b.c.I64Store(3, 0)
+	for i := byte(0); i < argc; i++ {
+		b.c.GlobalGet(b.e.sp)
+		b.c.LocalGet(b.local(args + lync.Register(i)))
+		b.c.I64Store(3, 8*(uint32(i)+1))
+	}
+}
+
+func (b *wasmBlockEncoder) pop(argc byte) {
+	b.c.GlobalGet(b.e.sp)
+	b.c.I32Const(8 * (int32(argc) + 1))
+	b.c.I32Add()
+	b.c.GlobalSet(b.e.sp)
+}
+
+// lookup finds the method that the object in the accumulator uses to respond to a message.
+func (b *wasmBlockEncoder) lookup(method lync.Symbol) {
+	b.c.LocalGet(b.acc)
+	b.c.I64Const(int64(method))
+	b.c.Call(importLookup)
+	b.c.I32WrapI64()
+}
+
+func (b *wasmBlockEncoder) Return() {
+	b.c.LocalGet(b.acc)
+	b.c.Return()
+}
+
+func (b *wasmBlockEncoder) If() {
+	b.c.LocalGet(b.acc)
+	b.c.Call(importTruth)
+	b.c.If(wasm.Void)
+}
+
+func (b *wasmBlockEncoder) Else() {
+	b.c.Else()
+}
+
+func (b *wasmBlockEncoder) End() {
+	b.c.End()
 }
@@ -9,6 +9,30 @@ type Node interface {
 
 type Program struct {
 	Stmts []Stmt
+
+	// Comments are those after the last statement, which belong to no node.
+	Comments []Comment
+}
+
+// Comment is a comment in the source, which runs to the end of the line.
+type Comment struct {
+	astNodeData
+
+	Text string
+}
+
+// Comments are the comments in the source that belong to a node. They are not part of the tree
+// itself, but go with it so that the source can be printed with them in place.
+type Comments struct {
+	// Leading comments are on lines of their own before the node.
+	Leading []Comment
+
+	// Trailing comments follow the node on the line where it ends.
+	Trailing []Comment
+
+	// Inner comments are on lines of their own after the last statement, member or argument
+	// inside the node, before the bracket that closes it.
+	Inner []Comment
 }
 
 // Expressions
@@ -67,25 +91,35 @@ type Call struct {
 	Args   []Expr
 }
 
+type SuperCall struct {
+	astNodeData
+
+	Member string
+	Args   []Expr
+}
+
 type Class struct {
 	astNodeData
 
 	Name    string
+	Super   Expr
 	Members []Member
 }
 
 type Function struct {
 	astNodeData
 
-	Name string
-	Args []Arg
-	Body []Stmt
+	Name   string
+	Args   []Arg
+	Result Type
+	Body   []Stmt
 }
 
 type Arg struct {
 	astNodeData
 
 	Name string
+	Type Type
 }
 
 func (Unit) expr()           {}
@@ -96,6 +130,7 @@ func (FltConstant) expr()    {}
 func (VariableRef) expr()    {}
 func (MemberAccess) expr()   {}
 func (Call) expr()           {}
+func (SuperCall) expr()      {}
 func (Class) expr()          {}
 func (Function) expr()       {}
 
@@ -110,12 +145,40 @@ type Member interface {
 type Method struct {
 	astNodeData
 
-	Name string
-	Args []Arg
-	Body []Stmt
+	Name   string
+	Args   []Arg
+	Result Type
+	Body   []Stmt
+}
+
+type Field struct {
+	astNodeData
+
+	Name  string
+	Type  Type
+	Value Expr
 }
 
 func (Method) member() {}
+func (Field) member()  {}
+
+// Types
+
+// Type is an annotation giving the type of a variable, argument, field or result. Annotations are
+// optional, and have no effect on how a program runs.
+type Type interface {
+	typ()
+	Node
+}
+
+// TypeName names a type, such as Int or a class.
+type TypeName struct {
+	astNodeData
+
+	Name string
+}
+
+func (TypeName) typ() {}
 
 // Statements
 
@@ -142,6 +205,7 @@ type Variable struct {
 	astNodeData
 
 	Name  string
+	Type  Type
 	Value Expr
 }
 
@@ -174,5 +238,6 @@ func (FltConstant) stmt()    {}
 func (VariableRef) stmt()    {}
 func (MemberAccess) stmt()   {}
 func (Call) stmt()           {}
+func (SuperCall) stmt()      {}
 func (Class) stmt()          {}
 func (Function) stmt()       {}
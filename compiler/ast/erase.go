@@ -0,0 +1,26 @@
+package ast
+
+// Erase removes the type annotations from a program, which are only of use to the type checker.
+// The program that it is given is not changed.
+func Erase(p Program) Program {
+	return Rewrite(p, nil, func(c *Cursor) bool {
+		switch n := c.Node().(type) {
+		case Variable:
+			n.Type = nil
+			c.Replace(n)
+		case Arg:
+			n.Type = nil
+			c.Replace(n)
+		case Field:
+			n.Type = nil
+			c.Replace(n)
+		case Function:
+			n.Result = nil
+			c.Replace(n)
+		case Method:
+			n.Result = nil
+			c.Replace(n)
+		}
+		return true
+	}).(Program)
+}
@@ -0,0 +1,36 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestErase(t *testing.T) {
+	p := Program{
+		Stmts: []Stmt{
+			NodeAt(1, Variable{Name: "x", Type: TypeName{Name: "Int"}, Value: IntConstant{Value: 1}}),
+			Class{Members: []Member{
+				Field{Name: "y", Type: TypeName{Name: "Str"}, Value: StringConstant{}},
+				Method{Name: "m", Args: []Arg{{Name: "a", Type: TypeName{Name: "A"}}}, Result: TypeName{Name: "A"}},
+			}},
+			Function{Args: []Arg{{Name: "b", Type: TypeName{Name: "B"}}}, Result: TypeName{Name: "B"}},
+		},
+		Comments: []Comment{{Text: "// c"}},
+	}
+
+	assert.Equal(t, Erase(p), Program{
+		Stmts: []Stmt{
+			NodeAt(1, Variable{Name: "x", Value: IntConstant{Value: 1}}),
+			Class{Members: []Member{
+				Field{Name: "y", Value: StringConstant{}},
+				Method{Name: "m", Args: []Arg{{Name: "a"}}},
+			}},
+			Function{Args: []Arg{{Name: "b"}}},
+		},
+		Comments: []Comment{{Text: "// c"}},
+	})
+
+	// the original is left alone
+	assert.Equal(t, p.Stmts[0].(Variable).Type, Type(TypeName{Name: "Int"}))
+}
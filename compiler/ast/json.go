@@ -0,0 +1,274 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrNodeType = errors.New("unexpected node type")
+)
+
+// nodeTypes finds the types of node by the names used for them in JSON.
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, n := range []Node{
+		Program{}, Comment{},
+		StringConstant{}, IntConstant{}, FltConstant{}, Unit{}, Name{}, VariableRef{},
+		MemberAccess{}, Call{}, SuperCall{}, Class{}, Function{}, Arg{},
+		Method{}, Field{},
+		Assign{}, Return{}, Variable{}, Import{}, If{},
+		TypeName{},
+	} {
+		t := reflect.TypeOf(n)
+		nodeTypes[t.Name()] = t
+	}
+}
+
+// Nodes are encoded as JSON objects. The "kind" field names the kind of node and the "start" field
+// gives where it starts in the source. The "comments" field holds the comments that belong to the
+// node, if there are any. The node's own fields follow, named as in Go but starting with a lower
+// case letter. Fields with zero values are left out.
+//
+// For example, the call f(1) at the start of the source is encoded as
+//
+//	{"kind":"Call","start":1,"method":{"kind":"VariableRef","start":0,"var":"f"},"args":[{"kind":"IntConstant","start":2,"value":1}]}
+//
+// Programs have no "start" field.
+func (n Program) MarshalJSON() ([]byte, error)        { return marshalNode(n) }
+func (n Comment) MarshalJSON() ([]byte, error)        { return marshalNode(n) }
+func (n StringConstant) MarshalJSON() ([]byte, error) { return marshalNode(n) }
+func (n IntConstant) MarshalJSON() ([]byte, error)    { return marshalNode(n) }
+func (n FltConstant) MarshalJSON() ([]byte, error)    { return marshalNode(n) }
+func (n Unit) MarshalJSON() ([]byte, error)           { return marshalNode(n) }
+func (n Name) MarshalJSON() ([]byte, error)           { return marshalNode(n) }
+func (n VariableRef) MarshalJSON() ([]byte, error)    { return marshalNode(n) }
+func (n MemberAccess) MarshalJSON() ([]byte, error)   { return marshalNode(n) }
+func (n Call) MarshalJSON() ([]byte, error)           { return marshalNode(n) }
+func (n SuperCall) MarshalJSON() ([]byte, error)      { return marshalNode(n) }
+func (n Class) MarshalJSON() ([]byte, error)          { return marshalNode(n) }
+func (n Function) MarshalJSON() ([]byte, error)       { return marshalNode(n) }
+func (n Arg) MarshalJSON() ([]byte, error)            { return marshalNode(n) }
+func (n Method) MarshalJSON() ([]byte, error)         { return marshalNode(n) }
+func (n Field) MarshalJSON() ([]byte, error)          { return marshalNode(n) }
+func (n Assign) MarshalJSON() ([]byte, error)         { return marshalNode(n) }
+func (n Return) MarshalJSON() ([]byte, error)         { return marshalNode(n) }
+func (n Variable) MarshalJSON() ([]byte, error)       { return marshalNode(n) }
+func (n Import) MarshalJSON() ([]byte, error)         { return marshalNode(n) }
+func (n If) MarshalJSON() ([]byte, error)             { return marshalNode(n) }
+func (n TypeName) MarshalJSON() ([]byte, error)       { return marshalNode(n) }
+
+func (n *Program) UnmarshalJSON(buf []byte) error        { return unmarshalNode(buf, n) }
+func (n *Comment) UnmarshalJSON(buf []byte) error        { return unmarshalNode(buf, n) }
+func (n *StringConstant) UnmarshalJSON(buf []byte) error { return unmarshalNode(buf, n) }
+func (n *IntConstant) UnmarshalJSON(buf []byte) error    { return unmarshalNode(buf, n) }
+func (n *FltConstant) UnmarshalJSON(buf []byte) error    { return unmarshalNode(buf, n) }
+func (n *Unit) UnmarshalJSON(buf []byte) error           { return unmarshalNode(buf, n) }
+func (n *Name) UnmarshalJSON(buf []byte) error           { return unmarshalNode(buf, n) }
+func (n *VariableRef) UnmarshalJSON(buf []byte) error    { return unmarshalNode(buf, n) }
+func (n *MemberAccess) UnmarshalJSON(buf []byte) error   { return unmarshalNode(buf, n) }
+func (n *Call) UnmarshalJSON(buf []byte) error           { return unmarshalNode(buf, n) }
+func (n *SuperCall) UnmarshalJSON(buf []byte) error      { return unmarshalNode(buf, n) }
+func (n *Class) UnmarshalJSON(buf []byte) error          { return unmarshalNode(buf, n) }
+func (n *Function) UnmarshalJSON(buf []byte) error       { return unmarshalNode(buf, n) }
+func (n *Arg) UnmarshalJSON(buf []byte) error            { return unmarshalNode(buf, n) }
+func (n *Method) UnmarshalJSON(buf []byte) error         { return unmarshalNode(buf, n) }
+func (n *Field) UnmarshalJSON(buf []byte) error          { return unmarshalNode(buf, n) }
+func (n *Assign) UnmarshalJSON(buf []byte) error         { return unmarshalNode(buf, n) }
+func (n *Return) UnmarshalJSON(buf []byte) error         { return unmarshalNode(buf, n) }
+func (n *Variable) UnmarshalJSON(buf []byte) error       { return unmarshalNode(buf, n) }
+func (n *Import) UnmarshalJSON(buf []byte) error         { return unmarshalNode(buf, n) }
+func (n *If) UnmarshalJSON(buf []byte) error             { return unmarshalNode(buf, n) }
+func (n *TypeName) UnmarshalJSON(buf []byte) error       { return unmarshalNode(buf, n) }
+
+// UnmarshalNode decodes a node of any kind, such as a statement or an expression.
+func UnmarshalNode(buf []byte) (Node, error) {
+	var header struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(buf, &header); err != nil {
+		return nil, err
+	}
+	t, ok := nodeTypes[header.Kind]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", header.Kind, ErrNodeType)
+	}
+	n := reflect.New(t)
+	if err := json.Unmarshal(buf, n.Interface()); err != nil {
+		return nil, err
+	}
+	return n.Elem().Interface().(Node), nil
+}
+
+func marshalNode(n Node) ([]byte, error) {
+	v := reflect.ValueOf(n)
+	t := v.Type()
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"kind":`)
+	buf.WriteString(quote(t.Name()))
+	if hasPosition(t) {
+		fmt.Fprintf(&buf, `,"start":%d`, n.Start())
+	}
+	if c := CommentsOf(n); c != nil {
+		comments, err := marshalComments(c)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"comments":`)
+		buf.Write(comments)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || v.Field(i).IsZero() {
+			continue
+		}
+		field, err := json.Marshal(v.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.WriteString(quote(fieldKey(f.Name)))
+		buf.WriteByte(':')
+		buf.Write(field)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func unmarshalNode[T Node](buf []byte, n *T) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(n).Elem()
+	t := v.Type()
+
+	var typ string
+	if err := json.Unmarshal(fields["kind"], &typ); err != nil || typ != t.Name() {
+		return fmt.Errorf("%q is not %s: %w", typ, t.Name(), ErrNodeType)
+	}
+
+	var res T
+	rv := reflect.ValueOf(&res).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		raw, ok := fields[fieldKey(f.Name)]
+		if !f.IsExported() || !ok {
+			continue
+		}
+		if err := unmarshalField(rv.Field(i), raw); err != nil {
+			return fmt.Errorf("%s.%s: %w", t.Name(), f.Name, err)
+		}
+	}
+
+	if hasPosition(t) {
+		var start int
+		if raw, ok := fields["start"]; ok {
+			if err := json.Unmarshal(raw, &start); err != nil {
+				return err
+			}
+		}
+		res = NodeAt(start, res)
+
+		if raw, ok := fields["comments"]; ok {
+			c, err := unmarshalComments(raw)
+			if err != nil {
+				return err
+			}
+			res = WithComments(c, res)
+		}
+	}
+	*n = res
+	return nil
+}
+
+// The comments that belong to a node are encoded as an object with a field for each kind of
+// comment that the node has.
+func marshalComments(c *Comments) ([]byte, error) {
+	fields := map[string][]Comment{}
+	for key, cs := range map[string][]Comment{"leading": c.Leading, "trailing": c.Trailing, "inner": c.Inner} {
+		if len(cs) != 0 {
+			fields[key] = cs
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func unmarshalComments(raw json.RawMessage) (*Comments, error) {
+	var fields map[string][]Comment
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return &Comments{
+		Leading:  fields["leading"],
+		Trailing: fields["trailing"],
+		Inner:    fields["inner"],
+	}, nil
+}
+
+// Fields that hold statements, expressions, members or types are decoded according to the types of node
+// found in them. Other fields are decoded as usual.
+func unmarshalField(f reflect.Value, raw json.RawMessage) error {
+	switch {
+	case f.Kind() == reflect.Interface:
+		return unmarshalInterface(f, raw)
+
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Interface:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+		if items == nil {
+			return nil
+		}
+		f.Set(reflect.MakeSlice(f.Type(), len(items), len(items)))
+		for i, item := range items {
+			if err := unmarshalInterface(f.Index(i), item); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return json.Unmarshal(raw, f.Addr().Interface())
+	}
+}
+
+func unmarshalInterface(f reflect.Value, raw json.RawMessage) error {
+	if string(raw) == "null" {
+		return nil
+	}
+	n, err := UnmarshalNode(raw)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(n)
+	if !v.Type().Implements(f.Type()) {
+		return fmt.Errorf("%s is not %s: %w", v.Type().Name(), f.Type().Name(), ErrNodeType)
+	}
+	f.Set(v)
+	return nil
+}
+
+// Programs are the only nodes that do not know where they start.
+func hasPosition(t reflect.Type) bool {
+	return t.NumField() != 0 && t.Field(0).Type == reflect.TypeOf(astNodeData{})
+}
+
+func fieldKey(name string) string {
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[n:]
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
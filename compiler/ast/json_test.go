@@ -0,0 +1,160 @@
+package ast
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	// give every node a position, so that they can be seen to survive
+	pos := 1
+	prog := Rewrite(everything, nil, func(c *Cursor) bool {
+		if _, ok := c.Node().(Program); !ok {
+			c.Replace(setStart(c.Node(), pos))
+			pos++
+		}
+		return true
+	}).(Program)
+	prog.Stmts[0] = WithComments(&Comments{
+		Leading:  []Comment{NodeAt(7, Comment{Text: "// x"})},
+		Trailing: []Comment{NodeAt(8, Comment{Text: "// y"})},
+	}, prog.Stmts[0])
+	prog.Comments = []Comment{NodeAt(9, Comment{Text: "// z"})}
+
+	buf, err := json.Marshal(prog)
+	assert.Nil(t, err)
+
+	var res Program
+	assert.Nil(t, json.Unmarshal(buf, &res))
+	assert.Equal(t, res, prog)
+
+	var starts []int
+	Inspect(res, func(n Node) bool {
+		if n != nil {
+			starts = append(starts, n.Start())
+		}
+		return true
+	})
+	assert.Equal(t, starts[:4], []int{0, 1, 4, 2})
+}
+
+func setStart(n Node, start int) Node {
+	switch n := n.(type) {
+	case Import:
+		return NodeAt(start, n)
+	case Variable:
+		return NodeAt(start, n)
+	case Assign:
+		return NodeAt(start, n)
+	case If:
+		return NodeAt(start, n)
+	case Return:
+		return NodeAt(start, n)
+	case Class:
+		return NodeAt(start, n)
+	case Function:
+		return NodeAt(start, n)
+	case Field:
+		return NodeAt(start, n)
+	case Method:
+		return NodeAt(start, n)
+	case Arg:
+		return NodeAt(start, n)
+	case Call:
+		return NodeAt(start, n)
+	case SuperCall:
+		return NodeAt(start, n)
+	case MemberAccess:
+		return NodeAt(start, n)
+	case VariableRef:
+		return NodeAt(start, n)
+	case Name:
+		return NodeAt(start, n)
+	case Unit:
+		return NodeAt(start, n)
+	case StringConstant:
+		return NodeAt(start, n)
+	case IntConstant:
+		return NodeAt(start, n)
+	case FltConstant:
+		return NodeAt(start, n)
+	case TypeName:
+		return NodeAt(start, n)
+	}
+	return n
+}
+
+func TestJSONEncoding(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   Node
+		out  string
+	}{
+		{
+			name: "Call",
+			in: NodeAt(1, Call{
+				Method: VariableRef{Var: "f"},
+				Args:   []Expr{NodeAt(2, IntConstant{Value: 1})},
+			}),
+			out: `{"kind":"Call","start":1,"method":{"kind":"VariableRef","start":0,"var":"f"},"args":[{"kind":"IntConstant","start":2,"value":1}]}`,
+		},
+		{
+			name: "Class",
+			in:   Class{Name: "A", Members: []Member{Field{Name: "x", Value: Unit{}}}},
+			out:  `{"kind":"Class","start":0,"name":"A","members":[{"kind":"Field","start":0,"name":"x","value":{"kind":"Unit","start":0}}]}`,
+		},
+		{
+			name: "Annotated",
+			in:   Variable{Name: "x", Type: TypeName{Name: "Int"}, Value: IntConstant{Value: 1}},
+			out:  `{"kind":"Variable","start":0,"name":"x","type":{"kind":"TypeName","start":0,"name":"Int"},"value":{"kind":"IntConstant","start":0,"value":1}}`,
+		},
+		{
+			name: "Program",
+			in:   Program{Stmts: []Stmt{Return{Value: Name{Name: "n"}}}},
+			out:  `{"kind":"Program","stmts":[{"kind":"Return","start":0,"value":{"kind":"Name","start":0,"name":"n"}}]}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf, err := json.Marshal(test.in)
+			assert.Nil(t, err)
+			assert.Equal(t, string(buf), test.out)
+
+			res, err := UnmarshalNode(buf)
+			assert.Nil(t, err)
+			assert.Equal(t, res, test.in)
+		})
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+	}{
+		{
+			name: "UnknownType",
+			in:   `{"kind":"Program","stmts":[{"kind":"Loop"}]}`,
+		},
+		{
+			name: "MemberForStmt",
+			in:   `{"kind":"Program","stmts":[{"kind":"Field","name":"x"}]}`,
+		},
+		{
+			name: "StmtForExpr",
+			in:   `{"kind":"Program","stmts":[{"kind":"Return","value":{"kind":"Return"}}]}`,
+		},
+		{
+			name: "WrongType",
+			in:   `{"kind":"Call"}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var p Program
+			err := json.Unmarshal([]byte(test.in), &p)
+			assert.True(t, errors.Is(err, ErrNodeType))
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package ast
 
-import "unsafe"
+import (
+	"reflect"
+	"unsafe"
+)
 
 func NodeAt[T Node](start int, n T) T {
 	d := (*astNodeData)(unsafe.Pointer(&n))
@@ -8,12 +11,38 @@ func NodeAt[T Node](start int, n T) T {
 	return n
 }
 
+// WithComments gives a node the comments that belong to it. Unlike NodeAt, the node can be held in
+// an interface, such as when it is found by Rewrite. Programs are left as they are.
+func WithComments[T Node](c *Comments, n T) T {
+	t := reflect.TypeOf(n)
+	if !hasPosition(t) {
+		return n
+	}
+	v := reflect.New(t).Elem()
+	v.Set(reflect.ValueOf(n))
+	(*astNodeData)(v.Addr().UnsafePointer()).c = c
+	return v.Interface().(T)
+}
+
+// CommentsOf gives the comments that belong to a node, or nil if there are none.
+func CommentsOf(n Node) *Comments {
+	if n, ok := n.(interface{ comments() *Comments }); ok {
+		return n.comments()
+	}
+	return nil
+}
+
 type astNodeData struct {
 	s int
+	c *Comments
 }
 
 func (d astNodeData) Start() int {
 	return d.s
 }
 
+func (d astNodeData) comments() *Comments {
+	return d.c
+}
+
 func (d astNodeData) node() {}
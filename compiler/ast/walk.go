@@ -0,0 +1,296 @@
+package ast
+
+import (
+	"fmt"
+)
+
+// Programs can be walked like any other node. They start at the start of the source.
+func (Program) node() {}
+
+func (Program) Start() int {
+	return 0
+}
+
+// A Visitor's Visit method is called for each node that Walk finds. If it returns a visitor w, the
+// node's children are walked with w, followed by a call to w.Visit(nil).
+type Visitor interface {
+	Visit(n Node) (w Visitor)
+}
+
+// Walk traverses a tree in depth-first order, visiting a node before its children. Every kind of
+// node is visited, including class members, the arguments of functions and methods and type
+// annotations, but not comments.
+func Walk(v Visitor, n Node) {
+	if v = v.Visit(n); v == nil {
+		return
+	}
+
+	switch n := n.(type) {
+	case Program:
+		walkList(v, n.Stmts)
+
+	case Assign:
+		walkChild(v, n.Object)
+		walkChild(v, n.Value)
+
+	case Return:
+		walkChild(v, n.Value)
+
+	case Variable:
+		walkChild(v, n.Type)
+		walkChild(v, n.Value)
+
+	case If:
+		walkChild(v, n.Cond)
+		walkList(v, n.Then)
+		walkList(v, n.Else)
+
+	case MemberAccess:
+		walkChild(v, n.Object)
+
+	case Call:
+		walkChild(v, n.Method)
+		walkList(v, n.Args)
+
+	case SuperCall:
+		walkList(v, n.Args)
+
+	case Class:
+		walkChild(v, n.Super)
+		walkList(v, n.Members)
+
+	case Function:
+		walkList(v, n.Args)
+		walkChild(v, n.Result)
+		walkList(v, n.Body)
+
+	case Method:
+		walkList(v, n.Args)
+		walkChild(v, n.Result)
+		walkList(v, n.Body)
+
+	case Arg:
+		walkChild(v, n.Type)
+
+	case Field:
+		walkChild(v, n.Type)
+		walkChild(v, n.Value)
+	}
+
+	v.Visit(nil)
+}
+
+func walkList[T Node](v Visitor, ns []T) {
+	for _, n := range ns {
+		walkChild(v, n)
+	}
+}
+
+// Some children can be left out, such as the superclass of a class.
+func walkChild(v Visitor, n Node) {
+	if n != nil {
+		Walk(v, n)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(n Node) Visitor {
+	if f(n) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a tree in the same order as Walk. It calls f for each node, and if f returns
+// true it goes on to the node's children, followed by a call of f(nil).
+func Inspect(n Node, f func(Node) bool) {
+	Walk(inspector(f), n)
+}
+
+// Cursor describes a node found by Rewrite, and where it was found.
+type Cursor struct {
+	node Node
+	path []Node
+}
+
+// Node is the node being visited, or what it has been replaced with.
+func (c *Cursor) Node() Node {
+	return c.node
+}
+
+// Parent is the node that contains the node being visited, or nil at the root.
+func (c *Cursor) Parent() Node {
+	if len(c.path) == 0 {
+		return nil
+	}
+	return c.path[len(c.path)-1]
+}
+
+// Path lists the nodes that contain the node being visited, starting from the root. The slice is
+// only valid until the visit ends.
+func (c *Cursor) Path() []Node {
+	return c.path
+}
+
+// Replace puts another node in place of the one being visited. The replacement must be of a kind
+// that can appear in the same place, so that an expression can only be replaced by another
+// expression and a member by another member.
+//
+// Replacing a node in a pre-order hook means that the replacement's children are visited rather
+// than the original's.
+//
+// A node in a list, such as a statement in a block or an argument to a call, can be replaced with
+// nil to remove it from the list. Its children are not visited.
+func (c *Cursor) Replace(n Node) {
+	c.node = n
+}
+
+// ApplyFunc is called by Rewrite for each node that it visits.
+type ApplyFunc func(c *Cursor) bool
+
+// Rewrite traverses a tree in the same order as Walk, and gives the tree that results from the
+// replacements made along the way. The tree that it is given is not changed.
+//
+// If pre is not nil, it is called for each node before the node's children are visited. If it
+// returns false, the children and the post-order call are skipped. If post is not nil, it is
+// called for each node after its children have been visited. If it returns false, the traversal
+// stops, and the rest of the tree is left as it is.
+//
+// Rewrite panics if a node is replaced with something that cannot appear in its place.
+func Rewrite(n Node, pre, post ApplyFunc) Node {
+	r := &rewriter{pre: pre, post: post}
+	return r.apply(n)
+}
+
+type rewriter struct {
+	pre, post ApplyFunc
+	cursor    Cursor
+	stopped   bool
+}
+
+func (r *rewriter) apply(n Node) Node {
+	if r.stopped || n == nil {
+		return n
+	}
+
+	r.cursor.node = n
+	if r.pre != nil && !r.pre(&r.cursor) {
+		return r.cursor.node
+	}
+	n = r.cursor.node
+	if n == nil {
+		return nil
+	}
+
+	r.cursor.path = append(r.cursor.path, n)
+	n = r.children(n)
+	r.cursor.path = r.cursor.path[:len(r.cursor.path)-1]
+
+	r.cursor.node = n
+	if r.post != nil && !r.stopped && !r.post(&r.cursor) {
+		r.stopped = true
+	}
+	return r.cursor.node
+}
+
+// children rewrites the children of a node, giving a copy of the node with the results.
+func (r *rewriter) children(n Node) Node {
+	switch n := n.(type) {
+	case Program:
+		n.Stmts = rewriteList(r, n.Stmts)
+		return n
+
+	case Assign:
+		n.Object = rewriteNode[Expr](r, n.Object)
+		n.Value = rewriteNode[Expr](r, n.Value)
+		return n
+
+	case Return:
+		n.Value = rewriteNode[Expr](r, n.Value)
+		return n
+
+	case Variable:
+		n.Type = rewriteNode[Type](r, n.Type)
+		n.Value = rewriteNode[Expr](r, n.Value)
+		return n
+
+	case If:
+		n.Cond = rewriteNode[Expr](r, n.Cond)
+		n.Then = rewriteList(r, n.Then)
+		n.Else = rewriteList(r, n.Else)
+		return n
+
+	case MemberAccess:
+		n.Object = rewriteNode[Expr](r, n.Object)
+		return n
+
+	case Call:
+		n.Method = rewriteNode[Expr](r, n.Method)
+		n.Args = rewriteList(r, n.Args)
+		return n
+
+	case SuperCall:
+		n.Args = rewriteList(r, n.Args)
+		return n
+
+	case Class:
+		n.Super = rewriteNode[Expr](r, n.Super)
+		n.Members = rewriteList(r, n.Members)
+		return n
+
+	case Function:
+		n.Args = rewriteList(r, n.Args)
+		n.Result = rewriteNode[Type](r, n.Result)
+		n.Body = rewriteList(r, n.Body)
+		return n
+
+	case Method:
+		n.Args = rewriteList(r, n.Args)
+		n.Result = rewriteNode[Type](r, n.Result)
+		n.Body = rewriteList(r, n.Body)
+		return n
+
+	case Arg:
+		n.Type = rewriteNode[Type](r, n.Type)
+		return n
+
+	case Field:
+		n.Type = rewriteNode[Type](r, n.Type)
+		n.Value = rewriteNode[Expr](r, n.Value)
+		return n
+	}
+	return n
+}
+
+// Some children can be left out, such as the superclass of a class, and these are left as they
+// are.
+func rewriteNode[T Node](r *rewriter, n T) T {
+	if Node(n) == nil {
+		return n
+	}
+	return replacement(n, r.apply(n))
+}
+
+func replacement[T Node](n T, x Node) T {
+	res, ok := x.(T)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace %T with %T", n, x))
+	}
+	return res
+}
+
+// The lists in the tree that is being rewritten are shared with the tree that results, so new
+// lists are made for the results. Nodes that are replaced with nil are left out of them.
+func rewriteList[T Node](r *rewriter, ns []T) []T {
+	if ns == nil {
+		return nil
+	}
+	res := make([]T, 0, len(ns))
+	for _, n := range ns {
+		if x := r.apply(n); x != nil {
+			res = append(res, replacement(n, x))
+		}
+	}
+	return res
+}
@@ -0,0 +1,213 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+// everything has a node of every kind
+var everything = Program{Stmts: []Stmt{
+	Import{Name: "sys", Path: "sys"},
+	Variable{Name: "x", Type: TypeName{Name: "Int"}, Value: IntConstant{Value: 1}},
+	Assign{Object: VariableRef{Var: "o"}, Name: "y", Value: FltConstant{Value: 2}},
+	If{
+		Cond: Name{Name: "c"},
+		Then: []Stmt{Return{Value: StringConstant{Value: "a"}}},
+		Else: []Stmt{Return{Value: Unit{}}},
+	},
+	Class{
+		Name:  "A",
+		Super: VariableRef{Var: "B"},
+		Members: []Member{
+			Field{Name: "f", Value: IntConstant{Value: 3}},
+			Method{
+				Name: "m",
+				Args: []Arg{{Name: "a"}},
+				Body: []Stmt{SuperCall{Member: "m", Args: []Expr{VariableRef{Var: "a"}}}},
+			},
+		},
+	},
+	Function{
+		Name:   "f",
+		Args:   []Arg{{Name: "b", Type: TypeName{Name: "Str"}}},
+		Result: TypeName{Name: "Int"},
+		Body: []Stmt{
+			Call{
+				Method: MemberAccess{Object: VariableRef{Var: "b"}, Member: "g"},
+				Args:   []Expr{IntConstant{Value: 4}},
+			},
+		},
+	},
+}}
+
+func TestInspect(t *testing.T) {
+	var b strings.Builder
+	depth := 0
+	Inspect(everything, func(n Node) bool {
+		if n == nil {
+			depth--
+			return false
+		}
+		fmt.Fprintf(&b, "%s%T\n", strings.Repeat(" ", depth), n)
+		depth++
+		return true
+	})
+
+	assert.Equal(t, b.String(), `ast.Program
+ ast.Import
+ ast.Variable
+  ast.TypeName
+  ast.IntConstant
+ ast.Assign
+  ast.VariableRef
+  ast.FltConstant
+ ast.If
+  ast.Name
+  ast.Return
+   ast.StringConstant
+  ast.Return
+   ast.Unit
+ ast.Class
+  ast.VariableRef
+  ast.Field
+   ast.IntConstant
+  ast.Method
+   ast.Arg
+   ast.SuperCall
+    ast.VariableRef
+ ast.Function
+  ast.Arg
+   ast.TypeName
+  ast.TypeName
+  ast.Call
+   ast.MemberAccess
+    ast.VariableRef
+   ast.IntConstant
+`)
+}
+
+func TestInspectSkip(t *testing.T) {
+	n := 0
+	Inspect(everything, func(x Node) bool {
+		if x != nil {
+			n++
+		}
+		_, isClass := x.(Class)
+		return !isClass
+	})
+	assert.Equal(t, n, 23)
+}
+
+func TestRewrite(t *testing.T) {
+	var parents []string
+	res := Rewrite(everything, nil, func(c *Cursor) bool {
+		if x, ok := c.Node().(IntConstant); ok {
+			parents = append(parents, fmt.Sprintf("%T", c.Parent()))
+			c.Replace(IntConstant{Value: x.Value * 10})
+		}
+		return true
+	})
+
+	var ints []int
+	Inspect(res, func(n Node) bool {
+		if x, ok := n.(IntConstant); ok {
+			ints = append(ints, x.Value)
+		}
+		return true
+	})
+	assert.Equal(t, ints, []int{10, 30, 40})
+	assert.Equal(t, parents, []string{"ast.Variable", "ast.Field", "ast.Call"})
+
+	// the original is left alone
+	assert.Equal(t, everything.Stmts[1], Stmt(Variable{Name: "x", Type: TypeName{Name: "Int"}, Value: IntConstant{Value: 1}}))
+}
+
+func TestRewritePre(t *testing.T) {
+	// replacing a node before its children are visited means the replacement's are visited instead
+	var seen []string
+	res := Rewrite(everything, func(c *Cursor) bool {
+		switch n := c.Node().(type) {
+		case Function:
+			c.Replace(Call{Method: VariableRef{Var: "gone"}})
+		case Class:
+			return false
+		case VariableRef:
+			seen = append(seen, n.Var)
+			var path []string
+			for _, p := range c.Path() {
+				path = append(path, fmt.Sprintf("%T", p))
+			}
+			seen = append(seen, strings.Join(path, "/"))
+		}
+		return true
+	}, nil)
+
+	assert.Equal(t, seen, []string{
+		"o", "ast.Program/ast.Assign",
+		"gone", "ast.Program/ast.Call",
+	})
+	stmts := res.(Program).Stmts
+	assert.Equal(t, stmts[len(stmts)-1], Stmt(Call{Method: VariableRef{Var: "gone"}}))
+}
+
+func TestRewriteRemove(t *testing.T) {
+	// removing in either hook leaves the node out of its list, and before its children are visited
+	// means they are not
+	var seen []string
+	res := Rewrite(everything, func(c *Cursor) bool {
+		switch n := c.Node().(type) {
+		case Field:
+			c.Replace(nil)
+		case IntConstant:
+			seen = append(seen, fmt.Sprint(n.Value))
+		}
+		return true
+	}, func(c *Cursor) bool {
+		switch c.Node().(type) {
+		case Import:
+			c.Replace(nil)
+		case IntConstant:
+			if _, ok := c.Parent().(Call); ok {
+				c.Replace(nil)
+			}
+		}
+		return true
+	})
+
+	assert.Equal(t, seen, []string{"1", "4"})
+	stmts := res.(Program).Stmts
+	assert.Equal(t, len(stmts), len(everything.Stmts)-1)
+	assert.Equal(t, stmts[3].(Class).Members, []Member{everything.Stmts[4].(Class).Members[1]})
+	assert.Equal(t, stmts[4].(Function).Body[0].(Call).Args, []Expr{})
+}
+
+func TestRewriteStop(t *testing.T) {
+	visited := 0
+	res := Rewrite(everything, nil, func(c *Cursor) bool {
+		visited++
+		if _, ok := c.Node().(Variable); ok {
+			c.Replace(Variable{Name: "z", Value: Unit{}})
+			return false
+		}
+		return true
+	})
+	assert.Equal(t, visited, 4)
+	assert.Equal(t, res.(Program).Stmts[1], Stmt(Variable{Name: "z", Value: Unit{}}))
+	assert.Equal(t, res.(Program).Stmts[2], everything.Stmts[2])
+}
+
+func TestRewriteBadReplacement(t *testing.T) {
+	defer func() {
+		assert.Equal(t, recover(), any("ast.Rewrite: cannot replace ast.IntConstant with ast.Field"))
+	}()
+	Rewrite(everything, func(c *Cursor) bool {
+		if _, ok := c.Node().(IntConstant); ok {
+			c.Replace(Field{})
+		}
+		return true
+	}, nil)
+	t.Fatal("expected a panic")
+}
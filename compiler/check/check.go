@@ -0,0 +1,370 @@
+// Package check finds mistakes in programs that would otherwise only show up when they run, such
+// as referring to names that are never declared.
+package check
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+)
+
+var (
+	ErrUndefined          = errors.New("undefined")
+	ErrRedeclared         = errors.New("redeclared in this block")
+	ErrUsedBeforeDeclared = errors.New("used before declaration")
+	ErrDuplicateMember    = errors.New("duplicate member")
+	ErrArgCount           = errors.New("wrong number of arguments")
+	ErrSuperOutsideMethod = errors.New("super used outside of a method")
+)
+
+// Error is a problem found at an offset into the source.
+type Error struct {
+	Offset int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Config describes what a program can refer to besides what it declares itself.
+type Config struct {
+	// Globals are defined before the program runs, as by earlier entries in an interactive
+	// session.
+	Globals []string
+}
+
+// Program checks a program before it is lowered, reporting every problem that it finds as an
+// *Error, in the order they appear in the source. Problems found are:
+//
+//   - references to names that are not declared, neither in the program nor as globals
+//   - names declared twice in the same block, including the arguments of a function
+//   - names used before they are declared, unless the use is in a function that might be called
+//     later
+//   - classes with two members of the same name
+//   - super calls made outside of methods
+//   - calls to functions and classes declared in the program with the wrong number of arguments,
+//     unless the variable holding them is assigned elsewhere
+//   - values whose types do not match the annotations where they are used, and calls to methods
+//     that the class of an object does not have, as described in types.go
+func Program(p ast.Program) error {
+	return Config{}.Program(p)
+}
+
+// Program checks a program, as the package-level Program does.
+func (c Config) Program(p ast.Program) error {
+	universe := newScope(nil, false)
+	// the parser gives empty returns the value void
+	universe.define("void")
+	for _, name := range c.Globals {
+		universe.define(name)
+	}
+
+	ch := &checker{scope: universe}
+	ch.block(p.Stmts, newScope(universe, false))
+	ch.types(p)
+
+	for _, call := range ch.calls {
+		if call.decl.arity < 0 || call.decl.assigned || call.args == call.decl.arity {
+			continue
+		}
+		ch.fail(call.at, fmt.Errorf("%w to %s: have %d, want %d", ErrArgCount, call.name, call.args, call.decl.arity))
+	}
+
+	slices.SortStableFunc(ch.errs, func(a, b *Error) int {
+		return a.Offset - b.Offset
+	})
+	errs := make([]error, len(ch.errs))
+	for i, err := range ch.errs {
+		errs[i] = err
+	}
+	return errors.Join(errs...)
+}
+
+// Declared lists the names that a program declares at the top level, which become globals when it
+// runs.
+func Declared(p ast.Program) []string {
+	var res []string
+	for _, s := range p.Stmts {
+		if name, _ := declaration(s); name != "" && !slices.Contains(res, name) {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+type checker struct {
+	scope *scope
+	calls []call
+	errs  []*Error
+}
+
+type scope struct {
+	outer *scope
+	names map[string]*decl
+
+	// body is set for the scopes of functions and methods, whose code runs later than the code
+	// around them.
+	body bool
+
+	// method is set for the scopes of methods and field initializers, where super can be used.
+	method bool
+}
+
+// decl is a declared name. The arity is the number of arguments that the function or class it
+// names takes, or -1 when that is not known.
+type decl struct {
+	defined  bool
+	assigned bool
+	arity    int
+}
+
+// call is a call to a named function, whose arguments are counted once the whole program has been
+// seen.
+type call struct {
+	at   int
+	name string
+	args int
+	decl *decl
+}
+
+func newScope(outer *scope, body bool) *scope {
+	return &scope{outer: outer, names: map[string]*decl{}, body: body}
+}
+
+func (s *scope) define(name string) {
+	s.names[name] = &decl{defined: true, arity: -1}
+}
+
+// inMethod reports whether code in a scope is part of a method, including in the functions that
+// the method makes.
+func (s *scope) inMethod() bool {
+	for ; s != nil; s = s.outer {
+		if s.method {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) fail(at int, err error) {
+	c.errs = append(c.errs, &Error{Offset: at, Err: err})
+}
+
+// declare adds a name to the current scope, before the code that defines it has run.
+func (c *checker) declare(at int, name string, arity int) {
+	if _, ok := c.scope.names[name]; ok {
+		c.fail(at, fmt.Errorf("%w: %s", ErrRedeclared, name))
+		return
+	}
+	c.scope.names[name] = &decl{arity: arity}
+}
+
+func (c *checker) defined(name string) {
+	if d, ok := c.scope.names[name]; ok {
+		d.defined = true
+	}
+}
+
+// lookup finds the declaration of a name. Names declared in an enclosing block can be used before
+// they are defined from within a function, because the function might not be called until later.
+func (c *checker) lookup(at int, name string) *decl {
+	later := false
+	for s := c.scope; s != nil; s = s.outer {
+		if d, ok := s.names[name]; ok {
+			if !d.defined && !later {
+				c.fail(at, fmt.Errorf("%w: %s", ErrUsedBeforeDeclared, name))
+			}
+			return d
+		}
+		later = later || s.body
+	}
+	c.fail(at, fmt.Errorf("%w: %s", ErrUndefined, name))
+	return nil
+}
+
+func (c *checker) enter(s *scope) func() {
+	outer := c.scope
+	c.scope = s
+	return func() { c.scope = outer }
+}
+
+// block checks a list of statements in a scope. Everything that the block declares is in scope
+// throughout, but is only defined once the statement that declares it has run.
+func (c *checker) block(stmts []ast.Stmt, s *scope) {
+	defer c.enter(s)()
+	for _, stmt := range stmts {
+		if name, arity := declaration(stmt); name != "" {
+			c.declare(stmt.Start(), name, arity)
+		}
+	}
+	for _, stmt := range stmts {
+		c.stmt(stmt)
+	}
+}
+
+// declaration gives the name that a statement declares, if any, and the number of arguments taken
+// by what it declares.
+func declaration(s ast.Stmt) (string, int) {
+	switch s := s.(type) {
+	case ast.Variable:
+		return s.Name, arity(s.Value)
+	case ast.Import:
+		return s.Name, -1
+	case ast.Function:
+		return s.Name, arity(s)
+	case ast.Class:
+		return s.Name, arity(s)
+	}
+	return "", -1
+}
+
+func arity(e ast.Expr) int {
+	switch e := e.(type) {
+	case ast.Function:
+		return len(e.Args)
+
+	case ast.Class:
+		for _, m := range e.Members {
+			if m, ok := m.(ast.Method); ok && m.Name == "init" {
+				return len(m.Args)
+			}
+		}
+		// without a constructor of its own, a class takes what its superclass does
+		if e.Super == nil {
+			return 0
+		}
+	}
+	return -1
+}
+
+func (c *checker) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case ast.Import:
+		c.defined(s.Name)
+
+	case ast.Variable:
+		c.expr(s.Value)
+		c.defined(s.Name)
+
+	case ast.Assign:
+		if s.Object != nil {
+			c.expr(s.Object)
+			c.expr(s.Value)
+			return
+		}
+		c.expr(s.Value)
+		if d := c.lookup(s.Start(), s.Name); d != nil {
+			d.assigned = true
+		}
+
+	case ast.Return:
+		c.expr(s.Value)
+
+	case ast.If:
+		c.expr(s.Cond)
+		c.block(s.Then, newScope(c.scope, false))
+		c.block(s.Else, newScope(c.scope, false))
+
+	case ast.Function:
+		// a function can call itself
+		c.defined(s.Name)
+		c.expr(s)
+
+	case ast.Class:
+		c.expr(s)
+		c.defined(s.Name)
+
+	case ast.Expr:
+		c.expr(s)
+	}
+}
+
+func (c *checker) expr(e ast.Expr) {
+	switch e := e.(type) {
+	case ast.VariableRef:
+		c.lookup(e.Start(), e.Var)
+
+	case ast.MemberAccess:
+		c.expr(e.Object)
+
+	case ast.Call:
+		if ref, ok := e.Method.(ast.VariableRef); ok {
+			if d := c.lookup(ref.Start(), ref.Var); d != nil {
+				c.calls = append(c.calls, call{at: ref.Start(), name: ref.Var, args: len(e.Args), decl: d})
+			}
+		} else {
+			c.expr(e.Method)
+		}
+		for _, a := range e.Args {
+			c.expr(a)
+		}
+
+	case ast.SuperCall:
+		if !c.scope.inMethod() {
+			c.fail(e.Start(), ErrSuperOutsideMethod)
+		}
+		for _, a := range e.Args {
+			c.expr(a)
+		}
+
+	case ast.Function:
+		c.function(e.Args, e.Body, false)
+
+	case ast.Class:
+		c.expr(e.Super)
+		c.members(e.Members)
+	}
+}
+
+// function checks the body of a function or method, in a scope of its own that starts with the
+// arguments. Methods also have this.
+func (c *checker) function(args []ast.Arg, body []ast.Stmt, method bool) {
+	s := newScope(c.scope, true)
+	if method {
+		s.define("this")
+		s.method = true
+	}
+	defer c.enter(s)()
+	for _, a := range args {
+		c.declare(a.Start(), a.Name, -1)
+		c.defined(a.Name)
+	}
+	c.block(body, s)
+}
+
+func (c *checker) members(members []ast.Member) {
+	var names []string
+	for _, m := range members {
+		var name string
+		switch m := m.(type) {
+		case ast.Method:
+			name = m.Name
+			c.function(m.Args, m.Body, true)
+
+		case ast.Field:
+			name = m.Name
+			c.field(m.Value)
+		}
+		if slices.Contains(names, name) {
+			c.fail(m.Start(), fmt.Errorf("%w: %s", ErrDuplicateMember, name))
+		}
+		names = append(names, name)
+	}
+}
+
+// Fields are initialized as each instance is made, by a method that only has the instance to go
+// on, and so cannot see the arguments to init.
+func (c *checker) field(value ast.Expr) {
+	s := newScope(c.scope, true)
+	s.define("this")
+	s.method = true
+	defer c.enter(s)()
+	c.expr(value)
+}
@@ -0,0 +1,205 @@
+package check
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestProgram(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		in      string
+		globals []string
+		errs    []string
+	}{
+		{
+			name: "Valid",
+			in: `import "sys"
+class Greeter {
+	var greeting = "hello"
+	init(name) {
+		this.name = name
+	}
+	greet() {
+		sys.write(this.greeting)
+		return this.name
+	}
+}
+func main() {
+	var g = Greeter("world")
+	helper(g)
+	return
+}
+func helper(g) {
+	if g {
+		var x = g.greet()
+		x = g
+	}
+	return g
+}
+main()`,
+		},
+		{
+			name: "Undefined",
+			in: `var x = y
+func f(a) {
+	return b
+}
+z = 1
+print(x)`,
+			errs: []string{
+				"offset 8: undefined: y",
+				"offset 30: undefined: b",
+				"offset 34: undefined: z",
+				"offset 40: undefined: print",
+			},
+		},
+		{
+			name:    "Globals",
+			in:      `print(x)`,
+			globals: []string{"print", "x"},
+		},
+		{
+			name: "OutOfScope",
+			in: `func f() {
+	if f {
+		var x = 1
+	}
+	return x
+}`,
+			errs: []string{"offset 42: undefined: x"},
+		},
+		{
+			name: "Redeclared",
+			in: `var x = 1
+func x() {}
+func f(a, a) {
+	var a = 1
+	if a {
+		var a = 2
+	}
+}`,
+			errs: []string{
+				"offset 10: redeclared in this block: x",
+				"offset 32: redeclared in this block: a",
+				"offset 38: redeclared in this block: a",
+			},
+		},
+		{
+			name: "UsedBeforeDeclared",
+			in: `x
+var x = x
+func f() {
+	return y
+}
+var y = 1
+if y {
+	z
+}
+var z = 2`,
+			errs: []string{
+				"offset 0: used before declaration: x",
+				"offset 10: used before declaration: x",
+				"offset 53: used before declaration: z",
+			},
+		},
+		{
+			name: "DuplicateMember",
+			in: `class A {
+	var x = 1
+	x() {}
+	m() {}
+	m(a) {}
+}`,
+			errs: []string{
+				"offset 22: duplicate member: x",
+				"offset 38: duplicate member: m",
+			},
+		},
+		{
+			name: "SuperOutsideMethod",
+			in: `super.m()
+func f() {
+	return super.m()
+}
+class A {
+	var x = super.x()
+	m() {
+		return func() { return super.m() }
+	}
+}`,
+			errs: []string{
+				"offset 0: super used outside of a method",
+				"offset 29: super used outside of a method",
+			},
+		},
+		{
+			name: "ArgCount",
+			in: `func f(a, b) {
+	return g(a)
+}
+var g = func() {}
+class A {
+	init(x) {}
+}
+class B extends A {}
+class C {}
+f(1)
+A()
+B()
+C(1)
+var h = func(a) {}
+h = f
+h()`,
+			errs: []string{
+				"offset 23: wrong number of arguments to g: have 1, want 0",
+				"offset 104: wrong number of arguments to f: have 1, want 2",
+				"offset 109: wrong number of arguments to A: have 0, want 1",
+				"offset 117: wrong number of arguments to C: have 1, want 0",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := parser.Parse([]byte(test.in))
+			assert.Nil(t, err)
+
+			err = Config{Globals: test.globals}.Program(p)
+
+			var errs []string
+			if err != nil {
+				for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+					errs = append(errs, err.Error())
+				}
+			}
+			assert.Equal(t, errs, test.errs)
+		})
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	p, err := parser.Parse([]byte(`x`))
+	assert.Nil(t, err)
+
+	err = Program(p)
+	assert.True(t, errors.Is(err, ErrUndefined))
+
+	var cerr *Error
+	assert.True(t, errors.As(err, &cerr))
+	assert.Equal(t, cerr.Offset, 0)
+}
+
+func TestDeclared(t *testing.T) {
+	p, err := parser.Parse([]byte(`import "sys"
+var x = 1
+func f() {
+	var y = 2
+}
+class A {}
+x = 2
+func() {}`))
+	assert.Nil(t, err)
+	assert.Equal(t, Declared(p), []string{"sys", "x", "f", "A"})
+}
@@ -0,0 +1,521 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+)
+
+var (
+	ErrTypeMismatch = errors.New("type mismatch")
+	ErrUnknownType  = errors.New("unknown type")
+	ErrNoMethod     = errors.New("no such method")
+)
+
+// The type checker is gradual. Anything that is not annotated has the type Any, which can be used
+// as any other type, and any other type can be used as Any. So unannotated code is never at fault,
+// except when it calls a method that an object's class does not have.
+//
+// Local variables that are never assigned after they are declared take the type of the value
+// they are declared with. Calling a class gives an instance of the class, and the members of the
+// class are found from its declaration.
+
+// typ is what the type checker knows about the values of an expression.
+type typ interface {
+	String() string
+}
+
+// basicType is one of the types that have names of their own.
+type basicType string
+
+func (t basicType) String() string {
+	return string(t)
+}
+
+const (
+	anyType   basicType = "Any"
+	intType   basicType = "Int"
+	floatType basicType = "Float"
+	strType   basicType = "Str"
+)
+
+var basicTypes = map[string]typ{
+	"Any":   anyType,
+	"Int":   intType,
+	"Float": floatType,
+	"Str":   strType,
+}
+
+type funcType struct {
+	args   []typ
+	result typ
+}
+
+func (t *funcType) String() string {
+	args := make([]string, len(t.args))
+	for i, a := range t.args {
+		args[i] = a.String()
+	}
+	return "func(" + strings.Join(args, ", ") + ") -> " + t.result.String()
+}
+
+// classType is the type of the instances of a class.
+type classType struct {
+	name  string
+	super *classType
+
+	// open is set when the superclass is not known, so that the class might have members other
+	// than those it declares.
+	open bool
+
+	// fields include the members that methods assign to this, as well as those declared as fields
+	fields  map[string]typ
+	methods map[string]*funcType
+}
+
+func (t *classType) String() string {
+	if t.name == "" {
+		return "class"
+	}
+	return t.name
+}
+
+// metaType is the type of a class itself.
+type metaType struct {
+	class *classType
+}
+
+func (t metaType) String() string {
+	return "class " + t.class.String()
+}
+
+// consistent reports whether a value of one type can be used where another is expected.
+func consistent(from, to typ) bool {
+	if from == anyType || to == anyType {
+		return true
+	}
+	switch from := from.(type) {
+	case *classType:
+		for c := from; c != nil; c = c.super {
+			if c == to || c.open {
+				return true
+			}
+		}
+		return false
+
+	case *funcType:
+		to, ok := to.(*funcType)
+		if !ok || len(from.args) != len(to.args) {
+			return false
+		}
+		for i := range from.args {
+			if !consistent(to.args[i], from.args[i]) {
+				return false
+			}
+		}
+		return consistent(from.result, to.result)
+	}
+	return from == to
+}
+
+// member finds the type of a member of a class or its superclasses. It reports false if the
+// member might not exist.
+func (t *classType) member(name string) (typ, bool) {
+	for c := t; c != nil; c = c.super {
+		if m, ok := c.methods[name]; ok {
+			return m, true
+		}
+		if f, ok := c.fields[name]; ok {
+			return f, true
+		}
+		if c.open {
+			return anyType, true
+		}
+	}
+	return nil, false
+}
+
+// inherits reports whether a class is another or one of its subclasses.
+func (t *classType) inherits(from *classType) bool {
+	for c := t; c != nil; c = c.super {
+		if c == from {
+			return true
+		}
+	}
+	return false
+}
+
+// constructor gives the type of the init method that making an instance of the class calls, or
+// nil if it is not known.
+func (t *classType) constructor() *funcType {
+	for c := t; c != nil; c = c.super {
+		if m, ok := c.methods["init"]; ok {
+			return m
+		}
+		if c.open {
+			return nil
+		}
+	}
+	return &funcType{result: anyType}
+}
+
+type typer struct {
+	c        *checker
+	scope    *typeScope
+	assigned map[string]bool
+
+	// result is the annotated result type of the function being checked, if any
+	result typ
+}
+
+type typeScope struct {
+	outer *typeScope
+	vars  map[string]typ
+}
+
+func (s *typeScope) lookup(name string) typ {
+	for ; s != nil; s = s.outer {
+		if t, ok := s.vars[name]; ok {
+			return t
+		}
+	}
+	return anyType
+}
+
+func (c *checker) types(p ast.Program) {
+	t := &typer{c: c, assigned: map[string]bool{}}
+	ast.Inspect(p, func(n ast.Node) bool {
+		if a, ok := n.(ast.Assign); ok && a.Object == nil {
+			t.assigned[a.Name] = true
+		}
+		return true
+	})
+	t.block(p.Stmts)
+}
+
+func (t *typer) enter(vars map[string]typ) func() {
+	outer := t.scope
+	t.scope = &typeScope{outer: outer, vars: vars}
+	return func() { t.scope = outer }
+}
+
+// resolve finds the type that an annotation names. Types can be named before the classes they
+// name are declared, as long as they are declared in the same block or an enclosing one.
+func (t *typer) resolve(a ast.Type) typ {
+	if a == nil {
+		return anyType
+	}
+	name := a.(ast.TypeName).Name
+	if b, ok := basicTypes[name]; ok {
+		return b
+	}
+	if m, ok := t.scope.lookup(name).(metaType); ok {
+		return m.class
+	}
+	t.c.fail(a.Start(), fmt.Errorf("%w: %s", ErrUnknownType, name))
+	return anyType
+}
+
+// expect reports a value that is used where a value of another type is expected.
+func (t *typer) expect(e ast.Expr, got, want typ) {
+	if !consistent(got, want) {
+		t.c.fail(exprStart(e), fmt.Errorf("%w: cannot use %s as %s", ErrTypeMismatch, got, want))
+	}
+}
+
+func (t *typer) block(stmts []ast.Stmt) {
+	defer t.enter(map[string]typ{})()
+
+	// classes and functions declared by the block can be used anywhere in it
+	classes := map[string]*classType{}
+	for _, s := range stmts {
+		if s, ok := s.(ast.Class); ok && s.Name != "" {
+			classes[s.Name] = &classType{name: s.Name}
+			t.scope.vars[s.Name] = metaType{classes[s.Name]}
+		}
+	}
+	for _, s := range stmts {
+		if s, ok := s.(ast.Class); ok && s.Name != "" {
+			t.declareClass(classes[s.Name], s)
+		}
+	}
+	for _, s := range stmts {
+		if s, ok := s.(ast.Function); ok && s.Name != "" && !t.assigned[s.Name] {
+			t.scope.vars[s.Name] = t.signature(s.Args, s.Result)
+		}
+	}
+
+	for _, s := range stmts {
+		t.stmt(s, classes)
+	}
+}
+
+func (t *typer) signature(args []ast.Arg, result ast.Type) *funcType {
+	f := &funcType{args: make([]typ, len(args)), result: t.resolve(result)}
+	for i, a := range args {
+		f.args[i] = t.resolve(a.Type)
+	}
+	return f
+}
+
+// declareClass fills in the members of a class from its declaration.
+func (t *typer) declareClass(c *classType, decl ast.Class) {
+	c.fields = map[string]typ{}
+	c.methods = map[string]*funcType{}
+	if decl.Super != nil {
+		m, ok := t.exprType(decl.Super).(metaType)
+		if ok && !m.class.inherits(c) {
+			c.super = m.class
+		} else {
+			c.open = true
+		}
+	}
+	for _, m := range decl.Members {
+		switch m := m.(type) {
+		case ast.Field:
+			c.fields[m.Name] = t.resolve(m.Type)
+		case ast.Method:
+			c.methods[m.Name] = t.signature(m.Args, m.Result)
+		}
+	}
+	// methods can give instances members that are not declared as fields
+	for _, m := range decl.Members {
+		ast.Inspect(m, func(n ast.Node) bool {
+			a, ok := n.(ast.Assign)
+			if !ok {
+				return true
+			}
+			if this, ok := a.Object.(ast.VariableRef); ok && this.Var == "this" {
+				if _, ok := c.fields[a.Name]; !ok {
+					c.fields[a.Name] = anyType
+				}
+			}
+			return true
+		})
+	}
+}
+
+// exprType gives the type of an expression without checking it, for when it is checked elsewhere.
+func (t *typer) exprType(e ast.Expr) typ {
+	errs := len(t.c.errs)
+	res := t.expr(e)
+	t.c.errs = t.c.errs[:errs]
+	return res
+}
+
+func (t *typer) stmt(s ast.Stmt, classes map[string]*classType) {
+	switch s := s.(type) {
+	case ast.Import:
+		t.scope.vars[s.Name] = anyType
+
+	case ast.Variable:
+		value := t.expr(s.Value)
+		if m, ok := value.(metaType); ok && m.class.name == "" {
+			m.class.name = s.Name
+		}
+		switch {
+		case s.Type != nil:
+			declared := t.resolve(s.Type)
+			t.expect(s.Value, value, declared)
+			t.scope.vars[s.Name] = declared
+		case t.assigned[s.Name]:
+			t.scope.vars[s.Name] = anyType
+		default:
+			t.scope.vars[s.Name] = value
+		}
+
+	case ast.Assign:
+		if s.Object == nil {
+			t.expect(s.Value, t.expr(s.Value), t.scope.lookup(s.Name))
+			return
+		}
+		object := t.expr(s.Object)
+		value := t.expr(s.Value)
+		if c, ok := object.(*classType); ok {
+			if f, ok := c.member(s.Name); ok {
+				t.expect(s.Value, value, f)
+			}
+		}
+
+	case ast.Return:
+		value := t.expr(s.Value)
+		if t.result != nil {
+			t.expect(s.Value, value, t.result)
+		}
+
+	case ast.If:
+		t.expr(s.Cond)
+		t.block(s.Then)
+		t.block(s.Else)
+
+	case ast.Function:
+		if f, ok := t.scope.vars[s.Name].(*funcType); ok && s.Name != "" {
+			t.function(s.Args, f, s.Result != nil, s.Body, nil)
+			return
+		}
+		t.expr(s)
+
+	case ast.Class:
+		if s.Name != "" {
+			t.classBody(classes[s.Name], s)
+			return
+		}
+		t.expr(s)
+
+	case ast.Expr:
+		t.expr(s)
+	}
+}
+
+func (t *typer) expr(e ast.Expr) typ {
+	switch e := e.(type) {
+	case ast.IntConstant:
+		return intType
+
+	case ast.FltConstant:
+		return floatType
+
+	case ast.StringConstant:
+		return strType
+
+	case ast.VariableRef:
+		return t.scope.lookup(e.Var)
+
+	case ast.MemberAccess:
+		if c, ok := t.expr(e.Object).(*classType); ok {
+			if m, ok := c.member(e.Member); ok {
+				return m
+			}
+		}
+		return anyType
+
+	case ast.Call:
+		if access, ok := e.Method.(ast.MemberAccess); ok {
+			return t.methodCall(access, e.Args)
+		}
+		switch f := t.expr(e.Method).(type) {
+		case *funcType:
+			t.args(e.Args, f)
+			return f.result
+		case metaType:
+			if init := f.class.constructor(); init != nil {
+				t.args(e.Args, init)
+			} else {
+				t.args(e.Args, nil)
+			}
+			return f.class
+		}
+		t.args(e.Args, nil)
+		return anyType
+
+	case ast.SuperCall:
+		t.args(e.Args, nil)
+		return anyType
+
+	case ast.Function:
+		f := t.signature(e.Args, e.Result)
+		t.function(e.Args, f, e.Result != nil, e.Body, nil)
+		return f
+
+	case ast.Class:
+		// the class is named after the variable it is declared with, if any
+		c := &classType{}
+		t.declareClass(c, e)
+		t.classBody(c, e)
+		return metaType{c}
+	}
+	return anyType
+}
+
+// methodCall checks a call to a method of an object. Calls to methods of objects whose class is
+// known must name methods that the class has, and are checked against the method's arguments.
+func (t *typer) methodCall(access ast.MemberAccess, args []ast.Expr) typ {
+	c, ok := t.expr(access.Object).(*classType)
+	if !ok {
+		t.args(args, nil)
+		return anyType
+	}
+	m, ok := c.member(access.Member)
+	if !ok {
+		t.c.fail(access.Start(), fmt.Errorf("%w: %s.%s", ErrNoMethod, c, access.Member))
+		t.args(args, nil)
+		return anyType
+	}
+	f, ok := m.(*funcType)
+	if !ok {
+		t.args(args, nil)
+		return anyType
+	}
+	if len(args) != len(f.args) {
+		t.c.fail(access.Start(), fmt.Errorf("%w to %s.%s: have %d, want %d", ErrArgCount, c, access.Member, len(args), len(f.args)))
+		t.args(args, nil)
+		return f.result
+	}
+	t.args(args, f)
+	return f.result
+}
+
+// args checks the arguments to a call. Calls with the wrong number of arguments are reported
+// elsewhere, so only the types of the arguments to calls with the right number are checked.
+func (t *typer) args(args []ast.Expr, f *funcType) {
+	types := make([]typ, len(args))
+	for i, a := range args {
+		types[i] = t.expr(a)
+	}
+	if f == nil || len(args) != len(f.args) {
+		return
+	}
+	for i, a := range args {
+		t.expect(a, types[i], f.args[i])
+	}
+}
+
+// function checks the body of a function or method. The result is only checked if it is
+// annotated.
+func (t *typer) function(args []ast.Arg, f *funcType, annotated bool, body []ast.Stmt, this typ) {
+	vars := map[string]typ{}
+	if this != nil {
+		vars["this"] = this
+	}
+	for i, a := range args {
+		vars[a.Name] = f.args[i]
+	}
+	defer t.enter(vars)()
+
+	result := t.result
+	defer func() { t.result = result }()
+	t.result = nil
+	if annotated {
+		t.result = f.result
+	}
+
+	t.block(body)
+}
+
+func (t *typer) classBody(c *classType, decl ast.Class) {
+	for _, m := range decl.Members {
+		switch m := m.(type) {
+		case ast.Field:
+			restore := t.enter(map[string]typ{"this": c})
+			t.expect(m.Value, t.expr(m.Value), c.fields[m.Name])
+			restore()
+
+		case ast.Method:
+			t.function(m.Args, c.methods[m.Name], m.Result != nil, m.Body, c)
+		}
+	}
+}
+
+// exprStart finds where an expression's source starts. Calls and member accesses are positioned
+// at their punctuation, so the start of what they apply to is used instead.
+func exprStart(e ast.Expr) int {
+	switch e := e.(type) {
+	case ast.Call:
+		return exprStart(e.Method)
+	case ast.MemberAccess:
+		return exprStart(e.Object)
+	}
+	return e.Start()
+}
@@ -0,0 +1,159 @@
+package check
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestTypes(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		errs []string
+	}{
+		{
+			name: "Annotations",
+			in: `var x: Int = "a"
+func f(a: Int, b) -> Str {
+	return a
+}
+f("b", 1)
+f(1, "b")
+var g = func(c: Float) {}
+g(1.5)
+g(1)`,
+			errs: []string{
+				`offset 13: type mismatch: cannot use Str as Int`,
+				`offset 52: type mismatch: cannot use Int as Str`,
+				`offset 58: type mismatch: cannot use Str as Int`,
+				`offset 111: type mismatch: cannot use Int as Float`,
+			},
+		},
+		{
+			name: "Inference",
+			in: `var x = 1
+var y: Str = x
+var z = "a"
+z = 2
+var w: Int = z
+func f() -> Int {
+	var s = "a"
+	return s
+}`,
+			errs: []string{
+				`offset 23: type mismatch: cannot use Int as Str`,
+				`offset 97: type mismatch: cannot use Str as Int`,
+			},
+		},
+		{
+			name: "UnknownType",
+			in: `var x: Strr = 1
+func f(a: P) -> Q {}
+class P {}`,
+			errs: []string{
+				`offset 7: unknown type: Strr`,
+				`offset 32: unknown type: Q`,
+			},
+		},
+		{
+			name: "Methods",
+			in: `class Point {
+	var x: Int = 0
+	init(y) {
+		this.y = y
+	}
+	move(by: Int) -> Point {
+		this.x = by
+		return this
+	}
+}
+var p = Point(1)
+p.move(1).move("a")
+p.mvoe(1)
+p.move()
+p.x = "a"
+p.y = "a"
+p.y.anything()`,
+			errs: []string{
+				`offset 148: type mismatch: cannot use Str as Int`,
+				`offset 154: no such method: Point.mvoe`,
+				`offset 164: wrong number of arguments to Point.move: have 0, want 1`,
+				`offset 178: type mismatch: cannot use Str as Int`,
+			},
+		},
+		{
+			name: "Subclasses",
+			in: `import "shapes"
+class A {
+	m() {}
+}
+class B extends A {
+	n() {}
+}
+class C extends shapes.Shape {}
+var a: A = B()
+var b: B = A()
+B().m()
+B().o()
+C().o()
+var c: A = C()
+var anon = class {}
+anon().m()`,
+			errs: []string{
+				`offset 124: type mismatch: cannot use A as B`,
+				`offset 139: no such method: B.o`,
+				`offset 193: no such method: anon.m`,
+			},
+		},
+		{
+			name: "Cycle",
+			in: `class A extends B {}
+class B extends A {}
+A().m()`,
+			errs: []string{`offset 16: used before declaration: B`},
+		},
+		{
+			name: "Unannotated",
+			in: `import "sys"
+func loop(f) {
+	func step(a, i) {
+		if i.gt(a.size) {
+			return
+		}
+		f(a.get(i))
+		return step(a, i.add(1))
+	}
+	return func(a) {
+		return step(a, 0)
+	}
+}
+var x = 1
+x = "a"
+sys.write(x)`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := parser.Parse([]byte(test.in))
+			assert.Nil(t, err)
+
+			err = Program(p)
+
+			var errs []string
+			if err != nil {
+				for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+					errs = append(errs, err.Error())
+				}
+			}
+			assert.Equal(t, errs, test.errs)
+		})
+	}
+}
+
+func TestTypeErrorIs(t *testing.T) {
+	p, err := parser.Parse([]byte(`var x: Int = "a"`))
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(Program(p), ErrTypeMismatch))
+}
@@ -0,0 +1,234 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/compiler/asm"
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/check"
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/compiler/transform"
+)
+
+var (
+	ErrNotFound    = errors.New("package not found")
+	ErrImportCycle = errors.New("import cycle")
+)
+
+// Error is a problem with a package's source, which it carries so that the problem can be shown
+// in context.
+type Error struct {
+	Path   string
+	Source []byte
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// File extensions for package sources and package objects.
+const (
+	SourceExt = ".lync"
+	ObjectExt = ".lyo"
+)
+
+// Loader finds packages on a search path, compiling them as needed.
+//
+// Each entry on the path is the root of a tree of packages. The package "a/b" is either the source
+// file a/b.lync or the package object a/b.lyo in one of those trees. The entries are searched in
+// order, and where a tree holds both the source and the object the source is used.
+type Loader struct {
+	Path []fs.FS
+
+	// Builtin lists the packages that the runtime provides, which are not loaded.
+	Builtin []string
+
+	// Globals are defined before any package runs, and so can be used by packages without being
+	// declared.
+	Globals []string
+
+	// Options are used when compiling sources.
+	Options asm.Options
+
+	loaded  map[string]*lync.Package
+	loading map[string]bool
+	order   []*lync.Package
+}
+
+// Load finds the package with the given path along with everything that it imports, and gives
+// them in an order in which each package follows those that it imports.
+func (l *Loader) Load(path string) ([]*lync.Package, error) {
+	l.reset()
+	if err := l.load(path); err != nil {
+		return nil, err
+	}
+	return l.order, nil
+}
+
+// LoadSource compiles a package that is not on the search path, such as a program given on the
+// command line, and then loads what it imports as Load does.
+func (l *Loader) LoadSource(path string, src []byte) ([]*lync.Package, error) {
+	l.reset()
+	p, err := l.compile(path, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.add(p); err != nil {
+		return nil, err
+	}
+	return l.order, nil
+}
+
+func (l *Loader) reset() {
+	l.order = nil
+	if l.loaded == nil {
+		l.loaded = map[string]*lync.Package{}
+	}
+	l.loading = map[string]bool{}
+}
+
+func (l *Loader) load(path string) error {
+	if slices.Contains(l.Builtin, path) {
+		return nil
+	}
+	if p, ok := l.loaded[path]; ok {
+		if !slices.Contains(l.order, p) {
+			return l.add(p)
+		}
+		return nil
+	}
+	if l.loading[path] {
+		return fmt.Errorf("%s: %w", path, ErrImportCycle)
+	}
+	p, err := l.find(path)
+	if err != nil {
+		return err
+	}
+	return l.add(p)
+}
+
+// add loads the package's imports before the package itself.
+func (l *Loader) add(p *lync.Package) error {
+	l.loading[p.Path] = true
+	defer delete(l.loading, p.Path)
+
+	for _, imp := range p.Imports {
+		if err := l.load(imp); err != nil {
+			return fmt.Errorf("%s: %w", p.Path, err)
+		}
+	}
+	l.loaded[p.Path] = p
+	l.order = append(l.order, p)
+	return nil
+}
+
+func (l *Loader) find(path string) (*lync.Package, error) {
+	if !fs.ValidPath(path) {
+		return nil, fmt.Errorf("%q: %w", path, ErrNotFound)
+	}
+	for _, root := range l.Path {
+		src, err := fs.ReadFile(root, path+SourceExt)
+		if err == nil {
+			return l.compile(path, src)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		obj, err := fs.ReadFile(root, path+ObjectExt)
+		if err == nil {
+			p, err := DecodePackage(obj)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return p, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+}
+
+func (l *Loader) compile(path string, src []byte) (*lync.Package, error) {
+	prog, err := parser.Parse(src)
+	if err != nil {
+		return nil, &Error{Path: path, Source: src, Err: err}
+	}
+	if err := (check.Config{Globals: l.Globals}).Program(prog); err != nil {
+		return nil, &Error{Path: path, Source: src, Err: err}
+	}
+	unit, err := asm.AssembleProgram(path, transform.Program(ast.Erase(prog)), l.Options)
+	if err != nil {
+		return nil, &Error{Path: path, Source: src, Err: err}
+	}
+	return &lync.Package{
+		Path:    path,
+		Imports: imports(prog),
+		Exports: exports(prog),
+		Unit:    unit,
+	}, nil
+}
+
+func imports(p ast.Program) []string {
+	var res []string
+	for _, s := range p.Stmts {
+		if s, ok := s.(ast.Import); ok && !slices.Contains(res, s.Path) {
+			res = append(res, s.Path)
+		}
+	}
+	return res
+}
+
+// The globals that a package defines are exported. Imports are not.
+func exports(p ast.Program) []string {
+	var res []string
+	for _, s := range p.Stmts {
+		switch s := s.(type) {
+		case ast.Variable:
+			res = append(res, s.Name)
+		case ast.Function:
+			if s.Name != "" {
+				res = append(res, s.Name)
+			}
+		case ast.Class:
+			if s.Name != "" {
+				res = append(res, s.Name)
+			}
+		}
+	}
+	return res
+}
+
+// Link gives packages a shared symbol table, so that each symbol has the same ID in every unit.
+// The units are given in the same order as the packages. Each package keeps its own global
+// variables, so packages can export the same names.
+func Link(pkgs []*lync.Package) ([]lync.Unit, error) {
+	var symbols []string
+	for _, p := range pkgs {
+		for _, s := range p.Unit.Symbols {
+			if !slices.Contains(symbols, s) {
+				symbols = append(symbols, s)
+			}
+		}
+	}
+
+	units := make([]lync.Unit, len(pkgs))
+	for i, p := range pkgs {
+		u, err := asm.RemapSymbols(p.Unit, symbols)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Path, err)
+		}
+		units[i] = u
+	}
+	return units, nil
+}
@@ -0,0 +1,175 @@
+package loader
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/compiler/check"
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func TestPackageObject(t *testing.T) {
+	p := &lync.Package{
+		Path:    "a/b",
+		Imports: []string{"sys"},
+		Exports: []string{"f", "x"},
+		Unit: lync.Unit{
+			Registers: 2,
+			Code:      []byte("\x00asm"),
+			Symbols:   []string{"f", "write"},
+		},
+	}
+	buf, err := EncodePackage(p)
+	assert.Nil(t, err)
+
+	q, err := DecodePackage(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, q, p)
+
+	_, err = DecodePackage([]byte("\x00asm"))
+	assert.True(t, errors.Is(err, ErrNotPackage))
+
+	_, err = DecodePackage(buf[:len(buf)-1])
+	assert.True(t, errors.Is(err, ErrNotPackage))
+}
+
+func TestLoad(t *testing.T) {
+	var l Loader
+	util, err := l.LoadSource("util", []byte(`func twice(f) {
+		return func(x) { return f(f(x)) }
+	}`))
+	assert.Nil(t, err)
+	obj, err := EncodePackage(util[0])
+	assert.Nil(t, err)
+
+	src := fstest.MapFS{
+		"lib/greet.lync": {Data: []byte(`import "sys"
+			import "util"
+			var hello = "hello"
+			func greet(who) { return sys.write(who) }`)},
+		// the source is preferred over the object
+		"lib/greet.lyo": {Data: []byte("not an object")},
+	}
+	objs := fstest.MapFS{
+		"util.lyo": {Data: obj},
+	}
+
+	l = Loader{Path: []fs.FS{src, objs}, Builtin: []string{"sys"}}
+	pkgs, err := l.LoadSource("main", []byte(`import "lib/greet"
+		import "util"
+		greet.greet("world")`))
+	assert.Nil(t, err)
+
+	var paths []string
+	for _, p := range pkgs {
+		paths = append(paths, p.Path)
+	}
+	assert.Equal(t, paths, []string{"util", "lib/greet", "main"})
+	assert.Equal(t, pkgs[1].Imports, []string{"sys", "util"})
+	assert.Equal(t, pkgs[1].Exports, []string{"hello", "greet"})
+	assert.Equal(t, pkgs[0].Exports, []string{"twice"})
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		fs   fstest.MapFS
+		err  error
+	}{
+		{
+			name: "NotFound",
+			fs:   fstest.MapFS{},
+			err:  ErrNotFound,
+		},
+		{
+			name: "Cycle",
+			fs: fstest.MapFS{
+				"a.lync": {Data: []byte(`import "b"`)},
+				"b.lync": {Data: []byte(`import "a"`)},
+			},
+			err: ErrImportCycle,
+		},
+		{
+			name: "Undefined",
+			fs: fstest.MapFS{
+				"a.lync": {Data: []byte(`x`)},
+			},
+			err: check.ErrUndefined,
+		},
+		{
+			name: "BadObject",
+			fs: fstest.MapFS{
+				"a.lyo": {Data: []byte("lync")},
+			},
+			err: ErrNotPackage,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := Loader{Path: []fs.FS{test.fs}}
+			_, err := l.Load("a")
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLink(t *testing.T) {
+	l := Loader{Globals: []string{"x"}}
+	a, err := l.LoadSource("a", []byte(`x.foo()
+		x.bar()`))
+	assert.Nil(t, err)
+	b, err := l.LoadSource("b", []byte(`x.bar()
+		x.baz()`))
+	assert.Nil(t, err)
+
+	units, err := Link([]*lync.Package{a[0], b[0]})
+	assert.Nil(t, err)
+
+	for _, u := range units {
+		assert.Equal(t, u.Symbols, units[0].Symbols)
+	}
+	// both units look up bar with the same ID
+	bar := lync.Symbol(slices.Index(units[0].Symbols, "bar"))
+	for _, u := range units {
+		assert.True(t, slices.Contains(lookups(t, u), bar))
+	}
+	assert.False(t, slices.Contains(lookups(t, units[1]), lync.Symbol(slices.Index(units[0].Symbols, "foo"))))
+}
+
+func TestLinkSameExport(t *testing.T) {
+	var l Loader
+	a, err := l.LoadSource("a", []byte(`var x = 1`))
+	assert.Nil(t, err)
+	b, err := l.LoadSource("b", []byte(`func x() {}`))
+	assert.Nil(t, err)
+
+	units, err := Link([]*lync.Package{a[0], b[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, len(units), 2)
+}
+
+// lookups lists the selectors of the methods that a unit calls. The runtime's lookup function is
+// the unit's first import.
+func lookups(t *testing.T, u lync.Unit) []lync.Symbol {
+	t.Helper()
+	m, err := wasm.Decode(u.Code)
+	assert.Nil(t, err)
+
+	var res []lync.Symbol
+	for _, c := range m.Codes {
+		instrs, err := c.Decode()
+		assert.Nil(t, err)
+		for i, x := range instrs[1:] {
+			if x.Op == wasm.OpCall && x.Args[0] == 0 && instrs[i].Op == wasm.OpI64Const {
+				res = append(res, lync.Symbol(instrs[i].Args[0]))
+			}
+		}
+	}
+	return res
+}
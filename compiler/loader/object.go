@@ -0,0 +1,55 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/util/format"
+)
+
+var (
+	ErrNotPackage = errors.New("not a package object")
+	ErrVersion    = errors.New("unsupported package object version")
+)
+
+// Package objects start with a header that identifies them, followed by the package itself.
+type objectHeader struct {
+	Magic   [4]byte
+	Version uint
+}
+
+var objectMagic = [4]byte{'l', 'y', 'n', 'c'}
+
+const objectVersion = 1
+
+// EncodePackage renders a compiled package as a package object.
+func EncodePackage(p *lync.Package) ([]byte, error) {
+	buf, err := format.Marshal(objectHeader{Magic: objectMagic, Version: objectVersion})
+	if err != nil {
+		return nil, err
+	}
+	return format.MarshalInto(buf, *p)
+}
+
+// DecodePackage reads a package object.
+func DecodePackage(buf []byte) (*lync.Package, error) {
+	var h objectHeader
+	rest, err := format.UnmarshalFrom(buf, &h)
+	if err != nil || h.Magic != objectMagic {
+		return nil, ErrNotPackage
+	}
+	if h.Version != objectVersion {
+		return nil, fmt.Errorf("version %d: %w", h.Version, ErrVersion)
+	}
+
+	var p lync.Package
+	rest, err = format.UnmarshalFrom(rest, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotPackage, err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes: %w", len(rest), ErrNotPackage)
+	}
+	return &p, nil
+}
@@ -0,0 +1,232 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+)
+
+// attachComments gives the nodes of a program the comments that belong to them, working from the
+// tokens that the program was parsed from, comments and spaces included.
+//
+// A comment that follows something else on its line trails the largest node that ends with the
+// token before it, not counting a comma. Any other comment leads the largest node that starts with
+// the token after it. Comments that neither rule places, such as those just before a closing
+// bracket, go inside the smallest node around them, or at the end of the program.
+func attachComments(p ast.Program, toks []token) ast.Program {
+	a := newAttacher(toks)
+	if len(a.comments) == 0 {
+		return p
+	}
+
+	// find the nodes first, and then attach to them in the same order
+	var nodes []ast.Node
+	ast.Inspect(p, func(n ast.Node) bool {
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+		return true
+	})
+	owners := make([]*ast.Comments, len(nodes))
+	for _, c := range a.comments {
+		if !a.place(c, nodes, owners) {
+			p.Comments = append(p.Comments, c.Comment)
+		}
+	}
+
+	i := -1
+	return ast.Rewrite(p, func(c *ast.Cursor) bool {
+		if i++; owners[i] != nil {
+			c.Replace(ast.WithComments(owners[i], c.Node()))
+		}
+		return true
+	}, nil).(ast.Program)
+}
+
+type attacher struct {
+	// code holds the tokens other than comments and spaces, with the indexes of those that start
+	// at each offset and of the brackets that close those that open
+	code    []token
+	at      map[int]int
+	closing map[int]int
+
+	comments []comment
+}
+
+// comment is a comment along with the indexes of the code tokens either side of it.
+type comment struct {
+	ast.Comment
+	prev, next int
+	trailing   bool
+}
+
+func newAttacher(toks []token) *attacher {
+	a := &attacher{at: map[int]int{}, closing: map[int]int{}}
+	var open []int
+	lineStart := true
+	for _, t := range toks {
+		switch t.(type) {
+		case spaceTok:
+			lineStart = lineStart || strings.Contains(t.text(), "\n")
+			continue
+		case commentTok:
+			a.comments = append(a.comments, comment{
+				Comment:  ast.NodeAt(t.start(), ast.Comment{Text: t.text()}),
+				prev:     len(a.code) - 1,
+				next:     len(a.code),
+				trailing: !lineStart,
+			})
+		case openPTok, openBTok:
+			open = append(open, len(a.code))
+		case closePTok, closeBTok:
+			if len(open) != 0 {
+				a.closing[open[len(open)-1]] = len(a.code)
+				open = open[:len(open)-1]
+			}
+		}
+		lineStart = false
+		if _, ok := t.(commentTok); !ok {
+			a.at[t.start()] = len(a.code)
+			a.code = append(a.code, t)
+		}
+	}
+	return a
+}
+
+// place finds the node that a comment belongs to, reporting false if there is none.
+func (a *attacher) place(c comment, nodes []ast.Node, owners []*ast.Comments) bool {
+	// nodes come before the nodes inside them, so the first that matches is the largest
+	if c.trailing {
+		end := c.prev
+		if _, ok := a.code[end].(commaTok); ok {
+			end--
+		}
+		for i, n := range nodes {
+			if _, last, ok := a.span(n); ok && last == end {
+				owner(owners, i).Trailing = append(owner(owners, i).Trailing, c.Comment)
+				return true
+			}
+		}
+	}
+	for i, n := range nodes {
+		if first, _, ok := a.span(n); ok && first == c.next {
+			owner(owners, i).Leading = append(owner(owners, i).Leading, c.Comment)
+			return true
+		}
+	}
+	inner := -1
+	for i, n := range nodes {
+		if first, last, ok := a.span(n); ok && first <= c.prev && last >= c.next {
+			inner = i
+		}
+	}
+	if inner == -1 {
+		return false
+	}
+	owner(owners, inner).Inner = append(owner(owners, inner).Inner, c.Comment)
+	return true
+}
+
+func owner(owners []*ast.Comments, i int) *ast.Comments {
+	if owners[i] == nil {
+		owners[i] = &ast.Comments{}
+	}
+	return owners[i]
+}
+
+// span gives the indexes of the first and last code tokens of a node. Nodes that do not come from
+// the source, such as the value of a return without one, have no span.
+func (a *attacher) span(n ast.Node) (int, int, bool) {
+	first, ok := a.first(n)
+	if !ok {
+		return 0, 0, false
+	}
+	last, ok := a.last(n)
+	return first, last, ok && last >= first
+}
+
+func (a *attacher) first(n ast.Node) (int, bool) {
+	switch n := n.(type) {
+	case ast.Program:
+		return 0, false
+	case ast.Call:
+		return a.first(n.Method)
+	case ast.MemberAccess:
+		return a.first(n.Object)
+	case ast.Assign:
+		if n.Object != nil {
+			return a.first(n.Object)
+		}
+	case ast.VariableRef:
+		i, ok := a.at[n.Start()]
+		return i, ok && a.code[i].text() == n.Var
+	}
+	i, ok := a.at[n.Start()]
+	return i, ok
+}
+
+func (a *attacher) last(n ast.Node) (int, bool) {
+	i, ok := a.at[n.Start()]
+	if !ok {
+		return 0, false
+	}
+	switch n := n.(type) {
+	case ast.MemberAccess, ast.Import:
+		return i + 1, i+1 < len(a.code)
+
+	case ast.Call:
+		end, ok := a.closing[i]
+		return end, ok
+
+	case ast.SuperCall:
+		// super . name (
+		end, ok := a.closing[i+3]
+		return end, ok
+
+	case ast.Function, ast.Class, ast.Method, ast.If:
+		return a.body(i)
+
+	case ast.Arg:
+		if n.Type != nil {
+			return a.last(n.Type)
+		}
+
+	case ast.Variable:
+		return a.last(n.Value)
+
+	case ast.Field:
+		return a.last(n.Value)
+
+	case ast.Assign:
+		return a.last(n.Value)
+
+	case ast.Return:
+		if n.Value.Start() > n.Start() {
+			return a.last(n.Value)
+		}
+	}
+	return i, true
+}
+
+// body finds the brace that closes the body of something that starts at a token, skipping over
+// what is in brackets before it, such as the arguments of a function.
+func (a *attacher) body(from int) (int, bool) {
+	depth := 0
+	for i := from; i < len(a.code); i++ {
+		switch a.code[i].(type) {
+		case openPTok:
+			depth++
+		case closePTok:
+			depth--
+		case openBTok:
+			if depth == 0 {
+				end, ok := a.closing[i]
+				return end, ok
+			}
+			depth++
+		case closeBTok:
+			depth--
+		}
+	}
+	return 0, false
+}
@@ -62,55 +62,109 @@ func (t fltTok) value() float64 {
 type eqTok struct{ tokenData }
 type dotTok struct{ tokenData }
 type commaTok struct{ tokenData }
+type colonTok struct{ tokenData }
+type arrowTok struct{ tokenData }
 type openPTok struct{ tokenData }
 type closePTok struct{ tokenData }
 type openBTok struct{ tokenData }
 type closeBTok struct{ tokenData }
 type spaceTok struct{ tokenData }
 type newlineTok struct{ tokenData }
+type commentTok struct{ tokenData }
 
 // keywords
 
 type varTok struct{ tokenData }
 type classTok struct{ tokenData }
+type extendsTok struct{ tokenData }
+type superTok struct{ tokenData }
 type funcTok struct{ tokenData }
 type ifTok struct{ tokenData }
 type importTok struct{ tokenData }
 type returnTok struct{ tokenData }
 
 var keywords = map[string]text.TokenConstructor[token]{
-	"var":    tokenType[varTok],
-	"class":  tokenType[classTok],
-	"func":   tokenType[funcTok],
-	"if":     tokenType[ifTok],
-	"import": tokenType[importTok],
-	"return": tokenType[returnTok],
+	"var":     tokenType[varTok],
+	"class":   tokenType[classTok],
+	"extends": tokenType[extendsTok],
+	"super":   tokenType[superTok],
+	"func":    tokenType[funcTok],
+	"if":      tokenType[ifTok],
+	"import":  tokenType[importTok],
+	"return":  tokenType[returnTok],
 }
 
-func tokenize(src []byte) ([]token, error) {
+// tokenize gives the tokens that the parser works from, along with all of the tokens in the
+// source, comments and spaces included.
+func tokenize(src []byte) ([]token, []token, error) {
 	toks, err := lexer.Tokenize(src).Force()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+
+	// the lexer stops at the first thing that it does not recognise
+	end := 0
+	if len(toks) != 0 {
+		last := toks[len(toks)-1]
+		end = last.start() + len(last.text())
+	}
+	if end < len(src) {
+		return nil, nil, &Error{Offset: end, Message: "unrecognised input"}
+	}
+
+	res, _, err := layout(toks)
+	return res, toks, err
+}
+
+// Incomplete reports whether the source ends inside brackets, and so needs more input before it
+// can be parsed. Source with other problems is not incomplete, as more input will not help it.
+func Incomplete(src []byte) bool {
+	toks, err := lexer.Tokenize(src).Force()
+	if err != nil {
+		return false
+	}
+	_, context, err := layout(toks)
+	return err == nil && len(context) != 0
+}
+
+// layout removes whitespace, other than newlines that can end statements, and checks that
+// brackets are balanced. Comments are removed as well. It also gives the brackets that are still
+// open at the end.
+func layout(toks []token) ([]token, []token, error) {
 	var res []token
 	var context []token
 	for _, t := range toks {
 		switch t := t.(type) {
 		case spaceTok:
-			if tokenIsNewline(t, context) {
+			// a comment on a line of its own leaves a newline on either side of it
+			if tokenIsNewline(t, context) && !lastIsNewline(res) {
 				res = append(res, newlineTok(t))
 			}
+			continue
+		case commentTok:
+			continue
 		case openBTok, openPTok:
 			context = append(context, t)
 			res = append(res, t)
 		case closePTok, closeBTok:
+			if len(context) == 0 {
+				return nil, nil, unexpectedToken(t)
+			}
 			context = context[:len(context)-1]
 			res = append(res, t)
 		default:
 			res = append(res, t)
 		}
 	}
-	return res, nil
+	return res, context, nil
+}
+
+func lastIsNewline(toks []token) bool {
+	if len(toks) == 0 {
+		return false
+	}
+	_, ok := toks[len(toks)-1].(newlineTok)
+	return ok
 }
 
 func tokenIsNewline(t token, context []token) bool {
@@ -131,10 +185,13 @@ var lexer = must.Be(text.NewLexer(
 	text.Regex(`\d+`, tokenType[intTok]),
 	text.Regex(`\d+\.\d+`, tokenType[fltTok]),
 	text.Regex(`[a-zA-Z_]\w*`, tokenIdType),
+	text.Regex(`//[^\n]*`, tokenType[commentTok]),
 	text.Regex(`=`, tokenType[eqTok]),
 	text.Regex(`\s+`, tokenType[spaceTok]),
 	text.Regex(`\.`, tokenType[dotTok]),
 	text.Regex(`,`, tokenType[commaTok]),
+	text.Regex(`:`, tokenType[colonTok]),
+	text.Regex(`->`, tokenType[arrowTok]),
 	text.Regex(`\(`, tokenType[openPTok]),
 	text.Regex(`\)`, tokenType[closePTok]),
 	text.Regex(`{`, tokenType[openBTok]),
@@ -7,7 +7,7 @@ import (
 )
 
 func TestLex(t *testing.T) {
-	s, err := tokenize([]byte(`
+	s, _, err := tokenize([]byte(`
 
 {
 	var aVariable = object.method("an argument\n\"with quotes in\"", 123)
@@ -37,3 +37,20 @@ func TestLex(t *testing.T) {
 
 	t.Logf("%#v", s)
 }
+
+func TestIncomplete(t *testing.T) {
+	for _, test := range []struct {
+		in         string
+		incomplete bool
+	}{
+		{"f(x)", false},
+		{"func f(x) {", true},
+		{"func f(x) {\n\treturn g(x,\n", true},
+		{"func f(x) {\n\treturn x\n}", false},
+		{"f())", false},
+		{"f(", true},
+		{"", false},
+	} {
+		assert.Equal(t, Incomplete([]byte(test.in)), test.incomplete)
+	}
+}
@@ -1,18 +1,56 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"path"
 
 	"github.com/bobappleyard/lync/compiler/ast"
 	"github.com/bobappleyard/lync/util/text"
 )
 
+// Error is a syntax error, found at an offset into the source.
+type Error struct {
+	Offset  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Message)
+}
+
 func Parse(src []byte) (ast.Program, error) {
-	toks, err := tokenize(src)
+	toks, all, err := tokenize(src)
 	if err != nil {
 		return ast.Program{}, err
 	}
-	return parser.Parse(toks)
+	prog, err := parser.Parse(toks)
+	if err != nil {
+		return ast.Program{}, syntaxError(src, err)
+	}
+	return attachComments(prog, all), nil
+}
+
+func syntaxError(src []byte, err error) error {
+	var unexpected *text.UnexpectedToken
+	switch {
+	case errors.As(err, &unexpected):
+		if t, ok := unexpected.Token.(token); ok {
+			return unexpectedToken(t)
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &Error{Offset: len(src), Message: "unexpected end of input"}
+	}
+	return err
+}
+
+func unexpectedToken(t token) *Error {
+	switch t.(type) {
+	case newlineTok:
+		return &Error{Offset: t.start(), Message: "unexpected newline"}
+	}
+	return &Error{Offset: t.start(), Message: fmt.Sprintf("unexpected %q", t.text())}
 }
 
 type syntax struct {
@@ -34,11 +72,12 @@ func (syntax) ParseImport(imp importTok, importPath stringTok) ast.Stmt {
 	})
 }
 
-func (syntax) ParseFunctionStmt(fn funcTok, name idTok, args argList[ast.Arg], stmts block[ast.Stmt]) ast.Stmt {
+func (syntax) ParseFunctionStmt(fn funcTok, name idTok, args argList[ast.Arg], res result, stmts block[ast.Stmt]) ast.Stmt {
 	return ast.NodeAt(fn.start(), ast.Function{
-		Name: name.text(),
-		Args: args.items,
-		Body: stmts.stmts,
+		Name:   name.text(),
+		Args:   args.items,
+		Result: res.typ,
+		Body:   stmts.stmts,
 	})
 }
 
@@ -49,11 +88,20 @@ func (syntax) ParseClassStmt(class classTok, name idTok, members block[ast.Membe
 	})
 }
 
-func (syntax) ParseFunctionExpr(fn funcTok, args argList[ast.Arg], stmts block[ast.Stmt]) ast.Expr {
+func (syntax) ParseSubclassStmt(class classTok, name idTok, _ extendsTok, super ast.Expr, members block[ast.Member]) ast.Stmt {
+	return ast.NodeAt(class.start(), ast.Class{
+		Name:    name.text(),
+		Super:   super,
+		Members: members.stmts,
+	})
+}
+
+func (syntax) ParseFunctionExpr(fn funcTok, args argList[ast.Arg], res result, stmts block[ast.Stmt]) ast.Expr {
 	return ast.NodeAt(fn.start(), ast.Function{
-		Name: "",
-		Args: args.items,
-		Body: stmts.stmts,
+		Name:   "",
+		Args:   args.items,
+		Result: res.typ,
+		Body:   stmts.stmts,
 	})
 }
 
@@ -64,9 +112,18 @@ func (syntax) ParseClassExpr(class classTok, members block[ast.Member]) ast.Expr
 	})
 }
 
-func (syntax) ParseArg(arg idTok) ast.Arg {
+func (syntax) ParseSubclassExpr(class classTok, _ extendsTok, super ast.Expr, members block[ast.Member]) ast.Expr {
+	return ast.NodeAt(class.start(), ast.Class{
+		Name:    "",
+		Super:   super,
+		Members: members.stmts,
+	})
+}
+
+func (syntax) ParseArg(arg idTok, ann annotation) ast.Arg {
 	return ast.NodeAt(arg.start(), ast.Arg{
 		Name: arg.text(),
+		Type: ann.typ,
 	})
 }
 
@@ -82,9 +139,10 @@ func (syntax) ParseEmptyReturn(ret returnTok) ast.Stmt {
 	})
 }
 
-func (syntax) ParseVarDecl(v varTok, name idTok, _ eqTok, value ast.Expr) ast.Stmt {
+func (syntax) ParseVarDecl(v varTok, name idTok, ann annotation, _ eqTok, value ast.Expr) ast.Stmt {
 	return ast.NodeAt(v.start(), ast.Variable{
 		Name:  name.text(),
+		Type:  ann.typ,
 		Value: value,
 	})
 }
@@ -96,6 +154,14 @@ func (syntax) ParseVarAssign(name idTok, _ eqTok, value ast.Expr) ast.Stmt {
 	})
 }
 
+func (syntax) ParseMemberAssign(object ast.Expr, _ dotTok, name idTok, _ eqTok, value ast.Expr) ast.Stmt {
+	return ast.NodeAt(object.Start(), ast.Assign{
+		Object: object,
+		Name:   name.text(),
+		Value:  value,
+	})
+}
+
 func (syntax) ParseIf(ifT ifTok, cond ast.Expr, stmts block[ast.Stmt]) ast.Stmt {
 	return ast.NodeAt(ifT.start(), ast.If{
 		Cond: cond,
@@ -141,10 +207,58 @@ func (syntax) ParseCall(callable ast.Expr, args argList[ast.Expr]) ast.Expr {
 	})
 }
 
-func (syntax) ParseMethod(name idTok, args argList[ast.Arg], body block[ast.Stmt]) ast.Member {
+func (syntax) ParseSuperCall(s superTok, _ dotTok, id idTok, args argList[ast.Expr]) ast.Expr {
+	return ast.NodeAt(s.start(), ast.SuperCall{
+		Member: id.text(),
+		Args:   args.items,
+	})
+}
+
+func (syntax) ParseMethod(name idTok, args argList[ast.Arg], res result, body block[ast.Stmt]) ast.Member {
 	return ast.NodeAt(name.start(), ast.Method{
+		Name:   name.text(),
+		Args:   args.items,
+		Result: res.typ,
+		Body:   body.stmts,
+	})
+}
+
+func (syntax) ParseField(v varTok, name idTok, ann annotation, _ eqTok, value ast.Expr) ast.Member {
+	return ast.NodeAt(v.start(), ast.Field{
+		Name:  name.text(),
+		Type:  ann.typ,
+		Value: value,
+	})
+}
+
+// annotation is the optional type that follows the name of a variable, argument or field.
+type annotation struct {
+	typ ast.Type
+}
+
+func (syntax) ParseAnnotation(_ colonTok, t ast.Type) annotation {
+	return annotation{typ: t}
+}
+
+func (syntax) ParseNoAnnotation() annotation {
+	return annotation{}
+}
+
+// result is the optional type that follows the arguments of a function or method.
+type result struct {
+	typ ast.Type
+}
+
+func (syntax) ParseResult(_ arrowTok, t ast.Type) result {
+	return result{typ: t}
+}
+
+func (syntax) ParseNoResult() result {
+	return result{}
+}
+
+func (syntax) ParseTypeName(name idTok) ast.Type {
+	return ast.NodeAt(name.start(), ast.TypeName{
 		Name: name.text(),
-		Args: args.items,
-		Body: body.stmts,
 	})
 }
@@ -1,339 +1,385 @@
 package parser
 
 import (
+	"errors"
 	"slices"
 	"testing"
 
 	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/printer"
 	"github.com/bobappleyard/lync/util/assert"
 	"github.com/r3labs/diff"
 )
 
-func TestSyntax(t *testing.T) {
-	for _, test := range []struct {
-		name string
-		in   string
-		out  ast.Program
-	}{
-		{
-			name: "Empty",
-			in:   ``,
-			out:  ast.Program{},
-		},
-		{
-			name: "Import",
-			in:   `import "path"`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Import{
-						Name: "path",
-						Path: "path",
-					},
+var syntaxTests = []struct {
+	name string
+	in   string
+	out  ast.Program
+}{
+	{
+		name: "Empty",
+		in:   ``,
+		out:  ast.Program{},
+	},
+	{
+		name: "Import",
+		in:   `import "path"`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Import{
+					Name: "path",
+					Path: "path",
 				},
 			},
 		},
-		{
-			name: "ImportLeadingNewline",
-			in: `
+	},
+	{
+		name: "ImportLeadingNewline",
+		in: `
 			import "path"`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Import{
-						Name: "path",
-						Path: "path",
-					},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Import{
+					Name: "path",
+					Path: "path",
 				},
 			},
 		},
-		{
-			name: "ImportTwice",
-			in: `
+	},
+	{
+		name: "ImportTwice",
+		in: `
 			import "path"
 			import "path"`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Import{
-						Name: "path",
-						Path: "path",
-					},
-					ast.Import{
-						Name: "path",
-						Path: "path",
-					},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Import{
+					Name: "path",
+					Path: "path",
+				},
+				ast.Import{
+					Name: "path",
+					Path: "path",
 				},
 			},
 		},
-		{
-			name: "ImportNested",
-			in: `
+	},
+	{
+		name: "ImportNested",
+		in: `
 			import "path/of"`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Import{
-						Name: "of",
-						Path: "path/of",
-					},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Import{
+					Name: "of",
+					Path: "path/of",
 				},
 			},
 		},
-		{
-			name: "VarDecl",
-			in:   `var a = 1`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Variable{
-						Name:  "a",
-						Value: ast.IntConstant{Value: 1},
-					},
+	},
+	{
+		name: "VarDecl",
+		in:   `var a = 1`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Variable{
+					Name:  "a",
+					Value: ast.IntConstant{Value: 1},
 				},
 			},
 		},
-		{
-			name: "VarRef",
-			in:   `a`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.VariableRef{
-						Var: "a",
-					},
+	},
+	{
+		name: "VarRef",
+		in:   `a`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.VariableRef{
+					Var: "a",
 				},
 			},
 		},
-		{
-			name: "StringConstant",
-			in:   `"a"`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.StringConstant{
-						Value: "a",
-					},
+	},
+	{
+		name: "StringConstant",
+		in:   `"a"`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.StringConstant{
+					Value: "a",
 				},
 			},
 		},
-		{
-			name: "IntConstant",
-			in:   `1000`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.IntConstant{
-						Value: 1000,
-					},
+	},
+	{
+		name: "IntConstant",
+		in:   `1000`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.IntConstant{
+					Value: 1000,
 				},
 			},
 		},
-		{
-			name: "FloatConstant",
-			in:   `1.234`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.FltConstant{
-						Value: 1.234,
-					},
+	},
+	{
+		name: "FloatConstant",
+		in:   `1.234`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.FltConstant{
+					Value: 1.234,
 				},
 			},
 		},
-		{
-			name: "FunctionCall",
-			in:   `f(1, 2)`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Call{
-						Method: ast.VariableRef{Var: "f"},
-						Args: []ast.Expr{
-							ast.IntConstant{Value: 1},
-							ast.IntConstant{Value: 2},
-						},
+	},
+	{
+		name: "FunctionCall",
+		in:   `f(1, 2)`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Call{
+					Method: ast.VariableRef{Var: "f"},
+					Args: []ast.Expr{
+						ast.IntConstant{Value: 1},
+						ast.IntConstant{Value: 2},
 					},
 				},
 			},
 		},
-		{
-			name: "MethodCall",
-			in:   `object.method(1, 2)`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Call{
-						Method: ast.MemberAccess{
-							Object: ast.VariableRef{Var: "object"},
-							Member: "method",
-						},
-						Args: []ast.Expr{
-							ast.IntConstant{Value: 1},
-							ast.IntConstant{Value: 2},
-						},
+	},
+	{
+		name: "MethodCall",
+		in:   `object.method(1, 2)`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "object"},
+						Member: "method",
+					},
+					Args: []ast.Expr{
+						ast.IntConstant{Value: 1},
+						ast.IntConstant{Value: 2},
 					},
 				},
 			},
 		},
-		{
-			name: "HOFCall",
-			in:   `object.method(func(x) { return x })`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Call{
-						Method: ast.MemberAccess{
-							Object: ast.VariableRef{Var: "object"},
-							Member: "method",
-						},
-						Args: []ast.Expr{
-							ast.Function{
-								Args: []ast.Arg{{Name: "x"}},
-								Body: []ast.Stmt{
-									ast.Return{Value: ast.VariableRef{Var: "x"}},
-								},
+	},
+	{
+		name: "HOFCall",
+		in:   `object.method(func(x) { return x })`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "object"},
+						Member: "method",
+					},
+					Args: []ast.Expr{
+						ast.Function{
+							Args: []ast.Arg{{Name: "x"}},
+							Body: []ast.Stmt{
+								ast.Return{Value: ast.VariableRef{Var: "x"}},
 							},
 						},
 					},
 				},
 			},
 		},
-		{
-			name: "BasicFunction",
-			in:   `func f(a, b, c) { return a }`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Function{
-						Name: "f",
-						Args: []ast.Arg{{Name: "a"}, {Name: "b"}, {Name: "c"}},
-						Body: []ast.Stmt{
-							ast.Return{Value: ast.VariableRef{Var: "a"}},
-						},
+	},
+	{
+		name: "BasicFunction",
+		in:   `func f(a, b, c) { return a }`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Function{
+					Name: "f",
+					Args: []ast.Arg{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+					Body: []ast.Stmt{
+						ast.Return{Value: ast.VariableRef{Var: "a"}},
 					},
 				},
 			},
 		},
-		{
-			name: "BasicFunctionNoArgs",
-			in:   `func f() { return a }`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Function{
-						Name: "f",
-						Body: []ast.Stmt{
-							ast.Return{Value: ast.VariableRef{Var: "a"}},
-						},
+	},
+	{
+		name: "BasicFunctionNoArgs",
+		in:   `func f() { return a }`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Function{
+					Name: "f",
+					Body: []ast.Stmt{
+						ast.Return{Value: ast.VariableRef{Var: "a"}},
 					},
 				},
 			},
 		},
-		{
-			name: "MultiStmtFunction",
-			in: `func f(x) {
+	},
+	{
+		name: "MultiStmtFunction",
+		in: `func f(x) {
 				var y = 1
 				return a
 			}`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Function{
-						Name: "f",
-						Args: []ast.Arg{{Name: "x"}},
-						Body: []ast.Stmt{
-							ast.Variable{
-								Name:  "y",
-								Value: ast.IntConstant{Value: 1},
-							},
-							ast.Return{Value: ast.VariableRef{Var: "a"}},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Function{
+					Name: "f",
+					Args: []ast.Arg{{Name: "x"}},
+					Body: []ast.Stmt{
+						ast.Variable{
+							Name:  "y",
+							Value: ast.IntConstant{Value: 1},
 						},
+						ast.Return{Value: ast.VariableRef{Var: "a"}},
 					},
 				},
 			},
 		},
-		{
-			name: "FunctionMultilineArgs",
-			in: `func f(
+	},
+	{
+		name: "FunctionMultilineArgs",
+		in: `func f(
 				x,
 				y
 			) {
 				return x
 			}`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Function{
-						Name: "f",
-						Args: []ast.Arg{{Name: "x"}, {Name: "y"}},
-						Body: []ast.Stmt{
-							ast.Return{Value: ast.VariableRef{Var: "x"}},
-						},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Function{
+					Name: "f",
+					Args: []ast.Arg{{Name: "x"}, {Name: "y"}},
+					Body: []ast.Stmt{
+						ast.Return{Value: ast.VariableRef{Var: "x"}},
 					},
 				},
 			},
 		},
-		{
-			name: "FunctionNestedMultiline",
-			in: `func f(x) {
+	},
+	{
+		name: "FunctionNestedMultiline",
+		in: `func f(x) {
 				func g() {
 					var y = 1
 					return y
 				}
 				return x
 			}`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Function{
-						Name: "f",
-						Args: []ast.Arg{{Name: "x"}},
-						Body: []ast.Stmt{
-							ast.Function{
-								Name: "g",
-								Body: []ast.Stmt{
-									ast.Variable{
-										Name:  "y",
-										Value: ast.IntConstant{Value: 1},
-									},
-									ast.Return{Value: ast.VariableRef{Var: "y"}},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Function{
+					Name: "f",
+					Args: []ast.Arg{{Name: "x"}},
+					Body: []ast.Stmt{
+						ast.Function{
+							Name: "g",
+							Body: []ast.Stmt{
+								ast.Variable{
+									Name:  "y",
+									Value: ast.IntConstant{Value: 1},
 								},
+								ast.Return{Value: ast.VariableRef{Var: "y"}},
 							},
-							ast.Return{Value: ast.VariableRef{Var: "x"}},
 						},
+						ast.Return{Value: ast.VariableRef{Var: "x"}},
 					},
 				},
 			},
 		},
-		{
-			name: "If",
-			in: `if x {
+	},
+	{
+		name: "If",
+		in: `if x {
 				return 2
 			}`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.If{
-						Cond: ast.VariableRef{Var: "x"},
-						Then: []ast.Stmt{
-							ast.Return{
-								Value: ast.IntConstant{Value: 2},
-							},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.If{
+					Cond: ast.VariableRef{Var: "x"},
+					Then: []ast.Stmt{
+						ast.Return{
+							Value: ast.IntConstant{Value: 2},
 						},
 					},
 				},
 			},
 		},
-		{
-			name: "EmptyClass",
-			in:   `class A {}`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Class{
-						Name: "A",
-					},
+	},
+	{
+		name: "EmptyClass",
+		in:   `class A {}`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Class{
+					Name: "A",
 				},
 			},
 		},
-		{
-			name: "ClassWithMethod",
-			in: `class A {
+	},
+	{
+		name: "ClassWithMethod",
+		in: `class A {
 				name() {
 					return "A"
 				}
 			}`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Class{
-						Name: "A",
-						Members: []ast.Member{
-							ast.Method{
-								Name: "name",
-								Body: []ast.Stmt{
-									ast.Return{Value: ast.StringConstant{Value: "A"}},
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Class{
+					Name: "A",
+					Members: []ast.Member{
+						ast.Method{
+							Name: "name",
+							Body: []ast.Stmt{
+								ast.Return{Value: ast.StringConstant{Value: "A"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "ClassWithField",
+		in: `class A {
+				var x = 1
+			}`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Class{
+					Name: "A",
+					Members: []ast.Member{
+						ast.Field{Name: "x", Value: ast.IntConstant{Value: 1}},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "ClassWithConstructor",
+		in: `class A {
+				var x = 1
+				init(y) {
+					this.y = y
+				}
+			}`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Class{
+					Name: "A",
+					Members: []ast.Member{
+						ast.Field{Name: "x", Value: ast.IntConstant{Value: 1}},
+						ast.Method{
+							Name: "init",
+							Args: []ast.Arg{{Name: "y"}},
+							Body: []ast.Stmt{
+								ast.Assign{
+									Object: ast.VariableRef{Var: "this"},
+									Name:   "y",
+									Value:  ast.VariableRef{Var: "y"},
 								},
 							},
 						},
@@ -341,9 +387,127 @@ func TestSyntax(t *testing.T) {
 				},
 			},
 		},
-		{
-			name: "SemiRealProgram",
-			in: `
+	},
+	{
+		name: "VarAssign",
+		in:   `a = 1`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Assign{
+					Name:  "a",
+					Value: ast.IntConstant{Value: 1},
+				},
+			},
+		},
+	},
+	{
+		name: "MemberAssign",
+		in:   `a.b.c = 1`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Assign{
+					Object: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "a"},
+						Member: "b",
+					},
+					Name:  "c",
+					Value: ast.IntConstant{Value: 1},
+				},
+			},
+		},
+	},
+	{
+		name: "Subclass",
+		in: `class B extends A {
+				name() {
+					return super.name()
+				}
+			}`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Class{
+					Name:  "B",
+					Super: ast.VariableRef{Var: "A"},
+					Members: []ast.Member{
+						ast.Method{
+							Name: "name",
+							Body: []ast.Stmt{
+								ast.Return{Value: ast.SuperCall{Member: "name"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "SubclassExpr",
+		in:   `var B = class extends a.A {}`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Variable{
+					Name: "B",
+					Value: ast.Class{
+						Super: ast.MemberAccess{
+							Object: ast.VariableRef{Var: "a"},
+							Member: "A",
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "Annotations",
+		in: `var x: Int = 1
+func f(a: Str, b) -> Point {
+	return func(c: Float) -> Str {}
+}
+class Point {
+	var x: Float = 0.0
+	move(by: Point) -> Point {}
+}`,
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Variable{
+					Name:  "x",
+					Type:  ast.TypeName{Name: "Int"},
+					Value: ast.IntConstant{Value: 1},
+				},
+				ast.Function{
+					Name:   "f",
+					Args:   []ast.Arg{{Name: "a", Type: ast.TypeName{Name: "Str"}}, {Name: "b"}},
+					Result: ast.TypeName{Name: "Point"},
+					Body: []ast.Stmt{
+						ast.Return{
+							Value: ast.Function{
+								Args:   []ast.Arg{{Name: "c", Type: ast.TypeName{Name: "Float"}}},
+								Result: ast.TypeName{Name: "Str"},
+							},
+						},
+					},
+				},
+				ast.Class{
+					Name: "Point",
+					Members: []ast.Member{
+						ast.Field{
+							Name:  "x",
+							Type:  ast.TypeName{Name: "Float"},
+							Value: ast.FltConstant{Value: 0},
+						},
+						ast.Method{
+							Name:   "move",
+							Args:   []ast.Arg{{Name: "by", Type: ast.TypeName{Name: "Point"}}},
+							Result: ast.TypeName{Name: "Point"},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "SemiRealProgram",
+		in: `
 				import "array"
 
 				func loop(f) {
@@ -359,83 +523,82 @@ func TestSyntax(t *testing.T) {
 					}
 				}
 			`,
-			out: ast.Program{
-				Stmts: []ast.Stmt{
-					ast.Import{
-						Name: "array",
-						Path: "array",
-					},
-					ast.Function{
-						Name: "loop",
-						Args: []ast.Arg{{Name: "f"}},
-						Body: []ast.Stmt{
-							ast.Function{
-								Name: "step",
-								Args: []ast.Arg{{Name: "a"}, {Name: "i"}},
-								Body: []ast.Stmt{
-									ast.If{
-										Cond: ast.Call{
+		out: ast.Program{
+			Stmts: []ast.Stmt{
+				ast.Import{
+					Name: "array",
+					Path: "array",
+				},
+				ast.Function{
+					Name: "loop",
+					Args: []ast.Arg{{Name: "f"}},
+					Body: []ast.Stmt{
+						ast.Function{
+							Name: "step",
+							Args: []ast.Arg{{Name: "a"}, {Name: "i"}},
+							Body: []ast.Stmt{
+								ast.If{
+									Cond: ast.Call{
+										Method: ast.MemberAccess{
+											Object: ast.VariableRef{Var: "i"},
+											Member: "gt",
+										},
+										Args: []ast.Expr{
+											ast.MemberAccess{
+												Object: ast.VariableRef{Var: "a"},
+												Member: "size",
+											},
+										},
+									},
+									Then: []ast.Stmt{
+										ast.Return{
+											Value: ast.VariableRef{Var: "void"},
+										},
+									},
+								},
+								ast.Call{
+									Method: ast.VariableRef{Var: "f"},
+									Args: []ast.Expr{
+										ast.Call{
 											Method: ast.MemberAccess{
-												Object: ast.VariableRef{Var: "i"},
-												Member: "gt",
+												Object: ast.VariableRef{Var: "a"},
+												Member: "get",
 											},
 											Args: []ast.Expr{
-												ast.MemberAccess{
-													Object: ast.VariableRef{Var: "a"},
-													Member: "size",
-												},
-											},
-										},
-										Then: []ast.Stmt{
-											ast.Return{
-												Value: ast.VariableRef{Var: "void"},
+												ast.VariableRef{Var: "i"},
 											},
 										},
 									},
-									ast.Call{
-										Method: ast.VariableRef{Var: "f"},
+								},
+								ast.Return{
+									Value: ast.Call{
+										Method: ast.VariableRef{Var: "step"},
 										Args: []ast.Expr{
+											ast.VariableRef{Var: "a"},
 											ast.Call{
 												Method: ast.MemberAccess{
-													Object: ast.VariableRef{Var: "a"},
-													Member: "get",
+													Object: ast.VariableRef{Var: "i"},
+													Member: "plus",
 												},
 												Args: []ast.Expr{
-													ast.VariableRef{Var: "i"},
+													ast.IntConstant{Value: 1},
 												},
 											},
 										},
 									},
+								},
+							},
+						},
+						ast.Return{
+							Value: ast.Function{
+								Args: []ast.Arg{{Name: "a"}},
+								Body: []ast.Stmt{
 									ast.Return{
 										Value: ast.Call{
 											Method: ast.VariableRef{Var: "step"},
 											Args: []ast.Expr{
 												ast.VariableRef{Var: "a"},
-												ast.Call{
-													Method: ast.MemberAccess{
-														Object: ast.VariableRef{Var: "i"},
-														Member: "plus",
-													},
-													Args: []ast.Expr{
-														ast.IntConstant{Value: 1},
-													},
-												},
-											},
-										},
-									},
-								},
-							},
-							ast.Return{
-								Value: ast.Function{
-									Args: []ast.Arg{{Name: "a"}},
-									Body: []ast.Stmt{
-										ast.Return{
-											Value: ast.Call{
-												Method: ast.VariableRef{Var: "step"},
-												Args: []ast.Expr{
-													ast.VariableRef{Var: "a"},
-													ast.IntConstant{Value: 0},
-												},
+												ast.IntConstant{Value: 0},
 											},
 										},
 									},
@@ -446,26 +609,120 @@ func TestSyntax(t *testing.T) {
 				},
 			},
 		},
-	} {
+	},
+}
+
+func TestSyntax(t *testing.T) {
+	for _, test := range syntaxTests {
 		t.Run(test.name, func(t *testing.T) {
 			prog, err := Parse([]byte(test.in))
 
 			assert.Nil(t, err)
+			assertSameTree(t, prog, test.out)
+			t.Log(prog)
+		})
+	}
 
-			cl, _ := diff.Diff(test.out, prog)
-			for _, c := range cl {
-				if c.Type == "update" && len(c.Path) > 2 &&
-					slices.Equal([]string{"astNodeData", "s"}, c.Path[len(c.Path)-2:]) {
-					continue
-				}
-				if c.Type == "update" {
-					t.Errorf("at %v: %v -> %v", c.Path, c.From, c.To)
-				} else {
-					t.Error(c)
-				}
+}
+
+// The source printed for each program in the corpus parses back into the same program.
+func TestFormatRoundTrip(t *testing.T) {
+	for _, test := range syntaxTests {
+		t.Run(test.name, func(t *testing.T) {
+			src, err := printer.Format(test.out)
+			assert.Nil(t, err)
+			prog, err := Parse(src)
+			assert.Nil(t, err)
+			assertSameTree(t, prog, test.out)
+
+			again, err := printer.Format(prog)
+			assert.Nil(t, err)
+			assert.Equal(t, string(again), string(src))
+		})
+	}
+}
+
+// assertSameTree compares programs, ignoring where their nodes are.
+func assertSameTree(t *testing.T, got, expected ast.Program) {
+	t.Helper()
+
+	cl, _ := diff.Diff(expected, got)
+	for _, c := range cl {
+		if c.Type == "update" && len(c.Path) > 2 &&
+			slices.Equal([]string{"astNodeData", "s"}, c.Path[len(c.Path)-2:]) {
+			continue
+		}
+		if c.Type == "update" {
+			t.Errorf("at %v: %v -> %v", c.Path, c.From, c.To)
+		} else {
+			t.Error(c)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		err  Error
+	}{
+		{"UnexpectedToken", "var x = = 1", Error{Offset: 8, Message: `unexpected "="`}},
+		{"UnexpectedNewline", "var x =\nvar y = 1", Error{Offset: 7, Message: "unexpected newline"}},
+		{"UnexpectedEnd", "func f(x) {", Error{Offset: 11, Message: "unexpected end of input"}},
+		{"Unbalanced", "f())", Error{Offset: 3, Message: `unexpected ")"`}},
+		{"Unrecognised", "var x = 1 @ 2", Error{Offset: 10, Message: "unrecognised input"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.in))
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected a syntax error, got %v", err)
 			}
-			t.Log(prog)
+			assert.Equal(t, *perr, test.err)
 		})
 	}
+}
+
+func TestComments(t *testing.T) {
+	prog, err := Parse([]byte(`// leading
+var x = 1 // trailing
+func f() {
 
+	// inside
+	return g(x, // in the call
+		x)
+	// at the end
+}
+// last`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(prog.Stmts), 2)
+
+	f := prog.Stmts[1].(ast.Function)
+	ret := f.Body[0].(ast.Return)
+	call := ret.Value.(ast.Call)
+	for _, test := range []struct {
+		name string
+		node ast.Node
+		want *ast.Comments
+	}{
+		{"Variable", prog.Stmts[0], &ast.Comments{
+			Leading:  []ast.Comment{ast.NodeAt(0, ast.Comment{Text: "// leading"})},
+			Trailing: []ast.Comment{ast.NodeAt(21, ast.Comment{Text: "// trailing"})},
+		}},
+		{"Function", f, &ast.Comments{
+			Inner: []ast.Comment{ast.NodeAt(90, ast.Comment{Text: "// at the end"})},
+		}},
+		{"Return", ret, &ast.Comments{
+			Leading: []ast.Comment{ast.NodeAt(46, ast.Comment{Text: "// inside"})},
+		}},
+		{"Arg", call.Args[0], &ast.Comments{
+			Trailing: []ast.Comment{ast.NodeAt(69, ast.Comment{Text: "// in the call"})},
+		}},
+		{"Call", call, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, ast.CommentsOf(test.node), test.want)
+		})
+	}
+	assert.Equal(t, prog.Comments, []ast.Comment{ast.NodeAt(106, ast.Comment{Text: "// last"})})
 }
@@ -0,0 +1,593 @@
+// Package printer turns syntax trees back into Lync source, laid out in a canonical way.
+package printer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+)
+
+var (
+	ErrNoSyntax = errors.New("no syntax for node")
+)
+
+// Lines are kept within this width where possible, counting tabs as four columns.
+const (
+	maxWidth = 100
+	tabWidth = 4
+)
+
+// Config controls how programs are printed.
+type Config struct {
+	// Lowered allows the nodes that only appear once a program has been through
+	// transform.Program, as when showing how the program is lowered. Unit is printed as <unit>,
+	// names as #name and an If with an Else in the manner of most languages. The result cannot be
+	// parsed.
+	Lowered bool
+}
+
+// Format gives the source for a program. Statements are indented with tabs and calls whose
+// arguments do not fit on one line have one argument per line. Declarations are separated from
+// what is around them with blank lines.
+//
+// Comments are put back with the nodes that they belong to: on lines of their own before a node,
+// at the end of the line where it finishes, or before the bracket that closes it. Arguments and
+// parameters with comments among them are put one to a line.
+func Format(p ast.Program) ([]byte, error) {
+	return Config{}.Format(p)
+}
+
+// Format gives the source for a program, as the package-level Format does.
+func (c Config) Format(p ast.Program) ([]byte, error) {
+	pr := &printer{lowered: c.Lowered}
+	printList(pr, p.Stmts, stmtIsDecl, pr.stmt)
+	for _, c := range p.Comments {
+		if len(pr.out) != 0 {
+			pr.newline()
+		}
+		pr.write(c.Text)
+	}
+	if len(pr.out) != 0 {
+		pr.write("\n")
+	}
+	if pr.err != nil {
+		return nil, pr.err
+	}
+	return pr.out, nil
+}
+
+// Fprint writes the source for a program.
+func Fprint(w io.Writer, p ast.Program) error {
+	return Config{}.Fprint(w, p)
+}
+
+// Fprint writes the source for a program, as the package-level Fprint does.
+func (c Config) Fprint(w io.Writer, p ast.Program) error {
+	src, err := c.Format(p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+type printer struct {
+	out []byte
+	col int
+
+	// indent is how far lines are indented, and depth is how many braces enclose them. They
+	// differ inside calls whose arguments are on separate lines.
+	indent int
+	depth  int
+
+	// pending are comments for the end of the line, which are printed before anything else is.
+	pending []ast.Comment
+
+	lowered bool
+	err     error
+}
+
+func (p *printer) write(s string) {
+	if len(p.pending) != 0 && s != "" {
+		p.flush()
+		if s[0] != '\n' {
+			p.newline()
+		}
+	}
+	p.out = append(p.out, s...)
+	if i := strings.LastIndexByte(s, '\n'); i != -1 {
+		p.col = len(s) - i - 1
+	} else {
+		p.col += len(s)
+	}
+}
+
+func (p *printer) newline() {
+	p.write("\n")
+	p.write(strings.Repeat("\t", p.indent))
+	p.col = p.indent * tabWidth
+}
+
+// flush prints the comments that are pending at the end of the line, any after the first on lines
+// of their own.
+func (p *printer) flush() {
+	cs := p.pending
+	p.pending = nil
+	for i, c := range cs {
+		if i == 0 {
+			p.write(" ")
+		} else {
+			p.newline()
+		}
+		p.write(c.Text)
+	}
+}
+
+// comma ends an item in a list, before any comment that is pending for the end of the line.
+func (p *printer) comma() {
+	p.out = append(p.out, ',')
+	p.col++
+}
+
+func (p *printer) fail(n ast.Node) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%T: %w", n, ErrNoSyntax)
+	}
+}
+
+// printList prints the items of a program or a block, each on its own line.
+func printList[T ast.Node](p *printer, items []T, blank func(T) bool, item func(T)) {
+	for i, x := range items {
+		if i != 0 || p.depth != 0 {
+			if i != 0 && (blank(items[i-1]) || blank(x)) {
+				p.write("\n")
+			}
+			p.newline()
+		}
+		item(x)
+	}
+}
+
+// printBlock prints a list of items in braces, followed by the comments that were inside the
+// braces after the last item.
+func printBlock[T ast.Node](p *printer, items []T, inner []ast.Comment, blank func(T) bool, item func(T)) {
+	p.write("{")
+	p.indent++
+	p.depth++
+	printList(p, items, blank, item)
+	for _, c := range inner {
+		p.newline()
+		p.write(c.Text)
+	}
+	p.depth--
+	p.indent--
+	if len(items) != 0 || len(inner) != 0 {
+		p.newline()
+	}
+	p.write("}")
+}
+
+// commented prints a node along with its comments. Those inside nodes that have brackets are left
+// for the node to print, and those inside other nodes are kept for the end of the line.
+func (p *printer) commented(n ast.Node, print func()) {
+	c := ast.CommentsOf(n)
+	if c == nil {
+		print()
+		return
+	}
+	for _, l := range c.Leading {
+		p.write(l.Text)
+		p.newline()
+	}
+	print()
+	switch n.(type) {
+	case ast.Call, ast.SuperCall, ast.Function, ast.Class, ast.Method, ast.If:
+	default:
+		p.pending = append(p.pending, c.Inner...)
+	}
+	p.pending = append(p.pending, c.Trailing...)
+}
+
+// inner gives the comments inside a node, before the bracket that closes it.
+func inner(n ast.Node) []ast.Comment {
+	if c := ast.CommentsOf(n); c != nil {
+		return c.Inner
+	}
+	return nil
+}
+
+// hasComments reports whether there are comments anywhere among some nodes.
+func hasComments[T ast.Node](ns []T) bool {
+	found := false
+	for _, n := range ns {
+		ast.Inspect(n, func(n ast.Node) bool {
+			found = found || n != nil && ast.CommentsOf(n) != nil
+			return !found
+		})
+	}
+	return found
+}
+
+func stmtIsDecl(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case ast.Function:
+		return s.Name != ""
+	case ast.Class:
+		return s.Name != ""
+	}
+	return false
+}
+
+func memberIsDecl(m ast.Member) bool {
+	_, ok := m.(ast.Method)
+	return ok
+}
+
+func neverBlank[T any](T) bool {
+	return false
+}
+
+func (p *printer) stmt(s ast.Stmt) {
+	if e, ok := s.(ast.Expr); ok {
+		p.expr(e)
+		return
+	}
+	p.commented(s, func() { p.stmtSyntax(s) })
+}
+
+func (p *printer) stmtSyntax(s ast.Stmt) {
+	switch s := s.(type) {
+	case ast.Import:
+		if s.Name != path.Base(s.Path) {
+			p.fail(s)
+		}
+		p.write("import ")
+		p.write(strconv.Quote(s.Path))
+
+	case ast.Variable:
+		p.write("var ")
+		p.write(s.Name)
+		p.annotation(s.Type)
+		p.write(" = ")
+		p.expr(s.Value)
+
+	case ast.Assign:
+		if s.Object != nil {
+			p.expr(s.Object)
+			p.write(".")
+		}
+		p.write(s.Name)
+		p.write(" = ")
+		p.expr(s.Value)
+
+	case ast.Return:
+		p.write("return")
+		// a return without a value gives void
+		if v, ok := s.Value.(ast.VariableRef); ok && v.Var == "void" {
+			return
+		}
+		p.write(" ")
+		p.expr(s.Value)
+
+	case ast.If:
+		if len(s.Else) != 0 && !p.lowered {
+			p.fail(s)
+		}
+		p.write("if ")
+		p.expr(s.Cond)
+		p.write(" ")
+		printBlock(p, s.Then, inner(s), neverBlank, p.stmt)
+		if len(s.Else) != 0 {
+			p.write(" else ")
+			printBlock(p, s.Else, nil, neverBlank, p.stmt)
+		}
+
+	default:
+		p.fail(s)
+	}
+}
+
+func (p *printer) expr(e ast.Expr) {
+	p.commented(e, func() { p.exprSyntax(e) })
+}
+
+func (p *printer) exprSyntax(e ast.Expr) {
+	switch e := e.(type) {
+	case ast.StringConstant:
+		p.write(strconv.Quote(e.Value))
+
+	case ast.IntConstant:
+		// there is no syntax for negative numbers
+		if e.Value < 0 && !p.lowered {
+			p.fail(e)
+		}
+		p.write(strconv.Itoa(e.Value))
+
+	case ast.FltConstant:
+		if (e.Value < 0 || math.IsInf(e.Value, 0) || math.IsNaN(e.Value)) && !p.lowered {
+			p.fail(e)
+		}
+		p.write(formatFloat(e.Value))
+
+	case ast.Unit:
+		if !p.lowered {
+			p.fail(e)
+		}
+		p.write("<unit>")
+
+	case ast.Name:
+		if !p.lowered {
+			p.fail(e)
+		}
+		p.write("#")
+		p.write(e.Name)
+
+	case ast.VariableRef:
+		p.write(e.Var)
+
+	case ast.MemberAccess:
+		p.expr(e.Object)
+		p.write(".")
+		p.write(e.Member)
+
+	case ast.Call:
+		p.expr(e.Method)
+		p.args(e.Args, inner(e))
+
+	case ast.SuperCall:
+		p.write("super.")
+		p.write(e.Member)
+		p.args(e.Args, inner(e))
+
+	case ast.Function:
+		p.write("func")
+		if e.Name != "" {
+			p.write(" ")
+			p.write(e.Name)
+		}
+		p.params(e.Args)
+		p.result(e.Result)
+		p.write(" ")
+		printBlock(p, e.Body, inner(e), neverBlank, p.stmt)
+
+	case ast.Class:
+		p.write("class")
+		if e.Name != "" {
+			p.write(" ")
+			p.write(e.Name)
+		}
+		if e.Super != nil {
+			p.write(" extends ")
+			p.expr(e.Super)
+		}
+		p.write(" ")
+		printBlock(p, e.Members, inner(e), memberIsDecl, p.member)
+
+	default:
+		p.fail(e)
+	}
+}
+
+func (p *printer) member(m ast.Member) {
+	p.commented(m, func() { p.memberSyntax(m) })
+}
+
+func (p *printer) memberSyntax(m ast.Member) {
+	switch m := m.(type) {
+	case ast.Method:
+		p.write(m.Name)
+		p.params(m.Args)
+		p.result(m.Result)
+		p.write(" ")
+		printBlock(p, m.Body, inner(m), neverBlank, p.stmt)
+
+	case ast.Field:
+		p.write("var ")
+		p.write(m.Name)
+		p.annotation(m.Type)
+		p.write(" = ")
+		p.expr(m.Value)
+
+	default:
+		p.fail(m)
+	}
+}
+
+// params prints the parameters of a function or method on one line, unless there are comments among
+// them.
+func (p *printer) params(args []ast.Arg) {
+	if !hasComments(args) {
+		p.write(formatParams(args))
+		return
+	}
+	printItems(p, args, nil, func(a ast.Arg) {
+		p.commented(a, func() {
+			p.write(a.Name)
+			p.annotation(a.Type)
+		})
+	})
+}
+
+func (p *printer) annotation(t ast.Type) {
+	if t != nil {
+		p.write(": ")
+		p.typ(t)
+	}
+}
+
+func (p *printer) result(t ast.Type) {
+	if t != nil {
+		p.write(" -> ")
+		p.typ(t)
+	}
+}
+
+func (p *printer) typ(t ast.Type) {
+	p.commented(t, func() { p.write(formatType(t)) })
+}
+
+// args prints the arguments to a call on the same line as the call if they fit, and otherwise
+// one to a line, as they are if there are comments among them.
+func (p *printer) args(args []ast.Expr, inner []ast.Comment) {
+	if len(inner) != 0 || hasComments(args) {
+		printItems(p, args, inner, p.expr)
+		return
+	}
+	if w, _ := argsWidth(args); len(args) == 0 || p.col+w <= maxWidth {
+		p.write("(")
+		for i, a := range args {
+			if i != 0 {
+				p.write(", ")
+			}
+			p.expr(a)
+		}
+		p.write(")")
+		return
+	}
+	printItems(p, args, nil, p.expr)
+}
+
+// printItems prints a list of items in brackets one to a line, followed by the comments that were
+// inside the brackets after the last item.
+func printItems[T ast.Node](p *printer, items []T, inner []ast.Comment, item func(T)) {
+	p.write("(")
+	p.indent++
+	for i, x := range items {
+		p.newline()
+		item(x)
+		if i+1 < len(items) {
+			p.comma()
+		}
+	}
+	for _, c := range inner {
+		p.newline()
+		p.write(c.Text)
+	}
+	p.indent--
+	p.newline()
+	p.write(")")
+}
+
+// lineWidth measures an expression up to the end of its first line, supposing that the arguments
+// to any calls are kept on one line. It also reports whether the expression ends on that line.
+func lineWidth(e ast.Expr) (int, bool) {
+	switch e := e.(type) {
+	case ast.StringConstant:
+		return len(strconv.Quote(e.Value)), true
+
+	case ast.IntConstant:
+		return len(strconv.Itoa(e.Value)), true
+
+	case ast.FltConstant:
+		return len(formatFloat(e.Value)), true
+
+	case ast.VariableRef:
+		return len(e.Var), true
+
+	case ast.Unit:
+		return len("<unit>"), true
+
+	case ast.Name:
+		return len("#") + len(e.Name), true
+
+	case ast.MemberAccess:
+		w, done := lineWidth(e.Object)
+		if !done {
+			return w, false
+		}
+		return w + 1 + len(e.Member), true
+
+	case ast.Call:
+		w, done := lineWidth(e.Method)
+		if !done {
+			return w, false
+		}
+		aw, done := argsWidth(e.Args)
+		return w + aw, done
+
+	case ast.SuperCall:
+		w, done := argsWidth(e.Args)
+		return len("super.") + len(e.Member) + w, done
+
+	case ast.Function:
+		w := len("func") + len(formatParams(e.Args)) + len(formatResult(e.Result)) + len(" {")
+		if e.Name != "" {
+			w += 1 + len(e.Name)
+		}
+		return w, false
+
+	case ast.Class:
+		w := len("class")
+		if e.Name != "" {
+			w += 1 + len(e.Name)
+		}
+		if e.Super != nil {
+			sw, done := lineWidth(e.Super)
+			if !done {
+				return w + len(" extends ") + sw, false
+			}
+			w += len(" extends ") + sw
+		}
+		return w + len(" {"), false
+	}
+	return 0, true
+}
+
+func argsWidth(args []ast.Expr) (int, bool) {
+	w := len("(")
+	for i, a := range args {
+		if i != 0 {
+			w += len(", ")
+		}
+		aw, done := lineWidth(a)
+		w += aw
+		if !done {
+			return w, false
+		}
+	}
+	return w + len(")"), true
+}
+
+func formatParams(args []ast.Arg) string {
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = a.Name + formatAnnotation(a.Type)
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+func formatAnnotation(t ast.Type) string {
+	if t == nil {
+		return ""
+	}
+	return ": " + formatType(t)
+}
+
+func formatResult(t ast.Type) string {
+	if t == nil {
+		return ""
+	}
+	return " -> " + formatType(t)
+}
+
+func formatType(t ast.Type) string {
+	switch t := t.(type) {
+	case ast.TypeName:
+		return t.Name
+	}
+	return ""
+}
+
+func formatFloat(x float64) string {
+	s := strconv.FormatFloat(x, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
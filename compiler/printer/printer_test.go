@@ -0,0 +1,224 @@
+package printer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "Empty",
+			in:   "",
+			out:  "",
+		},
+		{
+			name: "Indentation",
+			in: `import "sys"
+   var x = 1
+func f(a,b) { if a {
+ return b
+}
+return
+}`,
+			out: `import "sys"
+var x = 1
+
+func f(a, b) {
+	if a {
+		return b
+	}
+	return
+}
+`,
+		},
+		{
+			name: "EmptyBlocks",
+			in: `func f() {
+}
+class A extends B {}`,
+			out: `func f() {}
+
+class A extends B {}
+`,
+		},
+		{
+			name: "Class",
+			in: `class A {
+	var x = 1
+	var y = 2
+	get() { return this.x }
+	set(x) {
+		this.x = x
+	}
+}`,
+			out: `class A {
+	var x = 1
+	var y = 2
+
+	get() {
+		return this.x
+	}
+
+	set(x) {
+		this.x = x
+	}
+}
+`,
+		},
+		{
+			name: "Constants",
+			in:   `f("a\tb", 12, 1.50, 2.0)`,
+			out:  "f(\"a\\tb\", 12, 1.5, 2.0)\n",
+		},
+		{
+			name: "WrappedArgs",
+			in:   `object.method(aVeryLongArgumentName, anotherVeryLongArgumentName, yetAnotherVeryLongArgumentName, g(x))`,
+			out: `object.method(
+	aVeryLongArgumentName,
+	anotherVeryLongArgumentName,
+	yetAnotherVeryLongArgumentName,
+	g(x)
+)
+`,
+		},
+		{
+			name: "FunctionArg",
+			in: `each(xs, func(x) {
+	return f(x)
+})`,
+			out: `each(xs, func(x) {
+	return f(x)
+})
+`,
+		},
+		{
+			name: "Comments",
+			in: `// leading
+var x = 1 // trailing
+
+// before f
+func f() {
+	// inside
+	return x   // after return
+	// at the end
+} // after f
+// last`,
+			out: `// leading
+var x = 1 // trailing
+
+// before f
+func f() {
+	// inside
+	return x // after return
+	// at the end
+} // after f
+// last
+`,
+		},
+		{
+			name: "CommentsInEmptyBlock",
+			in: `func f() {
+	// nothing yet
+}
+func g() {
+	// in g
+	return 1
+}`,
+			out: `func f() {
+	// nothing yet
+}
+
+func g() {
+	// in g
+	return 1
+}
+`,
+		},
+		{
+			name: "CommentsInParams",
+			in: `func f(a, // first
+	b) {}`,
+			out: `func f(
+	a, // first
+	b
+) {}
+`,
+		},
+		{
+			name: "CommentsInArgs",
+			in: `sys.write(f(1,
+	// between args
+	2, g(3) // after g
+	// at the end
+))`,
+			out: `sys.write(
+	f(
+		1,
+		// between args
+		2,
+		g(3) // after g
+		// at the end
+	)
+)
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := parser.Parse([]byte(test.in))
+			assert.Nil(t, err)
+			out, err := Format(p)
+			assert.Nil(t, err)
+			assert.Equal(t, string(out), test.out)
+
+			// formatting is idempotent
+			p, err = parser.Parse(out)
+			assert.Nil(t, err)
+			again, err := Format(p)
+			assert.Nil(t, err)
+			assert.Equal(t, string(again), test.out)
+		})
+	}
+}
+
+func TestFormatNoSyntax(t *testing.T) {
+	for _, p := range []ast.Program{
+		{Stmts: []ast.Stmt{ast.Unit{}}},
+		{Stmts: []ast.Stmt{ast.IntConstant{Value: -1}}},
+		{Stmts: []ast.Stmt{ast.If{Cond: ast.VariableRef{Var: "x"}, Else: []ast.Stmt{ast.Unit{}}}}},
+	} {
+		_, err := Format(p)
+		assert.True(t, errors.Is(err, ErrNoSyntax))
+	}
+}
+
+func TestFormatLowered(t *testing.T) {
+	p := ast.Program{Stmts: []ast.Stmt{
+		ast.Call{
+			Method: ast.MemberAccess{Object: ast.Unit{}, Member: "global_define"},
+			Args:   []ast.Expr{ast.Name{Name: "x"}, ast.IntConstant{Value: -1}},
+		},
+		ast.If{
+			Cond: ast.VariableRef{Var: "x"},
+			Then: []ast.Stmt{ast.Return{Value: ast.IntConstant{Value: 1}}},
+			Else: []ast.Stmt{ast.Return{Value: ast.IntConstant{Value: 2}}},
+		},
+	}}
+	out, err := Config{Lowered: true}.Format(p)
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), `<unit>.global_define(#x, -1)
+if x {
+	return 1
+} else {
+	return 2
+}
+`)
+}
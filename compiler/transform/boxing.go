@@ -18,9 +18,18 @@ type boxing struct {
 	args  *data.Set[string]
 }
 
+// boxScopeAnalyzer finds the variables that need boxes. Closures are given copies of the variables
+// they capture, so a variable needs a box when a closure assigns to it, or when it is assigned after
+// a closure that might still be called has captured it. A variable also needs a box if it can be
+// referred to before it is declared.
+//
+// Not every closure needs the variables it captures to be boxed. A function that is called as soon
+// as it is made has finished before anything after it is assigned. A local variable whose function
+// is only ever called is dealt with by inlineLocalFunctions, so that its calls are of this kind.
 type boxScopeAnalyzer struct {
 	fallbackAnalyzer
 	inClosure bool
+	escaping  bool
 	locals    *data.Set[string]
 	referred  *data.Set[string]
 	captured  *data.Set[string]
@@ -28,6 +37,7 @@ type boxScopeAnalyzer struct {
 }
 
 func (b *boxing) transformBlock(stmts []ast.Stmt) []ast.Stmt {
+	stmts = inlineLocalFunctions(stmts)
 	needBoxes := b.needBoxes(stmts)
 	var res []ast.Stmt
 	declared := blockVars(stmts)
@@ -96,16 +106,88 @@ func (b *boxing) transformExpr(expr ast.Expr) ast.Expr {
 			boxed: b.boxed,
 			args:  args,
 		}
-		return ast.Function{
+		return ast.NodeAt(expr.Start(), ast.Function{
+			Name: expr.Name,
 			Args: expr.Args,
 			Body: inner.transformBlock(expr.Body),
-		}
+		})
 
 	default:
 		return b.fallbackTransformer.transformExpr(expr)
 	}
 }
 
+// inlineLocalFunctions puts the function that a variable is declared with in place of the variable
+// where it is called, when all that is done with the variable is to call it later in the same
+// block. The function is then made where it is called, and sees what has been assigned before the
+// call without the variables that it captures needing boxes.
+func inlineLocalFunctions(stmts []ast.Stmt) []ast.Stmt {
+	for i := 0; i < len(stmts); i++ {
+		v, ok := stmts[i].(ast.Variable)
+		if !ok {
+			continue
+		}
+		f, ok := v.Value.(ast.Function)
+		if !ok || uses([]ast.Stmt{f}, v.Name) || uses(stmts[:i], v.Name) {
+			continue
+		}
+		calls := &localCalls{name: v.Name, fn: f, only: true}
+		rest := data.MapSlice(stmts[i+1:], withFallbackTransformer(calls).transformStmt)
+		if !calls.only || calls.count == 0 {
+			continue
+		}
+		stmts = append(stmts[:i:i], rest...)
+		i--
+	}
+	return stmts
+}
+
+// localCalls replaces calls to a local variable with calls to the function that it was declared
+// with. It reports whether the calls are the only uses of the variable, other than in blocks and
+// functions, which are left alone.
+type localCalls struct {
+	fallbackTransformer
+	name  string
+	fn    ast.Function
+	only  bool
+	count int
+}
+
+func (c *localCalls) transformBlock(stmts []ast.Stmt) []ast.Stmt {
+	c.only = c.only && !uses(stmts, c.name)
+	return stmts
+}
+
+func (c *localCalls) transformStmt(stmt ast.Stmt) ast.Stmt {
+	if a, ok := stmt.(ast.Assign); ok && a.Object == nil && a.Name == c.name {
+		c.only = false
+	}
+	return c.fallbackTransformer.transformStmt(stmt)
+}
+
+func (c *localCalls) transformExpr(expr ast.Expr) ast.Expr {
+	switch expr := expr.(type) {
+
+	case ast.VariableRef:
+		c.only = c.only && expr.Var != c.name
+		return expr
+
+	case ast.Call:
+		if m, ok := expr.Method.(ast.VariableRef); ok && m.Var == c.name {
+			c.count++
+			return ast.Call{Method: c.fn, Args: data.MapSlice(expr.Args, c.transformExpr)}
+		}
+		return c.fallbackTransformer.transformExpr(expr)
+
+	case ast.Function:
+		c.only = c.only && !uses([]ast.Stmt{expr}, c.name)
+		return expr
+
+	default:
+		return c.fallbackTransformer.transformExpr(expr)
+	}
+}
+
 func (b *boxing) needBoxes(stmts []ast.Stmt) *data.Set[string] {
 	tracking := withFallbackAnalzyer(&boxScopeAnalyzer{
 		referred: newVarSet(),
@@ -134,7 +216,7 @@ func (t *boxScopeAnalyzer) analyzeStmt(stmt ast.Stmt) {
 	switch stmt := stmt.(type) {
 
 	case ast.Variable:
-		t.analyzeStmt(stmt.Value)
+		t.analyzeExpr(stmt.Value)
 
 		if t.locals.Contains(stmt.Name) {
 			return
@@ -144,8 +226,8 @@ func (t *boxScopeAnalyzer) analyzeStmt(stmt ast.Stmt) {
 		}
 
 	case ast.Assign:
-		t.analyzeStmt(stmt.Object)
-		t.analyzeStmt(stmt.Value)
+		t.analyzeExpr(stmt.Object)
+		t.analyzeExpr(stmt.Value)
 
 		if stmt.Object != nil || t.locals.Contains(stmt.Name) {
 			return
@@ -167,35 +249,53 @@ func (t *boxScopeAnalyzer) analyzeExpr(expr ast.Expr) {
 			return
 		}
 		t.referred.Put(expr.Var)
-		if t.inClosure {
+		if t.escaping {
 			t.captured.Put(expr.Var)
 		}
 
+	case ast.Call:
+		f, ok := expr.Method.(ast.Function)
+		if !ok {
+			t.fallbackAnalyzer.analyzeExpr(expr)
+			return
+		}
+		// the function has returned before anything after the call runs
+		for _, x := range expr.Args {
+			t.analyzeExpr(x)
+		}
+		t.analyzeFunction(f, t.escaping)
+
 	case ast.Function:
-		locals := newVarSet()
-		locals.AddSet(t.locals)
-		locals.AddSlice(data.MapSlice(expr.Args, argName))
-
-		inner := withFallbackAnalzyer(&boxScopeAnalyzer{
-			inClosure: true,
-			locals:    locals,
-			referred:  t.referred,
-			captured:  t.captured,
-			boxed:     t.boxed,
-		})
-		inner.analyzeBlock(expr.Body)
+		t.analyzeFunction(expr, true)
 
 	default:
 		t.fallbackAnalyzer.analyzeExpr(expr)
 	}
 }
 
+func (t *boxScopeAnalyzer) analyzeFunction(f ast.Function, escaping bool) {
+	locals := newVarSet()
+	locals.AddSet(t.locals)
+	locals.AddSlice(data.MapSlice(f.Args, argName))
+
+	inner := withFallbackAnalzyer(&boxScopeAnalyzer{
+		inClosure: true,
+		escaping:  escaping,
+		locals:    locals,
+		referred:  t.referred,
+		captured:  t.captured,
+		boxed:     t.boxed,
+	})
+	inner.analyzeBlock(f.Body)
+}
+
 func (t *boxScopeAnalyzer) analyzeBlock(stmts []ast.Stmt) {
 	locals := newVarSet()
 	locals.AddSet(t.locals)
 	locals.AddSet(blockVars(stmts))
 	inner := withFallbackAnalzyer(&boxScopeAnalyzer{
 		inClosure: t.inClosure,
+		escaping:  t.escaping,
 		locals:    locals,
 		referred:  t.referred,
 		captured:  t.captured,
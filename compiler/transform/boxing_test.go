@@ -100,7 +100,10 @@ func TestBoxing(t *testing.T) {
 			name: "AssignedToplevelReferredInside",
 			in: ast.Program{Stmts: []ast.Stmt{
 				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
-				ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}},
+				ast.Call{
+					Method: ast.VariableRef{Var: "g"},
+					Args:   []ast.Expr{ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+				},
 				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
 			}},
 			out: ast.Program{Stmts: []ast.Stmt{
@@ -109,24 +112,18 @@ func TestBoxing(t *testing.T) {
 					Args:   []ast.Expr{ast.Name{Name: "x"}},
 				}},
 				ast.Call{
-					Method: ast.MemberAccess{
-						Object: ast.VariableRef{Var: "x"},
-						Member: "define",
-					},
-					Args: []ast.Expr{ast.IntConstant{Value: 1}},
+					Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "define"},
+					Args:   []ast.Expr{ast.IntConstant{Value: 1}},
 				},
-				ast.Function{Body: []ast.Stmt{
-					ast.Call{Method: ast.MemberAccess{
-						Object: ast.VariableRef{Var: "x"},
-						Member: "get",
-					}},
-				}},
 				ast.Call{
-					Method: ast.MemberAccess{
-						Object: ast.VariableRef{Var: "x"},
-						Member: "set",
-					},
-					Args: []ast.Expr{ast.IntConstant{Value: 2}},
+					Method: ast.VariableRef{Var: "g"},
+					Args: []ast.Expr{ast.Function{Body: []ast.Stmt{
+						ast.Call{Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "get"}},
+					}}},
+				},
+				ast.Call{
+					Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "set"},
+					Args:   []ast.Expr{ast.IntConstant{Value: 2}},
 				},
 			}},
 		},
@@ -134,9 +131,12 @@ func TestBoxing(t *testing.T) {
 			name: "AssignedInside",
 			in: ast.Program{Stmts: []ast.Stmt{
 				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
-				ast.Function{Body: []ast.Stmt{
-					ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
-				}},
+				ast.Call{
+					Method: ast.VariableRef{Var: "g"},
+					Args: []ast.Expr{ast.Function{Body: []ast.Stmt{
+						ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+					}}},
+				},
 			}},
 			out: ast.Program{Stmts: []ast.Stmt{
 				ast.Variable{Name: "x", Value: ast.Call{
@@ -144,21 +144,18 @@ func TestBoxing(t *testing.T) {
 					Args:   []ast.Expr{ast.Name{Name: "x"}},
 				}},
 				ast.Call{
-					Method: ast.MemberAccess{
-						Object: ast.VariableRef{Var: "x"},
-						Member: "define",
-					},
-					Args: []ast.Expr{ast.IntConstant{Value: 1}},
+					Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "define"},
+					Args:   []ast.Expr{ast.IntConstant{Value: 1}},
 				},
-				ast.Function{Body: []ast.Stmt{
-					ast.Call{
-						Method: ast.MemberAccess{
-							Object: ast.VariableRef{Var: "x"},
-							Member: "set",
+				ast.Call{
+					Method: ast.VariableRef{Var: "g"},
+					Args: []ast.Expr{ast.Function{Body: []ast.Stmt{
+						ast.Call{
+							Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "set"},
+							Args:   []ast.Expr{ast.IntConstant{Value: 2}},
 						},
-						Args: []ast.Expr{ast.IntConstant{Value: 2}},
-					},
-				}},
+					}}},
+				},
 			}},
 		},
 		{
@@ -302,7 +299,10 @@ func TestBoxing(t *testing.T) {
 				ast.Function{
 					Args: []ast.Arg{{Name: "x"}},
 					Body: []ast.Stmt{
-						ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}},
+						ast.Call{
+							Method: ast.VariableRef{Var: "g"},
+							Args:   []ast.Expr{ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+						},
 						ast.Assign{Name: "x", Value: ast.IntConstant{Value: 1}},
 					},
 				},
@@ -311,22 +311,19 @@ func TestBoxing(t *testing.T) {
 				ast.Function{
 					Args: []ast.Arg{{Name: "x"}},
 					Body: []ast.Stmt{
-						ast.Variable{Name: "x", Value: ast.Call{
+						ast.Assign{Name: "x", Value: ast.Call{
 							Method: ast.MemberAccess{Object: ast.Unit{}, Member: "create_box"},
 							Args:   []ast.Expr{ast.VariableRef{Var: "x"}},
 						}},
-						ast.Function{Body: []ast.Stmt{
-							ast.Call{Method: ast.MemberAccess{
-								Object: ast.VariableRef{Var: "x"},
-								Member: "get",
-							}},
-						}},
 						ast.Call{
-							Method: ast.MemberAccess{
-								Object: ast.VariableRef{Var: "x"},
-								Member: "set",
-							},
-							Args: []ast.Expr{ast.IntConstant{Value: 1}},
+							Method: ast.VariableRef{Var: "g"},
+							Args: []ast.Expr{ast.Function{Body: []ast.Stmt{
+								ast.Call{Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "get"}},
+							}}},
+						},
+						ast.Call{
+							Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "set"},
+							Args:   []ast.Expr{ast.IntConstant{Value: 1}},
 						},
 					},
 				},
@@ -340,7 +337,10 @@ func TestBoxing(t *testing.T) {
 					Body: []ast.Stmt{
 						ast.If{Then: []ast.Stmt{
 							ast.Variable{Name: "x", Value: ast.IntConstant{Value: 2}},
-							ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}},
+							ast.Call{
+								Method: ast.VariableRef{Var: "g"},
+								Args:   []ast.Expr{ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+							},
 							ast.Assign{Name: "x", Value: ast.IntConstant{Value: 1}},
 						}},
 					},
@@ -356,26 +356,263 @@ func TestBoxing(t *testing.T) {
 								Args:   []ast.Expr{ast.Name{Name: "x"}},
 							}},
 							ast.Call{
-								Method: ast.MemberAccess{
-									Object: ast.VariableRef{Var: "x"},
-									Member: "define",
-								},
-								Args: []ast.Expr{ast.IntConstant{Value: 2}},
+								Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "define"},
+								Args:   []ast.Expr{ast.IntConstant{Value: 2}},
 							},
-							ast.Function{Body: []ast.Stmt{
-								ast.Call{Method: ast.MemberAccess{
-									Object: ast.VariableRef{Var: "x"},
-									Member: "get",
-								}},
-							}},
 							ast.Call{
-								Method: ast.MemberAccess{
-									Object: ast.VariableRef{Var: "x"},
-									Member: "set",
-								},
-								Args: []ast.Expr{ast.IntConstant{Value: 1}},
+								Method: ast.VariableRef{Var: "g"},
+								Args: []ast.Expr{ast.Function{Body: []ast.Stmt{
+									ast.Call{Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "get"}},
+								}}},
 							},
+							ast.Call{
+								Method: ast.MemberAccess{Object: ast.VariableRef{Var: "x"}, Member: "set"},
+								Args:   []ast.Expr{ast.IntConstant{Value: 1}},
+							},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "EscapingReferredInside",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.Call{
+					Method: ast.MemberAccess{Object: ast.Unit{}, Member: "create_undefined_box"},
+					Args:   []ast.Expr{ast.Name{Name: "x"}},
+				}},
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "define",
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 1}},
+				},
+				ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{
+					ast.Call{Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "get",
+					}},
+				}}},
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "set",
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 2}},
+				},
+			}},
+		},
+		{
+			name: "AssignedBeforeCapture",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+				ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+				ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+			}},
+		},
+		{
+			name: "CalledLocal",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{
+					ast.Return{Value: ast.VariableRef{Var: "x"}},
+				}}},
+				ast.Return{Value: ast.Call{Method: ast.VariableRef{Var: "f"}}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Return{Value: ast.Call{Method: ast.Function{Body: []ast.Stmt{
+					ast.Return{Value: ast.VariableRef{Var: "x"}},
+				}}}},
+			}},
+		},
+		{
+			name: "CalledLocalAssignedAfterCapture",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Variable{Name: "f", Value: ast.Function{
+					Args: []ast.Arg{{Name: "y"}},
+					Body: []ast.Stmt{ast.Return{Value: ast.VariableRef{Var: "x"}}},
+				}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+				ast.Call{Method: ast.VariableRef{Var: "f"}, Args: []ast.Expr{ast.IntConstant{Value: 3}}},
+				ast.Return{Value: ast.Call{Method: ast.VariableRef{Var: "f"}, Args: []ast.Expr{ast.IntConstant{Value: 4}}}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+				ast.Call{
+					Method: ast.Function{
+						Args: []ast.Arg{{Name: "y"}},
+						Body: []ast.Stmt{ast.Return{Value: ast.VariableRef{Var: "x"}}},
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 3}},
+				},
+				ast.Return{Value: ast.Call{
+					Method: ast.Function{
+						Args: []ast.Arg{{Name: "y"}},
+						Body: []ast.Stmt{ast.Return{Value: ast.VariableRef{Var: "x"}}},
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 4}},
+				}},
+			}},
+		},
+		{
+			name: "CalledLocalEscaping",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{
+					ast.Return{Value: ast.VariableRef{Var: "x"}},
+				}}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+				ast.Call{Method: ast.VariableRef{Var: "f"}},
+				ast.Return{Value: ast.VariableRef{Var: "f"}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.Call{
+					Method: ast.MemberAccess{Object: ast.Unit{}, Member: "create_undefined_box"},
+					Args:   []ast.Expr{ast.Name{Name: "x"}},
+				}},
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "define",
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 1}},
+				},
+				ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{
+					ast.Return{Value: ast.Call{Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "get",
+					}}},
+				}}},
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "set",
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 2}},
+				},
+				ast.Call{Method: ast.VariableRef{Var: "f"}},
+				ast.Return{Value: ast.VariableRef{Var: "f"}},
+			}},
+		},
+		{
+			name: "CalledLocalArgument",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Function{
+					Args: []ast.Arg{{Name: "x"}},
+					Body: []ast.Stmt{
+						ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+						ast.Call{Method: ast.VariableRef{Var: "f"}},
+						ast.Assign{Name: "x", Value: ast.IntConstant{Value: 1}},
+					},
+				},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Function{
+					Args: []ast.Arg{{Name: "x"}},
+					Body: []ast.Stmt{
+						ast.Call{Method: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+						ast.Assign{Name: "x", Value: ast.IntConstant{Value: 1}},
+					},
+				},
+			}},
+		},
+		{
+			name: "ReferredInsideCall",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Call{Method: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Call{Method: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+			}},
+		},
+		{
+			name: "EscapingFromCall",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.IntConstant{Value: 1}},
+				ast.Call{Method: ast.Function{Body: []ast.Stmt{
+					ast.Return{Value: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+				}}},
+				ast.Assign{Name: "x", Value: ast.IntConstant{Value: 2}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Variable{Name: "x", Value: ast.Call{
+					Method: ast.MemberAccess{Object: ast.Unit{}, Member: "create_undefined_box"},
+					Args:   []ast.Expr{ast.Name{Name: "x"}},
+				}},
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "define",
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 1}},
+				},
+				ast.Call{Method: ast.Function{Body: []ast.Stmt{
+					ast.Return{Value: ast.Function{Body: []ast.Stmt{
+						ast.Call{Method: ast.MemberAccess{
+							Object: ast.VariableRef{Var: "x"},
+							Member: "get",
+						}},
+					}}},
+				}}},
+				ast.Call{
+					Method: ast.MemberAccess{
+						Object: ast.VariableRef{Var: "x"},
+						Member: "set",
+					},
+					Args: []ast.Expr{ast.IntConstant{Value: 2}},
+				},
+			}},
+		},
+		{
+			name: "EscapingArgument",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Function{
+					Args: []ast.Arg{{Name: "x"}},
+					Body: []ast.Stmt{
+						ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{ast.VariableRef{Var: "x"}}}},
+						ast.Assign{Name: "x", Value: ast.IntConstant{Value: 1}},
+					},
+				},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Function{
+					Args: []ast.Arg{{Name: "x"}},
+					Body: []ast.Stmt{
+						ast.Assign{Name: "x", Value: ast.Call{
+							Method: ast.MemberAccess{Object: ast.Unit{}, Member: "create_box"},
+							Args:   []ast.Expr{ast.VariableRef{Var: "x"}},
 						}},
+						ast.Variable{Name: "f", Value: ast.Function{Body: []ast.Stmt{
+							ast.Call{Method: ast.MemberAccess{
+								Object: ast.VariableRef{Var: "x"},
+								Member: "get",
+							}},
+						}}},
+						ast.Call{
+							Method: ast.MemberAccess{
+								Object: ast.VariableRef{Var: "x"},
+								Member: "set",
+							},
+							Args: []ast.Expr{ast.IntConstant{Value: 1}},
+						},
 					},
 				},
 			}},
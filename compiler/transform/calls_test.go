@@ -1,24 +1,40 @@
 package transform
 
 import (
-	ast2 "go/ast"
 	"testing"
 
-	"github.com/bobappleyard/lync/compiler/ast"
 	"github.com/bobappleyard/lync/util/assert"
 )
 
 func TestFunctionCall(t *testing.T) {
 	for _, test := range []struct {
 		name    string
-		in, out ast.Program
-	}{} {
+		in, out string
+	}{
+		{
+			name: "Call",
+			in:   `f(x, 1)`,
+			out:  "<unit>.call_function(f, x, 1)\n",
+		},
+		{
+			name: "MethodCall",
+			in:   `x.f(1)`,
+			out:  "x.f(1)\n",
+		},
+		{
+			name: "Nested",
+			in: `func g(h) {
+	return h(h(1))
+}`,
+			out: `func g(h) {
+	return <unit>.call_function(h, <unit>.call_function(h, 1))
+}
+`,
+		},
+	} {
 		t.Run(test.name, func(t *testing.T) {
-			out := transformFunctionCalls(test.in)
-			assert.Equal(t, out, test.out)
-			if t.Failed() {
-				ast2.Print(nil, out)
-			}
+			out := transformFunctionCalls(parse(t, test.in))
+			assert.Equal(t, show(t, out), test.out)
 		})
 	}
 
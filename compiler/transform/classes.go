@@ -13,16 +13,24 @@ func transformClasses(p ast.Program) ast.Program {
 
 type classTransformer struct {
 	fallbackTransformer
+	inMethod bool
 }
 
-var classInit = ast.Variable{
-	Name: "@",
-	Value: ast.Call{
-		Method: ast.MemberAccess{
-			Object: ast.Unit{},
-			Member: "create_class",
+func (t *classTransformer) classInit(super ast.Expr) ast.Stmt {
+	var args []ast.Expr
+	if super != nil {
+		args = []ast.Expr{t.transformExpr(super)}
+	}
+	return ast.Variable{
+		Name: "@",
+		Value: ast.Call{
+			Method: ast.MemberAccess{
+				Object: ast.Unit{},
+				Member: "create_class",
+			},
+			Args: args,
 		},
-	},
+	}
 }
 
 func (t *classTransformer) transformExpr(expr ast.Expr) ast.Expr {
@@ -30,18 +38,63 @@ func (t *classTransformer) transformExpr(expr ast.Expr) ast.Expr {
 
 	case ast.Class:
 		var body []ast.Stmt
-		body = append(body, classInit)
-		body = append(body, data.MapSlice(expr.Members, t.implementMember)...)
+		var fields []ast.Field
+
+		body = append(body, t.classInit(expr.Super))
+		for _, m := range expr.Members {
+			if f, ok := m.(ast.Field); ok {
+				fields = append(fields, f)
+				continue
+			}
+			body = append(body, t.implementMember(m))
+		}
+		if len(fields) != 0 {
+			body = append(body, t.implementMember(fieldInitializer(fields)))
+		}
 		body = append(body, ast.Return{Value: ast.VariableRef{Var: "@"}})
 
 		return ast.Call{Method: ast.Function{Body: body}}
 
+	case ast.SuperCall:
+		if !t.inMethod {
+			// check reports these, so they only get this far in programs that were not checked
+			return t.fallbackTransformer.transformExpr(expr)
+		}
+		// the method closes over the class it was defined in, so the runtime can find the parent
+		args := []ast.Expr{
+			ast.VariableRef{Var: "@"},
+			ast.VariableRef{Var: "this"},
+			ast.Name{Name: expr.Member},
+		}
+		return unitMethodCall("call_super", append(args, data.MapSlice(expr.Args, t.transformExpr)...)...)
+
 	default:
 		return t.fallbackTransformer.transformExpr(expr)
 	}
 }
 
+// When a class is called, the runtime makes a new instance and initializes its fields, running the
+// fieldsName method of each class that the instance belongs to, from the root of the hierarchy
+// down. The method cannot be named in a program, so classes never override it, and it takes no
+// arguments other than the instance, so initializers cannot see the arguments to init. The runtime
+// then calls the instance's init method, if it has one, passing along the arguments to the call.
+const fieldsName = "@fields"
+
+func fieldInitializer(fields []ast.Field) ast.Method {
+	m := ast.Method{Name: fieldsName}
+	for _, f := range fields {
+		m.Body = append(m.Body, ast.NodeAt(f.Start(), ast.Assign{
+			Object: ast.VariableRef{Var: "this"},
+			Name:   f.Name,
+			Value:  f.Value,
+		}))
+	}
+	return m
+}
+
 func (t *classTransformer) implementMember(member ast.Member) ast.Stmt {
+	methods := withFallbackTransformer(&classTransformer{inMethod: true})
+
 	switch member := member.(type) {
 	case ast.Method:
 		return ast.Assign{
@@ -55,7 +108,7 @@ func (t *classTransformer) implementMember(member ast.Member) ast.Stmt {
 				Args: []ast.Expr{
 					ast.Function{
 						Args: append([]ast.Arg{{Name: "this"}}, member.Args...),
-						Body: t.transformBlock(member.Body),
+						Body: methods.transformBlock(member.Body),
 					},
 				},
 			},
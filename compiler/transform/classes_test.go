@@ -73,6 +73,147 @@ func TestClasses(t *testing.T) {
 				}}},
 			}},
 		},
+		{
+			name: "ClassWithFieldsAndConstructor",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Class{Members: []ast.Member{
+					ast.NodeAt(10, ast.Field{Name: "x", Value: ast.IntConstant{Value: 1}}),
+					ast.Method{
+						Name: "init",
+						Args: []ast.Arg{{Name: "y"}},
+						Body: []ast.Stmt{
+							ast.Assign{
+								Object: ast.VariableRef{Var: "this"},
+								Name:   "y",
+								Value:  ast.VariableRef{Var: "y"},
+							},
+						},
+					},
+					ast.NodeAt(20, ast.Field{Name: "z", Value: ast.IntConstant{Value: 2}}),
+				}},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Call{Method: ast.Function{Body: []ast.Stmt{
+					ast.Variable{Name: "@", Value: ast.Call{
+						Method: ast.MemberAccess{
+							Object: ast.Unit{},
+							Member: "create_class",
+						},
+					}},
+					ast.Assign{
+						Object: ast.VariableRef{Var: "@"},
+						Name:   "init",
+						Value: ast.Call{
+							Method: ast.MemberAccess{
+								Object: ast.Unit{},
+								Member: "create_method",
+							},
+							Args: []ast.Expr{
+								ast.Function{
+									Args: []ast.Arg{{Name: "this"}, {Name: "y"}},
+									Body: []ast.Stmt{
+										ast.Assign{
+											Object: ast.VariableRef{Var: "this"},
+											Name:   "y",
+											Value:  ast.VariableRef{Var: "y"},
+										},
+									},
+								},
+							},
+						},
+					},
+					ast.Assign{
+						Object: ast.VariableRef{Var: "@"},
+						Name:   "@fields",
+						Value: ast.Call{
+							Method: ast.MemberAccess{
+								Object: ast.Unit{},
+								Member: "create_method",
+							},
+							Args: []ast.Expr{
+								ast.Function{
+									Args: []ast.Arg{{Name: "this"}},
+									Body: []ast.Stmt{
+										ast.NodeAt(10, ast.Assign{
+											Object: ast.VariableRef{Var: "this"},
+											Name:   "x",
+											Value:  ast.IntConstant{Value: 1},
+										}),
+										ast.NodeAt(20, ast.Assign{
+											Object: ast.VariableRef{Var: "this"},
+											Name:   "z",
+											Value:  ast.IntConstant{Value: 2},
+										}),
+									},
+								},
+							},
+						},
+					},
+					ast.Return{Value: ast.VariableRef{Var: "@"}},
+				}}},
+			}},
+		},
+		{
+			name: "Subclass",
+			in: ast.Program{Stmts: []ast.Stmt{
+				ast.Class{
+					Super: ast.VariableRef{Var: "A"},
+					Members: []ast.Member{
+						ast.Method{
+							Name: "example",
+							Args: []ast.Arg{{Name: "x"}},
+							Body: []ast.Stmt{
+								ast.Return{Value: ast.SuperCall{
+									Member: "example",
+									Args:   []ast.Expr{ast.VariableRef{Var: "x"}},
+								}},
+							},
+						},
+					},
+				},
+			}},
+			out: ast.Program{Stmts: []ast.Stmt{
+				ast.Call{Method: ast.Function{Body: []ast.Stmt{
+					ast.Variable{Name: "@", Value: ast.Call{
+						Method: ast.MemberAccess{
+							Object: ast.Unit{},
+							Member: "create_class",
+						},
+						Args: []ast.Expr{ast.VariableRef{Var: "A"}},
+					}},
+					ast.Assign{
+						Object: ast.VariableRef{Var: "@"},
+						Name:   "example",
+						Value: ast.Call{
+							Method: ast.MemberAccess{
+								Object: ast.Unit{},
+								Member: "create_method",
+							},
+							Args: []ast.Expr{
+								ast.Function{
+									Args: []ast.Arg{{Name: "this"}, {Name: "x"}},
+									Body: []ast.Stmt{
+										ast.Return{Value: ast.Call{
+											Method: ast.MemberAccess{
+												Object: ast.Unit{},
+												Member: "call_super",
+											},
+											Args: []ast.Expr{
+												ast.VariableRef{Var: "@"},
+												ast.VariableRef{Var: "this"},
+												ast.Name{Name: "example"},
+												ast.VariableRef{Var: "x"},
+											},
+										}},
+									},
+								},
+							},
+						},
+					},
+					ast.Return{Value: ast.VariableRef{Var: "@"}},
+				}}},
+			}},
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			out := transformClasses(test.in)
@@ -108,11 +108,11 @@ func (c *closures) createClosure(f ast.Function, closure *data.Set[string]) ast.
 	captured.AddSet(closure)
 	inner := withFallbackTransformer(&closures{captured: captured})
 
-	lifted := ast.Function{
+	lifted := ast.NodeAt(f.Start(), ast.Function{
 		Name: f.Name,
 		Args: append(data.MapSlice(closure.Items(), namedArg), f.Args...),
 		Body: inner.transformBlock(f.Body),
-	}
+	})
 	if closure.Empty() {
 		return lifted
 	}
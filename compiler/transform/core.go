@@ -1,24 +1,72 @@
 package transform
 
 import (
+	"errors"
+	"fmt"
+	"slices"
 	"unsafe"
 
 	"github.com/bobappleyard/lync/compiler/ast"
 	"github.com/bobappleyard/lync/util/data"
 )
 
-func Program(p ast.Program) ast.Program {
-	p = transformDeclarators(p)
-	p = transformClasses(p)
-	p = transformMemberAccess(p)
-	p = transformGlobals(p)
-	p = transformBoxing(p)
-	p = transformClosures(p)
-	p = transformFunctionCalls(p)
+var (
+	ErrUnknownPass = errors.New("unknown pass")
+)
+
+// Pass is a step in lowering a program.
+type Pass struct {
+	Name string
+	Run  func(ast.Program) ast.Program
+}
 
+// Passes are the steps that Program takes, in the order that it takes them.
+var Passes = []Pass{
+	{"declarators", transformDeclarators},
+	{"classes", transformClasses},
+	{"optimize", transformOptimize},
+	{"member_access", transformMemberAccess},
+	{"globals", transformGlobals},
+	{"boxing", transformBoxing},
+	{"closures", transformClosures},
+	{"calls", transformFunctionCalls},
+}
+
+// Options control how Lower runs the passes.
+type Options struct {
+	// Until names the last pass to run. Every pass is run if it is empty.
+	Until string
+
+	// Trace is called with the program after each pass that is run.
+	Trace func(pass string, p ast.Program)
+}
+
+// Program lowers a program into calls on the unit, ready for assembly.
+func Program(p ast.Program) ast.Program {
+	for _, pass := range Passes {
+		p = pass.Run(p)
+	}
 	return p
 }
 
+// Lower runs the passes in the same way as Program, but can stop part way through and show the
+// program as it goes.
+func Lower(p ast.Program, opts Options) (ast.Program, error) {
+	if opts.Until != "" && !slices.ContainsFunc(Passes, func(pass Pass) bool { return pass.Name == opts.Until }) {
+		return ast.Program{}, fmt.Errorf("%s: %w", opts.Until, ErrUnknownPass)
+	}
+	for _, pass := range Passes {
+		p = pass.Run(p)
+		if opts.Trace != nil {
+			opts.Trace(pass.Name, p)
+		}
+		if pass.Name == opts.Until {
+			break
+		}
+	}
+	return p, nil
+}
+
 type transformer interface {
 	transformBlock(stmts []ast.Stmt) []ast.Stmt
 	transformStmt(stmt ast.Stmt) ast.Stmt
@@ -47,11 +95,11 @@ func (t fallbackTransformer) transformStmt(stmt ast.Stmt) ast.Stmt {
 	switch stmt := stmt.(type) {
 
 	case ast.Assign:
-		return ast.Assign{
+		return ast.NodeAt(stmt.Start(), ast.Assign{
 			Object: t.impl.transformExpr(stmt.Object),
 			Name:   stmt.Name,
 			Value:  t.impl.transformExpr(stmt.Value),
-		}
+		})
 
 	case ast.Return:
 		return ast.Return{Value: t.impl.transformExpr(stmt.Value)}
@@ -92,18 +140,25 @@ func (t fallbackTransformer) transformExpr(expr ast.Expr) ast.Expr {
 			Args:   data.MapSlice(expr.Args, t.impl.transformExpr),
 		}
 
+	case ast.SuperCall:
+		return ast.SuperCall{
+			Member: expr.Member,
+			Args:   data.MapSlice(expr.Args, t.impl.transformExpr),
+		}
+
 	case ast.Class:
 		return ast.Class{
 			Name:    expr.Name,
+			Super:   t.impl.transformExpr(expr.Super),
 			Members: data.MapSlice(expr.Members, t.impl.transformMember),
 		}
 
 	case ast.Function:
-		return ast.Function{
+		return ast.NodeAt(expr.Start(), ast.Function{
 			Name: expr.Name,
 			Args: expr.Args,
 			Body: t.impl.transformBlock(expr.Body),
-		}
+		})
 
 	default:
 		return expr
@@ -120,6 +175,12 @@ func (t fallbackTransformer) transformMember(member ast.Member) ast.Member {
 			Body: t.impl.transformBlock(member.Body),
 		}
 
+	case ast.Field:
+		return ast.Field{
+			Name:  member.Name,
+			Value: t.impl.transformExpr(member.Value),
+		}
+
 	default:
 		return member
 	}
@@ -187,7 +248,13 @@ func (a fallbackAnalyzer) analyzeExpr(expr ast.Expr) {
 			a.impl.analyzeExpr(x)
 		}
 
+	case ast.SuperCall:
+		for _, x := range expr.Args {
+			a.impl.analyzeExpr(x)
+		}
+
 	case ast.Class:
+		a.impl.analyzeExpr(expr.Super)
 		for _, m := range expr.Members {
 			a.impl.analyzeMember(m)
 		}
@@ -204,5 +271,8 @@ func (a fallbackAnalyzer) analyzeMember(member ast.Member) {
 	case ast.Method:
 		a.impl.analyzeBlock(member.Body)
 
+	case ast.Field:
+		a.impl.analyzeExpr(member.Value)
+
 	}
 }
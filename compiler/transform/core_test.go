@@ -1,9 +1,12 @@
 package transform
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/compiler/parser"
+	"github.com/bobappleyard/lync/compiler/printer"
 	"github.com/bobappleyard/lync/util/assert"
 )
 
@@ -29,3 +32,46 @@ func TestWithTransformer(t *testing.T) {
 func TestFallbackIdentity(t *testing.T) {
 
 }
+
+func TestLower(t *testing.T) {
+	src := parse(t, `var x = 1
+f(x)`)
+
+	var passes []string
+	trace := func(pass string, p ast.Program) {
+		passes = append(passes, pass)
+	}
+
+	p, err := Lower(src, Options{Trace: trace})
+	assert.Nil(t, err)
+	assert.Equal(t, passes, []string{
+		"declarators", "classes", "optimize", "member_access", "globals", "boxing", "closures", "calls",
+	})
+	assert.Equal(t, show(t, p), show(t, Program(src)))
+
+	passes = nil
+	p, err = Lower(src, Options{Until: "globals", Trace: trace})
+	assert.Nil(t, err)
+	assert.Equal(t, passes, []string{"declarators", "classes", "optimize", "member_access", "globals"})
+	assert.Equal(t, show(t, p), `<unit>.global_define(#x, 1)
+<unit>.global_get(#f)(<unit>.global_get(#x))
+`)
+
+	_, err = Lower(src, Options{Until: "frobnicate"})
+	assert.True(t, errors.Is(err, ErrUnknownPass))
+}
+
+func parse(t *testing.T, src string) ast.Program {
+	t.Helper()
+	p, err := parser.Parse([]byte(src))
+	assert.Nil(t, err)
+	return p
+}
+
+// show prints a program in the form that printer uses for lowered programs.
+func show(t *testing.T, p ast.Program) string {
+	t.Helper()
+	out, err := printer.Config{Lowered: true}.Format(p)
+	assert.Nil(t, err)
+	return string(out)
+}
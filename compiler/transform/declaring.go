@@ -22,8 +22,11 @@ func (d *declarators) transformStmt(s ast.Stmt) ast.Stmt {
 			return d.fallbackTransformer.transformStmt(s)
 		}
 		return ast.Variable{
-			Name:  s.Name,
-			Value: ast.Class{Members: data.MapSlice(s.Members, d.transformMember)},
+			Name: s.Name,
+			Value: ast.Class{
+				Super:   d.transformExpr(s.Super),
+				Members: data.MapSlice(s.Members, d.transformMember),
+			},
 		}
 
 	case ast.Function:
@@ -32,7 +35,7 @@ func (d *declarators) transformStmt(s ast.Stmt) ast.Stmt {
 		}
 		return ast.Variable{
 			Name:  s.Name,
-			Value: ast.Function{Args: s.Args, Body: d.transformBlock(s.Body)}}
+			Value: ast.NodeAt(s.Start(), ast.Function{Name: s.Name, Args: s.Args, Body: d.transformBlock(s.Body)})}
 
 	case ast.Import:
 		return ast.Variable{Name: s.Name, Value: ast.Call{
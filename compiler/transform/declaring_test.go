@@ -59,6 +59,7 @@ func TestDeclarators(t *testing.T) {
 					ast.Variable{
 						Name: "f",
 						Value: ast.Function{
+							Name: "f",
 							Args: []ast.Arg{{Name: "x"}},
 							Body: []ast.Stmt{
 								ast.Return{Value: ast.VariableRef{Var: "x"}},
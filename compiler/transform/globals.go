@@ -62,11 +62,11 @@ func (t *globalsTransformer) transformExpr(expr ast.Expr) ast.Expr {
 		locals.AddSet(t.nonGlobal)
 		locals.AddSlice(data.MapSlice(expr.Args, argName))
 		inner := withFallbackTransformer(&globalsTransformer{nonGlobal: locals})
-		return ast.Function{
+		return ast.NodeAt(expr.Start(), ast.Function{
 			Name: expr.Name,
 			Args: expr.Args,
 			Body: inner.transformBlock(expr.Body),
-		}
+		})
 
 	default:
 		return t.fallbackTransformer.transformExpr(expr)
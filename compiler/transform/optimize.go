@@ -0,0 +1,348 @@
+package transform
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/util/data"
+)
+
+// transformOptimize simplifies a program without changing what it does. Statements that can never
+// run are removed, as are local variables that are never used and whose values have no effect,
+// and ifs with nothing in them.
+//
+// Ifs whose conditions are constants are replaced with the branch that the condition chooses, which
+// is the one that the runtime's truth function would choose.
+//
+// The functions that transformClasses wraps the bodies of classes in are called as soon as they
+// are made, and these are inlined into the code around them. This, and replacing an if with a
+// branch that declares variables, only happens inside functions, as at the top level the variables
+// would become globals.
+//
+// Lync has no operators, so there is no arithmetic to fold.
+func transformOptimize(p ast.Program) ast.Program {
+	o := withFallbackTransformer(&optimizer{fresh: new(int)})
+	return ast.Program{Stmts: o.transformToplevel(p.Stmts)}
+}
+
+type optimizer struct {
+	fallbackTransformer
+
+	// locals are the variables in scope that are not globals
+	locals *data.Set[string]
+
+	// fresh counts the variables renamed by inlining, to keep their names apart
+	fresh *int
+}
+
+func (o *optimizer) withLocals(locals *data.Set[string]) *optimizer {
+	return withFallbackTransformer(&optimizer{locals: locals, fresh: o.fresh})
+}
+
+func (o *optimizer) transformToplevel(stmts []ast.Stmt) []ast.Stmt {
+	var res []ast.Stmt
+	for _, s := range stmts {
+		ss := o.simplify(o.transformStmt(s))
+		res = append(res, ss...)
+		if slices.ContainsFunc(ss, terminates) {
+			break
+		}
+	}
+	return res
+}
+
+func (o *optimizer) transformBlock(stmts []ast.Stmt) []ast.Stmt {
+	locals := newVarSet()
+	locals.AddSet(o.locals)
+	locals.AddSet(blockVars(stmts))
+	inner := o.withLocals(locals)
+
+	var res []ast.Stmt
+	for _, s := range stmts {
+		ss := inner.simplify(inner.transformStmt(s))
+		for _, s := range ss {
+			res = append(res, inner.inline(s)...)
+		}
+		if slices.ContainsFunc(ss, terminates) {
+			break
+		}
+	}
+	return inner.removeUnused(res)
+}
+
+func (o *optimizer) transformExpr(expr ast.Expr) ast.Expr {
+	switch expr := expr.(type) {
+	case ast.Function:
+		locals := newVarSet()
+		locals.AddSet(o.locals)
+		locals.AddSlice(data.MapSlice(expr.Args, argName))
+		inner := o.withLocals(locals)
+		return ast.NodeAt(expr.Start(), ast.Function{
+			Name: expr.Name,
+			Args: expr.Args,
+			Body: inner.transformBlock(expr.Body),
+		})
+
+	default:
+		return o.fallbackTransformer.transformExpr(expr)
+	}
+}
+
+// simplify replaces ifs whose conditions are constants with the branch that they choose, and
+// removes ifs that have nothing to do other than evaluate their condition.
+func (o *optimizer) simplify(s ast.Stmt) []ast.Stmt {
+	i, ok := s.(ast.If)
+	if !ok {
+		return []ast.Stmt{s}
+	}
+	if cond, ok := truth(i.Cond); ok {
+		branch := i.Else
+		if cond {
+			branch = i.Then
+		}
+		if blockVars(branch).Empty() {
+			return branch
+		}
+		if o.locals != nil {
+			return o.renameVars(branch)
+		}
+	}
+	if len(i.Then) == 0 && len(i.Else) == 0 {
+		if o.pure(i.Cond) {
+			return nil
+		}
+		return []ast.Stmt{i.Cond}
+	}
+	return []ast.Stmt{s}
+}
+
+// truth gives whether a condition is true, if it is a constant. Unit, zero and the empty string are
+// false, and other constants are true. Ints are 32 bits once the program runs.
+func truth(e ast.Expr) (bool, bool) {
+	switch e := e.(type) {
+	case ast.Unit:
+		return false, true
+	case ast.IntConstant:
+		return int32(e.Value) != 0, true
+	case ast.FltConstant:
+		return e.Value != 0, true
+	case ast.StringConstant:
+		return e.Value != "", true
+	case ast.Name, ast.Function:
+		return true, true
+	}
+	return false, false
+}
+
+// terminates reports whether the statements after a statement are unreachable.
+func terminates(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case ast.Return:
+		return true
+	case ast.If:
+		return len(s.Else) != 0 && blockTerminates(s.Then) && blockTerminates(s.Else)
+	}
+	return false
+}
+
+func blockTerminates(stmts []ast.Stmt) bool {
+	return len(stmts) != 0 && terminates(stmts[len(stmts)-1])
+}
+
+// pure reports whether an expression can be evaluated without any effect. Globals are not pure,
+// as they might not be defined.
+func (o *optimizer) pure(e ast.Expr) bool {
+	switch e := e.(type) {
+	case ast.IntConstant, ast.FltConstant, ast.StringConstant, ast.Unit, ast.Name, ast.Function:
+		return true
+	case ast.VariableRef:
+		return o.locals.Contains(e.Var)
+	}
+	return false
+}
+
+// removeUnused removes the variables declared by a block that are never used, if their values are
+// pure. Removing one variable can leave another unused, so this goes on until there are none left.
+func (o *optimizer) removeUnused(stmts []ast.Stmt) []ast.Stmt {
+	for {
+		i := o.unusedVariable(stmts)
+		if i == -1 {
+			return stmts
+		}
+		stmts = append(stmts[:i:i], stmts[i+1:]...)
+	}
+}
+
+func (o *optimizer) unusedVariable(stmts []ast.Stmt) int {
+	for i, s := range stmts {
+		v, ok := s.(ast.Variable)
+		if ok && o.pure(v.Value) && !uses(stmts, v.Name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// uses reports whether a variable is referred to or assigned anywhere in a list of statements,
+// including in the functions that they make. Variables of the same name that shadow the variable
+// count as well, which is never wrong but could be better.
+func uses(stmts []ast.Stmt, name string) bool {
+	used := false
+	for _, s := range stmts {
+		ast.Inspect(s, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case ast.VariableRef:
+				used = used || n.Var == name
+			case ast.Assign:
+				used = used || n.Object == nil && n.Name == name
+			}
+			return !used
+		})
+	}
+	return used
+}
+
+// inline replaces a call to a function that is made and then immediately called, such as those
+// that transformClasses makes, with the body of the function. This is done where the call is the
+// value of a statement, so that the body can go before the statement.
+func (o *optimizer) inline(s ast.Stmt) []ast.Stmt {
+	switch s := s.(type) {
+	case ast.Variable:
+		if body, value, ok := o.inlineCall(s.Value); ok {
+			return append(body, ast.Variable{Name: s.Name, Value: value})
+		}
+
+	case ast.Assign:
+		// the object would be evaluated after the body, rather than before
+		if s.Object != nil {
+			break
+		}
+		if body, value, ok := o.inlineCall(s.Value); ok {
+			return append(body, ast.Assign{Name: s.Name, Value: value})
+		}
+
+	case ast.Return:
+		if body, value, ok := o.inlineCall(s.Value); ok {
+			return append(body, ast.Return{Value: value})
+		}
+
+	case ast.Expr:
+		if body, value, ok := o.inlineCall(s); ok {
+			if o.pure(value) {
+				return body
+			}
+			return append(body, value)
+		}
+	}
+	return []ast.Stmt{s}
+}
+
+// inlineCall gives the body of a function that is called immediately, without any arguments,
+// along with the value that it returns. The function must only return at the end of its body.
+// The variables that the function declares are renamed, so that they stay apart from those
+// already in scope.
+func (o *optimizer) inlineCall(e ast.Expr) ([]ast.Stmt, ast.Expr, bool) {
+	call, ok := e.(ast.Call)
+	if !ok || len(call.Args) != 0 {
+		return nil, nil, false
+	}
+	f, ok := call.Method.(ast.Function)
+	if !ok || f.Name != "" || len(f.Args) != 0 || len(f.Body) == 0 {
+		return nil, nil, false
+	}
+	_, ok = f.Body[len(f.Body)-1].(ast.Return)
+	if !ok || returns(f.Body[:len(f.Body)-1]) {
+		return nil, nil, false
+	}
+
+	body := o.renameVars(f.Body)
+	return body[:len(body)-1], body[len(body)-1].(ast.Return).Value, true
+}
+
+// renameVars renames the variables that a block declares, so that they stay apart from those
+// already in scope when the block's statements are put into another.
+func (o *optimizer) renameVars(stmts []ast.Stmt) []ast.Stmt {
+	for _, name := range blockVars(stmts).Items() {
+		*o.fresh++
+		to := fmt.Sprintf("%s@%d", name, *o.fresh)
+		o.locals.Put(to)
+		r := withFallbackTransformer(&renamer{from: name, to: to})
+		stmts = data.MapSlice(stmts, r.transformStmt)
+	}
+	return stmts
+}
+
+// returns reports whether any of a list of statements return, other than from functions that
+// they make.
+func returns(stmts []ast.Stmt) bool {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case ast.Return:
+			return true
+		case ast.If:
+			if returns(s.Then) || returns(s.Else) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renamer renames a variable declared by a block, along with the references to it. It is applied
+// to the statements of the block, and leaves alone the blocks and functions that declare a
+// variable of the same name.
+type renamer struct {
+	fallbackTransformer
+	from, to string
+}
+
+func (r *renamer) transformBlock(stmts []ast.Stmt) []ast.Stmt {
+	if blockVars(stmts).Contains(r.from) {
+		return stmts
+	}
+	return r.fallbackTransformer.transformBlock(stmts)
+}
+
+func (r *renamer) transformStmt(stmt ast.Stmt) ast.Stmt {
+	switch stmt := stmt.(type) {
+	case ast.Variable:
+		if stmt.Name == r.from {
+			stmt.Name = r.to
+		}
+		stmt.Value = r.transformExpr(stmt.Value)
+		return stmt
+
+	case ast.Assign:
+		if stmt.Object == nil && stmt.Name == r.from {
+			stmt.Name = r.to
+		}
+		stmt.Object = r.transformExpr(stmt.Object)
+		stmt.Value = r.transformExpr(stmt.Value)
+		return stmt
+
+	default:
+		return r.fallbackTransformer.transformStmt(stmt)
+	}
+}
+
+func (r *renamer) transformExpr(expr ast.Expr) ast.Expr {
+	switch expr := expr.(type) {
+	case ast.VariableRef:
+		if expr.Var == r.from {
+			expr.Var = r.to
+		}
+		return expr
+
+	case ast.Function:
+		for _, a := range expr.Args {
+			if a.Name == r.from {
+				return expr
+			}
+		}
+		return r.fallbackTransformer.transformExpr(expr)
+
+	default:
+		return r.fallbackTransformer.transformExpr(expr)
+	}
+}
@@ -0,0 +1,237 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync/compiler/ast"
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestOptimize(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		in, out string
+	}{
+		{
+			name: "Unreachable",
+			in: `func f(x) {
+	return x
+	g(x)
+}`,
+			out: `func f(x) {
+	return x
+}
+`,
+		},
+		{
+			name: "UnusedVariable",
+			in: `func f(x) {
+	var a = 1
+	var b = a
+	var c = g()
+	var d = x
+	return d
+}`,
+			out: `func f(x) {
+	var c = g()
+	var d = x
+	return d
+}
+`,
+		},
+		{
+			name: "AssignedVariable",
+			in: `func f() {
+	var a = 1
+	a = 2
+}`,
+			out: `func f() {
+	var a = 1
+	a = 2
+}
+`,
+		},
+		{
+			name: "Globals",
+			in: `var a = 1
+var b = c
+func f() {
+	var d = c
+}`,
+			out: `var a = 1
+var b = c
+
+func f() {
+	var d = c
+}
+`,
+		},
+		{
+			name: "EmptyIf",
+			in: `func f(x) {
+	if x {}
+	if g() {}
+}`,
+			out: `func f(x) {
+	g()
+}
+`,
+		},
+		{
+			name: "ConstantIf",
+			in: `func f() {
+	if 0 {
+		g()
+	}
+	if "" {
+		g()
+	}
+	if 1.5 {
+		h()
+	}
+	if "x" {
+		return 1
+	}
+	g()
+}`,
+			out: `func f() {
+	h()
+	return 1
+}
+`,
+		},
+		{
+			name: "ConstantIfVariables",
+			in: `func f() {
+	var x = g()
+	if 1 {
+		var x = 2
+		h(x)
+	}
+	return x
+}`,
+			out: `func f() {
+	var x = g()
+	var x@1 = 2
+	h(x@1)
+	return x
+}
+`,
+		},
+		{
+			name: "ToplevelConstantIf",
+			in: `if 0 {
+	g()
+}
+if 1 {
+	h()
+}
+if 1 {
+	var x = g()
+}`,
+			out: `h()
+if 1 {
+	var x = g()
+}
+`,
+		},
+		{
+			name: "Class",
+			in: `func f() {
+	var A = class {
+		m() {
+			return 1
+		}
+	}
+	return A
+}`,
+			out: `func f() {
+	var @@1 = <unit>.create_class()
+	@@1.m = <unit>.create_method(func(this) {
+		return 1
+	})
+	var A = @@1
+	return A
+}
+`,
+		},
+		{
+			name: "ToplevelClass",
+			in:   `var A = class {}`,
+			out: `var A = func() {
+	var @ = <unit>.create_class()
+	return @
+}()
+`,
+		},
+		{
+			name: "EarlyReturn",
+			in: `func f(x) {
+	return func() {
+		if x {
+			return 1
+		}
+		return 2
+	}()
+}`,
+			out: `func f(x) {
+	return func() {
+		if x {
+			return 1
+		}
+		return 2
+	}()
+}
+`,
+		},
+		{
+			name: "Shadowed",
+			in: `func f(x) {
+	var y = func() {
+		var x = 1
+		g(func(x) {
+			return x
+		})
+		return x
+	}()
+	return y
+}`,
+			out: `func f(x) {
+	var x@1 = 1
+	g(func(x) {
+		return x
+	})
+	var y = x@1
+	return y
+}
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out := transformOptimize(transformClasses(parse(t, test.in)))
+			assert.Equal(t, show(t, out), test.out)
+		})
+	}
+}
+
+func TestTerminates(t *testing.T) {
+	ret := ast.Return{Value: ast.IntConstant{Value: 1}}
+	for _, test := range []struct {
+		name string
+		in   ast.Stmt
+		out  bool
+	}{
+		{name: "Return", in: ret, out: true},
+		{name: "Expr", in: ast.VariableRef{Var: "x"}},
+		{name: "IfThen", in: ast.If{Cond: ast.VariableRef{Var: "x"}, Then: []ast.Stmt{ret}}},
+		{
+			name: "IfThenElse",
+			in:   ast.If{Cond: ast.VariableRef{Var: "x"}, Then: []ast.Stmt{ret}, Else: []ast.Stmt{ret}},
+			out:  true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, terminates(test.in), test.out)
+		})
+	}
+}
@@ -6,6 +6,15 @@ type Unit struct {
 	Symbols   []string
 }
 
+// Package is a compiled package. Its unit defines the globals named by Exports, and imports the
+// packages with the paths in Imports.
+type Package struct {
+	Path    string
+	Imports []string
+	Exports []string
+	Unit    Unit
+}
+
 type Symbol uint64
 
 type Register byte
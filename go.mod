@@ -8,7 +8,7 @@ require (
 )
 
 require (
-	github.com/wasmerio/wasmer-go v1.0.4 // indirect
+	github.com/stretchr/testify v1.7.0 // indirect
 	golang.org/x/mod v0.17.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 )
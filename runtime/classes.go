@@ -0,0 +1,39 @@
+package runtime
+
+import (
+	"maps"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// Class describes the behaviour shared by a set of objects. Methods are identified by the
+// position of their implementation in the function table.
+//
+// These are the classes that are known before a program runs. The classes that a program defines
+// are made by the runtime, which also takes care of inheritance between them.
+type Class struct {
+	Name    string
+	methods map[lync.Symbol]wasm.Index
+}
+
+func NewClass(name string) *Class {
+	return &Class{
+		Name:    name,
+		methods: map[lync.Symbol]wasm.Index{},
+	}
+}
+
+func (c *Class) Define(selector lync.Symbol, method wasm.Index) {
+	c.methods[selector] = method
+}
+
+func (c *Class) Lookup(selector lync.Symbol) (wasm.Index, bool) {
+	m, ok := c.methods[selector]
+	return m, ok
+}
+
+// Methods lists every method understood by instances of the class.
+func (c *Class) Methods() map[lync.Symbol]wasm.Index {
+	return maps.Clone(c.methods)
+}
@@ -0,0 +1,38 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func TestClassLookup(t *testing.T) {
+	const (
+		name lync.Symbol = iota
+		size
+		push
+	)
+
+	c := NewClass("Stack")
+	c.Define(name, 1)
+	c.Define(size, 2)
+	c.Define(size, 3)
+
+	m, ok := c.Lookup(name)
+	assert.True(t, ok)
+	assert.Equal(t, m, 1)
+	m, ok = c.Lookup(size)
+	assert.True(t, ok)
+	assert.Equal(t, m, 3)
+
+	_, ok = c.Lookup(push)
+	assert.False(t, ok)
+
+	methods := c.Methods()
+	assert.Equal(t, methods, map[lync.Symbol]wasm.Index{name: 1, size: 3})
+	methods[push] = 4
+	_, ok = c.Lookup(push)
+	assert.False(t, ok)
+}
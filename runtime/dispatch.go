@@ -0,0 +1,221 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"slices"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/util/data"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+// Values are 64 bits wide. The high word holds the ID of the value's class and the low word holds
+// a payload whose meaning depends on the class.
+func ValueClass(v uint64) int {
+	return int(v >> 32)
+}
+
+func MakeValue(class int, payload uint32) uint64 {
+	return uint64(class)<<32 | uint64(payload)
+}
+
+// Function table slot 0 is reserved for the handler that is invoked when an object does not
+// understand a message, so lookups that fail resolve to it.
+const MissingMethod wasm.Index = 0
+
+// DispatchTable maps (class, selector) pairs onto methods in constant time.
+//
+// Each class is a row of a sparse matrix whose columns are selectors. The rows are packed
+// together by displacement, so that the table is not much larger than the number of methods.
+//
+// The table only holds the classes that are known when it is built. A runtime can add rows for
+// the classes that a program defines as it runs, which is why the table says where its rows and
+// cells are rather than having them at fixed places.
+type DispatchTable struct {
+	classes  []*Class
+	matrix   data.SparseMatrix[wasm.Index]
+	fallback *wasm.Index
+	method   *wasm.Index
+}
+
+// NewDispatchTable places the methods of each class into a table. Classes are identified by their
+// position in the slice.
+func NewDispatchTable(classes []*Class) *DispatchTable {
+	t := &DispatchTable{classes: classes}
+	for _, c := range classes {
+		t.matrix.AddRow(classRow(c))
+	}
+	return t
+}
+
+func classRow(c *Class) []data.SparseMatrixElement[wasm.Index] {
+	var row []data.SparseMatrixElement[wasm.Index]
+	for sel, m := range c.Methods() {
+		row = append(row, data.SparseMatrixElement[wasm.Index]{
+			Col:   int(sel),
+			Value: m,
+		})
+	}
+	slices.SortFunc(row, func(a, b data.SparseMatrixElement[wasm.Index]) int {
+		return a.Col - b.Col
+	})
+	return row
+}
+
+func (t *DispatchTable) ClassID(c *Class) (int, bool) {
+	id := slices.Index(t.classes, c)
+	return id, id != -1
+}
+
+// SetFallback names a function for the lookup function to defer to when the table has no method for
+// an object, such as when the object's class is only defined once the program runs. The function
+// takes the same arguments as lookup. Without a fallback, these lookups resolve to MissingMethod.
+func (t *DispatchTable) SetFallback(f wasm.Index) {
+	t.fallback = &f
+}
+
+// SetMethodGlobal names a global for the lookup function to leave the value held by the cell that
+// it finds in. Cells that a runtime adds hold methods that are values rather than functions in the
+// table, and direct lookups to a function that calls the value in the global.
+func (t *DispatchTable) SetMethodGlobal(g wasm.Index) {
+	t.method = &g
+}
+
+func (t *DispatchTable) Lookup(class int, selector lync.Symbol) wasm.Index {
+	m, ok := t.matrix.LookupValue(class, int(selector))
+	if !ok {
+		return MissingMethod
+	}
+	return m
+}
+
+// Bytes renders the table as it is to be laid out in memory at the given address. All fields are
+// little-endian:
+//
+//	class count i32, cell count i32, address of the rows i32, address of the cells i32
+//	row offset i32 for each class
+//	(owning class i32, method i32, value i64) for each cell
+//
+// Cells that no class owns have -1 as their owner. The cells of the table as built hold 0 as their
+// value.
+func (t *DispatchTable) Bytes(base uint32) []byte {
+	offsets, cells := t.matrix.Packed()
+	rowsAt := base + headerSize
+	cellsAt := rowsAt + uint32(len(offsets))*rowSize
+
+	var buf []byte
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(offsets)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(cells)))
+	buf = binary.LittleEndian.AppendUint32(buf, rowsAt)
+	buf = binary.LittleEndian.AppendUint32(buf, cellsAt)
+	for _, off := range offsets {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(off)))
+	}
+	for _, c := range cells {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(c.Row)))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(c.Value))
+		buf = binary.LittleEndian.AppendUint64(buf, 0)
+	}
+	return buf
+}
+
+const (
+	headerSize = 16
+	rowSize    = 4
+	cellSize   = 16
+)
+
+// AddToModule places the table in memory at the given address and defines the runtime's lookup
+// function, exported as "lookup". It takes an object and a selector and returns the function table
+// slot of the method to invoke.
+func (t *DispatchTable) AddToModule(m *wasm.Module, mem wasm.Index, base uint32) *wasm.Code {
+	m.Data = append(m.Data, wasm.ActiveData{
+		Memory: mem,
+		Offset: base,
+		Bytes:  t.Bytes(base),
+	})
+
+	const object, selector = 0, 1
+
+	c := m.AddExportedFunc("lookup", []wasm.Type{wasm.Int64, wasm.Int64}, []wasm.Type{wasm.Int64})
+	class := c.AddLocal(wasm.Int32)
+	cell := c.AddLocal(wasm.Int32)
+
+	// class = object >> 32
+	c.LocalGet(object)
+	c.I64Const(32)
+	c.I64ShrU()
+	c.I32WrapI64()
+	c.LocalSet(class)
+
+	// if class >= class count { return missing }
+	c.LocalGet(class)
+	c.I32Const(0)
+	c.I32Load(2, base)
+	c.I32GeU()
+	t.lookupFailed(c)
+
+	// cell = rows[class] + selector
+	c.I32Const(0)
+	c.I32Load(2, base+8)
+	c.LocalGet(class)
+	c.I32Const(2)
+	c.I32Shl()
+	c.I32Add()
+	c.I32Load(2, 0)
+	c.LocalGet(selector)
+	c.I32WrapI64()
+	c.I32Add()
+	c.LocalSet(cell)
+
+	// if cell >= cell count { return missing }
+	// negative cells wrap around and so are also caught here
+	c.LocalGet(cell)
+	c.I32Const(0)
+	c.I32Load(2, base+4)
+	c.I32GeU()
+	t.lookupFailed(c)
+
+	// if cells[cell].owner != class { return missing }
+	c.I32Const(0)
+	c.I32Load(2, base+12)
+	c.LocalGet(cell)
+	c.I32Const(4)
+	c.I32Shl()
+	c.I32Add()
+	c.LocalTee(cell)
+	c.I32Load(2, 0)
+	c.LocalGet(class)
+	c.I32Ne()
+	t.lookupFailed(c)
+
+	if t.method != nil {
+		// method = cells[cell].value
+		c.LocalGet(cell)
+		c.I64Load(3, 8)
+		c.GlobalSet(uint32(*t.method))
+	}
+
+	// return cells[cell].method
+	c.LocalGet(cell)
+	c.I32Load(2, 4)
+	c.I64ExtendI32U()
+	c.End()
+
+	return c
+}
+
+func (t *DispatchTable) lookupFailed(c *wasm.Code) {
+	const object, selector = 0, 1
+
+	c.If(wasm.Void)
+	if t.fallback != nil {
+		c.LocalGet(object)
+		c.LocalGet(selector)
+		c.ReturnCall(uint32(*t.fallback))
+	} else {
+		c.I64Const(int64(MissingMethod))
+		c.Return()
+	}
+	c.End()
+}
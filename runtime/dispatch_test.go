@@ -0,0 +1,182 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func testClasses() []*Class {
+	base := NewClass("Base")
+	base.Define(0, 1)
+	base.Define(3, 2)
+
+	// shares selectors with base, so the two rows cannot overlap
+	derived := NewClass("Derived")
+	derived.Define(0, 1)
+	derived.Define(3, 3)
+	derived.Define(5, 4)
+
+	empty := NewClass("Empty")
+
+	other := NewClass("Other")
+	other.Define(1, 5)
+	other.Define(2, 6)
+
+	return []*Class{base, derived, empty, other}
+}
+
+func TestDispatchTable(t *testing.T) {
+	classes := testClasses()
+	table := NewDispatchTable(classes)
+
+	for id, c := range classes {
+		for sel := lync.Symbol(0); sel < 8; sel++ {
+			expect, ok := c.Lookup(sel)
+			if !ok {
+				expect = MissingMethod
+			}
+			assert.Equal(t, table.Lookup(id, sel), expect)
+		}
+	}
+
+	id, ok := table.ClassID(classes[2])
+	assert.True(t, ok)
+	assert.Equal(t, id, 2)
+
+	_, ok = table.ClassID(NewClass("Unknown"))
+	assert.False(t, ok)
+}
+
+func TestDispatchTableWasm(t *testing.T) {
+	classes := testClasses()
+	table := NewDispatchTable(classes)
+
+	var m wasm.Module
+	m.Memories = []wasm.Memory{wasm.MinMemory{Min: 1}}
+	table.AddToModule(&m, 0, 16)
+
+	lookup := instantiateLookup(t, m)
+	if lookup == nil {
+		return
+	}
+
+	for id := 0; id <= len(classes); id++ {
+		for sel := lync.Symbol(0); sel < 8; sel++ {
+			res, err := lookup.Call(MakeValue(id, 99), uint64(sel))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			assert.Equal(t, wasm.Index(res[0]), table.Lookup(id, sel))
+		}
+	}
+}
+
+func TestDispatchTableFallback(t *testing.T) {
+	classes := testClasses()
+	table := NewDispatchTable(classes)
+
+	var m wasm.Module
+	m.Memories = []wasm.Memory{wasm.MinMemory{Min: 1}}
+	fallback := m.AddFunc([]wasm.Type{wasm.Int64, wasm.Int64}, []wasm.Type{wasm.Int64})
+	fallback.I64Const(99)
+	fallback.End()
+	table.SetFallback(fallback.Func)
+	table.AddToModule(&m, 0, 16)
+
+	lookup := instantiateLookup(t, m)
+	if lookup == nil {
+		return
+	}
+
+	for id := 0; id <= len(classes); id++ {
+		for sel := lync.Symbol(0); sel < 8; sel++ {
+			res, err := lookup.Call(MakeValue(id, 99), uint64(sel))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			want := table.Lookup(id, sel)
+			if want == MissingMethod {
+				want = 99
+			}
+			assert.Equal(t, wasm.Index(res[0]), want)
+		}
+	}
+}
+
+func instantiateLookup(t *testing.T, m wasm.Module) *wasm.Function {
+	t.Helper()
+
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	inst, err := wasm.InstantiateBytes(m.AppendWasm(nil), nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	lookup, err := inst.Func("lookup")
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	return lookup
+}
+
+type dispatchKey struct {
+	class    int
+	selector lync.Symbol
+}
+
+func benchmarkClasses(n, methods int) []*Class {
+	var classes []*Class
+	for i := 0; i < n; i++ {
+		c := NewClass("")
+		for j := 0; j < methods; j++ {
+			c.Define(lync.Symbol(i*7+j*13), wasm.Index(i*methods+j+1))
+		}
+		classes = append(classes, c)
+	}
+	return classes
+}
+
+func BenchmarkDispatchTable(b *testing.B) {
+	classes := benchmarkClasses(64, 8)
+	table := NewDispatchTable(classes)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Lookup(i%len(classes), lync.Symbol(i%512))
+	}
+}
+
+func BenchmarkMapDispatch(b *testing.B) {
+	classes := benchmarkClasses(64, 8)
+	table := map[dispatchKey]wasm.Index{}
+	for id, c := range classes {
+		for sel, m := range c.Methods() {
+			table[dispatchKey{id, sel}] = m
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = table[dispatchKey{i % len(classes), lync.Symbol(i % 512)}]
+	}
+}
+
+func BenchmarkClassLookup(b *testing.B) {
+	classes := benchmarkClasses(64, 8)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		classes[i%len(classes)].Lookup(lync.Symbol(i % 512))
+	}
+}
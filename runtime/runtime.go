@@ -2,26 +2,102 @@ package runtime
 
 import "github.com/bobappleyard/lync/util/wasm"
 
+// TailCalls selects how calls in tail position are made.
+type TailCalls int
+
+const (
+	// ReturnCall uses the instructions from the wasm tail call proposal.
+	ReturnCall TailCalls = iota
+	// Trampoline is for engines without the proposal. Rather than making the call, the caller
+	// schedules it as the pending action and returns, and the main loop makes the call instead.
+	Trampoline
+)
+
 type runtimeScope struct {
-	actionType    uint32
+	methodType    uint32
 	pendingAction uint32
+	pendingArgv   uint32
+	pendingArgc   uint32
+}
+
+// Actions are method calls that have been put off until the caller has returned. An action is
+// identified by its function table slot, and slot 0 means that there is nothing left to do. The
+// arguments are on the stack, as for any other method call.
+func declareScope(m *wasm.Module) runtimeScope {
+	var none wasm.Code
+	none.I32Const(0)
+	none.End()
+
+	return runtimeScope{
+		methodType: uint32(m.EnsureType(wasm.FuncType{
+			In:  []wasm.Type{wasm.Int32, wasm.Int32},
+			Out: []wasm.Type{wasm.Int64},
+		})),
+		pendingAction: uint32(m.AddGlobal(wasm.Global{Type: wasm.Int32, Mutable: true, Init: none})),
+		pendingArgv:   uint32(m.AddGlobal(wasm.Global{Type: wasm.Int32, Mutable: true, Init: none})),
+		pendingArgc:   uint32(m.AddGlobal(wasm.Global{Type: wasm.Int32, Mutable: true, Init: none})),
+	}
 }
 
+// mainLoop runs pending actions until there are none left. It takes the result of the call that
+// scheduled the first action and gives the result of the last one.
 func mainLoop(s runtimeScope) *wasm.Code {
+	const result = 0
+
 	var c wasm.Code
 
-	c.Loop()
+	done := c.Block(wasm.Void)
+	loop := c.Loop(wasm.Void)
 
 	c.GlobalGet(s.pendingAction)
 	c.I32Eqz()
-	c.BrIf(1)
+	c.BranchIf(done)
 
+	c.GlobalGet(s.pendingArgv)
+	c.GlobalGet(s.pendingArgc)
 	c.GlobalGet(s.pendingAction)
-	c.CallIndirect(s.actionType)
-	c.Br(0)
+	c.I32Const(0)
+	c.GlobalSet(s.pendingAction)
+	c.CallIndirect(s.methodType)
+	c.LocalSet(result)
+	c.Branch(loop)
 
 	c.End()
 	c.End()
+	c.LocalGet(result)
+	c.End()
+
+	return &c
+}
+
+// schedule makes an action pending. It takes the same operands as call_indirect. Slot 0 cannot
+// be scheduled, so calls to it are made immediately, which is safe because the method in that
+// slot never returns.
+func schedule(s runtimeScope) *wasm.Code {
+	const argv, argc, slot = 0, 1, 2
+
+	var c wasm.Code
+
+	c.LocalGet(slot)
+	c.I32Eqz()
+	c.If(wasm.Void)
+	c.LocalGet(argv)
+	c.LocalGet(argc)
+	c.LocalGet(slot)
+	c.CallIndirect(s.methodType)
+	c.Return()
+	c.End()
+
+	c.LocalGet(argv)
+	c.GlobalSet(s.pendingArgv)
+	c.LocalGet(argc)
+	c.GlobalSet(s.pendingArgc)
+	c.LocalGet(slot)
+	c.GlobalSet(s.pendingAction)
+
+	// the result is discarded by whoever runs the action
+	c.I64Const(0)
+	c.End()
 
 	return &c
 }
@@ -0,0 +1,108 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func TestMainLoop(t *testing.T) {
+	var m wasm.Module
+	s := declareScope(&m)
+
+	var zero wasm.Code
+	zero.I32Const(0)
+	zero.End()
+	count := uint32(m.AddGlobal(wasm.Global{Type: wasm.Int32, Mutable: true, Init: zero}))
+
+	// keeps itself scheduled until it has run three times, then gives the count and its argc
+	action := m.AddFunc([]wasm.Type{wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64})
+	action.GlobalGet(count)
+	action.I32Const(1)
+	action.I32Add()
+	action.GlobalSet(count)
+	action.GlobalGet(count)
+	action.I32Const(3)
+	action.I32Ne()
+	action.If(wasm.Void)
+	action.I32Const(1)
+	action.GlobalSet(s.pendingAction)
+	action.End()
+	action.GlobalGet(count)
+	action.I32Const(10)
+	action.I32Mul()
+	action.LocalGet(1)
+	action.I32Add()
+	action.I64ExtendI32U()
+	action.End()
+
+	run := m.AddExportedFunc("run", []wasm.Type{wasm.Int64}, []wasm.Type{wasm.Int64})
+	run.Instructions = mainLoop(s).Instructions
+
+	m.Tables = []wasm.Table{wasm.FuncTable}
+	m.Elements = []wasm.Element{&wasm.FuncElement{Funcs: []wasm.Index{action.Func}}}
+
+	sched := m.AddFunc([]wasm.Type{wasm.Int32, wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64})
+	sched.Instructions = schedule(s).Instructions
+
+	// schedules the action with two arguments
+	init := m.AddExportedFunc("init", nil, []wasm.Type{wasm.Int64})
+	init.NullFunc()
+	init.I32Const(2)
+	init.TableGrow(0)
+	init.Drop()
+	init.I32Const(1)
+	init.I32Const(0)
+	init.I32Const(1)
+	init.TableInit(0, 0)
+	init.I32Const(0)
+	init.I32Const(2)
+	init.I32Const(1)
+	init.Call(uint32(sched.Func))
+	init.End()
+
+	inst := instantiate(t, m)
+	if inst == nil {
+		return
+	}
+
+	initFn, err := inst.Func("init")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := initFn.Call()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	runFn, err := inst.Func("run")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err = runFn.Call(res[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.Equal(t, res, []uint64{32})
+}
+
+func instantiate(t *testing.T, m wasm.Module) *wasm.Instance {
+	t.Helper()
+
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	inst, err := wasm.InstantiateBytes(m.AppendWasm(nil), nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	return inst
+}
@@ -0,0 +1,356 @@
+package runtime
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/bobappleyard/lync"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+//go:embed wasi.wat
+var wasiSource []byte
+
+// The classes known to the WASI runtime. wasi.wat identifies them by position. The classes that a
+// program defines are numbered from wasiClassCount up, in the order that the runtime makes them.
+const (
+	unitClass = iota
+	nameClass
+	intClass
+	floatClass
+	stringClass
+	functionClass
+	closureClass
+	boxClass
+	packageClass
+	classClass
+	programPackageClass
+	wasiClassCount
+)
+
+// wasiMethods lists the methods that the WASI runtime implements, by the selector that they
+// respond to and the name of the function in wasi.wat.
+var wasiMethods = []struct {
+	class    int
+	selector string
+	fn       string
+}{
+	{unitClass, "global_define", "global_define"},
+	{unitClass, "global_set", "global_set"},
+	{unitClass, "global_get", "global_get"},
+	{unitClass, "import_package", "import_package"},
+	{unitClass, "call_function", "call_function"},
+	{unitClass, "create_closure", "create_closure"},
+	{unitClass, "create_box", "create_box"},
+	{unitClass, "create_undefined_box", "create_undefined_box"},
+	{unitClass, "create_class", "create_class"},
+	{unitClass, "call_super", "call_super"},
+	{unitClass, "create_method", "create_method"},
+	{unitClass, "property_get", "property_get"},
+	{unitClass, "property_set", "property_set"},
+	{boxClass, "get", "box_get"},
+	{boxClass, "set", "box_set"},
+	{boxClass, "define", "box_set"},
+	{packageClass, "write", "write"},
+	{packageClass, "exit", "exit"},
+	{packageClass, "arg_count", "arg_count"},
+	{packageClass, "arg", "arg"},
+	{packageClass, "env", "env"},
+	{packageClass, "time", "time"},
+}
+
+// The globals defined by wasi.wat, in order.
+const (
+	spGlobal = iota
+	heapGlobal
+	globalsGlobal
+	initGlobal
+	fieldsGlobal
+	packagesGlobal
+	stackLimitGlobal
+	dispatchGlobal
+	methodGlobal
+)
+
+// wasiSymbols are the symbols that the WASI runtime uses other than as selectors.
+var wasiSymbols = []string{"init", "@fields"}
+
+// WASIPackages lists the packages that the WASI runtime provides to programs.
+var WASIPackages = []string{"sys"}
+
+// Memory starts with scratch space and constant strings. Then come the dispatch table, the values
+// of global variables, the packages that the program is made of and the argument stack, with the
+// heap above them. The dispatch table moves its rows and cells to the heap when the program defines
+// classes that they have no room for.
+const (
+	wasiTableBase = 128
+	wasiStackSize = 64 << 10
+)
+
+// WASI builds the runtime for a WASI command. It imports the unit to run as "unit", and exports
+// "_start" along with what the unit imports from "runtime". The runtime responds to the messages
+// named by the unit's symbols, and makes tail calls in the same way as the unit.
+//
+// Programs reach the host through the "sys" package, which can write to standard output, exit,
+// and read the command line, the environment and the clock. They can also import the packages
+// given here, whose units must have been run by the time they are imported, and whose exports
+// must be named by the symbols. Each package has global variables of its own, and the packages'
+// units must start in the same order as they are given.
+func WASI(symbols []string, packages []*lync.Package, tailCalls TailCalls) (*wasm.Module, error) {
+	m, err := wasm.ParseWat(wasiSource)
+	if err != nil {
+		return nil, fmt.Errorf("wasi runtime: %w", err)
+	}
+	names, err := m.Names()
+	if err != nil {
+		return nil, fmt.Errorf("wasi runtime: %w", err)
+	}
+	funcs := map[string]wasm.Index{}
+	for idx, name := range names.Funcs {
+		funcs[name] = idx
+	}
+
+	classes := make([]*Class, wasiClassCount)
+	for i := range classes {
+		classes[i] = NewClass("")
+	}
+	elem := &wasm.FuncElement{Funcs: []wasm.Index{funcs["missing"], funcs["invoke"], funcs["call_export"]}}
+	for _, meth := range wasiMethods {
+		sel := slices.Index(symbols, meth.selector)
+		if sel == -1 {
+			continue
+		}
+		classes[meth.class].Define(lync.Symbol(sel), wasm.Index(len(elem.Funcs)))
+		elem.Funcs = append(elem.Funcs, funcs[meth.fn])
+	}
+
+	table := NewDispatchTable(classes)
+	table.SetFallback(funcs["lookup_package"])
+	table.SetMethodGlobal(methodGlobal)
+	table.AddToModule(m, 0, wasiTableBase)
+
+	globalsAt := align8(wasiTableBase + uint32(len(table.Bytes(wasiTableBase))))
+	globalsSize := 8 * len(symbols) * len(packages)
+	m.Data = append(m.Data, wasm.ActiveData{
+		Offset: globalsAt,
+		Bytes:  bytes.Repeat([]byte{0xff}, globalsSize),
+	})
+
+	packagesAt := globalsAt + uint32(globalsSize)
+	pkgs := packageTable(packagesAt, globalsAt, packages, symbols)
+	m.Data = append(m.Data, wasm.ActiveData{Offset: packagesAt, Bytes: pkgs})
+
+	stackLimit := align8(packagesAt + uint32(len(pkgs)))
+	stackTop := align8(stackLimit + wasiStackSize)
+	setGlobal(m, spGlobal, stackTop)
+	setGlobal(m, heapGlobal, stackTop)
+	setGlobal(m, globalsGlobal, globalsAt)
+	setGlobal(m, stackLimitGlobal, stackLimit)
+	setGlobal(m, initGlobal, uint32(slices.Index(symbols, "init")))
+	setGlobal(m, fieldsGlobal, uint32(slices.Index(symbols, "@fields")))
+	setGlobal(m, packagesGlobal, packagesAt)
+	setGlobal(m, dispatchGlobal, wasiTableBase)
+	m.Memories[0] = wasm.MinMemory{Min: (stackTop + 0xffff) >> 16}
+
+	// the method table is filled in when the runtime starts
+	m.Elements = append(m.Elements, elem)
+	start := m.AddFunc(nil, nil)
+	start.NullFunc()
+	start.I32Const(int32(len(elem.Funcs)))
+	start.TableGrow(0)
+	start.Drop()
+	start.I32Const(0)
+	start.I32Const(0)
+	start.I32Const(int32(len(elem.Funcs)))
+	start.TableInit(uint32(len(m.Elements)-1), 0)
+	start.End()
+	m.Start = &start.Func
+	names.Funcs[start.Func] = "<start>"
+
+	s := declareScope(m)
+
+	tail := m.AddExportedFunc("tail", []wasm.Type{wasm.Int32, wasm.Int32, wasm.Int32}, []wasm.Type{wasm.Int64})
+	tail.Instructions = schedule(s).Instructions
+	names.Funcs[tail.Func] = "tail"
+
+	funcCode(m, funcs["run"]).Instructions = mainLoop(s).Instructions
+
+	main := m.AddExportedFunc("_start", nil, nil)
+	main.GlobalGet(spGlobal)
+	main.I32Const(0)
+	main.Call(uint32(funcs["main"]))
+	main.Call(uint32(funcs["run"]))
+	main.Drop()
+	main.End()
+	names.Funcs[main.Func] = "_start"
+
+	if tailCalls == Trampoline {
+		if err := withoutTailCalls(m, tail.Func); err != nil {
+			return nil, fmt.Errorf("wasi runtime: %w", err)
+		}
+	}
+
+	m.SetNames(names)
+
+	return m, nil
+}
+
+// WASISession builds the runtime for an interactive session, which runs a series of units
+// against the same globals. Rather than being built around the symbols of a single unit, the
+// runtime has room for up to capacity symbols, and the units must share a symbol table that starts
+// with the symbols returned here. The runtime imports a unit but never starts it, so anything of
+// the right type will do. The entries of a session make up a single package, whose global
+// variables are at the address that the runtime's globals export gives the first time it is
+// called. Every unit should be given that address in place of calling globals itself.
+func WASISession(capacity int) (*wasm.Module, []string, error) {
+	var symbols []string
+	for _, meth := range wasiMethods {
+		if !slices.Contains(symbols, meth.selector) {
+			symbols = append(symbols, meth.selector)
+		}
+	}
+	symbols = append(symbols, wasiSymbols...)
+	if capacity < len(symbols) {
+		return nil, nil, fmt.Errorf("wasi session: room for %d symbols, but the runtime needs %d", capacity, len(symbols))
+	}
+
+	// the empty symbols are only there to make room
+	m, err := WASI(slices.Grow(symbols, capacity)[:capacity], []*lync.Package{{}}, ReturnCall)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, symbols, nil
+}
+
+// FormatWASIValue describes a value for display, as an interactive session does after it has
+// evaluated an expression. Strings and floats are read from the runtime's memory, and names from
+// the symbol table.
+func FormatWASIValue(v uint64, mem []byte, symbols []string) string {
+	payload := uint32(v)
+	switch class := ValueClass(v); class {
+	case unitClass:
+		return "unit"
+	case nameClass:
+		if int(payload) < len(symbols) {
+			return symbols[payload]
+		}
+	case intClass:
+		return strconv.Itoa(int(int32(payload)))
+	case floatClass:
+		if int(payload)+8 <= len(mem) {
+			return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(mem[payload:])), 'g', -1, 64)
+		}
+	case stringClass:
+		if int(payload)+8 <= len(mem) {
+			n := binary.LittleEndian.Uint32(mem[payload:])
+			addr := binary.LittleEndian.Uint32(mem[payload+4:])
+			if uint64(addr)+uint64(n) <= uint64(len(mem)) {
+				return strconv.Quote(string(mem[addr : addr+n]))
+			}
+		}
+	case functionClass, closureClass:
+		return "<function>"
+	case boxClass:
+		return "<box>"
+	case packageClass, programPackageClass:
+		return "<package>"
+	case classClass:
+		return "<class>"
+	default:
+		if class >= wasiClassCount {
+			return "<object>"
+		}
+	}
+	return "<invalid>"
+}
+
+// packageTable lays out the packages that a program is made of, as they are to be placed in memory
+// at the given address. Each package's global variables follow those of the package before it,
+// starting at the given address. All fields are 32-bit little-endian:
+//
+//	package count
+//	address of each package
+//	(path length, path address, globals address, export count, exported symbols...) for each package
+//	the paths
+func packageTable(base, globals uint32, packages []*lync.Package, symbols []string) []byte {
+	exports := make([][]uint32, len(packages))
+	size := uint32(0)
+	for i, p := range packages {
+		for _, name := range p.Exports {
+			if sym := slices.Index(symbols, name); sym != -1 {
+				exports[i] = append(exports[i], uint32(sym))
+			}
+		}
+		size += 16 + 4*uint32(len(exports[i]))
+	}
+
+	recordAt := base + 4 + 4*uint32(len(packages))
+	pathAt := recordAt + size
+
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(packages)))
+	var records, paths []byte
+	for i, p := range packages {
+		buf = binary.LittleEndian.AppendUint32(buf, recordAt+uint32(len(records)))
+		records = binary.LittleEndian.AppendUint32(records, uint32(len(p.Path)))
+		records = binary.LittleEndian.AppendUint32(records, pathAt+uint32(len(paths)))
+		records = binary.LittleEndian.AppendUint32(records, globals+8*uint32(i*len(symbols)))
+		records = binary.LittleEndian.AppendUint32(records, uint32(len(exports[i])))
+		for _, sym := range exports[i] {
+			records = binary.LittleEndian.AppendUint32(records, sym)
+		}
+		paths = append(paths, p.Path...)
+	}
+	buf = append(buf, records...)
+	return append(buf, paths...)
+}
+
+func setGlobal(m *wasm.Module, idx int, value uint32) {
+	var init wasm.Code
+	init.I32Const(int32(value))
+	init.End()
+	m.Globals[idx].Init = init
+}
+
+func funcCode(m *wasm.Module, idx wasm.Index) *wasm.Code {
+	for _, c := range m.Codes {
+		if c.Func == idx {
+			return c
+		}
+	}
+	return nil
+}
+
+func align8(n uint32) uint32 {
+	return (n + 7) &^ 7
+}
+
+// withoutTailCalls rewrites the tail calls made by the runtime into ordinary calls. Indirect calls
+// are scheduled for the main loop to make, and direct calls only ever go a bounded depth into the
+// runtime.
+func withoutTailCalls(m *wasm.Module, tail wasm.Index) error {
+	for _, c := range m.Codes {
+		instrs, err := c.Decode()
+		if err != nil {
+			return err
+		}
+		c.Instructions = nil
+		for _, x := range instrs {
+			switch x.Op {
+			case wasm.OpReturnCall:
+				c.Call(uint32(x.Args[0]))
+				c.Return()
+			case wasm.OpReturnCallIndirect:
+				c.Call(uint32(tail))
+				c.Return()
+			default:
+				c.Emit(x)
+			}
+		}
+	}
+	return nil
+}
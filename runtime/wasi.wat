@@ -0,0 +1,872 @@
+;; The runtime for Lync programs that run as WASI commands.
+;;
+;; Values are i64, with the class in the high word and a payload in the low word. Classes are
+;; identified by their position in wasi.go. Anything bigger than the payload lives in memory:
+;;
+;;   Float      f64
+;;   String     length i32, address i32
+;;   Function   function table slot i32, argument count i32
+;;   Closure    function i64, captured count i32, padding i32, captured values i64...
+;;   Box        value i64
+;;   Class      parent class i32, methods i32, ID i32, next class i32
+;;   Object     class i32, properties i32
+;;   Package    path length i32, path address i32, globals address i32, export count i32,
+;;              exported symbols i32...
+;;
+;; The methods of a class and the properties of an object are lists of entries, each of which is
+;; the next entry i32, a symbol i32 and a value i64. The last entry is followed by 0.
+;;
+;; Methods take the address of their arguments on the stack, receiver first, and how many there
+;; are. The dispatch table, the method table and the memory layout are filled in by wasi.go,
+;; which relies on the order of the globals below. wasi.go also defines _start and the main loop
+;; that makes calls on behalf of units that cannot make tail calls themselves.
+;;
+;; The classes that a program defines are only known once it runs, so the runtime adds them to the
+;; dispatch table as it makes them. Each gets the next ID and a row that holds every method that
+;; its instances understand, inherited or not, and the class of an object's value is the ID of the
+;; object's class. The lists of methods are only read when rows are built. The packages that a
+;; program is made of, other than sys, are not in the dispatch table. They are looked up by
+;; lookup_package, which the dispatch table defers to, and respond to messages by calling the
+;; functions that they export.
+;;
+;; Each package has global variables of its own, indexed by symbol. The unit of a package carries
+;; the address of its global variables, which the package's unit learns from globals as it starts.
+;; wasi.go arranges for the units to start and run in the same order as the packages, each before
+;; those that import it.
+(module $runtime
+  (import "wasi_snapshot_preview1" "fd_write"
+    (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "args_sizes_get"
+    (func $args_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "args_get"
+    (func $args_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_sizes_get"
+    (func $environ_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_get"
+    (func $environ_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit"
+    (func $proc_exit (param i32)))
+  (import "wasi_snapshot_preview1" "clock_time_get"
+    (func $clock_time_get (param i32 i64 i32) (result i32)))
+  (import "unit" "main" (func $main (param i32 i32) (result i64)))
+
+  (type $method (func (param i32 i32) (result i64)))
+
+  (memory (export "memory") 1)
+  (table (export "table") 0 funcref)
+
+  ;; the top of the argument stack, which grows downwards
+  (global $sp (export "sp") (mut i32) (i32.const 0))
+  ;; the next free address on the heap
+  (global $heap (mut i32) (i32.const 0))
+  ;; where the values of global variables are kept, one symbol-indexed region for each package
+  (global $globals i32 (i32.const 0))
+  ;; the symbol of the method that initializes new objects
+  (global $init i32 (i32.const -1))
+  ;; the symbol of the method that initializes the fields that a class declares
+  (global $fields i32 (i32.const -1))
+  ;; the packages that the program is made of, as a count followed by the address of each package
+  (global $packages i32 (i32.const 0))
+  ;; the bottom of the argument stack
+  (global $stack_limit (export "stack_limit") i32 (i32.const 0))
+  ;; the dispatch table, which starts with the class count, the cell count and the addresses of the
+  ;; rows and of the cells
+  (global $dispatch i32 (i32.const 0))
+  ;; the value held by the cell that the last lookup found
+  (global $method (mut i64) (i64.const 0))
+  ;; how many rows and cells there is room for once they have been moved to the heap
+  (global $row_capacity (mut i32) (i32.const 0))
+  (global $cell_capacity (mut i32) (i32.const 0))
+  ;; the class that the program defined most recently
+  (global $last_class (mut i32) (i32.const 0))
+  ;; how many packages have started
+  (global $started (mut i32) (i32.const 0))
+
+  ;; addresses 0 to 15 are scratch space for passing results to and from WASI
+  (data (i32.const 16) "sys")
+  (data (i32.const 32) "lync: message not understood\n")
+  (data (i32.const 64) "lync: stack overflow\n")
+  (data (i32.const 96) "lync: undefined variable\n")
+
+  ;; values
+
+  (func $value (param $class i32) (param $payload i32) (result i64)
+    (i64.or
+      (i64.shl (i64.extend_i32_u (local.get $class)) (i64.const 32))
+      (i64.extend_i32_u (local.get $payload))))
+
+  (func $class (param $v i64) (result i32)
+    (i32.wrap_i64 (i64.shr_u (local.get $v) (i64.const 32))))
+
+  ;; expect gives the payload of a value of the given class
+  (func $expect (param $v i64) (param $class i32) (result i32)
+    (if (i32.ne (call $class (local.get $v)) (local.get $class))
+      (then (call $not_understood)))
+    (i32.wrap_i64 (local.get $v)))
+
+  (func $alloc (export "alloc") (param $size i32) (result i32)
+    (local $addr i32)
+    (local.set $addr (global.get $heap))
+    (global.set $heap
+      (i32.and (i32.add (i32.add (local.get $addr) (local.get $size)) (i32.const 7))
+               (i32.const -8)))
+    (if (i32.gt_u (global.get $heap) (i32.shl (memory.size) (i32.const 16)))
+      (then
+        (if (i32.eq
+              (memory.grow
+                (i32.sub (i32.shr_u (i32.add (global.get $heap) (i32.const 0xffff)) (i32.const 16))
+                         (memory.size)))
+              (i32.const -1))
+          (then unreachable))))
+    (local.get $addr))
+
+  (func (export "unit") (param $globals i32) (result i64)
+    (call $value (i32.const 0) (local.get $globals)))
+
+  ;; truth gives whether a value counts as true, as the condition of an if. Unit, zero and the
+  ;; empty string are false, and everything else is true.
+  (func (export "truth") (param $v i64) (result i32)
+    (local $class i32)
+    (local.set $class (call $class (local.get $v)))
+    (if (i32.eqz (local.get $class))
+      (then (return (i32.const 0))))
+    (if (i32.eq (local.get $class) (i32.const 2))
+      (then (return (i32.ne (i32.wrap_i64 (local.get $v)) (i32.const 0)))))
+    (if (i32.eq (local.get $class) (i32.const 3))
+      (then (return (f64.ne (f64.load (i32.wrap_i64 (local.get $v))) (f64.const 0)))))
+    (if (i32.eq (local.get $class) (i32.const 4))
+      (then (return (i32.ne (i32.load (i32.wrap_i64 (local.get $v))) (i32.const 0)))))
+    (i32.const 1))
+
+  ;; globals gives the address of the global variables of the package whose unit is starting
+  (func (export "globals") (result i32)
+    (local $p i32)
+    (local.set $p
+      (i32.load offset=4
+        (i32.add (global.get $packages) (i32.shl (global.get $started) (i32.const 2)))))
+    (global.set $started (i32.add (global.get $started) (i32.const 1)))
+    (i32.load offset=8 (local.get $p)))
+
+  (func (export "name") (param $symbol i64) (result i64)
+    (call $value (i32.const 1) (i32.wrap_i64 (local.get $symbol))))
+
+  (func $int (export "int") (param $n i64) (result i64)
+    (call $value (i32.const 2) (i32.wrap_i64 (local.get $n))))
+
+  (func $float (export "float") (param $x f64) (result i64)
+    (local $addr i32)
+    (local.set $addr (call $alloc (i32.const 8)))
+    (f64.store (local.get $addr) (local.get $x))
+    (call $value (i32.const 3) (local.get $addr)))
+
+  (func $string (export "string") (param $addr i32) (param $len i32) (result i64)
+    (local $s i32)
+    (local.set $s (call $alloc (i32.const 8)))
+    (i32.store (local.get $s) (local.get $len))
+    (i32.store offset=4 (local.get $s) (local.get $addr))
+    (call $value (i32.const 4) (local.get $s)))
+
+  (func (export "block") (param $slot i32) (param $argc i32) (result i64)
+    (local $f i32)
+    (local.set $f (call $alloc (i32.const 8)))
+    (i32.store (local.get $f) (local.get $slot))
+    (i32.store offset=4 (local.get $f) (local.get $argc))
+    (call $value (i32.const 5) (local.get $f)))
+
+  ;; cstring wraps a string that ends with a zero byte
+  (func $cstring (param $s i32) (result i64)
+    (local $end i32)
+    (local.set $end (local.get $s))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (i32.load8_u (local.get $end))))
+        (local.set $end (i32.add (local.get $end) (i32.const 1)))
+        (br $next)))
+    (call $string (local.get $s) (i32.sub (local.get $end) (local.get $s))))
+
+  (func $equal (param $a i32) (param $b i32) (param $n i32) (result i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+        (if (i32.ne (i32.load8_u (local.get $a)) (i32.load8_u (local.get $b)))
+          (then (return (i32.const 0))))
+        (local.set $a (i32.add (local.get $a) (i32.const 1)))
+        (local.set $b (i32.add (local.get $b) (i32.const 1)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $next)))
+    (i32.const 1))
+
+  ;; format_int gives the decimal representation of an integer, as a string payload
+  (func $format_int (param $n i32) (result i32)
+    (local $end i32) (local $at i32) (local $u i32)
+    (local.set $end (i32.add (call $alloc (i32.const 12)) (i32.const 12)))
+    (local.set $at (local.get $end))
+    (local.set $u
+      (select (i32.sub (i32.const 0) (local.get $n)) (local.get $n)
+              (i32.lt_s (local.get $n) (i32.const 0))))
+    (loop $digit
+      (local.set $at (i32.sub (local.get $at) (i32.const 1)))
+      (i32.store8 (local.get $at) (i32.add (i32.const 48) (i32.rem_u (local.get $u) (i32.const 10))))
+      (local.set $u (i32.div_u (local.get $u) (i32.const 10)))
+      (br_if $digit (local.get $u)))
+    (if (i32.lt_s (local.get $n) (i32.const 0))
+      (then
+        (local.set $at (i32.sub (local.get $at) (i32.const 1)))
+        (i32.store8 (local.get $at) (i32.const 45))))
+    (i32.wrap_i64
+      (call $string (local.get $at) (i32.sub (local.get $end) (local.get $at)))))
+
+  ;; errors
+
+  ;; fail writes a message to standard error and stops the program
+  (func $fail (param $message i32) (param $size i32)
+    (i32.store (i32.const 0) (local.get $message))
+    (i32.store (i32.const 4) (local.get $size))
+    (drop (call $fd_write (i32.const 2) (i32.const 0) (i32.const 1) (i32.const 8)))
+    (call $proc_exit (i32.const 1))
+    unreachable)
+
+  (func $not_understood
+    (call $fail (i32.const 32) (i32.const 29)))
+
+  ;; stack_overflow is called when there is no room left on the argument stack
+  (func $stack_overflow (export "stack_overflow")
+    (call $fail (i32.const 64) (i32.const 21)))
+
+  ;; reserve makes room for size bytes on the argument stack, giving the new top of the stack
+  (func $reserve (param $size i32) (result i32)
+    (global.set $sp (i32.sub (global.get $sp) (local.get $size)))
+    (if (i32.lt_u (global.get $sp) (global.get $stack_limit))
+      (then (call $stack_overflow)))
+    (global.get $sp))
+
+  (func $arity (param $argc i32) (param $want i32)
+    (if (i32.ne (local.get $argc) (local.get $want))
+      (then (call $not_understood))))
+
+  ;; missing is invoked when an object does not understand a message
+  (func $missing (type $method)
+    (call $not_understood)
+    unreachable)
+
+  ;; invoke calls the method held by the cell that lookup found. The cells of the classes that a
+  ;; program defines all direct lookups to invoke, which is always in slot 1 of the function table.
+  (func $invoke (type $method)
+    (return_call $call (global.get $method) (local.get 0) (local.get 1)))
+
+  ;; call_export calls the function found by lookup_package, which is always in slot 2
+  ;; of the function table. The package is not passed to the function.
+  (func $call_export (type $method)
+    (return_call $call
+      (global.get $method)
+      (i32.add (local.get 0) (i32.const 8))
+      (i32.sub (local.get 1) (i32.const 1))))
+
+  ;; run makes the calls that have been put off until the caller has returned, giving the result of
+  ;; the last one. It is defined by wasi.go.
+  (func $run (export "run") (param $result i64) (result i64)
+    unreachable)
+
+  ;; call_now applies a function or closure and waits for the result, unlike call which may leave
+  ;; the work to the main loop.
+  (func $call_now (param $f i64) (param $argv i32) (param $argc i32) (result i64)
+    (call $run (call $call (local.get $f) (local.get $argv) (local.get $argc))))
+
+  ;; call applies a function or closure to arguments on the stack. Closures pass their captured
+  ;; values before the arguments.
+  ;;
+  ;; Functions copy their arguments before doing anything else, so the arguments can be released
+  ;; before the call is made. This keeps every call here in tail position.
+  (func $call (param $f i64) (param $argv i32) (param $argc i32) (result i64)
+    (local $p i32) (local $n i32) (local $size i32)
+    (local.set $p (i32.wrap_i64 (local.get $f)))
+    (if (i32.eq (call $class (local.get $f)) (i32.const 5))
+      (then
+        (return_call_indirect (type $method)
+          (local.get $argv) (local.get $argc) (i32.load (local.get $p)))))
+    (if (i32.eq (call $class (local.get $f)) (i32.const 9))
+      (then
+        (return_call $construct (local.get $p) (local.get $argv) (local.get $argc))))
+    (local.set $p (call $expect (local.get $f) (i32.const 6)))
+    (local.set $n (i32.load offset=8 (local.get $p)))
+    (local.set $size (i32.shl (i32.add (local.get $n) (local.get $argc)) (i32.const 3)))
+    (drop (call $reserve (local.get $size)))
+    (memory.copy
+      (global.get $sp)
+      (i32.add (local.get $p) (i32.const 16))
+      (i32.shl (local.get $n) (i32.const 3)))
+    (memory.copy
+      (i32.add (global.get $sp) (i32.shl (local.get $n) (i32.const 3)))
+      (local.get $argv)
+      (i32.shl (local.get $argc) (i32.const 3)))
+    (global.set $sp (i32.add (global.get $sp) (local.get $size)))
+    (return_call $call
+      (i64.load (local.get $p))
+      (i32.sub (global.get $sp) (local.get $size))
+      (i32.add (local.get $n) (local.get $argc))))
+
+  ;; classes and objects
+
+  ;; find gives the entry for a symbol in a list, or 0 if there is none
+  (func $find (param $entry i32) (param $symbol i32) (result i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $entry)))
+        (if (i32.eq (i32.load offset=4 (local.get $entry)) (local.get $symbol))
+          (then (return (local.get $entry))))
+        (local.set $entry (i32.load (local.get $entry)))
+        (br $next)))
+    (i32.const 0))
+
+  ;; put sets the value for a symbol in the list whose head is at an address
+  (func $put (param $head i32) (param $symbol i32) (param $v i64)
+    (local $entry i32)
+    (local.set $entry (call $find (i32.load (local.get $head)) (local.get $symbol)))
+    (if (i32.eqz (local.get $entry))
+      (then
+        (local.set $entry (call $alloc (i32.const 16)))
+        (i32.store (local.get $entry) (i32.load (local.get $head)))
+        (i32.store offset=4 (local.get $entry) (local.get $symbol))
+        (i32.store (local.get $head) (local.get $entry))))
+    (i64.store offset=8 (local.get $entry) (local.get $v)))
+
+  ;; lookup_package finds the function that a package exports under a name. It gives the function
+  ;; table slot of call_export, which calls the function. The dispatch table defers to lookup_package
+  ;; for anything that it has no method for.
+  (func $lookup_package (param $object i64) (param $selector i64) (result i64)
+    (if (i32.ne (call $class (local.get $object)) (i32.const 10))
+      (then (return (i64.const 0))))
+    (global.set $method
+      (call $export (i32.wrap_i64 (local.get $object)) (i32.wrap_i64 (local.get $selector))))
+    (i64.const 2))
+
+  ;; object gives the payload of an object, which has the ID of a class that the program defined
+  ;; as the class of its value
+  (func $object (param $v i64) (result i32)
+    (if (i32.lt_u (call $class (local.get $v)) (i32.const 11))
+      (then (call $not_understood)))
+    (i32.wrap_i64 (local.get $v)))
+
+  ;; construct makes a new instance of a class, initializing its fields and then passing the
+  ;; arguments to its init method
+  (func $construct (param $c i32) (param $argv i32) (param $argc i32) (result i64)
+    (local $object i64) (local $size i32) (local $frame i32) (local $init i32)
+    (local.set $object
+      (call $value (i32.load offset=8 (local.get $c)) (call $alloc (i32.const 8))))
+    (i32.store (i32.wrap_i64 (local.get $object)) (local.get $c))
+    (local.set $init (call $cell (i32.load offset=8 (local.get $c)) (global.get $init)))
+    (if (i32.eqz (local.get $init))
+      (then (call $arity (local.get $argc) (i32.const 0))))
+    ;; the arguments might be in space that has been released, so they are moved before anything
+    ;; else is put on the stack
+    (local.set $size (i32.shl (i32.add (local.get $argc) (i32.const 1)) (i32.const 3)))
+    (local.set $frame (call $reserve (local.get $size)))
+    (memory.copy
+      (i32.add (local.get $frame) (i32.const 8))
+      (local.get $argv)
+      (i32.shl (local.get $argc) (i32.const 3)))
+    (i64.store (local.get $frame) (local.get $object))
+    (call $init_fields (local.get $c) (local.get $object))
+    (if (local.get $init)
+      (then
+        (drop
+          (call $call_now
+            (i64.load offset=8 (local.get $init))
+            (local.get $frame)
+            (i32.add (local.get $argc) (i32.const 1))))))
+    (global.set $sp (i32.add (local.get $frame) (local.get $size)))
+    (local.get $object))
+
+  ;; init_fields initializes the fields that a class and its ancestors declare, starting with those
+  ;; of the root class. Each class has its own method for this, which is never inherited.
+  (func $init_fields (param $c i32) (param $object i64)
+    (local $entry i32)
+    (if (i32.eqz (local.get $c))
+      (then (return)))
+    (call $init_fields (i32.load (local.get $c)) (local.get $object))
+    (local.set $entry (call $find (i32.load offset=4 (local.get $c)) (global.get $fields)))
+    (if (i32.eqz (local.get $entry))
+      (then (return)))
+    (i64.store (call $reserve (i32.const 8)) (local.get $object))
+    (drop (call $call_now (i64.load offset=8 (local.get $entry)) (global.get $sp) (i32.const 1)))
+    (global.set $sp (i32.add (global.get $sp) (i32.const 8))))
+
+  ;; the dispatch table
+
+  ;; cell gives the address of the cell that holds the method that instances of a class use to
+  ;; respond to a message, or 0 if they do not understand it
+  (func $cell (param $class i32) (param $symbol i32) (result i32)
+    (local $i i32)
+    (local.set $i
+      (i32.add
+        (i32.load
+          (i32.add
+            (i32.load offset=8 (global.get $dispatch))
+            (i32.shl (local.get $class) (i32.const 2))))
+        (local.get $symbol)))
+    (if (i32.ge_u (local.get $i) (i32.load offset=4 (global.get $dispatch)))
+      (then (return (i32.const 0))))
+    (local.set $i
+      (i32.add (i32.load offset=12 (global.get $dispatch)) (i32.shl (local.get $i) (i32.const 4))))
+    (if (i32.ne (i32.load (local.get $i)) (local.get $class))
+      (then (return (i32.const 0))))
+    (local.get $i))
+
+  ;; add_class gives a class the next ID and a row, moving the rows to a bigger space on the heap if
+  ;; there is no room for another
+  (func $add_class (param $c i32)
+    (local $id i32) (local $rows i32)
+    (local.set $id (i32.load (global.get $dispatch)))
+    (if (i32.ge_u (local.get $id) (global.get $row_capacity))
+      (then
+        (global.set $row_capacity (i32.shl (local.get $id) (i32.const 1)))
+        (local.set $rows (call $alloc (i32.shl (global.get $row_capacity) (i32.const 2))))
+        (memory.copy
+          (local.get $rows)
+          (i32.load offset=8 (global.get $dispatch))
+          (i32.shl (local.get $id) (i32.const 2)))
+        (i32.store offset=8 (global.get $dispatch) (local.get $rows))))
+    (i32.store (global.get $dispatch) (i32.add (local.get $id) (i32.const 1)))
+    (i32.store offset=8 (local.get $c) (local.get $id))
+    (if (global.get $last_class)
+      (then (i32.store offset=12 (global.get $last_class) (local.get $c))))
+    (global.set $last_class (local.get $c))
+    (call $place_row (local.get $c)))
+
+  ;; update_rows rebuilds the rows of a class and of the classes that inherit from it, after the
+  ;; class has had a method defined. Classes are made after the classes that they inherit from, so
+  ;; only those made since need to be looked at.
+  (func $update_rows (param $c i32)
+    (local $d i32)
+    (local.set $d (local.get $c))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $d)))
+        (if (call $inherits (local.get $d) (local.get $c))
+          (then (call $place_row (local.get $d))))
+        (local.set $d (i32.load offset=12 (local.get $d)))
+        (br $next))))
+
+  ;; inherits tells whether a class is another class or inherits from it
+  (func $inherits (param $c i32) (param $ancestor i32) (result i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $c)))
+        (if (i32.eq (local.get $c) (local.get $ancestor))
+          (then (return (i32.const 1))))
+        (local.set $c (i32.load (local.get $c)))
+        (br $next)))
+    (i32.const 0))
+
+  ;; place_row gives a class a row that holds every method that its instances understand. The
+  ;; cells that the class had before are freed, and the row goes at the first offset where the
+  ;; cells that it needs are free.
+  ;;
+  ;; The methods are gathered on the argument stack as (symbol i32, padding i32, method i64),
+  ;; searching the class before its ancestors so that the methods that it defines override those
+  ;; that it inherits.
+  (func $place_row (param $c i32)
+    (local $id i32) (local $i i32) (local $at i32) (local $end i32) (local $class i32)
+    (local $entry i32) (local $symbol i32) (local $min i32) (local $max i32) (local $offset i32)
+    (local.set $id (i32.load offset=8 (local.get $c)))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eq (local.get $i) (i32.load offset=4 (global.get $dispatch))))
+        (local.set $at
+          (i32.add (i32.load offset=12 (global.get $dispatch)) (i32.shl (local.get $i) (i32.const 4))))
+        (if (i32.eq (i32.load (local.get $at)) (local.get $id))
+          (then (i32.store (local.get $at) (i32.const -1))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $next)))
+    (local.set $end (global.get $sp))
+    (local.set $min (i32.const -1))
+    (local.set $class (local.get $c))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $class)))
+        (local.set $entry (i32.load offset=4 (local.get $class)))
+        (block $entries_done
+          (loop $entries
+            (br_if $entries_done (i32.eqz (local.get $entry)))
+            (local.set $symbol (i32.load offset=4 (local.get $entry)))
+            (if (i32.eqz (call $gathered (global.get $sp) (local.get $end) (local.get $symbol)))
+              (then
+                (local.set $at (call $reserve (i32.const 16)))
+                (i32.store (local.get $at) (local.get $symbol))
+                (i64.store offset=8 (local.get $at) (i64.load offset=8 (local.get $entry)))
+                (local.set $min
+                  (select (local.get $symbol) (local.get $min)
+                          (i32.lt_u (local.get $symbol) (local.get $min))))
+                (local.set $max
+                  (select (local.get $symbol) (local.get $max)
+                          (i32.gt_u (local.get $symbol) (local.get $max))))))
+            (local.set $entry (i32.load (local.get $entry)))
+            (br $entries)))
+        (local.set $class (i32.load (local.get $class)))
+        (br $next)))
+    ;; a class without methods has a row that no cell belongs to
+    (if (i32.ne (global.get $sp) (local.get $end))
+      (then
+        (local.set $offset (i32.sub (i32.const 0) (local.get $min)))
+        (block $found
+          (loop $next
+            (br_if $found (call $fits (global.get $sp) (local.get $end) (local.get $offset)))
+            (local.set $offset (i32.add (local.get $offset) (i32.const 1)))
+            (br $next)))
+        (call $reserve_cells (i32.add (i32.add (local.get $offset) (local.get $max)) (i32.const 1)))))
+    (local.set $at (global.get $sp))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eq (local.get $at) (local.get $end)))
+        (local.set $entry
+          (i32.add
+            (i32.load offset=12 (global.get $dispatch))
+            (i32.shl (i32.add (local.get $offset) (i32.load (local.get $at))) (i32.const 4))))
+        (i32.store (local.get $entry) (local.get $id))
+        (i32.store offset=4 (local.get $entry) (i32.const 1))
+        (i64.store offset=8 (local.get $entry) (i64.load offset=8 (local.get $at)))
+        (local.set $at (i32.add (local.get $at) (i32.const 16)))
+        (br $next)))
+    (i32.store
+      (i32.add (i32.load offset=8 (global.get $dispatch)) (i32.shl (local.get $id) (i32.const 2)))
+      (local.get $offset))
+    (global.set $sp (local.get $end)))
+
+  ;; gathered tells whether a method for a symbol is among those gathered between two addresses
+  (func $gathered (param $at i32) (param $end i32) (param $symbol i32) (result i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eq (local.get $at) (local.get $end)))
+        (if (i32.eq (i32.load (local.get $at)) (local.get $symbol))
+          (then (return (i32.const 1))))
+        (local.set $at (i32.add (local.get $at) (i32.const 16)))
+        (br $next)))
+    (i32.const 0))
+
+  ;; fits tells whether the cells for the methods gathered between two addresses are free, were the
+  ;; row to go at an offset
+  (func $fits (param $at i32) (param $end i32) (param $offset i32) (result i32)
+    (local $i i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eq (local.get $at) (local.get $end)))
+        (local.set $i (i32.add (local.get $offset) (i32.load (local.get $at))))
+        (if (i32.lt_u (local.get $i) (i32.load offset=4 (global.get $dispatch)))
+          (then
+            (if (i32.ne
+                  (i32.load
+                    (i32.add
+                      (i32.load offset=12 (global.get $dispatch))
+                      (i32.shl (local.get $i) (i32.const 4))))
+                  (i32.const -1))
+              (then (return (i32.const 0))))))
+        (local.set $at (i32.add (local.get $at) (i32.const 16)))
+        (br $next)))
+    (i32.const 1))
+
+  ;; reserve_cells makes sure that there are at least n cells, moving them to a bigger space on the
+  ;; heap if there is no room for that many. The cells that are added are free.
+  (func $reserve_cells (param $n i32)
+    (local $count i32) (local $cells i32)
+    (local.set $count (i32.load offset=4 (global.get $dispatch)))
+    (if (i32.le_u (local.get $n) (local.get $count))
+      (then (return)))
+    (if (i32.gt_u (local.get $n) (global.get $cell_capacity))
+      (then
+        (global.set $cell_capacity
+          (select (local.get $n) (i32.shl (local.get $count) (i32.const 1))
+                  (i32.gt_u (local.get $n) (i32.shl (local.get $count) (i32.const 1)))))
+        (local.set $cells (call $alloc (i32.shl (global.get $cell_capacity) (i32.const 4))))
+        (memory.copy
+          (local.get $cells)
+          (i32.load offset=12 (global.get $dispatch))
+          (i32.shl (local.get $count) (i32.const 4)))
+        (memory.fill
+          (i32.add (local.get $cells) (i32.shl (local.get $count) (i32.const 4)))
+          (i32.const 0xff)
+          (i32.shl (i32.sub (global.get $cell_capacity) (local.get $count)) (i32.const 4)))
+        (i32.store offset=12 (global.get $dispatch) (local.get $cells))))
+    (i32.store offset=4 (global.get $dispatch) (local.get $n)))
+
+  ;; the unit
+
+  ;; global variables that have not been defined hold -1, which is not a valid value
+
+  ;; global gives the address of a global variable of the package whose unit is given
+  (func $global (param $unit i64) (param $name i64) (result i32)
+    (i32.add
+      (i32.wrap_i64 (local.get $unit))
+      (i32.shl (call $expect (local.get $name) (i32.const 1)) (i32.const 3))))
+
+  ;; defined_global is like global, but stops the program if the variable has not been defined
+  (func $defined_global (param $unit i64) (param $name i64) (result i32)
+    (local $g i32)
+    (local.set $g (call $global (local.get $unit) (local.get $name)))
+    (if (i64.eq (i64.load (local.get $g)) (i64.const -1))
+      (then (call $fail (i32.const 96) (i32.const 25))))
+    (local.get $g))
+
+  (func $global_define (type $method)
+    (call $arity (local.get 1) (i32.const 3))
+    (i64.store
+      (call $global (i64.load (local.get 0)) (i64.load offset=8 (local.get 0)))
+      (i64.load offset=16 (local.get 0)))
+    (i64.const 0))
+
+  (func $global_set (type $method)
+    (call $arity (local.get 1) (i32.const 3))
+    (i64.store
+      (call $defined_global (i64.load (local.get 0)) (i64.load offset=8 (local.get 0)))
+      (i64.load offset=16 (local.get 0)))
+    (i64.const 0))
+
+  (func $global_get (type $method)
+    (call $arity (local.get 1) (i32.const 2))
+    (i64.load (call $defined_global (i64.load (local.get 0)) (i64.load offset=8 (local.get 0)))))
+
+  (func $import_package (type $method)
+    (local $s i32) (local $i i32) (local $p i32)
+    (call $arity (local.get 1) (i32.const 2))
+    (local.set $s (call $expect (i64.load offset=8 (local.get 0)) (i32.const 4)))
+    (if (i32.and
+          (i32.eq (i32.load (local.get $s)) (i32.const 3))
+          (call $equal (i32.load offset=4 (local.get $s)) (i32.const 16) (i32.const 3)))
+      (then (return (call $value (i32.const 8) (i32.const 0)))))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eq (local.get $i) (i32.load (global.get $packages))))
+        (local.set $p
+          (i32.load offset=4
+            (i32.add (global.get $packages) (i32.shl (local.get $i) (i32.const 2)))))
+        (if (i32.eq (i32.load (local.get $s)) (i32.load (local.get $p)))
+          (then
+            (if (call $equal
+                  (i32.load offset=4 (local.get $s))
+                  (i32.load offset=4 (local.get $p))
+                  (i32.load (local.get $p)))
+              (then (return (call $value (i32.const 10) (local.get $p)))))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $next)))
+    (call $not_understood)
+    unreachable)
+
+  ;; export gives the value of a global variable that a package exports
+  (func $export (param $p i32) (param $symbol i32) (result i64)
+    (local $at i32) (local $end i32)
+    (local.set $at (i32.add (local.get $p) (i32.const 16)))
+    (local.set $end
+      (i32.add (local.get $at) (i32.shl (i32.load offset=12 (local.get $p)) (i32.const 2))))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eq (local.get $at) (local.get $end)))
+        (if (i32.eq (i32.load (local.get $at)) (local.get $symbol))
+          (then
+            (return
+              (i64.load
+                (call $defined_global
+                  (call $value (i32.const 0) (i32.load offset=8 (local.get $p)))
+                  (call $value (i32.const 1) (local.get $symbol)))))))
+        (local.set $at (i32.add (local.get $at) (i32.const 4)))
+        (br $next)))
+    (call $not_understood)
+    unreachable)
+
+  (func $call_function (type $method)
+    (if (i32.lt_u (local.get 1) (i32.const 2))
+      (then (call $not_understood)))
+    (return_call $call
+      (i64.load offset=8 (local.get 0))
+      (i32.add (local.get 0) (i32.const 16))
+      (i32.sub (local.get 1) (i32.const 2))))
+
+  (func $create_closure (type $method)
+    (local $n i32) (local $c i32)
+    (if (i32.lt_u (local.get 1) (i32.const 2))
+      (then (call $not_understood)))
+    (local.set $n (i32.sub (local.get 1) (i32.const 2)))
+    (local.set $c
+      (call $alloc (i32.add (i32.const 16) (i32.shl (local.get $n) (i32.const 3)))))
+    (i64.store (local.get $c) (i64.load offset=8 (local.get 0)))
+    (i32.store offset=8 (local.get $c) (local.get $n))
+    (memory.copy
+      (i32.add (local.get $c) (i32.const 16))
+      (i32.add (local.get 0) (i32.const 16))
+      (i32.shl (local.get $n) (i32.const 3)))
+    (call $value (i32.const 6) (local.get $c)))
+
+  (func $create_box (type $method)
+    (local $b i32)
+    (call $arity (local.get 1) (i32.const 2))
+    (local.set $b (call $alloc (i32.const 8)))
+    (i64.store (local.get $b) (i64.load offset=8 (local.get 0)))
+    (call $value (i32.const 7) (local.get $b)))
+
+  (func $create_undefined_box (type $method)
+    (local $b i32)
+    (call $arity (local.get 1) (i32.const 2))
+    (local.set $b (call $alloc (i32.const 8)))
+    (i64.store (local.get $b) (i64.const 0))
+    (call $value (i32.const 7) (local.get $b)))
+
+  ;; create_class makes a class, which inherits from another class if one is given
+  (func $create_class (type $method)
+    (local $c i32)
+    (if (i32.or
+          (i32.lt_u (local.get 1) (i32.const 1))
+          (i32.gt_u (local.get 1) (i32.const 2)))
+      (then (call $not_understood)))
+    (local.set $c (call $alloc (i32.const 16)))
+    (if (i32.eq (local.get 1) (i32.const 2))
+      (then
+        (i32.store
+          (local.get $c)
+          (call $expect (i64.load offset=8 (local.get 0)) (i32.const 9)))))
+    (call $add_class (local.get $c))
+    (call $value (i32.const 9) (local.get $c)))
+
+  ;; call_super(class, this, selector, args...) calls the method that the parent of the class that
+  ;; a method was defined in would use to respond to a message
+  (func $call_super (type $method)
+    (local $c i32) (local $entry i32)
+    (if (i32.lt_u (local.get 1) (i32.const 4))
+      (then (call $not_understood)))
+    (local.set $c (i32.load (call $expect (i64.load offset=8 (local.get 0)) (i32.const 9))))
+    (if (i32.eqz (local.get $c))
+      (then (call $not_understood)))
+    (local.set $entry
+      (call $cell
+        (i32.load offset=8 (local.get $c))
+        (call $expect (i64.load offset=24 (local.get 0)) (i32.const 1))))
+    (if (i32.eqz (local.get $entry))
+      (then (call $not_understood)))
+    ;; the receiver takes the place of the selector, so that it comes just before the arguments
+    (i64.store offset=24 (local.get 0) (i64.load offset=16 (local.get 0)))
+    (return_call $call
+      (i64.load offset=8 (local.get $entry))
+      (i32.add (local.get 0) (i32.const 24))
+      (i32.sub (local.get 1) (i32.const 3))))
+
+  ;; methods are functions that take the receiver as their first argument
+  (func $create_method (type $method)
+    (call $arity (local.get 1) (i32.const 2))
+    (i64.load offset=8 (local.get 0)))
+
+  (func $property_get (type $method)
+    (local $entry i32)
+    (call $arity (local.get 1) (i32.const 3))
+    (if (i32.eq (call $class (i64.load offset=8 (local.get 0))) (i32.const 10))
+      (then
+        (return
+          (call $export
+            (i32.wrap_i64 (i64.load offset=8 (local.get 0)))
+            (call $expect (i64.load offset=16 (local.get 0)) (i32.const 1))))))
+    (local.set $entry
+      (call $find
+        (i32.load offset=4 (call $object (i64.load offset=8 (local.get 0))))
+        (call $expect (i64.load offset=16 (local.get 0)) (i32.const 1))))
+    (if (i32.eqz (local.get $entry))
+      (then (call $not_understood)))
+    (i64.load offset=8 (local.get $entry)))
+
+  ;; property_set sets a property of an object, or defines a method of a class
+  (func $property_set (type $method)
+    (local $target i64) (local $symbol i32)
+    (call $arity (local.get 1) (i32.const 4))
+    (local.set $target (i64.load offset=8 (local.get 0)))
+    (local.set $symbol (call $expect (i64.load offset=16 (local.get 0)) (i32.const 1)))
+    (if (i32.eq (call $class (local.get $target)) (i32.const 9))
+      (then
+        (call $put
+          (i32.add (i32.wrap_i64 (local.get $target)) (i32.const 4))
+          (local.get $symbol)
+          (i64.load offset=24 (local.get 0)))
+        (call $update_rows (i32.wrap_i64 (local.get $target)))
+        (return (i64.const 0))))
+    (call $put
+      (i32.add (call $object (local.get $target)) (i32.const 4))
+      (local.get $symbol)
+      (i64.load offset=24 (local.get 0)))
+    (i64.const 0))
+
+  ;; boxes
+
+  (func $box_get (type $method)
+    (call $arity (local.get 1) (i32.const 1))
+    (i64.load (i32.wrap_i64 (i64.load (local.get 0)))))
+
+  (func $box_set (type $method)
+    (call $arity (local.get 1) (i32.const 2))
+    (i64.store (i32.wrap_i64 (i64.load (local.get 0))) (i64.load offset=8 (local.get 0)))
+    (i64.const 0))
+
+  ;; the sys package
+
+  ;; write prints a string or an integer to standard output
+  (func $write (type $method)
+    (local $x i64) (local $s i32)
+    (call $arity (local.get 1) (i32.const 2))
+    (local.set $x (i64.load offset=8 (local.get 0)))
+    (if (i32.eq (call $class (local.get $x)) (i32.const 2))
+      (then (local.set $s (call $format_int (i32.wrap_i64 (local.get $x)))))
+      (else (local.set $s (call $expect (local.get $x) (i32.const 4)))))
+    (i32.store (i32.const 0) (i32.load offset=4 (local.get $s)))
+    (i32.store (i32.const 4) (i32.load (local.get $s)))
+    (drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 8)))
+    (i64.const 0))
+
+  (func $exit (type $method)
+    (call $arity (local.get 1) (i32.const 2))
+    (call $proc_exit (call $expect (i64.load offset=8 (local.get 0)) (i32.const 2)))
+    unreachable)
+
+  (func $arg_count (type $method)
+    (call $arity (local.get 1) (i32.const 1))
+    (drop (call $args_sizes_get (i32.const 0) (i32.const 4)))
+    (call $int (i64.extend_i32_u (i32.load (i32.const 0)))))
+
+  ;; arg gives a command line argument, or the unit if there are not that many
+  (func $arg (type $method)
+    (local $i i32) (local $argv i32)
+    (call $arity (local.get 1) (i32.const 2))
+    (local.set $i (call $expect (i64.load offset=8 (local.get 0)) (i32.const 2)))
+    (drop (call $args_sizes_get (i32.const 0) (i32.const 4)))
+    (if (i32.ge_u (local.get $i) (i32.load (i32.const 0)))
+      (then (return (i64.const 0))))
+    (local.set $argv (call $alloc (i32.shl (i32.load (i32.const 0)) (i32.const 2))))
+    (drop (call $args_get (local.get $argv) (call $alloc (i32.load (i32.const 4)))))
+    (call $cstring
+      (i32.load (i32.add (local.get $argv) (i32.shl (local.get $i) (i32.const 2))))))
+
+  ;; env gives the value of an environment variable, or the unit if it is not set
+  (func $env (type $method)
+    (local $name i32) (local $len i32) (local $environ i32) (local $count i32) (local $entry i32)
+    (call $arity (local.get 1) (i32.const 2))
+    (local.set $name (call $expect (i64.load offset=8 (local.get 0)) (i32.const 4)))
+    (local.set $len (i32.load (local.get $name)))
+    (local.set $name (i32.load offset=4 (local.get $name)))
+    (drop (call $environ_sizes_get (i32.const 0) (i32.const 4)))
+    (local.set $count (i32.load (i32.const 0)))
+    (local.set $environ (call $alloc (i32.shl (local.get $count) (i32.const 2))))
+    (drop (call $environ_get (local.get $environ) (call $alloc (i32.load (i32.const 4)))))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $count)))
+        (local.set $entry (i32.load (local.get $environ)))
+        (if (i32.and
+              (call $equal (local.get $entry) (local.get $name) (local.get $len))
+              (i32.eq (i32.load8_u (i32.add (local.get $entry) (local.get $len))) (i32.const 61)))
+          (then
+            (return
+              (call $cstring (i32.add (i32.add (local.get $entry) (local.get $len)) (i32.const 1))))))
+        (local.set $environ (i32.add (local.get $environ) (i32.const 4)))
+        (local.set $count (i32.sub (local.get $count) (i32.const 1)))
+        (br $next)))
+    (i64.const 0))
+
+  ;; time gives the wall clock time in seconds
+  (func $time (type $method)
+    (call $arity (local.get 1) (i32.const 1))
+    (drop (call $clock_time_get (i32.const 0) (i64.const 1000) (i32.const 8)))
+    (call $float
+      (f64.div (f64.convert_i64_u (i64.load (i32.const 8))) (f64.const 1e9))))
+)
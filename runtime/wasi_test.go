@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+	"github.com/bobappleyard/lync/util/wasm"
+)
+
+func TestWASI(t *testing.T) {
+	symbols := []string{"x", "write", "get", "frobnicate"}
+	m, err := WASI(symbols, nil, ReturnCall)
+	assert.Nil(t, err)
+	assert.Nil(t, m.Validate())
+
+	exports := map[string]bool{}
+	for _, exp := range m.Exports {
+		exports[exportName(exp)] = true
+	}
+	for _, name := range []string{"_start", "memory", "table", "sp", "lookup", "alloc", "tail", "run"} {
+		assert.True(t, exports[name])
+	}
+
+	// only the methods named by the symbols are in the method table, after those for missing
+	// methods, methods of objects and functions exported by packages
+	elem := m.Elements[len(m.Elements)-1].(*wasm.FuncElement)
+	assert.Equal(t, len(elem.Funcs), 5)
+}
+
+func TestWASISession(t *testing.T) {
+	m, symbols, err := WASISession(100)
+	assert.Nil(t, err)
+	assert.Nil(t, m.Validate())
+
+	// every method is in the method table, along with those for missing methods, methods of
+	// objects and functions exported by packages
+	elem := m.Elements[len(m.Elements)-1].(*wasm.FuncElement)
+	assert.Equal(t, len(elem.Funcs), len(wasiMethods)+3)
+	assert.Equal(t, len(symbols), len(wasiMethods)+len(wasiSymbols))
+
+	_, _, err = WASISession(3)
+	assert.True(t, err != nil)
+}
+
+func TestFormatWASIValue(t *testing.T) {
+	mem := make([]byte, 32)
+	binary.LittleEndian.PutUint64(mem[8:], math.Float64bits(1.5))
+	binary.LittleEndian.PutUint32(mem[16:], 2)
+	binary.LittleEndian.PutUint32(mem[20:], 24)
+	copy(mem[24:], "hi")
+
+	for _, test := range []struct {
+		value uint64
+		out   string
+	}{
+		{MakeValue(unitClass, 0), "unit"},
+		{MakeValue(nameClass, 1), "y"},
+		{MakeValue(nameClass, 5), "<invalid>"},
+		{MakeValue(intClass, uint32(0xffffffff)), "-1"},
+		{MakeValue(floatClass, 8), "1.5"},
+		{MakeValue(stringClass, 16), `"hi"`},
+		{MakeValue(stringClass, 30), "<invalid>"},
+		{MakeValue(closureClass, 0), "<function>"},
+		{MakeValue(classClass, 0), "<class>"},
+		{MakeValue(wasiClassCount+9, 0), "<object>"},
+	} {
+		assert.Equal(t, FormatWASIValue(test.value, mem, []string{"x", "y"}), test.out)
+	}
+}
+
+func exportName(e wasm.Export) string {
+	switch e := e.(type) {
+	case wasm.FuncExport:
+		return e.Name
+	case wasm.TableExport:
+		return e.Name
+	case wasm.MemoryExport:
+		return e.Name
+	case wasm.GlobalExport:
+		return e.Name
+	}
+	return ""
+}
@@ -15,6 +15,13 @@ type SparseMatrixElement[T any] struct {
 	Value T
 }
 
+// SparseMatrixCell is a slot in the packed representation of a matrix. Row is -1 for slots that
+// no row occupies.
+type SparseMatrixCell[T any] struct {
+	Row   int
+	Value T
+}
+
 type matrixEntry[T any] struct {
 	value T
 	row   int // the ID of the row this belongs to
@@ -35,8 +42,13 @@ func (m *SparseMatrix[T]) Copy() *SparseMatrix[T] {
 }
 
 func (m *SparseMatrix[T]) AddRow(elements []SparseMatrixElement[T]) int {
-	start, end := rowBounds(elements)
 	row := len(m.rows)
+	if len(elements) == 0 {
+		m.rows = append(m.rows, matrixRow{start: -1})
+		return row
+	}
+
+	start, end := rowBounds(elements)
 
 	offset := m.findOffset(elements, start)
 	m.ensureEntries(offset + end + 1)
@@ -58,7 +70,7 @@ func (m *SparseMatrix[T]) LookupValue(row, col int) (T, bool) {
 	}
 
 	pos := m.rows[row].offset + col
-	if pos < 0 || pos >= len(m.entries) {
+	if pos < 0 || pos >= len(m.entries) || m.entries[pos].row != row {
 		return zero, false
 	}
 
@@ -84,6 +96,23 @@ func (m *SparseMatrix[T]) LookupRow(row int) []SparseMatrixElement[T] {
 	return res
 }
 
+// Packed returns the displacement of each row into the packed cells. The element at (row, col) is
+// found in cells[offsets[row]+col], provided that the cell exists and belongs to that row.
+func (m *SparseMatrix[T]) Packed() (offsets []int, cells []SparseMatrixCell[T]) {
+	offsets = make([]int, len(m.rows))
+	for i, r := range m.rows {
+		offsets[i] = r.offset
+	}
+	cells = make([]SparseMatrixCell[T], len(m.entries))
+	for i, e := range m.entries {
+		cells[i] = SparseMatrixCell[T]{
+			Row:   e.row,
+			Value: e.value,
+		}
+	}
+	return offsets, cells
+}
+
 func rowBounds[T any](elements []SparseMatrixElement[T]) (start, end int) {
 	start = math.MaxInt
 	for _, e := range elements {
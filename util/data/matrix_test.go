@@ -25,6 +25,9 @@ func TestMatrix_AddRow(t *testing.T) {
 	assertLookup(1, 2, 10)
 	assertLookup(0, 3, 30)
 
+	_, ok := m.LookupValue(0, 2)
+	assert.False(t, ok)
+
 	assert.Equal(t, m.LookupRow(0), []SparseMatrixElement[int]{{0, 0}, {1, 10}, {3, 30}})
 	assert.Equal(t, m.LookupRow(2), []SparseMatrixElement[int]{{5, 50}, {4, 40}, {9, 60}})
 
@@ -71,3 +74,34 @@ func TestMatrix_LookupRow(t *testing.T) {
 	assert.Equal(t, m.LookupRow(-1), nil)
 	assert.Equal(t, m.LookupRow(2), nil)
 }
+
+func TestMatrix_EmptyRow(t *testing.T) {
+	var m SparseMatrix[int]
+
+	m.AddRow([]SparseMatrixElement[int]{{1, 10}})
+	empty := m.AddRow(nil)
+	m.AddRow([]SparseMatrixElement[int]{{0, 20}})
+
+	assert.Equal(t, empty, 1)
+	assert.Equal(t, m.LookupRow(empty), nil)
+
+	_, ok := m.LookupValue(empty, 0)
+	assert.False(t, ok)
+	_, ok = m.LookupValue(empty, 1)
+	assert.False(t, ok)
+}
+
+func TestMatrix_Packed(t *testing.T) {
+	var m SparseMatrix[int]
+
+	m.AddRow([]SparseMatrixElement[int]{{0, 1}, {2, 3}})
+	m.AddRow([]SparseMatrixElement[int]{{1, 2}})
+
+	offsets, cells := m.Packed()
+	for row, elems := range [][]SparseMatrixElement[int]{{{0, 1}, {2, 3}}, {{1, 2}}} {
+		for _, e := range elems {
+			cell := cells[offsets[row]+e.Col]
+			assert.Equal(t, cell, SparseMatrixCell[int]{Row: row, Value: e.Value})
+		}
+	}
+}
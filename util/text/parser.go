@@ -352,7 +352,7 @@ func (p *matcher) matches(root *symbol) error {
 				continue
 			}
 			return &UnexpectedToken{
-				p.toks[i],
+				p.toks[i].Interface(),
 			}
 		}
 	}
@@ -0,0 +1,170 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnbalancedBlocks = errors.New("unbalanced blocks")
+	ErrLabelNotInScope  = errors.New("label not in scope")
+)
+
+// BlockType describes the values that a block takes from the operand stack and those that it
+// leaves there. Blocks that take nothing and leave at most one value are described inline, while
+// others refer to a function type in the module.
+type BlockType int64
+
+// Void is the type of blocks that neither take nor leave any values.
+const Void BlockType = -0x40
+
+// Result is the type of blocks that take nothing and leave a single value of type t.
+func Result(t NumberType) BlockType {
+	return BlockType(int64(t) - 0x80)
+}
+
+// BlockType gives the type of blocks that take values of the types in and leave values of the
+// types out, adding a function type to the module if needed.
+func (m *Module) BlockType(in, out []Type) BlockType {
+	if len(in) == 0 && len(out) == 0 {
+		return Void
+	}
+	if len(in) == 0 && len(out) == 1 {
+		if t, ok := out[0].(NumberType); ok {
+			return Result(t)
+		}
+	}
+	return BlockType(m.EnsureType(FuncType{In: in, Out: out}))
+}
+
+func (t BlockType) AppendWasm(buf []byte) []byte {
+	return appendInt64(buf, int64(t))
+}
+
+// Label refers to a block that is being built, so that branches can target it without counting
+// how deeply they are nested within it. Branching to a loop continues with the next iteration,
+// while branching to any other block leaves it.
+type Label struct {
+	block *block
+}
+
+type block struct {
+	op      Opcode
+	hasElse bool
+}
+
+func (c *Code) Block(t BlockType) Label { return c.open(OpBlock, t) }
+func (c *Code) Loop(t BlockType) Label  { return c.open(OpLoop, t) }
+func (c *Code) If(t BlockType) Label    { return c.open(OpIf, t) }
+
+func (c *Code) open(op Opcode, t BlockType) Label {
+	b := &block{op: op}
+	c.blocks = append(c.blocks, b)
+	c.Instructions = append(c.Instructions, byte(op))
+	c.Instructions = t.AppendWasm(c.Instructions)
+	return Label{b}
+}
+
+func (c *Code) Else() {
+	if len(c.blocks) == 0 {
+		c.fail(fmt.Errorf("%w: else outside of if", ErrUnbalancedBlocks))
+	} else if b := c.blocks[len(c.blocks)-1]; b.op != OpIf || b.hasElse {
+		c.fail(fmt.Errorf("%w: else outside of if", ErrUnbalancedBlocks))
+	} else {
+		b.hasElse = true
+	}
+	c.op(0x05)
+}
+
+// End closes the innermost block, or the function body if there are no blocks open. Temporary
+// locals only last until the end of the function.
+func (c *Code) End() {
+	if len(c.blocks) == 0 {
+		c.free = nil
+	} else if c.blocks = c.blocks[:len(c.blocks)-1]; len(c.blocks) == 0 {
+		c.blocks = nil
+	}
+	c.op(0x0b)
+}
+
+func (c *Code) Branch(l Label)   { c.op(0x0c, c.depth(l)) }
+func (c *Code) BranchIf(l Label) { c.op(0x0d, c.depth(l)) }
+
+// BranchTable branches to the label at the index on top of the stack, or to def if the index is
+// out of range.
+func (c *Code) BranchTable(ls []Label, def Label) {
+	c.Instructions = append(c.Instructions, 0x0e)
+	c.Instructions = appendUint32(c.Instructions, uint32(len(ls)))
+	for _, l := range ls {
+		c.Instructions = appendUint32(c.Instructions, c.depth(l))
+	}
+	c.Instructions = appendUint32(c.Instructions, c.depth(def))
+}
+
+func (c *Code) depth(l Label) uint32 {
+	for i := len(c.blocks) - 1; i >= 0; i-- {
+		if c.blocks[i] == l.block {
+			return uint32(len(c.blocks) - 1 - i)
+		}
+	}
+	c.fail(ErrLabelNotInScope)
+	return 0
+}
+
+func (c *Code) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Check reports the first mistake made while building the function body using labels, or whether
+// its blocks are unbalanced.
+func (c *Code) Check() error {
+	if c.err != nil {
+		return c.err
+	}
+	instrs, err := c.Decode()
+	if err != nil {
+		return err
+	}
+	_, _, err = matchBlocks(instrs)
+	return err
+}
+
+// matchBlocks finds the end of each block, loop and if, as well as the end of each else clause
+// and the else clause of each if, or -1 if it doesn't have one. The instructions must end with the
+// end of the function body.
+func matchBlocks(instrs []Instruction) (ends, elses []int, err error) {
+	ends = make([]int, len(instrs))
+	elses = make([]int, len(instrs))
+
+	var open []int
+	for i, x := range instrs {
+		elses[i] = -1
+		switch x.Op {
+		case OpBlock, OpLoop, OpIf:
+			open = append(open, i)
+
+		case OpElse:
+			if len(open) == 0 || instrs[open[len(open)-1]].Op != OpIf || elses[open[len(open)-1]] != -1 {
+				return nil, nil, fmt.Errorf("%w: else outside of if", ErrUnbalancedBlocks)
+			}
+			elses[open[len(open)-1]] = i
+
+		case OpEnd:
+			if len(open) == 0 {
+				if i != len(instrs)-1 {
+					return nil, nil, fmt.Errorf("%w: instructions after end of function", ErrUnbalancedBlocks)
+				}
+				return ends, elses, nil
+			}
+			start := open[len(open)-1]
+			open = open[:len(open)-1]
+			ends[start] = i
+			if e := elses[start]; e != -1 {
+				ends[e] = i
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: missing end", ErrUnbalancedBlocks)
+}
@@ -0,0 +1,174 @@
+package wasm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestLabels(t *testing.T) {
+	var m Module
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	acc := c.AddLocal(Int32)
+
+	// while n != 0 { acc = acc + n; n = n - 1 }
+	done := c.Block(Void)
+	loop := c.Loop(Void)
+
+	c.LocalGet(0)
+	c.I32Eqz()
+	c.BranchIf(done)
+
+	c.LocalGet(acc)
+	c.LocalGet(0)
+	c.I32Add()
+	c.LocalSet(acc)
+
+	c.LocalGet(0)
+	c.I32Const(1)
+	c.I32Sub()
+	c.LocalSet(0)
+	c.Branch(loop)
+
+	c.End()
+	c.End()
+	c.LocalGet(acc)
+	c.End()
+
+	assert.Nil(t, c.Check())
+
+	instrs, err := c.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, instrs[4], Instruction{Op: OpBrIf, Args: []uint64{1}})
+	assert.Equal(t, instrs[13], Instruction{Op: OpBr, Args: []uint64{0}})
+
+	testModule(t, m, 4, 10)
+}
+
+func TestTypedBlocks(t *testing.T) {
+	var m Module
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+
+	// (n + 1) * (n == 0 ? 10 : 20)
+	c.LocalGet(0)
+	c.Block(m.BlockType([]Type{Int32}, []Type{Int32}))
+	c.I32Const(1)
+	c.I32Add()
+	c.End()
+	c.LocalGet(0)
+	c.I32Eqz()
+	c.If(Result(Int32))
+	c.I32Const(10)
+	c.Else()
+	c.I32Const(20)
+	c.End()
+	c.I32Mul()
+	c.End()
+
+	assert.Nil(t, c.Check())
+	assert.Equal(t, m.Types, []Type{
+		FuncType{In: []Type{Int32}, Out: []Type{Int32}},
+	})
+
+	testModule(t, m, 0, 10)
+	testModule(t, m, 2, 60)
+}
+
+func TestBranchTable(t *testing.T) {
+	var m Module
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+
+	outer := c.Block(Void)
+	two := c.Block(Void)
+	one := c.Block(Void)
+	c.LocalGet(0)
+	c.BranchTable([]Label{one, two}, outer)
+	c.End()
+	c.I32Const(100)
+	c.Return()
+	c.End()
+	c.I32Const(200)
+	c.Return()
+	c.End()
+	c.I32Const(300)
+	c.End()
+
+	assert.Nil(t, c.Check())
+
+	testModule(t, m, 0, 100)
+	testModule(t, m, 1, 200)
+	testModule(t, m, 7, 300)
+}
+
+func TestUnbalancedBlocks(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		build func(c *Code)
+		err   error
+	}{
+		{
+			name: "MissingEnd",
+			build: func(c *Code) {
+				c.Block(Void)
+				c.End()
+			},
+			err: ErrUnbalancedBlocks,
+		},
+		{
+			name: "ExtraEnd",
+			build: func(c *Code) {
+				c.End()
+				c.End()
+			},
+			err: ErrUnbalancedBlocks,
+		},
+		{
+			name: "ElseOutsideIf",
+			build: func(c *Code) {
+				c.Block(Void)
+				c.Else()
+				c.End()
+				c.End()
+			},
+			err: ErrUnbalancedBlocks,
+		},
+		{
+			name: "ClosedLabel",
+			build: func(c *Code) {
+				l := c.Block(Void)
+				c.End()
+				c.Branch(l)
+				c.End()
+			},
+			err: ErrLabelNotInScope,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var m Module
+			c := m.AddFunc(nil, nil)
+			test.build(c)
+			assert.True(t, errors.Is(c.Check(), test.err))
+		})
+	}
+}
+
+func TestTemporaryLocals(t *testing.T) {
+	var m Module
+	c := m.AddFunc([]Type{Int32, Int32}, nil)
+
+	a := c.AllocLocal(Int32)
+	b := c.AllocLocal(Int64)
+	assert.Equal(t, []uint32{a, b}, []uint32{2, 3})
+
+	c.FreeLocal(a)
+	assert.Equal(t, c.AllocLocal(Int64), 4)
+	assert.Equal(t, c.AllocLocal(Int32), a)
+	assert.Equal(t, c.AllocLocal(Int32), 5)
+
+	assert.Equal(t, c.Locals, []LocalDecl{
+		{1, Int32},
+		{2, Int64},
+		{1, Int32},
+	})
+}
@@ -1,5 +1,10 @@
 package wasm
 
+import (
+	"encoding/binary"
+	"math"
+)
+
 func (c *Code) AppendWasm(buf []byte) []byte {
 	var tmp []byte
 	tmp = appendVector(tmp, c.Locals)
@@ -23,18 +28,28 @@ func (c *Code) op(code byte, args ...uint32) {
 	}
 }
 
+// Emit appends an instruction in the form that Decode produces.
+func (c *Code) Emit(x Instruction) {
+	c.Instructions = x.AppendWasm(c.Instructions)
+}
+
+func (c *Code) Unreachable()            { c.op(0x00) }
+func (c *Code) Return()                 { c.op(0x0f) }
 func (c *Code) Call(idx uint32)         { c.op(0x10, idx) }
 func (c *Code) CallIndirect(idx uint32) { c.op(0x11, idx, 0) }
-func (c *Code) Loop()                   { c.op(0x03, 0x40) }
-func (c *Code) If()                     { c.op(0x04, 0x40) }
-func (c *Code) Else()                   { c.op(0x05) }
-func (c *Code) End()                    { c.op(0x0b) }
-func (c *Code) Br(depth uint32)         { c.op(0x0c, depth) }
-func (c *Code) BrIf(depth uint32)       { c.op(0x0d, depth) }
+
+// ReturnCall and ReturnCallIndirect are from the tail call proposal. They make a call that replaces
+// the current one, so that the caller's frame is released before the callee runs.
+func (c *Code) ReturnCall(idx uint32)         { c.op(0x12, idx) }
+func (c *Code) ReturnCallIndirect(idx uint32) { c.op(0x13, idx, 0) }
+
+func (c *Code) Br(depth uint32)   { c.op(0x0c, depth) }
+func (c *Code) BrIf(depth uint32) { c.op(0x0d, depth) }
 
 func (c *Code) Drop()                { c.op(0x1a) }
 func (c *Code) LocalGet(idx uint32)  { c.op(0x20, idx) }
 func (c *Code) LocalSet(idx uint32)  { c.op(0x21, idx) }
+func (c *Code) LocalTee(idx uint32)  { c.op(0x22, idx) }
 func (c *Code) GlobalGet(idx uint32) { c.op(0x23, idx) }
 func (c *Code) GlobalSet(idx uint32) { c.op(0x24, idx) }
 
@@ -45,22 +60,165 @@ func (c *Code) TableGrow(table uint32)       { c.op(0xfc, 0xf, table) }
 func (c *Code) TableGet(table uint32)        { c.op(0x25, table) }
 
 func (c *Code) I32Load(align, offset uint32)  { c.op(0x28, align, offset) }
+func (c *Code) I64Load(align, offset uint32)  { c.op(0x29, align, offset) }
+func (c *Code) F32Load(align, offset uint32)  { c.op(0x2a, align, offset) }
+func (c *Code) F64Load(align, offset uint32)  { c.op(0x2b, align, offset) }
 func (c *Code) I32Store(align, offset uint32) { c.op(0x36, align, offset) }
+func (c *Code) I64Store(align, offset uint32) { c.op(0x37, align, offset) }
+func (c *Code) F32Store(align, offset uint32) { c.op(0x38, align, offset) }
+func (c *Code) F64Store(align, offset uint32) { c.op(0x39, align, offset) }
 func (c *Code) MemGrow()                      { c.op(0x40, 0) }
+func (c *Code) MemoryInit(data uint32)        { c.op(0xfc, 0x08, data, 0) }
+func (c *Code) DataDrop(data uint32)          { c.op(0xfc, 0x09, data) }
+
+func (c *Code) I32Const(x int32) {
+	c.Instructions = append(c.Instructions, 0x41)
+	c.Instructions = appendInt32(c.Instructions, x)
+}
+
+func (c *Code) I64Const(x int64) {
+	c.Instructions = append(c.Instructions, 0x42)
+	c.Instructions = appendInt64(c.Instructions, x)
+}
+
+func (c *Code) F32Const(x float32) {
+	c.Instructions = append(c.Instructions, 0x43)
+	c.Instructions = binary.LittleEndian.AppendUint32(c.Instructions, math.Float32bits(x))
+}
+
+func (c *Code) F64Const(x float64) {
+	c.Instructions = append(c.Instructions, 0x44)
+	c.Instructions = binary.LittleEndian.AppendUint64(c.Instructions, math.Float64bits(x))
+}
+
+func (c *Code) I32Eqz() { c.op(0x45) }
+func (c *Code) I32Eq()  { c.op(0x46) }
+func (c *Code) I32Ne()  { c.op(0x47) }
+func (c *Code) I32LtS() { c.op(0x48) }
+func (c *Code) I32LtU() { c.op(0x49) }
+func (c *Code) I32GtS() { c.op(0x4a) }
+func (c *Code) I32GtU() { c.op(0x4b) }
+func (c *Code) I32LeS() { c.op(0x4c) }
+func (c *Code) I32LeU() { c.op(0x4d) }
+func (c *Code) I32GeS() { c.op(0x4e) }
+func (c *Code) I32GeU() { c.op(0x4f) }
+
+func (c *Code) I64Eqz() { c.op(0x50) }
+func (c *Code) I64Eq()  { c.op(0x51) }
+func (c *Code) I64Ne()  { c.op(0x52) }
+func (c *Code) I64LtS() { c.op(0x53) }
+func (c *Code) I64LtU() { c.op(0x54) }
+func (c *Code) I64GtS() { c.op(0x55) }
+func (c *Code) I64GtU() { c.op(0x56) }
+func (c *Code) I64LeS() { c.op(0x57) }
+func (c *Code) I64LeU() { c.op(0x58) }
+func (c *Code) I64GeS() { c.op(0x59) }
+func (c *Code) I64GeU() { c.op(0x5a) }
+
+func (c *Code) F32Eq() { c.op(0x5b) }
+func (c *Code) F32Ne() { c.op(0x5c) }
+func (c *Code) F32Lt() { c.op(0x5d) }
+func (c *Code) F32Gt() { c.op(0x5e) }
+func (c *Code) F32Le() { c.op(0x5f) }
+func (c *Code) F32Ge() { c.op(0x60) }
+
+func (c *Code) F64Eq() { c.op(0x61) }
+func (c *Code) F64Ne() { c.op(0x62) }
+func (c *Code) F64Lt() { c.op(0x63) }
+func (c *Code) F64Gt() { c.op(0x64) }
+func (c *Code) F64Le() { c.op(0x65) }
+func (c *Code) F64Ge() { c.op(0x66) }
+
+func (c *Code) I32Clz()    { c.op(0x67) }
+func (c *Code) I32Ctz()    { c.op(0x68) }
+func (c *Code) I32Popcnt() { c.op(0x69) }
+func (c *Code) I32Add()    { c.op(0x6a) }
+func (c *Code) I32Sub()    { c.op(0x6b) }
+func (c *Code) I32Mul()    { c.op(0x6c) }
+func (c *Code) I32DivS()   { c.op(0x6d) }
+func (c *Code) I32DivU()   { c.op(0x6e) }
+func (c *Code) I32RemS()   { c.op(0x6f) }
+func (c *Code) I32RemU()   { c.op(0x70) }
+func (c *Code) I32And()    { c.op(0x71) }
+func (c *Code) I32Or()     { c.op(0x72) }
+func (c *Code) I32Xor()    { c.op(0x73) }
+func (c *Code) I32Shl()    { c.op(0x74) }
+func (c *Code) I32ShrS()   { c.op(0x75) }
+func (c *Code) I32ShrU()   { c.op(0x76) }
+func (c *Code) I32Rotl()   { c.op(0x77) }
+func (c *Code) I32Rotr()   { c.op(0x78) }
+
+func (c *Code) I64Clz()    { c.op(0x79) }
+func (c *Code) I64Ctz()    { c.op(0x7a) }
+func (c *Code) I64Popcnt() { c.op(0x7b) }
+func (c *Code) I64Add()    { c.op(0x7c) }
+func (c *Code) I64Sub()    { c.op(0x7d) }
+func (c *Code) I64Mul()    { c.op(0x7e) }
+func (c *Code) I64DivS()   { c.op(0x7f) }
+func (c *Code) I64DivU()   { c.op(0x80) }
+func (c *Code) I64RemS()   { c.op(0x81) }
+func (c *Code) I64RemU()   { c.op(0x82) }
+func (c *Code) I64And()    { c.op(0x83) }
+func (c *Code) I64Or()     { c.op(0x84) }
+func (c *Code) I64Xor()    { c.op(0x85) }
+func (c *Code) I64Shl()    { c.op(0x86) }
+func (c *Code) I64ShrS()   { c.op(0x87) }
+func (c *Code) I64ShrU()   { c.op(0x88) }
+func (c *Code) I64Rotl()   { c.op(0x89) }
+func (c *Code) I64Rotr()   { c.op(0x8a) }
+
+func (c *Code) F32Abs()      { c.op(0x8b) }
+func (c *Code) F32Neg()      { c.op(0x8c) }
+func (c *Code) F32Ceil()     { c.op(0x8d) }
+func (c *Code) F32Floor()    { c.op(0x8e) }
+func (c *Code) F32Trunc()    { c.op(0x8f) }
+func (c *Code) F32Nearest()  { c.op(0x90) }
+func (c *Code) F32Sqrt()     { c.op(0x91) }
+func (c *Code) F32Add()      { c.op(0x92) }
+func (c *Code) F32Sub()      { c.op(0x93) }
+func (c *Code) F32Mul()      { c.op(0x94) }
+func (c *Code) F32Div()      { c.op(0x95) }
+func (c *Code) F32Min()      { c.op(0x96) }
+func (c *Code) F32Max()      { c.op(0x97) }
+func (c *Code) F32Copysign() { c.op(0x98) }
+
+func (c *Code) F64Abs()      { c.op(0x99) }
+func (c *Code) F64Neg()      { c.op(0x9a) }
+func (c *Code) F64Ceil()     { c.op(0x9b) }
+func (c *Code) F64Floor()    { c.op(0x9c) }
+func (c *Code) F64Trunc()    { c.op(0x9d) }
+func (c *Code) F64Nearest()  { c.op(0x9e) }
+func (c *Code) F64Sqrt()     { c.op(0x9f) }
+func (c *Code) F64Add()      { c.op(0xa0) }
+func (c *Code) F64Sub()      { c.op(0xa1) }
+func (c *Code) F64Mul()      { c.op(0xa2) }
+func (c *Code) F64Div()      { c.op(0xa3) }
+func (c *Code) F64Min()      { c.op(0xa4) }
+func (c *Code) F64Max()      { c.op(0xa5) }
+func (c *Code) F64Copysign() { c.op(0xa6) }
 
-func (c *Code) I32Const(x uint32) { c.op(0x41, x) }
-func (c *Code) I32Eqz()           { c.op(0x45) }
-func (c *Code) I32Eq()            { c.op(0x46) }
-func (c *Code) I32Ne()            { c.op(0x47) }
-func (c *Code) I32Lts()           { c.op(0x48) }
-func (c *Code) I32Gts()           { c.op(0x4a) }
-func (c *Code) I32Les()           { c.op(0x4c) }
-func (c *Code) I32Ges()           { c.op(0x4e) }
-func (c *Code) I32Add()           { c.op(0x6a) }
-func (c *Code) I32Sub()           { c.op(0x6b) }
-func (c *Code) I32Mul()           { c.op(0x6c) }
-func (c *Code) I32Div()           { c.op(0x6d) }
-func (c *Code) I32And()           { c.op(0x71) }
-func (c *Code) I32Or()            { c.op(0x72) }
-func (c *Code) I32Shl()           { c.op(0x74) }
-func (c *Code) I32Shr()           { c.op(0x76) }
+func (c *Code) I32WrapI64()        { c.op(0xa7) }
+func (c *Code) I32TruncF32S()      { c.op(0xa8) }
+func (c *Code) I32TruncF32U()      { c.op(0xa9) }
+func (c *Code) I32TruncF64S()      { c.op(0xaa) }
+func (c *Code) I32TruncF64U()      { c.op(0xab) }
+func (c *Code) I64ExtendI32S()     { c.op(0xac) }
+func (c *Code) I64ExtendI32U()     { c.op(0xad) }
+func (c *Code) I64TruncF32S()      { c.op(0xae) }
+func (c *Code) I64TruncF32U()      { c.op(0xaf) }
+func (c *Code) I64TruncF64S()      { c.op(0xb0) }
+func (c *Code) I64TruncF64U()      { c.op(0xb1) }
+func (c *Code) F32ConvertI32S()    { c.op(0xb2) }
+func (c *Code) F32ConvertI32U()    { c.op(0xb3) }
+func (c *Code) F32ConvertI64S()    { c.op(0xb4) }
+func (c *Code) F32ConvertI64U()    { c.op(0xb5) }
+func (c *Code) F32DemoteF64()      { c.op(0xb6) }
+func (c *Code) F64ConvertI32S()    { c.op(0xb7) }
+func (c *Code) F64ConvertI32U()    { c.op(0xb8) }
+func (c *Code) F64ConvertI64S()    { c.op(0xb9) }
+func (c *Code) F64ConvertI64U()    { c.op(0xba) }
+func (c *Code) F64PromoteF32()     { c.op(0xbb) }
+func (c *Code) I32ReinterpretF32() { c.op(0xbc) }
+func (c *Code) I64ReinterpretF64() { c.op(0xbd) }
+func (c *Code) F32ReinterpretI32() { c.op(0xbe) }
+func (c *Code) F64ReinterpretI64() { c.op(0xbf) }
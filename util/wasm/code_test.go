@@ -1,10 +1,10 @@
 package wasm
 
 import (
+	"math"
 	"testing"
 
 	"github.com/bobappleyard/lync/util/assert"
-	"github.com/wasmerio/wasmer-go/wasmer"
 )
 
 func TestConst(t *testing.T) {
@@ -24,7 +24,7 @@ func TestLogic(t *testing.T) {
 	c.Locals = []LocalDecl{{1, Int32}}
 
 	c.LocalGet(0)
-	c.If()
+	c.If(Void)
 	c.I32Const(21)
 	c.LocalSet(1)
 	c.Else()
@@ -97,6 +97,79 @@ func TestCallIndirect(t *testing.T) {
 	testModule(t, m, 5, 6)
 }
 
+// Both tail call tests recurse further than the interpreter allows ordinary calls to.
+func TestReturnCall(t *testing.T) {
+	var m Module
+
+	// count(n, acc) = n == 0 ? acc : count(n - 1, acc + 2)
+	f := m.AddFunc([]Type{Int32, Int32}, []Type{Int32})
+	f.LocalGet(0)
+	f.I32Eqz()
+	f.If(Void)
+	f.LocalGet(1)
+	f.Return()
+	f.End()
+	f.LocalGet(0)
+	f.I32Const(1)
+	f.I32Sub()
+	f.LocalGet(1)
+	f.I32Const(2)
+	f.I32Add()
+	f.ReturnCall(0)
+	f.End()
+
+	g := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	g.LocalGet(0)
+	g.I32Const(0)
+	g.ReturnCall(0)
+	g.End()
+
+	testModule(t, m, 100000, 200000)
+}
+
+func TestReturnCallIndirect(t *testing.T) {
+	var m Module
+	m.Types = []Type{FuncType{In: []Type{Int32, Int32}, Out: []Type{Int32}}}
+
+	// the same as count above, but calling itself through table slot 0
+	f := m.AddFunc([]Type{Int32, Int32}, []Type{Int32})
+	f.LocalGet(0)
+	f.I32Eqz()
+	f.If(Void)
+	f.LocalGet(1)
+	f.Return()
+	f.End()
+	f.LocalGet(0)
+	f.I32Const(1)
+	f.I32Sub()
+	f.LocalGet(1)
+	f.I32Const(2)
+	f.I32Add()
+	f.I32Const(0)
+	f.ReturnCallIndirect(0)
+	f.End()
+
+	g := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	g.NullFunc()
+	g.I32Const(1)
+	g.TableGrow(0)
+	g.Drop()
+	g.I32Const(0)
+	g.I32Const(0)
+	g.I32Const(1)
+	g.TableInit(0, 0)
+	g.LocalGet(0)
+	g.I32Const(0)
+	g.I32Const(0)
+	g.ReturnCallIndirect(0)
+	g.End()
+
+	m.Tables = []Table{FuncTable}
+	m.Elements = []Element{&FuncElement{Funcs: []Index{0}}}
+
+	testModule(t, m, 100000, 200000)
+}
+
 func TestLoop(t *testing.T) {
 	var m Module
 	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
@@ -104,7 +177,7 @@ func TestLoop(t *testing.T) {
 	// var acc = 0
 	c.Locals = []LocalDecl{{1, Int32}}
 
-	c.Loop()
+	c.Loop(Void)
 
 	// acc = acc + n
 	c.LocalGet(0)
@@ -156,36 +229,274 @@ func TestMemory(t *testing.T) {
 	testModule(t, m, 0, 45)
 }
 
+func TestData(t *testing.T) {
+	var m Module
+	m.Memories = []Memory{MinMemory{1}}
+	m.Data = []Data{ActiveData{Offset: 200, Bytes: []byte{1, 2, 3, 4}}}
+
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	c.LocalGet(0)
+	c.I32Load(2, 200)
+	c.End()
+
+	testModule(t, m, 0, 0x04030201)
+	testModule(t, m, 1, 0x040302)
+}
+
+func TestPassiveData(t *testing.T) {
+	var m Module
+	m.Memories = []Memory{MinMemory{1}}
+	m.Data = []Data{PassiveData{Bytes: []byte{1, 2, 3, 4}}}
+
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+
+	// MemoryInit: [destPos, srcPos, size] -> []
+	c.I32Const(8)
+	c.LocalGet(0)
+	c.I32Const(1)
+	c.MemoryInit(0)
+	c.DataDrop(0)
+
+	c.I32Const(8)
+	c.I32Load(0, 0)
+	c.End()
+
+	testModule(t, m, 0, 0x01)
+	testModule(t, m, 3, 0x04)
+}
+
+func TestGlobals(t *testing.T) {
+	var m Module
+
+	var init Code
+	init.I32Const(10)
+	init.End()
+
+	base := m.AddGlobal(Global{Type: Int32, Init: init})
+	acc := m.AddGlobal(Global{Type: Int32, Mutable: true, Init: init})
+
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	c.GlobalGet(uint32(acc))
+	c.LocalGet(0)
+	c.I32Add()
+	c.GlobalSet(uint32(acc))
+	c.GlobalGet(uint32(acc))
+	c.GlobalGet(uint32(base))
+	c.I32Add()
+	c.End()
+
+	testModule(t, m, 1, 21)
+}
+
+func TestStart(t *testing.T) {
+	var m Module
+
+	var init Code
+	init.I32Const(0)
+	init.End()
+
+	g := m.AddGlobal(Global{Type: Int32, Mutable: true, Init: init})
+
+	start := m.AddFunc(nil, nil)
+	start.I32Const(7)
+	start.GlobalSet(uint32(g))
+	start.End()
+	m.Start = &start.Func
+
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	c.GlobalGet(uint32(g))
+	c.End()
+
+	testModule(t, m, 0, 7)
+}
+
+func TestCustomSection(t *testing.T) {
+	var m Module
+	m.Customs = []CustomSection{{Name: "lync", Bytes: []byte("hello")}}
+
+	c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	c.LocalGet(0)
+	c.End()
+
+	testModule(t, m, 3, 3)
+}
+
 func testModule(t *testing.T, m Module, in, out int32) {
 	t.Helper()
 
-	engine := wasmer.NewEngine()
-	store := wasmer.NewStore(engine)
-	imp := wasmer.NewImportObject()
-
-	mbytes := m.AppendWasm(nil)
-	mod, err := wasmer.NewModule(store, mbytes)
-	if err != nil {
+	if err := m.Validate(); err != nil {
 		t.Error(err)
 		return
 	}
 
-	inst, err := wasmer.NewInstance(mod, imp)
+	inst, err := InstantiateBytes(m.AppendWasm(nil), nil)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	test, err := inst.Exports.GetFunction("test")
+	test, err := inst.Func("test")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	res, err := test(in)
+	res, err := test.Call(uint64(uint32(in)))
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	assert.Equal(t, res.(int32), out)
+	assert.Equal(t, int32(res[0]), out)
+}
+
+func TestSignedConst(t *testing.T) {
+	var c Code
+	c.I32Const(-1)
+	c.I32Const(64)
+	c.I32Const(-2147483648)
+	assert.Equal(t, c.Instructions, []byte{
+		0x41, 0x7f,
+		0x41, 0xc0, 0x00,
+		0x41, 0x80, 0x80, 0x80, 0x80, 0x78,
+	})
+
+	var m Module
+	f := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	f.I32Const(-5)
+	f.LocalGet(0)
+	f.I32Add()
+	f.End()
+
+	testModule(t, m, 2, -3)
+}
+
+func TestNumeric(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		build func(c *Code)
+		out   uint64
+	}{
+		{
+			name: "I32DivSigned",
+			build: func(c *Code) {
+				c.I32Const(-7)
+				c.I32Const(2)
+				c.I32DivS()
+				c.I64ExtendI32S()
+			},
+			out: uint64(0xffff_ffff_ffff_fffd),
+		},
+		{
+			name: "I32Rotr",
+			build: func(c *Code) {
+				c.I32Const(1)
+				c.I32Const(1)
+				c.I32Rotr()
+				c.I64ExtendI32U()
+			},
+			out: 0x8000_0000,
+		},
+		{
+			name: "I64Arith",
+			build: func(c *Code) {
+				c.I64Const(1 << 40)
+				c.I64Const(3)
+				c.I64Mul()
+				c.I64Const(-1)
+				c.I64Add()
+			},
+			out: 3<<40 - 1,
+		},
+		{
+			name: "I64Compare",
+			build: func(c *Code) {
+				c.I64Const(-1)
+				c.I64Const(1)
+				c.I64LtU()
+				c.I64ExtendI32U()
+			},
+			out: 0,
+		},
+		{
+			name: "I64Shrs",
+			build: func(c *Code) {
+				c.I64Const(-16)
+				c.I64Const(2)
+				c.I64ShrS()
+			},
+			out: uint64(0xffff_ffff_ffff_fffc),
+		},
+		{
+			name: "F64Arith",
+			build: func(c *Code) {
+				c.F64Const(1.5)
+				c.F64Const(2.25)
+				c.F64Mul()
+				c.F64Sqrt()
+				c.I64ReinterpretF64()
+			},
+			out: math.Float64bits(math.Sqrt(1.5 * 2.25)),
+		},
+		{
+			name: "F32Nearest",
+			build: func(c *Code) {
+				c.F32Const(2.5)
+				c.F32Nearest()
+				c.I32ReinterpretF32()
+				c.I64ExtendI32U()
+			},
+			out: uint64(math.Float32bits(2)),
+		},
+		{
+			name: "F64Min",
+			build: func(c *Code) {
+				c.F64Const(0)
+				c.F64Const(math.Copysign(0, -1))
+				c.F64Min()
+				c.I64ReinterpretF64()
+			},
+			out: 1 << 63,
+		},
+		{
+			name: "Convert",
+			build: func(c *Code) {
+				c.F64Const(-3.75)
+				c.I64TruncF64S()
+				c.F32ConvertI64S()
+				c.F64PromoteF32()
+				c.I64TruncF64S()
+			},
+			out: uint64(0xffff_ffff_ffff_fffd),
+		},
+		{
+			name: "TruncUnsigned",
+			build: func(c *Code) {
+				c.F64Const(1 << 63)
+				c.I64TruncF64U()
+			},
+			out: 1 << 63,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var m Module
+			c := m.AddExportedFunc("test", nil, []Type{Int64})
+			test.build(c)
+			c.End()
+			assert.Nil(t, m.Validate())
+
+			inst, err := Instantiate(&m, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			f, err := inst.Func("test")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res, err := f.Call()
+			assert.Nil(t, err)
+			assert.Equal(t, res, []uint64{test.out})
+		})
+	}
 }
@@ -1 +1,51 @@
 package wasm
+
+type Data interface {
+	WasmAppender
+	data()
+}
+
+// ActiveData is copied into memory at the given offset when the module is instantiated.
+type ActiveData struct {
+	Memory Index
+	Offset uint32
+	Bytes  []byte
+}
+
+func (ActiveData) data() {}
+
+func (d ActiveData) AppendWasm(buf []byte) []byte {
+	if d.Memory == 0 {
+		buf = append(buf, 0)
+	} else {
+		buf = append(buf, 2)
+		buf = d.Memory.AppendWasm(buf)
+	}
+	buf = append(buf, 0x41)
+	buf = appendInt64(buf, int64(int32(d.Offset)))
+	buf = append(buf, 0x0b)
+	buf = appendBytes(buf, d.Bytes)
+	return buf
+}
+
+// PassiveData is only copied into memory when a memory.init instruction refers to it.
+type PassiveData struct {
+	Bytes []byte
+}
+
+func (PassiveData) data() {}
+
+func (d PassiveData) AppendWasm(buf []byte) []byte {
+	buf = append(buf, 1)
+	buf = appendBytes(buf, d.Bytes)
+	return buf
+}
+
+func hasPassiveData(ds []Data) bool {
+	for _, d := range ds {
+		if _, ok := d.(PassiveData); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,508 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+var (
+	ErrNotWasm     = errors.New("not a wasm module")
+	ErrMalformed   = errors.New("malformed module")
+	ErrUnsupported = errors.New("unsupported")
+)
+
+// Instruction is a decoded instruction. Immediate arguments appear in Args in the order in which they
+// are encoded. Signed constants are sign-extended, float constants are held as their bit patterns
+// and block types are held as the signed value of their encoding.
+type Instruction struct {
+	Op   Opcode
+	Args []uint64
+}
+
+func (o Opcode) String() string {
+	if info, ok := opcodes[o]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("<opcode %#x>", uint16(o))
+}
+
+func (i Instruction) String() string {
+	var sb strings.Builder
+	sb.WriteString(i.Op.String())
+	for _, a := range i.Args {
+		fmt.Fprintf(&sb, " %d", a)
+	}
+	return sb.String()
+}
+
+// Decode parses a binary module.
+func Decode(buf []byte) (*Module, error) {
+	r := &reader{buf: buf}
+	if !r.header() {
+		return nil, ErrNotWasm
+	}
+
+	m := new(Module)
+	for len(r.buf) != 0 && r.err == nil {
+		id := r.byte()
+		sec := &reader{buf: r.take(int(r.uint32()))}
+		if r.err != nil {
+			break
+		}
+		sec.section(m, id)
+		if sec.err == nil && len(sec.buf) != 0 {
+			sec.fail(ErrMalformed)
+		}
+		if sec.err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, sec.err)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return m, nil
+}
+
+// Decode parses the instructions of a function body.
+// AppendWasm encodes the instruction, which is the inverse of decoding it.
+func (i Instruction) AppendWasm(buf []byte) []byte {
+	if i.Op > 0xff {
+		buf = append(buf, byte(i.Op>>8))
+		buf = appendUint32(buf, uint32(i.Op&0xff))
+	} else {
+		buf = append(buf, byte(i.Op))
+	}
+
+	switch opcodes[i.Op].imm {
+	case immIndex, immIndex2, immMem:
+		for _, a := range i.Args {
+			buf = appendUint32(buf, uint32(a))
+		}
+
+	case immBrTable:
+		buf = appendUint32(buf, uint32(len(i.Args)-1))
+		for _, a := range i.Args {
+			buf = appendUint32(buf, uint32(a))
+		}
+
+	case immBlock, immI64:
+		buf = appendInt64(buf, int64(i.Args[0]))
+
+	case immI32:
+		buf = appendInt32(buf, int32(i.Args[0]))
+
+	case immF32:
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(i.Args[0]))
+
+	case immF64:
+		buf = binary.LittleEndian.AppendUint64(buf, i.Args[0])
+
+	case immRefType:
+		buf = append(buf, byte(i.Args[0]))
+
+	case immTypes:
+		buf = appendUint32(buf, uint32(len(i.Args)))
+		for _, a := range i.Args {
+			buf = append(buf, byte(a))
+		}
+	}
+
+	return buf
+}
+
+func (c *Code) Decode() ([]Instruction, error) {
+	return DecodeInstructions(c.Instructions)
+}
+
+func DecodeInstructions(code []byte) ([]Instruction, error) {
+	r := &reader{buf: code}
+	var res []Instruction
+	for len(r.buf) != 0 && r.err == nil {
+		res = append(res, r.instruction())
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return res, nil
+}
+
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+	r.buf = nil
+}
+
+func (r *reader) take(n int) []byte {
+	if n > len(r.buf) {
+		r.fail(io.ErrUnexpectedEOF)
+		return nil
+	}
+	res := r.buf[:n]
+	r.buf = r.buf[n:]
+	return res
+}
+
+func (r *reader) byte() byte {
+	bs := r.take(1)
+	if len(bs) == 0 {
+		return 0
+	}
+	return bs[0]
+}
+
+func (r *reader) uint32() uint32 {
+	var res uint64
+	for shift := 0; shift < 35; shift += 7 {
+		b := r.byte()
+		res |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if res > math.MaxUint32 {
+				r.fail(ErrMalformed)
+			}
+			return uint32(res)
+		}
+	}
+	r.fail(ErrMalformed)
+	return 0
+}
+
+func (r *reader) int64(bits int) int64 {
+	var res int64
+	for shift := 0; shift < bits+7; shift += 7 {
+		b := r.byte()
+		res |= int64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if shift+7 < 64 && b&0x40 != 0 {
+				res |= -1 << (shift + 7)
+			}
+			return res
+		}
+	}
+	r.fail(ErrMalformed)
+	return 0
+}
+
+func (r *reader) bytes() []byte {
+	return r.take(int(r.uint32()))
+}
+
+func (r *reader) name() string {
+	return string(r.bytes())
+}
+
+func (r *reader) header() bool {
+	h := r.take(8)
+	return r.err == nil && string(h) == "\x00asm\x01\x00\x00\x00"
+}
+
+func vector[T any](r *reader, item func(r *reader) T) []T {
+	n := r.uint32()
+	var res []T
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		res = append(res, item(r))
+	}
+	return res
+}
+
+func (r *reader) section(m *Module, id byte) {
+	switch id {
+	case 0:
+		m.Customs = append(m.Customs, CustomSection{
+			Name:  r.name(),
+			Bytes: r.take(len(r.buf)),
+		})
+
+	case 1:
+		m.Types = vector(r, (*reader).funcType)
+
+	case 2:
+		m.Imports = vector(r, (*reader).imprt)
+
+	case 3:
+		m.Funcs = vector(r, (*reader).index)
+
+	case 4:
+		m.Tables = vector(r, (*reader).table)
+
+	case 5:
+		m.Memories = vector(r, (*reader).memory)
+
+	case 6:
+		m.Globals = vector(r, (*reader).global)
+
+	case 7:
+		m.Exports = vector(r, (*reader).export)
+
+	case 8:
+		start := r.index()
+		m.Start = &start
+
+	case 9:
+		m.Elements = vector(r, (*reader).element)
+
+	case 10:
+		imported := Index(importCount[FuncImport](m.Imports))
+		m.Codes = vector(r, (*reader).code)
+		// bodies know their functions' parameters, so that locals can be added to them
+		for i, c := range m.Codes {
+			c.Func = imported + Index(i)
+			if i < len(m.Funcs) && int(m.Funcs[i]) < len(m.Types) {
+				if t, ok := m.Types[m.Funcs[i]].(FuncType); ok {
+					c.params = uint32(len(t.In))
+				}
+			}
+		}
+
+	case 11:
+		m.Data = vector(r, (*reader).data)
+
+	case 12:
+		// the data count is implied by the data section
+		r.uint32()
+
+	default:
+		r.fail(ErrMalformed)
+	}
+}
+
+func importCount[T Import](imports []Import) int {
+	n := 0
+	for _, imp := range imports {
+		if _, ok := imp.(T); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *reader) index() Index {
+	return Index(r.uint32())
+}
+
+func (r *reader) valueType() Type {
+	t := NumberType(r.byte())
+	switch t {
+	case Int32, Int64, Float32, Float64:
+		return t
+	}
+	r.fail(fmt.Errorf("value type %#x: %w", byte(t), ErrUnsupported))
+	return nil
+}
+
+func (r *reader) funcType() Type {
+	if r.byte() != 0x60 {
+		r.fail(ErrMalformed)
+		return nil
+	}
+	return FuncType{
+		In:  vector(r, (*reader).valueType),
+		Out: vector(r, (*reader).valueType),
+	}
+}
+
+func (r *reader) imprt() Import {
+	module, name := r.name(), r.name()
+	switch r.byte() {
+	case 0:
+		return FuncImport{Module: module, Name: name, Type: r.index()}
+
+	case 1:
+		if r.table() != FuncTable {
+			r.fail(ErrUnsupported)
+		}
+		return TableImport{Module: module, Name: name}
+
+	case 2:
+		return MemoryImport{Module: module, Name: name, Type: r.memory()}
+
+	case 3:
+		return GlobalImport{Module: module, Name: name, Type: r.valueType(), Mutable: r.mutability()}
+	}
+	r.fail(ErrMalformed)
+	return nil
+}
+
+func (r *reader) table() Table {
+	t := Table(r.byte())
+	if t != FuncTable && t != ExternTable {
+		r.fail(ErrMalformed)
+	}
+	// only empty tables without a maximum size can be represented
+	if r.byte() != 0 || r.uint32() != 0 {
+		r.fail(fmt.Errorf("table limits: %w", ErrUnsupported))
+	}
+	return t
+}
+
+func (r *reader) memory() Memory {
+	if r.byte() != 0 {
+		r.fail(fmt.Errorf("memory maximum: %w", ErrUnsupported))
+	}
+	return MinMemory{Min: r.uint32()}
+}
+
+func (r *reader) mutability() bool {
+	switch r.byte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	r.fail(ErrMalformed)
+	return false
+}
+
+func (r *reader) global() Global {
+	return Global{
+		Type:    r.valueType(),
+		Mutable: r.mutability(),
+		Init:    Code{Instructions: r.expr()},
+	}
+}
+
+func (r *reader) export() Export {
+	name := r.name()
+	kind := r.byte()
+	idx := r.index()
+	switch kind {
+	case 0:
+		return FuncExport{Name: name, Func: idx}
+	case 1:
+		return TableExport{Name: name, Table: idx}
+	case 2:
+		return MemoryExport{Name: name, Mem: idx}
+	case 3:
+		return GlobalExport{Name: name, Global: idx}
+	}
+	r.fail(ErrMalformed)
+	return nil
+}
+
+func (r *reader) element() Element {
+	if r.uint32() != 1 || r.byte() != 0 {
+		r.fail(fmt.Errorf("element segment: %w", ErrUnsupported))
+		return nil
+	}
+	return &FuncElement{Funcs: vector(r, (*reader).index)}
+}
+
+func (r *reader) code() *Code {
+	body := &reader{buf: r.bytes()}
+	c := &Code{
+		Locals: vector(body, func(r *reader) LocalDecl {
+			return LocalDecl{Count: r.uint32(), Type: r.valueType()}
+		}),
+		Instructions: body.buf,
+	}
+	if body.err != nil {
+		r.fail(body.err)
+	}
+	return c
+}
+
+func (r *reader) data() Data {
+	var mem Index
+	switch r.uint32() {
+	case 0:
+	case 1:
+		return PassiveData{Bytes: r.bytes()}
+	case 2:
+		mem = r.index()
+	default:
+		r.fail(ErrMalformed)
+		return nil
+	}
+	offset := r.expr()
+	if len(offset) == 0 || Opcode(offset[0]) != OpI32Const {
+		r.fail(fmt.Errorf("data offset: %w", ErrUnsupported))
+		return nil
+	}
+	off := (&reader{buf: offset[1:]}).int64(32)
+	return ActiveData{Memory: mem, Offset: uint32(off), Bytes: r.bytes()}
+}
+
+// expr reads a constant expression, returning its encoding including the final end instruction.
+func (r *reader) expr() []byte {
+	start := r.buf
+	depth := 0
+	for r.err == nil {
+		switch r.instruction().Op {
+		case OpBlock, OpLoop, OpIf:
+			depth++
+		case OpEnd:
+			if depth == 0 {
+				return start[:len(start)-len(r.buf)]
+			}
+			depth--
+		}
+	}
+	return nil
+}
+
+func (r *reader) instruction() Instruction {
+	op := Opcode(r.byte())
+	if op == 0xfc {
+		op = 0xfc00 | Opcode(r.uint32())
+	}
+	info, ok := opcodes[op]
+	if !ok {
+		r.fail(fmt.Errorf("opcode %#x: %w", uint16(op), ErrUnsupported))
+		return Instruction{}
+	}
+
+	var args []uint64
+	switch info.imm {
+	case immIndex:
+		args = []uint64{uint64(r.uint32())}
+
+	case immIndex2, immMem:
+		args = []uint64{uint64(r.uint32()), uint64(r.uint32())}
+
+	case immBlock:
+		args = []uint64{uint64(r.int64(33))}
+
+	case immBrTable:
+		args = vector(r, func(r *reader) uint64 { return uint64(r.uint32()) })
+		args = append(args, uint64(r.uint32()))
+
+	case immI32:
+		args = []uint64{uint64(r.int64(32))}
+
+	case immI64:
+		args = []uint64{uint64(r.int64(64))}
+
+	case immF32:
+		bs := r.take(4)
+		if len(bs) == 4 {
+			args = []uint64{uint64(bs[0]) | uint64(bs[1])<<8 | uint64(bs[2])<<16 | uint64(bs[3])<<24}
+		}
+
+	case immF64:
+		bs := r.take(8)
+		if len(bs) == 8 {
+			var bits uint64
+			for i, b := range bs {
+				bits |= uint64(b) << (8 * i)
+			}
+			args = []uint64{bits}
+		}
+
+	case immRefType:
+		args = []uint64{uint64(r.byte())}
+
+	case immTypes:
+		args = vector(r, func(r *reader) uint64 { return uint64(r.byte()) })
+	}
+
+	return Instruction{Op: op, Args: args}
+}
@@ -0,0 +1,160 @@
+package wasm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		build func(m *Module)
+	}{
+		{
+			name:  "Empty",
+			build: func(m *Module) {},
+		},
+		{
+			name: "Functions",
+			build: func(m *Module) {
+				m.Imports = []Import{FuncImport{Module: "runtime", Name: "lookup", Type: m.EnsureType(FuncType{
+					In:  []Type{Int64, Int64},
+					Out: []Type{Int64},
+				})}}
+				f := m.AddFunc([]Type{Int32}, []Type{Int32})
+				f.Locals = []LocalDecl{{2, Int64}}
+				f.LocalGet(0)
+				f.I32Const(1)
+				f.I32Add()
+				f.End()
+
+				g := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+				g.LocalGet(0)
+				g.Call(uint32(f.Func))
+				g.End()
+			},
+		},
+		{
+			name: "TablesAndMemories",
+			build: func(m *Module) {
+				m.Imports = []Import{
+					TableImport{Module: "m", Name: "table"},
+					MemoryImport{Module: "m", Name: "memory", Type: MinMemory{1}},
+					GlobalImport{Module: "m", Name: "global", Type: Int32, Mutable: true},
+				}
+				m.Tables = []Table{FuncTable, ExternTable}
+				m.Memories = []Memory{MinMemory{2}}
+				m.Exports = []Export{
+					TableExport{Name: "t", Table: 1},
+					MemoryExport{Name: "m", Mem: 1},
+					GlobalExport{Name: "g", Global: 0},
+				}
+				f := m.AddFunc(nil, nil)
+				f.End()
+				m.Elements = []Element{&FuncElement{Funcs: []Index{0}}}
+			},
+		},
+		{
+			name: "GlobalsAndData",
+			build: func(m *Module) {
+				var init Code
+				init.I64Const(-100)
+				init.End()
+				m.AddGlobal(Global{Type: Int64, Mutable: true, Init: init})
+
+				m.Memories = []Memory{MinMemory{1}}
+				m.Data = []Data{
+					ActiveData{Offset: 1000, Bytes: []byte("hello")},
+					PassiveData{Bytes: []byte("world")},
+				}
+				f := m.AddFunc(nil, nil)
+				f.End()
+				m.Start = &f.Func
+				m.Customs = []CustomSection{{Name: "extra", Bytes: []byte{1, 2, 3}}}
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var m Module
+			test.build(&m)
+			enc := m.AppendWasm(nil)
+
+			dec, err := Decode(enc)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			assert.Equal(t, dec, &m)
+			if !bytes.Equal(dec.AppendWasm(nil), enc) {
+				t.Error("re-encoded module differs")
+			}
+		})
+	}
+}
+
+func TestDecodeAddLocal(t *testing.T) {
+	// locals added to a decoded body follow its parameters and locals
+	var m Module
+	m.Imports = []Import{FuncImport{Module: "m", Name: "f", Type: m.EnsureType(FuncType{})}}
+	f := m.AddFunc([]Type{Int32, Int64}, nil)
+	f.Locals = []LocalDecl{{1, Int32}}
+	f.End()
+
+	dec, err := Decode(m.AppendWasm(nil))
+	assert.Nil(t, err)
+	c := dec.Codes[0]
+	assert.Equal(t, c.Func, Index(1))
+	assert.Equal(t, c.AddLocal(Int64), uint32(3))
+	assert.Equal(t, c.AllocLocal(Int32), uint32(4))
+}
+
+func TestDecodeInstructions(t *testing.T) {
+	var c Code
+	c.Loop(Void)
+	c.LocalGet(0)
+	c.I32Const(200)
+	c.I32Load(2, 16)
+	c.BrIf(0)
+	c.End()
+	c.I64Const(-2)
+	c.TableInit(1, 0)
+	c.End()
+
+	instrs, err := c.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, instrs, []Instruction{
+		{Op: OpLoop, Args: []uint64{blockTypeBits(0x40)}},
+		{Op: OpLocalGet, Args: []uint64{0}},
+		{Op: OpI32Const, Args: []uint64{200}},
+		{Op: OpI32Load, Args: []uint64{2, 16}},
+		{Op: OpBrIf, Args: []uint64{0}},
+		{Op: OpEnd},
+		{Op: OpI64Const, Args: []uint64{0xfffffffffffffffe}},
+		{Op: OpTableInit, Args: []uint64{1, 0}},
+		{Op: OpEnd},
+	})
+	assert.Equal(t, instrs[3].String(), "i32.load 2 16")
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode([]byte("not wasm"))
+	assert.Equal(t, err, ErrNotWasm)
+
+	var m Module
+	f := m.AddFunc(nil, nil)
+	f.End()
+	enc := m.AppendWasm(nil)
+
+	_, err = Decode(enc[:len(enc)-1])
+	assert.True(t, err != nil)
+
+	_, err = DecodeInstructions([]byte{0xff})
+	assert.True(t, err != nil)
+}
+
+// the signed 33-bit encoding of a block type, as Decode presents it
+func blockTypeBits(b byte) uint64 {
+	return uint64(int64(b) - 0x80)
+}
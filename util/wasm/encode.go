@@ -19,6 +19,22 @@ func appendUint32(buf []byte, x uint32) []byte {
 	return buf
 }
 
+// The signed LEB128 encoding of an int32 is the same as that of the equivalent int64.
+func appendInt32(buf []byte, x int32) []byte {
+	return appendInt64(buf, int64(x))
+}
+
+func appendInt64(buf []byte, x int64) []byte {
+	for {
+		b := byte(x & 0x7f)
+		x >>= 7
+		if (x == 0 && b&0x40 == 0) || (x == -1 && b&0x40 != 0) {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
 func appendVector[T WasmAppender](buf []byte, xs []T) []byte {
 	buf = appendUint32(buf, uint32(len(xs)))
 	for _, x := range xs {
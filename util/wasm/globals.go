@@ -0,0 +1,57 @@
+package wasm
+
+// Global is a variable defined by the module. Init is a constant expression, terminated by End,
+// that provides the global's initial value.
+type Global struct {
+	Type    Type
+	Mutable bool
+	Init    Code
+}
+
+func (g Global) AppendWasm(buf []byte) []byte {
+	buf = g.Type.AppendWasm(buf)
+	buf = appendMutability(buf, g.Mutable)
+	buf = append(buf, g.Init.Instructions...)
+	return buf
+}
+
+func appendMutability(buf []byte, mutable bool) []byte {
+	if mutable {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+type GlobalImport struct {
+	Module  string
+	Name    string
+	Type    Type
+	Mutable bool
+}
+
+func (GlobalImport) imprt() {}
+
+func (e GlobalImport) AppendWasm(buf []byte) []byte {
+	buf = appendString(buf, e.Module)
+	buf = appendString(buf, e.Name)
+	buf = append(buf, 3)
+	buf = e.Type.AppendWasm(buf)
+	buf = appendMutability(buf, e.Mutable)
+	return buf
+}
+
+// CustomSection holds data that engines ignore, such as debugging information.
+type CustomSection struct {
+	Name  string
+	Bytes []byte
+}
+
+func (s CustomSection) AppendWasm(buf []byte) []byte {
+	var tmp []byte
+	tmp = appendString(tmp, s.Name)
+	tmp = append(tmp, s.Bytes...)
+
+	buf = append(buf, 0)
+	buf = appendBytes(buf, tmp)
+	return buf
+}
@@ -0,0 +1,410 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownImport  = errors.New("unknown import")
+	ErrImportMismatch = errors.New("incompatible import")
+	ErrUnknownExport  = errors.New("unknown export")
+)
+
+const pageSize = 65536
+
+// Extern is something that can be imported into or exported from an instance.
+type Extern interface {
+	extern()
+}
+
+// Imports supplies the externs that a module imports, by module name then field name.
+type Imports map[string]map[string]Extern
+
+// Function is either a function defined by an instance or one provided by the host.
+type Function struct {
+	Type FuncType
+
+	inst *Instance
+	code *compiledCode
+	host func(args []uint64) ([]uint64, error)
+}
+
+type TableInstance struct {
+	Type     Table
+	Elements []*Function
+}
+
+type MemoryInstance struct {
+	Bytes []byte
+}
+
+type GlobalInstance struct {
+	Type    Type
+	Mutable bool
+	Value   uint64
+}
+
+func (*Function) extern()       {}
+func (*TableInstance) extern()  {}
+func (*MemoryInstance) extern() {}
+func (*GlobalInstance) extern() {}
+
+// HostFunction wraps a Go function so that it can be imported by a module. Values are passed in
+// the same representation that Function.Call uses.
+func HostFunction(t FuncType, impl func(args []uint64) ([]uint64, error)) *Function {
+	return &Function{Type: t, host: impl}
+}
+
+// Instance is a module that has been instantiated by the interpreter.
+//
+// Values are represented as uint64s. 32-bit integers are zero-extended, floats are held as their
+// bit patterns and references are opaque handles that are only meaningful to the instance that
+// produced them.
+type Instance struct {
+	types    []FuncType
+	funcs    []*Function
+	tables   []*TableInstance
+	memories []*MemoryInstance
+	globals  []*GlobalInstance
+	elements [][]*Function
+	data     [][]byte
+	exports  map[string]Extern
+	refs     []*Function
+	depth    int
+}
+
+// InstantiateBytes decodes a binary module and instantiates it.
+func InstantiateBytes(buf []byte, imports Imports) (*Instance, error) {
+	m, err := Decode(buf)
+	if err != nil {
+		return nil, err
+	}
+	return Instantiate(m, imports)
+}
+
+// Instantiate resolves a module's imports, initializes its state and runs its start function.
+func Instantiate(m *Module, imports Imports) (*Instance, error) {
+	inst := &Instance{exports: map[string]Extern{}}
+
+	for _, t := range m.Types {
+		ft, ok := t.(FuncType)
+		if !ok {
+			return nil, fmt.Errorf("type %T: %w", t, ErrUnsupported)
+		}
+		inst.types = append(inst.types, ft)
+	}
+
+	if err := inst.resolveImports(m, imports); err != nil {
+		return nil, err
+	}
+	if err := inst.defineFuncs(m); err != nil {
+		return nil, err
+	}
+	for _, t := range m.Tables {
+		inst.tables = append(inst.tables, &TableInstance{Type: t})
+	}
+	for _, mem := range m.Memories {
+		inst.memories = append(inst.memories, newMemory(mem))
+	}
+	for _, g := range m.Globals {
+		v, err := inst.evalConst(g.Init.Instructions)
+		if err != nil {
+			return nil, err
+		}
+		inst.globals = append(inst.globals, &GlobalInstance{Type: g.Type, Mutable: g.Mutable, Value: v})
+	}
+	if err := inst.defineExports(m); err != nil {
+		return nil, err
+	}
+	for _, e := range m.Elements {
+		fe, ok := e.(*FuncElement)
+		if !ok {
+			return nil, fmt.Errorf("element %T: %w", e, ErrUnsupported)
+		}
+		var funcs []*Function
+		for _, idx := range fe.Funcs {
+			if int(idx) >= len(inst.funcs) {
+				return nil, fmt.Errorf("element function %d: %w", idx, ErrMalformed)
+			}
+			funcs = append(funcs, inst.funcs[idx])
+		}
+		inst.elements = append(inst.elements, funcs)
+	}
+	if err := inst.initData(m); err != nil {
+		return nil, err
+	}
+
+	if m.Start != nil {
+		if int(*m.Start) >= len(inst.funcs) {
+			return nil, fmt.Errorf("start function %d: %w", *m.Start, ErrMalformed)
+		}
+		if _, err := inst.funcs[*m.Start].Call(); err != nil {
+			return nil, err
+		}
+	}
+
+	return inst, nil
+}
+
+func newMemory(m Memory) *MemoryInstance {
+	mm, _ := m.(MinMemory)
+	return &MemoryInstance{Bytes: make([]byte, int(mm.Min)*pageSize)}
+}
+
+func (inst *Instance) resolveImports(m *Module, imports Imports) error {
+	for _, imp := range m.Imports {
+		module, name := importName(imp)
+		ext, ok := imports[module][name]
+		if !ok {
+			return fmt.Errorf("%s.%s: %w", module, name, ErrUnknownImport)
+		}
+		if err := inst.bindImport(imp, ext); err != nil {
+			return fmt.Errorf("%s.%s: %w", module, name, err)
+		}
+	}
+	return nil
+}
+
+func importName(imp Import) (string, string) {
+	switch imp := imp.(type) {
+	case FuncImport:
+		return imp.Module, imp.Name
+	case TableImport:
+		return imp.Module, imp.Name
+	case MemoryImport:
+		return imp.Module, imp.Name
+	case GlobalImport:
+		return imp.Module, imp.Name
+	}
+	return "", ""
+}
+
+func (inst *Instance) bindImport(imp Import, ext Extern) error {
+	switch imp := imp.(type) {
+	case FuncImport:
+		f, ok := ext.(*Function)
+		if !ok || int(imp.Type) >= len(inst.types) || !f.Type.Matches(inst.types[imp.Type]) {
+			return ErrImportMismatch
+		}
+		inst.funcs = append(inst.funcs, f)
+
+	case TableImport:
+		t, ok := ext.(*TableInstance)
+		if !ok || t.Type != FuncTable {
+			return ErrImportMismatch
+		}
+		inst.tables = append(inst.tables, t)
+
+	case MemoryImport:
+		mem, ok := ext.(*MemoryInstance)
+		min, _ := imp.Type.(MinMemory)
+		if !ok || len(mem.Bytes) < int(min.Min)*pageSize {
+			return ErrImportMismatch
+		}
+		inst.memories = append(inst.memories, mem)
+
+	case GlobalImport:
+		g, ok := ext.(*GlobalInstance)
+		if !ok || !g.Type.Matches(imp.Type) || g.Mutable != imp.Mutable {
+			return ErrImportMismatch
+		}
+		inst.globals = append(inst.globals, g)
+	}
+	return nil
+}
+
+func (inst *Instance) defineFuncs(m *Module) error {
+	if len(m.Funcs) != len(m.Codes) {
+		return fmt.Errorf("%d functions but %d bodies: %w", len(m.Funcs), len(m.Codes), ErrMalformed)
+	}
+	for i, typeID := range m.Funcs {
+		if int(typeID) >= len(inst.types) {
+			return fmt.Errorf("function type %d: %w", typeID, ErrMalformed)
+		}
+		t := inst.types[typeID]
+		code, err := compile(inst, t, m.Codes[i])
+		if err != nil {
+			return fmt.Errorf("function %d: %w", len(inst.funcs), err)
+		}
+		inst.funcs = append(inst.funcs, &Function{Type: t, inst: inst, code: code})
+	}
+	return nil
+}
+
+func (inst *Instance) defineExports(m *Module) error {
+	for _, e := range m.Exports {
+		var name string
+		var ext Extern
+		var ok bool
+		switch e := e.(type) {
+		case FuncExport:
+			name = e.Name
+			ext, ok = element(inst.funcs, e.Func)
+		case TableExport:
+			name = e.Name
+			ext, ok = element(inst.tables, e.Table)
+		case MemoryExport:
+			name = e.Name
+			ext, ok = element(inst.memories, e.Mem)
+		case GlobalExport:
+			name = e.Name
+			ext, ok = element(inst.globals, e.Global)
+		}
+		if !ok {
+			return fmt.Errorf("export %s: %w", name, ErrMalformed)
+		}
+		inst.exports[name] = ext
+	}
+	return nil
+}
+
+func element[T Extern](xs []T, idx Index) (Extern, bool) {
+	if int(idx) >= len(xs) {
+		return nil, false
+	}
+	return xs[idx], true
+}
+
+func (inst *Instance) initData(m *Module) error {
+	for _, d := range m.Data {
+		switch d := d.(type) {
+		case ActiveData:
+			if int(d.Memory) >= len(inst.memories) {
+				return fmt.Errorf("data memory %d: %w", d.Memory, ErrMalformed)
+			}
+			mem := inst.memories[d.Memory].Bytes
+			if uint64(d.Offset)+uint64(len(d.Bytes)) > uint64(len(mem)) {
+				return &Trap{Reason: "out of bounds memory access"}
+			}
+			copy(mem[d.Offset:], d.Bytes)
+			// active segments are dropped once they have been copied
+			inst.data = append(inst.data, nil)
+
+		case PassiveData:
+			inst.data = append(inst.data, d.Bytes)
+		}
+	}
+	return nil
+}
+
+func (inst *Instance) evalConst(expr []byte) (uint64, error) {
+	instrs, err := DecodeInstructions(expr)
+	if err != nil {
+		return 0, err
+	}
+	if len(instrs) != 2 || instrs[1].Op != OpEnd {
+		return 0, fmt.Errorf("constant expression: %w", ErrUnsupported)
+	}
+	x := instrs[0]
+	switch x.Op {
+	case OpI32Const:
+		return uint64(uint32(x.Args[0])), nil
+	case OpI64Const, OpF32Const, OpF64Const:
+		return x.Args[0], nil
+	case OpGlobalGet:
+		if int(x.Args[0]) >= len(inst.globals) {
+			return 0, fmt.Errorf("constant expression: %w", ErrMalformed)
+		}
+		return inst.globals[x.Args[0]].Value, nil
+	case OpRefNull:
+		return 0, nil
+	case OpRefFunc:
+		if int(x.Args[0]) >= len(inst.funcs) {
+			return 0, fmt.Errorf("constant expression: %w", ErrMalformed)
+		}
+		return inst.ref(inst.funcs[x.Args[0]]), nil
+	}
+	return 0, fmt.Errorf("constant expression %s: %w", x.Op, ErrUnsupported)
+}
+
+// Export finds an extern that the instance exports.
+func (inst *Instance) Export(name string) (Extern, bool) {
+	ext, ok := inst.exports[name]
+	return ext, ok
+}
+
+// Exports lists everything that the instance exports, in a form suitable for importing into other
+// instances.
+func (inst *Instance) Exports() map[string]Extern {
+	return inst.exports
+}
+
+// Func finds an exported function.
+func (inst *Instance) Func(name string) (*Function, error) {
+	f, ok := inst.exports[name].(*Function)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrUnknownExport)
+	}
+	return f, nil
+}
+
+func (inst *Instance) ref(f *Function) uint64 {
+	if f == nil {
+		return 0
+	}
+	for i, g := range inst.refs {
+		if g == f {
+			return uint64(i + 1)
+		}
+	}
+	inst.refs = append(inst.refs, f)
+	return uint64(len(inst.refs))
+}
+
+func (inst *Instance) deref(r uint64) *Function {
+	if r == 0 || r > uint64(len(inst.refs)) {
+		return nil
+	}
+	return inst.refs[r-1]
+}
+
+// Trap is an error raised while executing code, either by the code itself or by a host function.
+type Trap struct {
+	Reason string
+	Err    error
+}
+
+func (t *Trap) Error() string {
+	if t.Err != nil {
+		return "wasm trap: " + t.Err.Error()
+	}
+	return "wasm trap: " + t.Reason
+}
+
+func (t *Trap) Unwrap() error {
+	return t.Err
+}
+
+func trap(reason string) {
+	panic(&Trap{Reason: reason})
+}
+
+// Call invokes the function. Host functions can abort execution by returning an error, which Call
+// returns wrapped in a Trap.
+func (f *Function) Call(args ...uint64) (res []uint64, err error) {
+	if len(args) != len(f.Type.In) {
+		return nil, fmt.Errorf("expecting %d arguments, got %d: %w", len(f.Type.In), len(args), ErrImportMismatch)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			t, ok := r.(*Trap)
+			if !ok {
+				panic(r)
+			}
+			res, err = nil, t
+		}
+	}()
+	return f.invoke(args), nil
+}
+
+func (f *Function) invoke(args []uint64) []uint64 {
+	if f.host != nil {
+		res, err := f.host(args)
+		if err != nil {
+			panic(&Trap{Err: err})
+		}
+		return res
+	}
+	return f.inst.exec(f, args)
+}
@@ -0,0 +1,196 @@
+package wasm
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestHostImport(t *testing.T) {
+	var m Module
+
+	double := FuncType{In: []Type{Int32}, Out: []Type{Int32}}
+	m.Imports = []Import{FuncImport{Module: "host", Name: "double", Type: m.EnsureType(double)}}
+
+	f := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	f.LocalGet(0)
+	f.Call(0)
+	f.I32Const(1)
+	f.I32Add()
+	f.End()
+
+	inst, err := Instantiate(&m, Imports{"host": {
+		"double": HostFunction(double, func(args []uint64) ([]uint64, error) {
+			return []uint64{args[0] * 2}, nil
+		}),
+	}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	test, err := inst.Func("test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := test.Call(20)
+	assert.Nil(t, err)
+	assert.Equal(t, res, []uint64{41})
+}
+
+func TestImportErrors(t *testing.T) {
+	var m Module
+	ft := FuncType{In: []Type{Int32}}
+	m.Imports = []Import{FuncImport{Module: "host", Name: "f", Type: m.EnsureType(ft)}}
+
+	_, err := Instantiate(&m, nil)
+	assert.True(t, errors.Is(err, ErrUnknownImport))
+
+	_, err = Instantiate(&m, Imports{"host": {
+		"f": HostFunction(FuncType{}, nil),
+	}})
+	assert.True(t, errors.Is(err, ErrImportMismatch))
+}
+
+func TestRecursion(t *testing.T) {
+	var m Module
+
+	// fact(n) = n == 0 ? 1 : n * fact(n - 1)
+	f := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	f.Locals = []LocalDecl{{1, Int32}}
+	f.I32Const(1)
+	f.LocalSet(1)
+	f.LocalGet(0)
+	f.If(Void)
+	f.LocalGet(0)
+	f.LocalGet(0)
+	f.I32Const(1)
+	f.I32Sub()
+	f.Call(0)
+	f.I32Mul()
+	f.LocalSet(1)
+	f.End()
+	f.LocalGet(1)
+	f.End()
+
+	testModule(t, m, 5, 120)
+}
+
+func TestLoadStore(t *testing.T) {
+	var m Module
+	m.Memories = []Memory{MinMemory{Min: 1}}
+	m.Data = []Data{ActiveData{Offset: 8, Bytes: []byte{1, 2, 3, 4}}}
+
+	f := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	f.I32Const(16)
+	f.LocalGet(0)
+	f.I32Load(2, 8)
+	f.I32Store(2, 0)
+	f.I32Const(16)
+	f.I32Load(2, 0)
+	f.End()
+
+	testModule(t, m, 0, 0x04030201)
+	testModule(t, m, 1, 0x00040302)
+}
+
+func TestTraps(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		build  func(c *Code)
+		reason string
+	}{
+		{
+			name: "DivideByZero",
+			build: func(c *Code) {
+				c.LocalGet(0)
+				c.I32Const(0)
+				c.I32DivS()
+			},
+			reason: "integer divide by zero",
+		},
+		{
+			name: "InvalidConversion",
+			build: func(c *Code) {
+				c.F64Const(math.NaN())
+				c.I32TruncF64S()
+			},
+			reason: "invalid conversion to integer",
+		},
+		{
+			name: "ConversionOverflow",
+			build: func(c *Code) {
+				c.F32Const(-1)
+				c.I32TruncF32U()
+			},
+			reason: "integer overflow",
+		},
+		{
+			name: "OutOfBoundsLoad",
+			build: func(c *Code) {
+				c.I32Const(pageSize)
+				c.I32Load(2, 0)
+			},
+			reason: "out of bounds memory access",
+		},
+		{
+			name: "UninitializedElement",
+			build: func(c *Code) {
+				c.NullFunc()
+				c.I32Const(1)
+				c.TableGrow(0)
+				c.CallIndirect(0)
+			},
+			reason: "uninitialized element",
+		},
+		{
+			name: "UndefinedElement",
+			build: func(c *Code) {
+				c.I32Const(0)
+				c.I32Const(5)
+				c.CallIndirect(0)
+			},
+			reason: "undefined element",
+		},
+		{
+			name: "StackExhausted",
+			build: func(c *Code) {
+				c.LocalGet(0)
+				c.Call(0)
+			},
+			reason: "call stack exhausted",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var m Module
+			m.Memories = []Memory{MinMemory{Min: 1}}
+			m.Tables = []Table{FuncTable}
+
+			c := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+			test.build(c)
+			c.End()
+
+			inst, err := Instantiate(&m, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			f, err := inst.Func("test")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			_, err = f.Call(0)
+			var tr *Trap
+			if !errors.As(err, &tr) {
+				t.Errorf("expected trap, got %v", err)
+				return
+			}
+			assert.Equal(t, tr.Reason, test.reason)
+		})
+	}
+}
@@ -0,0 +1,505 @@
+package wasm
+
+import (
+	"fmt"
+)
+
+// The interpreter recurses on the Go stack when calling functions, so calls are limited to a
+// depth that is well inside what Go can accommodate.
+const maxCallDepth = 10000
+
+type compiledCode struct {
+	instrs []Instruction
+	locals int
+
+	// for block, loop, if and else instructions, the position of the matching end
+	ends []int
+	// for if instructions, the position of the matching else, or -1
+	elses []int
+}
+
+func compile(inst *Instance, t FuncType, c *Code) (*compiledCode, error) {
+	instrs, err := c.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	ends, elses, err := matchBlocks(instrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range instrs {
+		switch x.Op {
+		case OpBlock, OpLoop, OpIf:
+			if _, _, ok := inst.blockArity(x.Args[0]); !ok {
+				return nil, fmt.Errorf("block type %d: %w", int64(x.Args[0]), ErrMalformed)
+			}
+		}
+	}
+
+	res := &compiledCode{
+		instrs: instrs,
+		locals: len(t.In),
+		ends:   ends,
+		elses:  elses,
+	}
+	for _, l := range c.Locals {
+		res.locals += int(l.Count)
+	}
+	return res, nil
+}
+
+// blockArity gives the number of values that a block takes from the stack and the number that it
+// leaves behind.
+func (inst *Instance) blockArity(bt uint64) (params, results int, ok bool) {
+	v := int64(bt)
+	switch {
+	case v == -64:
+		return 0, 0, true
+	case v < 0:
+		return 0, 1, true
+	case v < int64(len(inst.types)):
+		t := inst.types[v]
+		return len(t.In), len(t.Out), true
+	}
+	return 0, 0, false
+}
+
+type label struct {
+	// where execution continues after branching to the label
+	cont int
+	// the height of the operand stack when the block was entered
+	height int
+	// the number of values carried by a branch to the label
+	arity int
+}
+
+type machine struct {
+	inst   *Instance
+	code   *compiledCode
+	locals []uint64
+	stack  []uint64
+	labels []label
+	pc     int
+}
+
+func (inst *Instance) exec(f *Function, args []uint64) []uint64 {
+	inst.depth++
+	defer func() { inst.depth-- }()
+	if inst.depth > maxCallDepth {
+		trap("call stack exhausted")
+	}
+
+	m := &machine{
+		inst:   inst,
+		code:   f.code,
+		locals: make([]uint64, f.code.locals),
+		labels: []label{{cont: len(f.code.instrs), arity: len(f.Type.Out)}},
+	}
+	copy(m.locals, args)
+	m.run()
+
+	res := make([]uint64, len(f.Type.Out))
+	copy(res, m.stack[len(m.stack)-len(res):])
+	return res
+}
+
+func (m *machine) push(x uint64) {
+	m.stack = append(m.stack, x)
+}
+
+func (m *machine) pop() uint64 {
+	n := len(m.stack) - 1
+	x := m.stack[n]
+	m.stack = m.stack[:n]
+	return x
+}
+
+func (m *machine) pushN(xs []uint64) {
+	m.stack = append(m.stack, xs...)
+}
+
+func (m *machine) popN(n int) []uint64 {
+	res := make([]uint64, n)
+	copy(res, m.stack[len(m.stack)-n:])
+	m.stack = m.stack[:len(m.stack)-n]
+	return res
+}
+
+func (m *machine) push32(x uint32) {
+	m.push(uint64(x))
+}
+
+func (m *machine) pop32() uint32 {
+	return uint32(m.pop())
+}
+
+func (m *machine) pushBool(b bool) {
+	if b {
+		m.push(1)
+	} else {
+		m.push(0)
+	}
+}
+
+func (m *machine) enter(x Instruction, cont int) {
+	params, results, _ := m.inst.blockArity(x.Args[0])
+	arity := results
+	if x.Op == OpLoop {
+		arity = params
+	}
+	m.labels = append(m.labels, label{
+		cont:   cont,
+		height: len(m.stack) - params,
+		arity:  arity,
+	})
+}
+
+func (m *machine) branch(depth int) {
+	n := len(m.labels) - 1 - depth
+	l := m.labels[n]
+	copy(m.stack[l.height:], m.stack[len(m.stack)-l.arity:])
+	m.stack = m.stack[:l.height+l.arity]
+	m.labels = m.labels[:n]
+	m.pc = l.cont
+}
+
+func (m *machine) call(f *Function) {
+	m.pushN(f.invoke(m.popN(len(f.Type.In))))
+}
+
+// tailCall replaces the running function with f, so that a chain of tail calls within an instance
+// runs in constant space. Other functions are called as normal and their results returned.
+func (m *machine) tailCall(f *Function) {
+	args := m.popN(len(f.Type.In))
+	if f.host != nil || f.inst != m.inst {
+		m.pushN(f.invoke(args))
+		m.branch(len(m.labels) - 1)
+		return
+	}
+	m.code = f.code
+	m.locals = make([]uint64, f.code.locals)
+	copy(m.locals, args)
+	m.stack = m.stack[:0]
+	m.labels = append(m.labels[:0], label{cont: len(f.code.instrs), arity: len(f.Type.Out)})
+	m.pc = 0
+}
+
+func (m *machine) memory(idx uint64) *MemoryInstance {
+	return m.inst.memories[idx]
+}
+
+// address pops a dynamic address from the stack, applies the static offset and checks that size
+// bytes can be accessed from the resulting address.
+func (m *machine) address(offset uint64, size int) []byte {
+	mem := m.inst.memories[0].Bytes
+	ea := uint64(m.pop32()) + offset
+	if ea+uint64(size) > uint64(len(mem)) {
+		trap("out of bounds memory access")
+	}
+	return mem[ea : ea+uint64(size)]
+}
+
+func (m *machine) load(x Instruction, size int) uint64 {
+	bs := m.address(x.Args[1], size)
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(bs[i])
+	}
+	return v
+}
+
+func (m *machine) store(x Instruction, size int) {
+	v := m.pop()
+	bs := m.address(x.Args[1], size)
+	for i := range bs {
+		bs[i] = byte(v >> (8 * i))
+	}
+}
+
+func (m *machine) table(idx uint64) *TableInstance {
+	return m.inst.tables[idx]
+}
+
+// span checks that the range [start, start+n) lies within a sequence of the given length.
+func span(start, n uint32, length int) {
+	if uint64(start)+uint64(n) > uint64(length) {
+		trap("out of bounds access")
+	}
+}
+
+func (m *machine) run() {
+	code := m.code
+	inst := m.inst
+
+	for m.pc < len(code.instrs) {
+		x := code.instrs[m.pc]
+		m.pc++
+
+		switch x.Op {
+
+		// control
+
+		case OpUnreachable:
+			trap("unreachable")
+
+		case OpNop:
+
+		case OpBlock:
+			m.enter(x, code.ends[m.pc-1]+1)
+
+		case OpLoop:
+			m.enter(x, m.pc-1)
+
+		case OpIf:
+			start := m.pc - 1
+			cond := m.pop32()
+			m.enter(x, code.ends[start]+1)
+			if cond == 0 {
+				if e := code.elses[start]; e != -1 {
+					m.pc = e + 1
+				} else {
+					m.pc = code.ends[start]
+				}
+			}
+
+		case OpElse:
+			m.pc = code.ends[m.pc-1]
+
+		case OpEnd:
+			m.labels = m.labels[:len(m.labels)-1]
+
+		case OpBr:
+			m.branch(int(x.Args[0]))
+
+		case OpBrIf:
+			if m.pop32() != 0 {
+				m.branch(int(x.Args[0]))
+			}
+
+		case OpBrTable:
+			i := uint64(m.pop32())
+			if i >= uint64(len(x.Args)-1) {
+				i = uint64(len(x.Args) - 1)
+			}
+			m.branch(int(x.Args[i]))
+
+		case OpReturn:
+			m.branch(len(m.labels) - 1)
+
+		case OpCall:
+			m.call(inst.funcs[x.Args[0]])
+
+		case OpCallIndirect:
+			m.call(m.indirect(x))
+
+		case OpReturnCall:
+			m.tailCall(inst.funcs[x.Args[0]])
+			code = m.code
+
+		case OpReturnCallIndirect:
+			m.tailCall(m.indirect(x))
+			code = m.code
+
+		// parametric
+
+		case OpDrop:
+			m.pop()
+
+		case OpSelect, OpSelectT:
+			c := m.pop32()
+			b := m.pop()
+			a := m.pop()
+			if c != 0 {
+				m.push(a)
+			} else {
+				m.push(b)
+			}
+
+		// variables
+
+		case OpLocalGet:
+			m.push(m.locals[x.Args[0]])
+
+		case OpLocalSet:
+			m.locals[x.Args[0]] = m.pop()
+
+		case OpLocalTee:
+			m.locals[x.Args[0]] = m.stack[len(m.stack)-1]
+
+		case OpGlobalGet:
+			m.push(inst.globals[x.Args[0]].Value)
+
+		case OpGlobalSet:
+			inst.globals[x.Args[0]].Value = m.pop()
+
+		// references and tables
+
+		case OpRefNull:
+			m.push(0)
+
+		case OpRefIsNull:
+			m.pushBool(m.pop() == 0)
+
+		case OpRefFunc:
+			m.push(inst.ref(inst.funcs[x.Args[0]]))
+
+		case OpTableGet:
+			t := m.table(x.Args[0])
+			i := m.pop32()
+			span(i, 1, len(t.Elements))
+			m.push(inst.ref(t.Elements[i]))
+
+		case OpTableSet:
+			t := m.table(x.Args[0])
+			f := inst.deref(m.pop())
+			i := m.pop32()
+			span(i, 1, len(t.Elements))
+			t.Elements[i] = f
+
+		case OpTableSize:
+			m.push32(uint32(len(m.table(x.Args[0]).Elements)))
+
+		case OpTableGrow:
+			t := m.table(x.Args[0])
+			n := m.pop32()
+			f := inst.deref(m.pop())
+			old := len(t.Elements)
+			if uint64(old)+uint64(n) > 1<<32-1 {
+				m.push32(^uint32(0))
+				break
+			}
+			for i := uint32(0); i < n; i++ {
+				t.Elements = append(t.Elements, f)
+			}
+			m.push32(uint32(old))
+
+		case OpTableFill:
+			t := m.table(x.Args[0])
+			n := m.pop32()
+			f := inst.deref(m.pop())
+			i := m.pop32()
+			span(i, n, len(t.Elements))
+			for j := range t.Elements[i : i+n] {
+				t.Elements[i+uint32(j)] = f
+			}
+
+		case OpTableInit:
+			elem := inst.elements[x.Args[0]]
+			t := m.table(x.Args[1])
+			n, s, d := m.pop32(), m.pop32(), m.pop32()
+			span(s, n, len(elem))
+			span(d, n, len(t.Elements))
+			copy(t.Elements[d:], elem[s:s+n])
+
+		case OpElemDrop:
+			inst.elements[x.Args[0]] = nil
+
+		case OpTableCopy:
+			dst, src := m.table(x.Args[0]), m.table(x.Args[1])
+			n, s, d := m.pop32(), m.pop32(), m.pop32()
+			span(s, n, len(src.Elements))
+			span(d, n, len(dst.Elements))
+			copy(dst.Elements[d:d+n], src.Elements[s:s+n])
+
+		// memory
+
+		case OpI32Load, OpF32Load, OpI64Load32U:
+			m.push(m.load(x, 4))
+		case OpI64Load, OpF64Load:
+			m.push(m.load(x, 8))
+		case OpI32Load8S:
+			m.push32(uint32(int8(m.load(x, 1))))
+		case OpI32Load8U, OpI64Load8U:
+			m.push(m.load(x, 1))
+		case OpI32Load16S:
+			m.push32(uint32(int16(m.load(x, 2))))
+		case OpI32Load16U, OpI64Load16U:
+			m.push(m.load(x, 2))
+		case OpI64Load8S:
+			m.push(uint64(int8(m.load(x, 1))))
+		case OpI64Load16S:
+			m.push(uint64(int16(m.load(x, 2))))
+		case OpI64Load32S:
+			m.push(uint64(int32(m.load(x, 4))))
+
+		case OpI32Store8, OpI64Store8:
+			m.store(x, 1)
+		case OpI32Store16, OpI64Store16:
+			m.store(x, 2)
+		case OpI32Store, OpF32Store, OpI64Store32:
+			m.store(x, 4)
+		case OpI64Store, OpF64Store:
+			m.store(x, 8)
+
+		case OpMemorySize:
+			m.push32(uint32(len(m.memory(x.Args[0]).Bytes) / pageSize))
+
+		case OpMemoryGrow:
+			mem := m.memory(x.Args[0])
+			n := m.pop32()
+			old := len(mem.Bytes) / pageSize
+			if uint64(old)+uint64(n) > 1<<16 {
+				m.push32(^uint32(0))
+				break
+			}
+			mem.Bytes = append(mem.Bytes, make([]byte, int(n)*pageSize)...)
+			m.push32(uint32(old))
+
+		case OpMemoryInit:
+			data := inst.data[x.Args[0]]
+			mem := m.memory(x.Args[1])
+			n, s, d := m.pop32(), m.pop32(), m.pop32()
+			span(s, n, len(data))
+			span(d, n, len(mem.Bytes))
+			copy(mem.Bytes[d:], data[s:s+n])
+
+		case OpDataDrop:
+			inst.data[x.Args[0]] = nil
+
+		case OpMemoryCopy:
+			dst, src := m.memory(x.Args[0]), m.memory(x.Args[1])
+			n, s, d := m.pop32(), m.pop32(), m.pop32()
+			span(s, n, len(src.Bytes))
+			span(d, n, len(dst.Bytes))
+			copy(dst.Bytes[d:d+n], src.Bytes[s:s+n])
+
+		case OpMemoryFill:
+			mem := m.memory(x.Args[0])
+			n, v, d := m.pop32(), m.pop32(), m.pop32()
+			span(d, n, len(mem.Bytes))
+			for i := range mem.Bytes[d : d+n] {
+				mem.Bytes[d+uint32(i)] = byte(v)
+			}
+
+		// constants
+
+		case OpI32Const:
+			m.push32(uint32(x.Args[0]))
+
+		case OpI64Const, OpF32Const, OpF64Const:
+			m.push(x.Args[0])
+
+		default:
+			if !m.numeric(x.Op) {
+				trap(fmt.Sprintf("unsupported instruction %s", x.Op))
+			}
+		}
+	}
+}
+
+func (m *machine) indirect(x Instruction) *Function {
+	t := m.table(x.Args[1])
+	i := m.pop32()
+	if uint64(i) >= uint64(len(t.Elements)) {
+		trap("undefined element")
+	}
+	f := t.Elements[i]
+	if f == nil {
+		trap("uninitialized element")
+	}
+	if !f.Type.Matches(m.inst.types[x.Args[0]]) {
+		trap("indirect call type mismatch")
+	}
+	return f
+}
@@ -0,0 +1,503 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrImportCycle = errors.New("import cycle")
+
+// LinkModule is a module to be merged by Link, along with the name that other modules use to
+// import from it.
+type LinkModule struct {
+	Name   string
+	Module *Module
+}
+
+// Link merges modules into a single module. Imports are resolved against the exports of the other
+// modules, and anything that no module provides remains an import of the result. The result
+// exports what the first module exports.
+//
+// Each module keeps its own definitions, so the index spaces are renumbered and element and data
+// segments are concatenated. If more than one module has a start function they are called in
+// order.
+func Link(mods ...LinkModule) (*Module, error) {
+	l := &linker{
+		mods:      mods,
+		byName:    map[string]int{},
+		externals: map[externalKey]Index{},
+		out:       &Module{},
+	}
+	for i, m := range mods {
+		if _, ok := l.byName[m.Name]; ok {
+			return nil, fmt.Errorf("module %q linked twice: %w", m.Name, ErrImportMismatch)
+		}
+		l.byName[m.Name] = i
+	}
+	if err := l.link(); err != nil {
+		return nil, err
+	}
+	return l.out, nil
+}
+
+// The kinds of thing that can be imported, numbered as in the binary format.
+type externKind byte
+
+const (
+	funcKind externKind = iota
+	tableKind
+	memoryKind
+	globalKind
+)
+
+func (k externKind) String() string {
+	return [...]string{"function", "table", "memory", "global"}[k]
+}
+
+type externKey struct {
+	mod  int
+	kind externKind
+	idx  Index
+}
+
+type externalKey struct {
+	module, name string
+	kind         externKind
+}
+
+type linker struct {
+	mods      []LinkModule
+	byName    map[string]int
+	externals map[externalKey]Index
+	out       *Module
+
+	// per module
+	types  [][]Index
+	spaces [][4][]Index
+	elems  []Index
+	datas  []Index
+}
+
+func (l *linker) link() error {
+	l.types = make([][]Index, len(l.mods))
+	l.spaces = make([][4][]Index, len(l.mods))
+	l.elems = make([]Index, len(l.mods))
+	l.datas = make([]Index, len(l.mods))
+
+	for i, lm := range l.mods {
+		for _, t := range lm.Module.Types {
+			l.types[i] = append(l.types[i], l.out.EnsureType(t))
+		}
+	}
+
+	// imports that nothing provides must come first in each index space
+	var counts [4]int
+	resolved := make([][]resolution, len(l.mods))
+	for i, lm := range l.mods {
+		for _, imp := range lm.Module.Imports {
+			r, err := l.resolve(i, imp, 0)
+			if err != nil {
+				return err
+			}
+			if r.target.mod == -1 {
+				l.external(r.mod, r.imp, &counts)
+			}
+			resolved[i] = append(resolved[i], r)
+		}
+	}
+
+	for i, lm := range l.mods {
+		m := lm.Module
+		spaces := &l.spaces[i]
+		for kind, n := range [4]int{len(m.Funcs), len(m.Tables), len(m.Memories), len(m.Globals)} {
+			imported := importsOf(m, externKind(kind))
+			spaces[kind] = make([]Index, imported+n)
+			for j := 0; j < n; j++ {
+				spaces[kind][imported+j] = Index(counts[kind])
+				counts[kind]++
+			}
+		}
+	}
+	if counts[memoryKind] > 1 {
+		return fmt.Errorf("linked modules define %d memories: %w", counts[memoryKind], ErrInvalid)
+	}
+
+	// now that definitions have been numbered, fill in the imports
+	for i, lm := range l.mods {
+		seen := [4]int{}
+		for j, imp := range lm.Module.Imports {
+			kind := importKind(imp)
+			r := resolved[i][j]
+			idx := l.externals[externalOf(r.imp)]
+			if r.target.mod != -1 {
+				if err := l.checkImport(i, imp, r.target); err != nil {
+					return err
+				}
+				idx = l.spaces[r.target.mod][kind][r.target.idx]
+			}
+			l.spaces[i][kind][seen[kind]] = idx
+			seen[kind]++
+		}
+	}
+
+	var elems, datas int
+	for i, lm := range l.mods {
+		l.elems[i] = Index(elems)
+		l.datas[i] = Index(datas)
+		elems += len(lm.Module.Elements)
+		datas += len(lm.Module.Data)
+	}
+
+	for i := range l.mods {
+		if err := l.define(i); err != nil {
+			return fmt.Errorf("module %q: %w", l.mods[i].Name, err)
+		}
+	}
+	if len(l.mods) != 0 {
+		l.exports()
+	}
+	l.start()
+	return l.names()
+}
+
+func importKind(imp Import) externKind {
+	switch imp.(type) {
+	case TableImport:
+		return tableKind
+	case MemoryImport:
+		return memoryKind
+	case GlobalImport:
+		return globalKind
+	}
+	return funcKind
+}
+
+func importsOf(m *Module, kind externKind) int {
+	switch kind {
+	case tableKind:
+		return importCount[TableImport](m.Imports)
+	case memoryKind:
+		return importCount[MemoryImport](m.Imports)
+	case globalKind:
+		return importCount[GlobalImport](m.Imports)
+	}
+	return importCount[FuncImport](m.Imports)
+}
+
+func externalOf(imp Import) externalKey {
+	module, name := importName(imp)
+	return externalKey{module, name, importKind(imp)}
+}
+
+// resolution is where an import leads. If no linked module defines it, then the target's module is
+// -1 and the import that the result needs is given instead.
+type resolution struct {
+	target externKey
+	mod    int
+	imp    Import
+}
+
+// resolve finds the definition that an import refers to, following any exports that are
+// themselves imports.
+func (l *linker) resolve(mod int, imp Import, depth int) (resolution, error) {
+	module, name := importName(imp)
+	target, ok := l.byName[module]
+	if !ok {
+		return resolution{target: externKey{mod: -1}, mod: mod, imp: imp}, nil
+	}
+	if depth > len(l.mods) {
+		return resolution{}, fmt.Errorf("%s.%s: %w", module, name, ErrImportCycle)
+	}
+
+	kind := importKind(imp)
+	m := l.mods[target].Module
+	for _, e := range m.Exports {
+		ekind, eidx := exportOf(e)
+		if exportName(e) != name {
+			continue
+		}
+		if ekind != kind {
+			return resolution{}, fmt.Errorf("%s.%s is a %s: %w", module, name, ekind, ErrImportMismatch)
+		}
+		if int(eidx) >= importsOf(m, kind) {
+			return resolution{target: externKey{target, kind, eidx}}, nil
+		}
+		// re-exported import
+		n := 0
+		for _, imp := range m.Imports {
+			if importKind(imp) != kind {
+				continue
+			}
+			if n == int(eidx) {
+				return l.resolve(target, imp, depth+1)
+			}
+			n++
+		}
+	}
+	return resolution{}, fmt.Errorf("%s.%s: %w", module, name, ErrUnknownExport)
+}
+
+func exportOf(e Export) (externKind, Index) {
+	switch e := e.(type) {
+	case TableExport:
+		return tableKind, e.Table
+	case MemoryExport:
+		return memoryKind, e.Mem
+	case GlobalExport:
+		return globalKind, e.Global
+	case FuncExport:
+		return funcKind, e.Func
+	}
+	return 0, 0
+}
+
+func exportName(e Export) string {
+	switch e := e.(type) {
+	case TableExport:
+		return e.Name
+	case MemoryExport:
+		return e.Name
+	case GlobalExport:
+		return e.Name
+	case FuncExport:
+		return e.Name
+	}
+	return ""
+}
+
+// external adds an import that no module provides to the result. Modules that import the same
+// thing share the import.
+func (l *linker) external(mod int, imp Import, counts *[4]int) {
+	key := externalOf(imp)
+	if _, ok := l.externals[key]; ok {
+		return
+	}
+	l.externals[key] = Index(counts[key.kind])
+	counts[key.kind]++
+	if f, ok := imp.(FuncImport); ok {
+		f.Type = l.types[mod][f.Type]
+		imp = f
+	}
+	l.out.Imports = append(l.out.Imports, imp)
+}
+
+// checkImport makes sure that a definition is compatible with how it is imported.
+func (l *linker) checkImport(mod int, imp Import, target externKey) error {
+	m := l.mods[target.mod].Module
+	local := int(target.idx) - importsOf(m, target.kind)
+	ok := true
+
+	switch imp := imp.(type) {
+	case FuncImport:
+		want := l.mods[mod].Module.Types[imp.Type]
+		ok = m.Types[m.Funcs[local]].Matches(want)
+	case TableImport:
+		ok = m.Tables[local] == FuncTable
+	case MemoryImport:
+		want, _ := imp.Type.(MinMemory)
+		have, _ := m.Memories[local].(MinMemory)
+		ok = have.Min >= want.Min
+	case GlobalImport:
+		g := m.Globals[local]
+		ok = g.Type.Matches(imp.Type) && g.Mutable == imp.Mutable
+	}
+	if !ok {
+		module, name := importName(imp)
+		return fmt.Errorf("%s.%s: %w", module, name, ErrImportMismatch)
+	}
+	return nil
+}
+
+// define adds a module's definitions to the result.
+func (l *linker) define(mod int) error {
+	m := l.mods[mod].Module
+	spaces := l.spaces[mod]
+
+	if len(m.Funcs) != len(m.Codes) {
+		return fmt.Errorf("%d functions but %d bodies: %w", len(m.Funcs), len(m.Codes), ErrMalformed)
+	}
+	for i, t := range m.Funcs {
+		c := m.Codes[i]
+		code, err := l.relocate(mod, c.Instructions)
+		if err != nil {
+			return fmt.Errorf("function %d: %w", c.Func, err)
+		}
+		l.out.Funcs = append(l.out.Funcs, l.types[mod][t])
+		l.out.Codes = append(l.out.Codes, &Code{
+			Func:         spaces[funcKind][int(c.Func)],
+			Locals:       c.Locals,
+			Instructions: code,
+			params:       c.params,
+		})
+	}
+
+	l.out.Tables = append(l.out.Tables, m.Tables...)
+	l.out.Memories = append(l.out.Memories, m.Memories...)
+
+	for i, g := range m.Globals {
+		init, err := l.relocate(mod, g.Init.Instructions)
+		if err != nil {
+			return fmt.Errorf("global %d: %w", i, err)
+		}
+		g.Init = Code{Instructions: init}
+		l.out.Globals = append(l.out.Globals, g)
+	}
+
+	for _, e := range m.Elements {
+		if e, ok := e.(*FuncElement); ok {
+			res := &FuncElement{}
+			for _, f := range e.Funcs {
+				res.Funcs = append(res.Funcs, spaces[funcKind][f])
+			}
+			l.out.Elements = append(l.out.Elements, res)
+		}
+	}
+
+	for _, d := range m.Data {
+		if d, ok := d.(ActiveData); ok {
+			d.Memory = spaces[memoryKind][d.Memory]
+			l.out.Data = append(l.out.Data, d)
+			continue
+		}
+		l.out.Data = append(l.out.Data, d)
+	}
+
+	for _, s := range m.Customs {
+		if s.Name != nameSection {
+			l.out.Customs = append(l.out.Customs, s)
+		}
+	}
+	return nil
+}
+
+// relocate renumbers the indices that appear in a sequence of instructions.
+func (l *linker) relocate(mod int, code []byte) ([]byte, error) {
+	instrs, err := DecodeInstructions(code)
+	if err != nil {
+		return nil, err
+	}
+	spaces := l.spaces[mod]
+
+	var res Code
+	for _, x := range instrs {
+		args := append([]uint64(nil), x.Args...)
+		index := func(i int, space []Index) {
+			if int(args[i]) < len(space) {
+				args[i] = uint64(space[args[i]])
+			}
+		}
+
+		switch x.Op {
+		case OpCall, OpReturnCall, OpRefFunc:
+			index(0, spaces[funcKind])
+		case OpCallIndirect, OpReturnCallIndirect:
+			index(0, l.types[mod])
+			index(1, spaces[tableKind])
+		case OpBlock, OpLoop, OpIf:
+			if int64(args[0]) >= 0 {
+				index(0, l.types[mod])
+			}
+		case OpGlobalGet, OpGlobalSet:
+			index(0, spaces[globalKind])
+		case OpTableGet, OpTableSet, OpTableSize, OpTableGrow, OpTableFill:
+			index(0, spaces[tableKind])
+		case OpTableCopy:
+			index(0, spaces[tableKind])
+			index(1, spaces[tableKind])
+		case OpTableInit:
+			args[0] += uint64(l.elems[mod])
+			index(1, spaces[tableKind])
+		case OpElemDrop:
+			args[0] += uint64(l.elems[mod])
+		case OpMemorySize, OpMemoryGrow, OpMemoryFill:
+			index(0, spaces[memoryKind])
+		case OpMemoryCopy:
+			index(0, spaces[memoryKind])
+			index(1, spaces[memoryKind])
+		case OpMemoryInit:
+			args[0] += uint64(l.datas[mod])
+			index(1, spaces[memoryKind])
+		case OpDataDrop:
+			args[0] += uint64(l.datas[mod])
+		}
+		res.Emit(Instruction{Op: x.Op, Args: args})
+	}
+	return res.Instructions, nil
+}
+
+func (l *linker) exports() {
+	spaces := l.spaces[0]
+	for _, e := range l.mods[0].Module.Exports {
+		switch e := e.(type) {
+		case FuncExport:
+			e.Func = spaces[funcKind][e.Func]
+			l.out.Exports = append(l.out.Exports, e)
+		case TableExport:
+			e.Table = spaces[tableKind][e.Table]
+			l.out.Exports = append(l.out.Exports, e)
+		case MemoryExport:
+			e.Mem = spaces[memoryKind][e.Mem]
+			l.out.Exports = append(l.out.Exports, e)
+		case GlobalExport:
+			e.Global = spaces[globalKind][e.Global]
+			l.out.Exports = append(l.out.Exports, e)
+		}
+	}
+}
+
+func (l *linker) start() {
+	var starts []Index
+	for i, lm := range l.mods {
+		if lm.Module.Start != nil {
+			starts = append(starts, l.spaces[i][funcKind][*lm.Module.Start])
+		}
+	}
+	switch len(starts) {
+	case 0:
+	case 1:
+		l.out.Start = &starts[0]
+	default:
+		c := l.out.AddFunc(nil, nil)
+		for _, f := range starts {
+			c.Call(uint32(f))
+		}
+		c.End()
+		l.out.Start = &c.Func
+	}
+}
+
+// names merges the name sections of the modules. The result takes its name from the first module.
+func (l *linker) names() error {
+	var res *Names
+	for i, lm := range l.mods {
+		n, err := lm.Module.Names()
+		if err != nil {
+			return fmt.Errorf("module %q: %w", lm.Name, err)
+		}
+		if n == nil {
+			continue
+		}
+		if res == nil {
+			res = &Names{Funcs: map[Index]string{}, Locals: map[Index]map[uint32]string{}}
+		}
+		if i == 0 {
+			res.Module = n.Module
+		}
+		funcs := l.spaces[i][funcKind]
+		imported := importsOf(lm.Module, funcKind)
+		for f, name := range n.Funcs {
+			if int(f) >= imported && int(f) < len(funcs) {
+				res.Funcs[funcs[f]] = name
+			}
+		}
+		for f, locals := range n.Locals {
+			if int(f) >= imported && int(f) < len(funcs) {
+				res.Locals[funcs[f]] = locals
+			}
+		}
+	}
+	if res != nil {
+		l.out.SetNames(res)
+	}
+	return nil
+}
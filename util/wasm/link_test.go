@@ -0,0 +1,163 @@
+package wasm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func mustParseWat(t *testing.T, src string) *Module {
+	t.Helper()
+	m, err := ParseWat([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLink(t *testing.T) {
+	lib := mustParseWat(t, `(module
+		(import "env" "log" (func $log (param i64)))
+		(memory (export "memory") 1)
+		(table (export "table") 0 funcref)
+		(global $count (export "count") (mut i64) (i64.const 0))
+		(data "lib")
+		(func $bump (export "bump") (param i64) (result i64)
+			(global.set $count (i64.add (global.get $count) (local.get 0)))
+			(call $log (global.get $count))
+			(global.get $count))
+		(func $init
+			(memory.init 0 (i32.const 0) (i32.const 0) (i32.const 3)))
+		(start $init))`)
+
+	unit := mustParseWat(t, `(module
+		(import "env" "log" (func $log (param i64)))
+		(import "lib" "bump" (func $bump (param i64) (result i64)))
+		(import "lib" "memory" (memory 1))
+		(import "lib" "table" (table 0 funcref))
+		(import "lib" "count" (global $count (mut i64)))
+		(data "unit")
+		(elem func $twice)
+		(func $twice (param i64) (result i64)
+			(call $bump (call $bump (local.get 0))))
+		(func (export "main") (result i64)
+			(call $log (i64.const -1))
+			(drop (table.grow (ref.null func) (i32.const 1)))
+			(table.init 0 (i32.const 0) (i32.const 0) (i32.const 1))
+			(call_indirect (param i64) (result i64) (i64.const 5) (i32.const 0))
+			(global.get $count)
+			i64.add)
+		(func $init
+			(memory.init 0 (i32.const 3) (i32.const 0) (i32.const 4)))
+		(start $init))`)
+
+	m, err := Link(LinkModule{Name: "unit", Module: unit}, LinkModule{Name: "lib", Module: lib})
+	assert.Nil(t, err)
+	assert.Nil(t, m.Validate())
+
+	// both modules import env.log, so the result only needs it once
+	assert.Equal(t, len(m.Imports), 1)
+
+	var logged []int64
+	log := HostFunction(FuncType{In: []Type{Int64}}, func(args []uint64) ([]uint64, error) {
+		logged = append(logged, int64(args[0]))
+		return nil, nil
+	})
+	inst, err := Instantiate(m, Imports{"env": {"log": log}})
+	assert.Nil(t, err)
+
+	_, ok := inst.Export("bump")
+	assert.False(t, ok)
+
+	main, err := inst.Func("main")
+	assert.Nil(t, err)
+	res, err := main.Call()
+	assert.Nil(t, err)
+
+	// bumping by 5 twice leaves the count at 10, which is added to the result
+	assert.Equal(t, res, []uint64{20})
+	assert.Equal(t, logged, []int64{-1, 5, 10})
+
+	// both start functions ran, each with its own data segment
+	mem := inst.memories[0]
+	assert.Equal(t, string(mem.Bytes[:7]), "libunit")
+}
+
+func TestLinkNames(t *testing.T) {
+	a := mustParseWat(t, `(module
+		(import "b" "f" (func $f))
+		(func $g (call $f)))`)
+	a.SetNames(&Names{Module: "a", Funcs: map[Index]string{0: "f", 1: "g"}})
+
+	b := mustParseWat(t, `(module (func $f (export "f")))`)
+	b.SetNames(&Names{Module: "b", Funcs: map[Index]string{0: "f"}})
+
+	m, err := Link(LinkModule{Name: "a", Module: a}, LinkModule{Name: "b", Module: b})
+	assert.Nil(t, err)
+
+	names, err := m.Names()
+	assert.Nil(t, err)
+	assert.Equal(t, names.Module, "a")
+	assert.Equal(t, names.Funcs, map[Index]string{0: "g", 1: "f"})
+}
+
+func TestLinkErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		mods []string
+		err  error
+	}{
+		{
+			name: "UnknownExport",
+			mods: []string{
+				`(module (import "b" "f" (func)))`,
+				`(module)`,
+			},
+			err: ErrUnknownExport,
+		},
+		{
+			name: "WrongKind",
+			mods: []string{
+				`(module (import "b" "f" (func)))`,
+				`(module (global (export "f") i32 (i32.const 0)))`,
+			},
+			err: ErrImportMismatch,
+		},
+		{
+			name: "WrongType",
+			mods: []string{
+				`(module (import "b" "f" (func (param i32))))`,
+				`(module (func (export "f")))`,
+			},
+			err: ErrImportMismatch,
+		},
+		{
+			name: "Cycle",
+			mods: []string{
+				`(module (import "b" "f" (func)) (export "f" (func 0)))`,
+				`(module (import "a" "f" (func)) (export "f" (func 0)))`,
+			},
+			err: ErrImportCycle,
+		},
+		{
+			name: "TwoMemories",
+			mods: []string{
+				`(module (memory 1))`,
+				`(module (memory 1))`,
+			},
+			err: ErrInvalid,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var mods []LinkModule
+			for i, src := range test.mods {
+				mods = append(mods, LinkModule{Name: string(rune('a' + i)), Module: mustParseWat(t, src)})
+			}
+			_, err := Link(mods...)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package wasm
+
+// AddLocal declares a local of type t and returns its index, which follows the function's
+// parameters and any locals already declared.
+func (c *Code) AddLocal(t Type) uint32 {
+	idx := c.params
+	for _, l := range c.Locals {
+		idx += l.Count
+	}
+	if n := len(c.Locals); n != 0 && c.Locals[n-1].Type.Matches(t) {
+		c.Locals[n-1].Count++
+	} else {
+		c.Locals = append(c.Locals, LocalDecl{Count: 1, Type: t})
+	}
+	return idx
+}
+
+// AllocLocal returns a temporary local of type t, reusing one released by FreeLocal if possible.
+func (c *Code) AllocLocal(t Type) uint32 {
+	for i, idx := range c.free {
+		if c.localType(idx).Matches(t) {
+			c.free = append(c.free[:i], c.free[i+1:]...)
+			return idx
+		}
+	}
+	return c.AddLocal(t)
+}
+
+// FreeLocal releases a temporary local so that a later call to AllocLocal can reuse it.
+func (c *Code) FreeLocal(idx uint32) {
+	c.free = append(c.free, idx)
+}
+
+func (c *Code) localType(idx uint32) Type {
+	idx -= c.params
+	for _, l := range c.Locals {
+		if idx < l.Count {
+			return l.Type
+		}
+		idx -= l.Count
+	}
+	return nil
+}
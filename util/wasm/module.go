@@ -6,15 +6,24 @@ type Module struct {
 	Funcs    []Index
 	Tables   []Table
 	Memories []Memory
+	Globals  []Global
 	Exports  []Export
+	Start    *Index
 	Codes    []*Code
 	Elements []Element
+	Data     []Data
+	Customs  []CustomSection
 }
 
 type Code struct {
 	Func         Index
 	Locals       []LocalDecl
 	Instructions []byte
+
+	params uint32
+	blocks []*block
+	free   []uint32
+	err    error
 }
 
 type LocalDecl struct {
@@ -29,12 +38,39 @@ func (m *Module) AppendWasm(mod []byte) []byte {
 	mod = appendSection(mod, 3, m.Funcs)
 	mod = appendSection(mod, 4, m.Tables)
 	mod = appendSection(mod, 5, m.Memories)
+	mod = appendSection(mod, 6, m.Globals)
 	mod = appendSection(mod, 7, m.Exports)
+	mod = m.appendStart(mod)
 	mod = appendSection(mod, 9, m.Elements)
+	mod = m.appendDataCount(mod)
 	mod = appendSection(mod, 10, m.Codes)
+	mod = appendSection(mod, 11, m.Data)
+	for _, s := range m.Customs {
+		mod = s.AppendWasm(mod)
+	}
 	return mod
 }
 
+func (m *Module) appendStart(buf []byte) []byte {
+	if m.Start == nil {
+		return buf
+	}
+	buf = append(buf, 8)
+	buf = appendBytes(buf, m.Start.AppendWasm(nil))
+	return buf
+}
+
+// Engines need to know how many data segments there are before they see any code that refers to
+// them, which only passive segments can be.
+func (m *Module) appendDataCount(buf []byte) []byte {
+	if !hasPassiveData(m.Data) {
+		return buf
+	}
+	buf = append(buf, 12)
+	buf = appendBytes(buf, appendUint32(nil, uint32(len(m.Data))))
+	return buf
+}
+
 func (m *Module) EnsureType(t Type) Index {
 	typeID := -1
 	for i, u := range m.Types {
@@ -51,10 +87,11 @@ func (m *Module) EnsureType(t Type) Index {
 	return Index(typeID)
 }
 
+// AddFunc defines a function and returns its body. Its index follows any imported functions.
 func (m *Module) AddFunc(in []Type, out []Type) *Code {
 	typeID := m.EnsureType(FuncType{In: in, Out: out})
-	idx := len(m.Funcs)
-	res := &Code{Func: Index(idx)}
+	idx := importCount[FuncImport](m.Imports) + len(m.Funcs)
+	res := &Code{Func: Index(idx), params: uint32(len(in))}
 	m.Funcs = append(m.Funcs, typeID)
 	m.Codes = append(m.Codes, res)
 	return res
@@ -66,6 +103,13 @@ func (m *Module) AddExportedFunc(name string, in []Type, out []Type) *Code {
 	return c
 }
 
+// AddGlobal defines a global and returns its index, which follows any imported globals.
+func (m *Module) AddGlobal(g Global) Index {
+	idx := importCount[GlobalImport](m.Imports) + len(m.Globals)
+	m.Globals = append(m.Globals, g)
+	return Index(idx)
+}
+
 func (m *Module) wasmHeader(buf []byte) []byte {
 	buf = append(buf, 0)
 	buf = append(buf, []byte("asm")...)
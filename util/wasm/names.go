@@ -0,0 +1,132 @@
+package wasm
+
+import "slices"
+
+// Names holds the contents of the name section, a custom section that engines and tools use to
+// describe parts of a module that are otherwise only known by index.
+type Names struct {
+	Module string
+	Funcs  map[Index]string
+	Locals map[Index]map[uint32]string
+}
+
+const nameSection = "name"
+
+// Names reads the module's name section, returning nil if it has none.
+func (m *Module) Names() (*Names, error) {
+	for _, s := range m.Customs {
+		if s.Name != nameSection {
+			continue
+		}
+		return decodeNames(s.Bytes)
+	}
+	return nil, nil
+}
+
+func decodeNames(buf []byte) (*Names, error) {
+	res := &Names{
+		Funcs:  map[Index]string{},
+		Locals: map[Index]map[uint32]string{},
+	}
+
+	r := &reader{buf: buf}
+	for len(r.buf) != 0 && r.err == nil {
+		id := r.byte()
+		sub := &reader{buf: r.bytes()}
+		switch id {
+		case 0:
+			res.Module = sub.name()
+
+		case 1:
+			res.Funcs = nameMap(sub)
+
+		case 2:
+			for n := sub.uint32(); n > 0 && sub.err == nil; n-- {
+				f := sub.index()
+				locals := map[uint32]string{}
+				for k, v := range nameMap(sub) {
+					locals[uint32(k)] = v
+				}
+				res.Locals[f] = locals
+			}
+		}
+		// other kinds of name are ignored
+		if sub.err != nil {
+			r.fail(sub.err)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return res, nil
+}
+
+func nameMap(r *reader) map[Index]string {
+	res := map[Index]string{}
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		idx := r.index()
+		res[idx] = r.name()
+	}
+	return res
+}
+
+// SetNames replaces the module's name section.
+func (m *Module) SetNames(n *Names) {
+	s := CustomSection{Name: nameSection, Bytes: n.AppendWasm(nil)}
+	for i := range m.Customs {
+		if m.Customs[i].Name == nameSection {
+			m.Customs[i] = s
+			return
+		}
+	}
+	m.Customs = append(m.Customs, s)
+}
+
+// AppendWasm encodes the contents of the name section. Empty subsections are left out.
+func (n *Names) AppendWasm(buf []byte) []byte {
+	if n.Module != "" {
+		buf = appendSubsection(buf, 0, appendString(nil, n.Module))
+	}
+	if len(n.Funcs) != 0 {
+		buf = appendSubsection(buf, 1, appendNameMap(nil, n.Funcs))
+	}
+	if len(n.Locals) != 0 {
+		funcs := sortedKeys(n.Locals)
+		sub := appendUint32(nil, uint32(len(funcs)))
+		for _, f := range funcs {
+			locals := map[Index]string{}
+			for k, v := range n.Locals[f] {
+				locals[Index(k)] = v
+			}
+			sub = f.AppendWasm(sub)
+			sub = appendNameMap(sub, locals)
+		}
+		buf = appendSubsection(buf, 2, sub)
+	}
+	return buf
+}
+
+func appendSubsection(buf []byte, id byte, sub []byte) []byte {
+	buf = append(buf, id)
+	return appendBytes(buf, sub)
+}
+
+// Name maps must be sorted by index.
+func appendNameMap(buf []byte, names map[Index]string) []byte {
+	idxs := sortedKeys(names)
+	buf = appendUint32(buf, uint32(len(idxs)))
+	for _, idx := range idxs {
+		buf = idx.AppendWasm(buf)
+		buf = appendString(buf, names[idx])
+	}
+	return buf
+}
+
+func sortedKeys[V any](m map[Index]V) []Index {
+	keys := make([]Index, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
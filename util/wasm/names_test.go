@@ -0,0 +1,47 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestNamesRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		names Names
+	}{
+		{
+			name: "Empty",
+			names: Names{
+				Funcs:  map[Index]string{},
+				Locals: map[Index]map[uint32]string{},
+			},
+		},
+		{
+			name: "Everything",
+			names: Names{
+				Module: "mod",
+				Funcs:  map[Index]string{3: "c", 0: "a", 1: "b"},
+				Locals: map[Index]map[uint32]string{
+					1: {2: "y", 0: "x"},
+					0: {5: "z"},
+				},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var m Module
+			m.Customs = []CustomSection{{Name: "other"}}
+			m.SetNames(&Names{Module: "replaced"})
+			m.SetNames(&test.names)
+			assert.Equal(t, len(m.Customs), 2)
+
+			decoded, err := Decode(m.AppendWasm(nil))
+			assert.Nil(t, err)
+			names, err := decoded.Names()
+			assert.Nil(t, err)
+			assert.Equal(t, *names, test.names)
+		})
+	}
+}
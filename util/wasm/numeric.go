@@ -0,0 +1,415 @@
+package wasm
+
+import (
+	"math"
+	"math/bits"
+)
+
+// numeric executes instructions that operate only on values on the operand stack. It reports
+// false if op is not such an instruction.
+func (m *machine) numeric(op Opcode) bool {
+	switch {
+	case op == OpI32Eqz:
+		m.pushBool(m.pop32() == 0)
+
+	case op == OpI64Eqz:
+		m.pushBool(m.pop() == 0)
+
+	case op >= OpI32Eq && op <= OpF64Ge:
+		b := m.pop()
+		a := m.pop()
+		m.pushBool(compare(op, a, b))
+
+	case op >= OpI32Clz && op <= OpI32Popcnt:
+		m.push32(unaryI32(op, m.pop32()))
+
+	case op >= OpI32Add && op <= OpI32Rotr:
+		b := m.pop32()
+		a := m.pop32()
+		m.push32(arithI32(op, a, b))
+
+	case op >= OpI64Clz && op <= OpI64Popcnt:
+		m.push(unaryI64(op, m.pop()))
+
+	case op >= OpI64Add && op <= OpI64Rotr:
+		b := m.pop()
+		a := m.pop()
+		m.push(arithI64(op, a, b))
+
+	case op >= OpF32Abs && op <= OpF32Sqrt:
+		m.push(unaryF32(op, uint32(m.pop())))
+
+	case op >= OpF32Add && op <= OpF32Copysign:
+		b := uint32(m.pop())
+		a := uint32(m.pop())
+		m.push(arithF32(op, a, b))
+
+	case op >= OpF64Abs && op <= OpF64Sqrt:
+		m.push(unaryF64(op, m.pop()))
+
+	case op >= OpF64Add && op <= OpF64Copysign:
+		b := m.pop()
+		a := m.pop()
+		m.push(arithF64(op, a, b))
+
+	case op >= OpI32WrapI64 && op <= OpF64ReinterpretI64:
+		m.push(convert(op, m.pop()))
+
+	default:
+		return false
+	}
+	return true
+}
+
+func compare(op Opcode, a, b uint64) bool {
+	switch op {
+	case OpI32Eq, OpI64Eq:
+		return a == b
+	case OpI32Ne, OpI64Ne:
+		return a != b
+	case OpI32LtS:
+		return int32(a) < int32(b)
+	case OpI32LtU:
+		return uint32(a) < uint32(b)
+	case OpI32GtS:
+		return int32(a) > int32(b)
+	case OpI32GtU:
+		return uint32(a) > uint32(b)
+	case OpI32LeS:
+		return int32(a) <= int32(b)
+	case OpI32LeU:
+		return uint32(a) <= uint32(b)
+	case OpI32GeS:
+		return int32(a) >= int32(b)
+	case OpI32GeU:
+		return uint32(a) >= uint32(b)
+	case OpI64LtS:
+		return int64(a) < int64(b)
+	case OpI64LtU:
+		return a < b
+	case OpI64GtS:
+		return int64(a) > int64(b)
+	case OpI64GtU:
+		return a > b
+	case OpI64LeS:
+		return int64(a) <= int64(b)
+	case OpI64LeU:
+		return a <= b
+	case OpI64GeS:
+		return int64(a) >= int64(b)
+	case OpI64GeU:
+		return a >= b
+	case OpF32Eq, OpF32Ne, OpF32Lt, OpF32Gt, OpF32Le, OpF32Ge:
+		return compareFloat(op-OpF32Eq, float64(f32(a)), float64(f32(b)))
+	default:
+		return compareFloat(op-OpF64Eq, f64(a), f64(b))
+	}
+}
+
+// compareFloat takes the position of the comparison within the float comparison instructions,
+// which are laid out in the same order for both widths.
+func compareFloat(rel Opcode, a, b float64) bool {
+	switch rel {
+	case 0:
+		return a == b
+	case 1:
+		return a != b
+	case 2:
+		return a < b
+	case 3:
+		return a > b
+	case 4:
+		return a <= b
+	default:
+		return a >= b
+	}
+}
+
+func unaryI32(op Opcode, a uint32) uint32 {
+	switch op {
+	case OpI32Clz:
+		return uint32(bits.LeadingZeros32(a))
+	case OpI32Ctz:
+		return uint32(bits.TrailingZeros32(a))
+	default:
+		return uint32(bits.OnesCount32(a))
+	}
+}
+
+func arithI32(op Opcode, a, b uint32) uint32 {
+	switch op {
+	case OpI32Add:
+		return a + b
+	case OpI32Sub:
+		return a - b
+	case OpI32Mul:
+		return a * b
+	case OpI32DivS:
+		checkDivisor(uint64(b))
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint32(int32(a) / int32(b))
+	case OpI32DivU:
+		checkDivisor(uint64(b))
+		return a / b
+	case OpI32RemS:
+		checkDivisor(uint64(b))
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case OpI32RemU:
+		checkDivisor(uint64(b))
+		return a % b
+	case OpI32And:
+		return a & b
+	case OpI32Or:
+		return a | b
+	case OpI32Xor:
+		return a ^ b
+	case OpI32Shl:
+		return a << (b % 32)
+	case OpI32ShrS:
+		return uint32(int32(a) >> (b % 32))
+	case OpI32ShrU:
+		return a >> (b % 32)
+	case OpI32Rotl:
+		return bits.RotateLeft32(a, int(b%32))
+	default:
+		return bits.RotateLeft32(a, -int(b%32))
+	}
+}
+
+func unaryI64(op Opcode, a uint64) uint64 {
+	switch op {
+	case OpI64Clz:
+		return uint64(bits.LeadingZeros64(a))
+	case OpI64Ctz:
+		return uint64(bits.TrailingZeros64(a))
+	default:
+		return uint64(bits.OnesCount64(a))
+	}
+}
+
+func arithI64(op Opcode, a, b uint64) uint64 {
+	switch op {
+	case OpI64Add:
+		return a + b
+	case OpI64Sub:
+		return a - b
+	case OpI64Mul:
+		return a * b
+	case OpI64DivS:
+		checkDivisor(b)
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	case OpI64DivU:
+		checkDivisor(b)
+		return a / b
+	case OpI64RemS:
+		checkDivisor(b)
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case OpI64RemU:
+		checkDivisor(b)
+		return a % b
+	case OpI64And:
+		return a & b
+	case OpI64Or:
+		return a | b
+	case OpI64Xor:
+		return a ^ b
+	case OpI64Shl:
+		return a << (b % 64)
+	case OpI64ShrS:
+		return uint64(int64(a) >> (b % 64))
+	case OpI64ShrU:
+		return a >> (b % 64)
+	case OpI64Rotl:
+		return bits.RotateLeft64(a, int(b%64))
+	default:
+		return bits.RotateLeft64(a, -int(b%64))
+	}
+}
+
+func checkDivisor(b uint64) {
+	if b == 0 {
+		trap("integer divide by zero")
+	}
+}
+
+const (
+	f32Sign = 1 << 31
+	f64Sign = 1 << 63
+)
+
+func f32(x uint64) float32 {
+	return math.Float32frombits(uint32(x))
+}
+
+func f64(x uint64) float64 {
+	return math.Float64frombits(x)
+}
+
+func f32bits(x float32) uint64 {
+	return uint64(math.Float32bits(x))
+}
+
+// Rounding a float32 operand to integral values or taking its square root as a float64 and then
+// narrowing the result gives the correctly rounded float32 result, so these share an
+// implementation with the float64 instructions.
+func unaryF32(op Opcode, a uint32) uint64 {
+	switch op {
+	case OpF32Abs:
+		return uint64(a &^ f32Sign)
+	case OpF32Neg:
+		return uint64(a ^ f32Sign)
+	}
+	x := float64(math.Float32frombits(a))
+	return f32bits(float32(unaryFloat(op-OpF32Abs, x)))
+}
+
+func unaryF64(op Opcode, a uint64) uint64 {
+	switch op {
+	case OpF64Abs:
+		return a &^ f64Sign
+	case OpF64Neg:
+		return a ^ f64Sign
+	}
+	return math.Float64bits(unaryFloat(op-OpF64Abs, f64(a)))
+}
+
+func unaryFloat(rel Opcode, x float64) float64 {
+	switch rel {
+	case 2:
+		return math.Ceil(x)
+	case 3:
+		return math.Floor(x)
+	case 4:
+		return math.Trunc(x)
+	case 5:
+		return math.RoundToEven(x)
+	default:
+		return math.Sqrt(x)
+	}
+}
+
+func arithF32(op Opcode, a, b uint32) uint64 {
+	x, y := math.Float32frombits(a), math.Float32frombits(b)
+	switch op {
+	case OpF32Add:
+		return f32bits(x + y)
+	case OpF32Sub:
+		return f32bits(x - y)
+	case OpF32Mul:
+		return f32bits(x * y)
+	case OpF32Div:
+		return f32bits(x / y)
+	case OpF32Min:
+		return f32bits(float32(math.Min(float64(x), float64(y))))
+	case OpF32Max:
+		return f32bits(float32(math.Max(float64(x), float64(y))))
+	default:
+		return uint64(a&^f32Sign | b&f32Sign)
+	}
+}
+
+func arithF64(op Opcode, a, b uint64) uint64 {
+	x, y := f64(a), f64(b)
+	switch op {
+	case OpF64Add:
+		return math.Float64bits(x + y)
+	case OpF64Sub:
+		return math.Float64bits(x - y)
+	case OpF64Mul:
+		return math.Float64bits(x * y)
+	case OpF64Div:
+		return math.Float64bits(x / y)
+	case OpF64Min:
+		return math.Float64bits(math.Min(x, y))
+	case OpF64Max:
+		return math.Float64bits(math.Max(x, y))
+	default:
+		return a&^f64Sign | b&f64Sign
+	}
+}
+
+func convert(op Opcode, a uint64) uint64 {
+	switch op {
+	case OpI32WrapI64:
+		return uint64(uint32(a))
+	case OpI32TruncF32S:
+		return uint64(uint32(int32(truncate(float64(f32(a)), math.MinInt32, 1<<31))))
+	case OpI32TruncF32U:
+		return uint64(uint32(truncate(float64(f32(a)), 0, 1<<32)))
+	case OpI32TruncF64S:
+		return uint64(uint32(int32(truncate(f64(a), math.MinInt32, 1<<31))))
+	case OpI32TruncF64U:
+		return uint64(uint32(truncate(f64(a), 0, 1<<32)))
+	case OpI64ExtendI32S:
+		return uint64(int32(a))
+	case OpI64ExtendI32U:
+		return uint64(uint32(a))
+	case OpI64TruncF32S:
+		return uint64(int64(truncate(float64(f32(a)), math.MinInt64, 1<<63)))
+	case OpI64TruncF32U:
+		return truncateU64(float64(f32(a)))
+	case OpI64TruncF64S:
+		return uint64(int64(truncate(f64(a), math.MinInt64, 1<<63)))
+	case OpI64TruncF64U:
+		return truncateU64(f64(a))
+	case OpF32ConvertI32S:
+		return f32bits(float32(int32(a)))
+	case OpF32ConvertI32U:
+		return f32bits(float32(uint32(a)))
+	case OpF32ConvertI64S:
+		return f32bits(float32(int64(a)))
+	case OpF32ConvertI64U:
+		return f32bits(float32(a))
+	case OpF32DemoteF64:
+		return f32bits(float32(f64(a)))
+	case OpF64ConvertI32S:
+		return math.Float64bits(float64(int32(a)))
+	case OpF64ConvertI32U:
+		return math.Float64bits(float64(uint32(a)))
+	case OpF64ConvertI64S:
+		return math.Float64bits(float64(int64(a)))
+	case OpF64ConvertI64U:
+		return math.Float64bits(float64(a))
+	case OpF64PromoteF32:
+		return math.Float64bits(float64(f32(a)))
+	case OpI32ReinterpretF32, OpF32ReinterpretI32:
+		return uint64(uint32(a))
+	default:
+		// i64.reinterpret_f64 and f64.reinterpret_i64 leave the bits as they are
+		return a
+	}
+}
+
+// truncate rounds x towards zero, trapping if the result does not lie in [lo, hi). Values in
+// (-1, 0) truncate to -0, which compares equal to 0, so they convert to unsigned integers.
+func truncate(x, lo, hi float64) float64 {
+	if math.IsNaN(x) {
+		trap("invalid conversion to integer")
+	}
+	t := math.Trunc(x)
+	if t < lo || t >= hi {
+		trap("integer overflow")
+	}
+	return t
+}
+
+// truncateU64 is needed because float64 cannot represent every uint64, so the result has to be
+// split at 2^63 to convert it exactly.
+func truncateU64(x float64) uint64 {
+	t := truncate(x, 0, 1<<64)
+	if t >= 1<<63 {
+		return uint64(t-(1<<63)) | 1<<63
+	}
+	return uint64(t)
+}
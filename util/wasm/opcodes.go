@@ -0,0 +1,438 @@
+package wasm
+
+// Opcode identifies an instruction. Instructions with a prefix byte hold the prefix in the high byte.
+type Opcode uint16
+
+const (
+	OpUnreachable        Opcode = 0x00
+	OpNop                Opcode = 0x01
+	OpBlock              Opcode = 0x02
+	OpLoop               Opcode = 0x03
+	OpIf                 Opcode = 0x04
+	OpElse               Opcode = 0x05
+	OpEnd                Opcode = 0x0b
+	OpBr                 Opcode = 0x0c
+	OpBrIf               Opcode = 0x0d
+	OpBrTable            Opcode = 0x0e
+	OpReturn             Opcode = 0x0f
+	OpCall               Opcode = 0x10
+	OpCallIndirect       Opcode = 0x11
+	OpReturnCall         Opcode = 0x12
+	OpReturnCallIndirect Opcode = 0x13
+	OpDrop               Opcode = 0x1a
+	OpSelect             Opcode = 0x1b
+	OpSelectT            Opcode = 0x1c
+	OpLocalGet           Opcode = 0x20
+	OpLocalSet           Opcode = 0x21
+	OpLocalTee           Opcode = 0x22
+	OpGlobalGet          Opcode = 0x23
+	OpGlobalSet          Opcode = 0x24
+	OpTableGet           Opcode = 0x25
+	OpTableSet           Opcode = 0x26
+	OpI32Load            Opcode = 0x28
+	OpI64Load            Opcode = 0x29
+	OpF32Load            Opcode = 0x2a
+	OpF64Load            Opcode = 0x2b
+	OpI32Load8S          Opcode = 0x2c
+	OpI32Load8U          Opcode = 0x2d
+	OpI32Load16S         Opcode = 0x2e
+	OpI32Load16U         Opcode = 0x2f
+	OpI64Load8S          Opcode = 0x30
+	OpI64Load8U          Opcode = 0x31
+	OpI64Load16S         Opcode = 0x32
+	OpI64Load16U         Opcode = 0x33
+	OpI64Load32S         Opcode = 0x34
+	OpI64Load32U         Opcode = 0x35
+	OpI32Store           Opcode = 0x36
+	OpI64Store           Opcode = 0x37
+	OpF32Store           Opcode = 0x38
+	OpF64Store           Opcode = 0x39
+	OpI32Store8          Opcode = 0x3a
+	OpI32Store16         Opcode = 0x3b
+	OpI64Store8          Opcode = 0x3c
+	OpI64Store16         Opcode = 0x3d
+	OpI64Store32         Opcode = 0x3e
+	OpMemorySize         Opcode = 0x3f
+	OpMemoryGrow         Opcode = 0x40
+	OpI32Const           Opcode = 0x41
+	OpI64Const           Opcode = 0x42
+	OpF32Const           Opcode = 0x43
+	OpF64Const           Opcode = 0x44
+	OpI32Eqz             Opcode = 0x45
+	OpI32Eq              Opcode = 0x46
+	OpI32Ne              Opcode = 0x47
+	OpI32LtS             Opcode = 0x48
+	OpI32LtU             Opcode = 0x49
+	OpI32GtS             Opcode = 0x4a
+	OpI32GtU             Opcode = 0x4b
+	OpI32LeS             Opcode = 0x4c
+	OpI32LeU             Opcode = 0x4d
+	OpI32GeS             Opcode = 0x4e
+	OpI32GeU             Opcode = 0x4f
+	OpI64Eqz             Opcode = 0x50
+	OpI64Eq              Opcode = 0x51
+	OpI64Ne              Opcode = 0x52
+	OpI64LtS             Opcode = 0x53
+	OpI64LtU             Opcode = 0x54
+	OpI64GtS             Opcode = 0x55
+	OpI64GtU             Opcode = 0x56
+	OpI64LeS             Opcode = 0x57
+	OpI64LeU             Opcode = 0x58
+	OpI64GeS             Opcode = 0x59
+	OpI64GeU             Opcode = 0x5a
+	OpF32Eq              Opcode = 0x5b
+	OpF32Ne              Opcode = 0x5c
+	OpF32Lt              Opcode = 0x5d
+	OpF32Gt              Opcode = 0x5e
+	OpF32Le              Opcode = 0x5f
+	OpF32Ge              Opcode = 0x60
+	OpF64Eq              Opcode = 0x61
+	OpF64Ne              Opcode = 0x62
+	OpF64Lt              Opcode = 0x63
+	OpF64Gt              Opcode = 0x64
+	OpF64Le              Opcode = 0x65
+	OpF64Ge              Opcode = 0x66
+	OpI32Clz             Opcode = 0x67
+	OpI32Ctz             Opcode = 0x68
+	OpI32Popcnt          Opcode = 0x69
+	OpI32Add             Opcode = 0x6a
+	OpI32Sub             Opcode = 0x6b
+	OpI32Mul             Opcode = 0x6c
+	OpI32DivS            Opcode = 0x6d
+	OpI32DivU            Opcode = 0x6e
+	OpI32RemS            Opcode = 0x6f
+	OpI32RemU            Opcode = 0x70
+	OpI32And             Opcode = 0x71
+	OpI32Or              Opcode = 0x72
+	OpI32Xor             Opcode = 0x73
+	OpI32Shl             Opcode = 0x74
+	OpI32ShrS            Opcode = 0x75
+	OpI32ShrU            Opcode = 0x76
+	OpI32Rotl            Opcode = 0x77
+	OpI32Rotr            Opcode = 0x78
+	OpI64Clz             Opcode = 0x79
+	OpI64Ctz             Opcode = 0x7a
+	OpI64Popcnt          Opcode = 0x7b
+	OpI64Add             Opcode = 0x7c
+	OpI64Sub             Opcode = 0x7d
+	OpI64Mul             Opcode = 0x7e
+	OpI64DivS            Opcode = 0x7f
+	OpI64DivU            Opcode = 0x80
+	OpI64RemS            Opcode = 0x81
+	OpI64RemU            Opcode = 0x82
+	OpI64And             Opcode = 0x83
+	OpI64Or              Opcode = 0x84
+	OpI64Xor             Opcode = 0x85
+	OpI64Shl             Opcode = 0x86
+	OpI64ShrS            Opcode = 0x87
+	OpI64ShrU            Opcode = 0x88
+	OpI64Rotl            Opcode = 0x89
+	OpI64Rotr            Opcode = 0x8a
+	OpF32Abs             Opcode = 0x8b
+	OpF32Neg             Opcode = 0x8c
+	OpF32Ceil            Opcode = 0x8d
+	OpF32Floor           Opcode = 0x8e
+	OpF32Trunc           Opcode = 0x8f
+	OpF32Nearest         Opcode = 0x90
+	OpF32Sqrt            Opcode = 0x91
+	OpF32Add             Opcode = 0x92
+	OpF32Sub             Opcode = 0x93
+	OpF32Mul             Opcode = 0x94
+	OpF32Div             Opcode = 0x95
+	OpF32Min             Opcode = 0x96
+	OpF32Max             Opcode = 0x97
+	OpF32Copysign        Opcode = 0x98
+	OpF64Abs             Opcode = 0x99
+	OpF64Neg             Opcode = 0x9a
+	OpF64Ceil            Opcode = 0x9b
+	OpF64Floor           Opcode = 0x9c
+	OpF64Trunc           Opcode = 0x9d
+	OpF64Nearest         Opcode = 0x9e
+	OpF64Sqrt            Opcode = 0x9f
+	OpF64Add             Opcode = 0xa0
+	OpF64Sub             Opcode = 0xa1
+	OpF64Mul             Opcode = 0xa2
+	OpF64Div             Opcode = 0xa3
+	OpF64Min             Opcode = 0xa4
+	OpF64Max             Opcode = 0xa5
+	OpF64Copysign        Opcode = 0xa6
+	OpI32WrapI64         Opcode = 0xa7
+	OpI32TruncF32S       Opcode = 0xa8
+	OpI32TruncF32U       Opcode = 0xa9
+	OpI32TruncF64S       Opcode = 0xaa
+	OpI32TruncF64U       Opcode = 0xab
+	OpI64ExtendI32S      Opcode = 0xac
+	OpI64ExtendI32U      Opcode = 0xad
+	OpI64TruncF32S       Opcode = 0xae
+	OpI64TruncF32U       Opcode = 0xaf
+	OpI64TruncF64S       Opcode = 0xb0
+	OpI64TruncF64U       Opcode = 0xb1
+	OpF32ConvertI32S     Opcode = 0xb2
+	OpF32ConvertI32U     Opcode = 0xb3
+	OpF32ConvertI64S     Opcode = 0xb4
+	OpF32ConvertI64U     Opcode = 0xb5
+	OpF32DemoteF64       Opcode = 0xb6
+	OpF64ConvertI32S     Opcode = 0xb7
+	OpF64ConvertI32U     Opcode = 0xb8
+	OpF64ConvertI64S     Opcode = 0xb9
+	OpF64ConvertI64U     Opcode = 0xba
+	OpF64PromoteF32      Opcode = 0xbb
+	OpI32ReinterpretF32  Opcode = 0xbc
+	OpI64ReinterpretF64  Opcode = 0xbd
+	OpF32ReinterpretI32  Opcode = 0xbe
+	OpF64ReinterpretI64  Opcode = 0xbf
+	OpI32Extend8S        Opcode = 0xc0
+	OpI32Extend16S       Opcode = 0xc1
+	OpI64Extend8S        Opcode = 0xc2
+	OpI64Extend16S       Opcode = 0xc3
+	OpI64Extend32S       Opcode = 0xc4
+	OpRefNull            Opcode = 0xd0
+	OpRefIsNull          Opcode = 0xd1
+	OpRefFunc            Opcode = 0xd2
+	OpI32TruncSatF32S    Opcode = 0xfc00
+	OpI32TruncSatF32U    Opcode = 0xfc01
+	OpI32TruncSatF64S    Opcode = 0xfc02
+	OpI32TruncSatF64U    Opcode = 0xfc03
+	OpI64TruncSatF32S    Opcode = 0xfc04
+	OpI64TruncSatF32U    Opcode = 0xfc05
+	OpI64TruncSatF64S    Opcode = 0xfc06
+	OpI64TruncSatF64U    Opcode = 0xfc07
+	OpMemoryInit         Opcode = 0xfc08
+	OpDataDrop           Opcode = 0xfc09
+	OpMemoryCopy         Opcode = 0xfc0a
+	OpMemoryFill         Opcode = 0xfc0b
+	OpTableInit          Opcode = 0xfc0c
+	OpElemDrop           Opcode = 0xfc0d
+	OpTableCopy          Opcode = 0xfc0e
+	OpTableGrow          Opcode = 0xfc0f
+	OpTableSize          Opcode = 0xfc10
+	OpTableFill          Opcode = 0xfc11
+)
+
+type immediates byte
+
+const (
+	immNone immediates = iota
+	immIndex
+	immIndex2
+	immBlock
+	immBrTable
+	immMem
+	immI32
+	immI64
+	immF32
+	immF64
+	immRefType
+	immTypes
+)
+
+type opcodeInfo struct {
+	name string
+	imm  immediates
+}
+
+var opcodes = map[Opcode]opcodeInfo{
+	OpUnreachable:        {"unreachable", immNone},
+	OpNop:                {"nop", immNone},
+	OpBlock:              {"block", immBlock},
+	OpLoop:               {"loop", immBlock},
+	OpIf:                 {"if", immBlock},
+	OpElse:               {"else", immNone},
+	OpEnd:                {"end", immNone},
+	OpBr:                 {"br", immIndex},
+	OpBrIf:               {"br_if", immIndex},
+	OpBrTable:            {"br_table", immBrTable},
+	OpReturn:             {"return", immNone},
+	OpCall:               {"call", immIndex},
+	OpCallIndirect:       {"call_indirect", immIndex2},
+	OpReturnCall:         {"return_call", immIndex},
+	OpReturnCallIndirect: {"return_call_indirect", immIndex2},
+	OpDrop:               {"drop", immNone},
+	OpSelect:             {"select", immNone},
+	OpSelectT:            {"select", immTypes},
+	OpLocalGet:           {"local.get", immIndex},
+	OpLocalSet:           {"local.set", immIndex},
+	OpLocalTee:           {"local.tee", immIndex},
+	OpGlobalGet:          {"global.get", immIndex},
+	OpGlobalSet:          {"global.set", immIndex},
+	OpTableGet:           {"table.get", immIndex},
+	OpTableSet:           {"table.set", immIndex},
+	OpI32Load:            {"i32.load", immMem},
+	OpI64Load:            {"i64.load", immMem},
+	OpF32Load:            {"f32.load", immMem},
+	OpF64Load:            {"f64.load", immMem},
+	OpI32Load8S:          {"i32.load8_s", immMem},
+	OpI32Load8U:          {"i32.load8_u", immMem},
+	OpI32Load16S:         {"i32.load16_s", immMem},
+	OpI32Load16U:         {"i32.load16_u", immMem},
+	OpI64Load8S:          {"i64.load8_s", immMem},
+	OpI64Load8U:          {"i64.load8_u", immMem},
+	OpI64Load16S:         {"i64.load16_s", immMem},
+	OpI64Load16U:         {"i64.load16_u", immMem},
+	OpI64Load32S:         {"i64.load32_s", immMem},
+	OpI64Load32U:         {"i64.load32_u", immMem},
+	OpI32Store:           {"i32.store", immMem},
+	OpI64Store:           {"i64.store", immMem},
+	OpF32Store:           {"f32.store", immMem},
+	OpF64Store:           {"f64.store", immMem},
+	OpI32Store8:          {"i32.store8", immMem},
+	OpI32Store16:         {"i32.store16", immMem},
+	OpI64Store8:          {"i64.store8", immMem},
+	OpI64Store16:         {"i64.store16", immMem},
+	OpI64Store32:         {"i64.store32", immMem},
+	OpMemorySize:         {"memory.size", immIndex},
+	OpMemoryGrow:         {"memory.grow", immIndex},
+	OpI32Const:           {"i32.const", immI32},
+	OpI64Const:           {"i64.const", immI64},
+	OpF32Const:           {"f32.const", immF32},
+	OpF64Const:           {"f64.const", immF64},
+	OpI32Eqz:             {"i32.eqz", immNone},
+	OpI32Eq:              {"i32.eq", immNone},
+	OpI32Ne:              {"i32.ne", immNone},
+	OpI32LtS:             {"i32.lt_s", immNone},
+	OpI32LtU:             {"i32.lt_u", immNone},
+	OpI32GtS:             {"i32.gt_s", immNone},
+	OpI32GtU:             {"i32.gt_u", immNone},
+	OpI32LeS:             {"i32.le_s", immNone},
+	OpI32LeU:             {"i32.le_u", immNone},
+	OpI32GeS:             {"i32.ge_s", immNone},
+	OpI32GeU:             {"i32.ge_u", immNone},
+	OpI64Eqz:             {"i64.eqz", immNone},
+	OpI64Eq:              {"i64.eq", immNone},
+	OpI64Ne:              {"i64.ne", immNone},
+	OpI64LtS:             {"i64.lt_s", immNone},
+	OpI64LtU:             {"i64.lt_u", immNone},
+	OpI64GtS:             {"i64.gt_s", immNone},
+	OpI64GtU:             {"i64.gt_u", immNone},
+	OpI64LeS:             {"i64.le_s", immNone},
+	OpI64LeU:             {"i64.le_u", immNone},
+	OpI64GeS:             {"i64.ge_s", immNone},
+	OpI64GeU:             {"i64.ge_u", immNone},
+	OpF32Eq:              {"f32.eq", immNone},
+	OpF32Ne:              {"f32.ne", immNone},
+	OpF32Lt:              {"f32.lt", immNone},
+	OpF32Gt:              {"f32.gt", immNone},
+	OpF32Le:              {"f32.le", immNone},
+	OpF32Ge:              {"f32.ge", immNone},
+	OpF64Eq:              {"f64.eq", immNone},
+	OpF64Ne:              {"f64.ne", immNone},
+	OpF64Lt:              {"f64.lt", immNone},
+	OpF64Gt:              {"f64.gt", immNone},
+	OpF64Le:              {"f64.le", immNone},
+	OpF64Ge:              {"f64.ge", immNone},
+	OpI32Clz:             {"i32.clz", immNone},
+	OpI32Ctz:             {"i32.ctz", immNone},
+	OpI32Popcnt:          {"i32.popcnt", immNone},
+	OpI32Add:             {"i32.add", immNone},
+	OpI32Sub:             {"i32.sub", immNone},
+	OpI32Mul:             {"i32.mul", immNone},
+	OpI32DivS:            {"i32.div_s", immNone},
+	OpI32DivU:            {"i32.div_u", immNone},
+	OpI32RemS:            {"i32.rem_s", immNone},
+	OpI32RemU:            {"i32.rem_u", immNone},
+	OpI32And:             {"i32.and", immNone},
+	OpI32Or:              {"i32.or", immNone},
+	OpI32Xor:             {"i32.xor", immNone},
+	OpI32Shl:             {"i32.shl", immNone},
+	OpI32ShrS:            {"i32.shr_s", immNone},
+	OpI32ShrU:            {"i32.shr_u", immNone},
+	OpI32Rotl:            {"i32.rotl", immNone},
+	OpI32Rotr:            {"i32.rotr", immNone},
+	OpI64Clz:             {"i64.clz", immNone},
+	OpI64Ctz:             {"i64.ctz", immNone},
+	OpI64Popcnt:          {"i64.popcnt", immNone},
+	OpI64Add:             {"i64.add", immNone},
+	OpI64Sub:             {"i64.sub", immNone},
+	OpI64Mul:             {"i64.mul", immNone},
+	OpI64DivS:            {"i64.div_s", immNone},
+	OpI64DivU:            {"i64.div_u", immNone},
+	OpI64RemS:            {"i64.rem_s", immNone},
+	OpI64RemU:            {"i64.rem_u", immNone},
+	OpI64And:             {"i64.and", immNone},
+	OpI64Or:              {"i64.or", immNone},
+	OpI64Xor:             {"i64.xor", immNone},
+	OpI64Shl:             {"i64.shl", immNone},
+	OpI64ShrS:            {"i64.shr_s", immNone},
+	OpI64ShrU:            {"i64.shr_u", immNone},
+	OpI64Rotl:            {"i64.rotl", immNone},
+	OpI64Rotr:            {"i64.rotr", immNone},
+	OpF32Abs:             {"f32.abs", immNone},
+	OpF32Neg:             {"f32.neg", immNone},
+	OpF32Ceil:            {"f32.ceil", immNone},
+	OpF32Floor:           {"f32.floor", immNone},
+	OpF32Trunc:           {"f32.trunc", immNone},
+	OpF32Nearest:         {"f32.nearest", immNone},
+	OpF32Sqrt:            {"f32.sqrt", immNone},
+	OpF32Add:             {"f32.add", immNone},
+	OpF32Sub:             {"f32.sub", immNone},
+	OpF32Mul:             {"f32.mul", immNone},
+	OpF32Div:             {"f32.div", immNone},
+	OpF32Min:             {"f32.min", immNone},
+	OpF32Max:             {"f32.max", immNone},
+	OpF32Copysign:        {"f32.copysign", immNone},
+	OpF64Abs:             {"f64.abs", immNone},
+	OpF64Neg:             {"f64.neg", immNone},
+	OpF64Ceil:            {"f64.ceil", immNone},
+	OpF64Floor:           {"f64.floor", immNone},
+	OpF64Trunc:           {"f64.trunc", immNone},
+	OpF64Nearest:         {"f64.nearest", immNone},
+	OpF64Sqrt:            {"f64.sqrt", immNone},
+	OpF64Add:             {"f64.add", immNone},
+	OpF64Sub:             {"f64.sub", immNone},
+	OpF64Mul:             {"f64.mul", immNone},
+	OpF64Div:             {"f64.div", immNone},
+	OpF64Min:             {"f64.min", immNone},
+	OpF64Max:             {"f64.max", immNone},
+	OpF64Copysign:        {"f64.copysign", immNone},
+	OpI32WrapI64:         {"i32.wrap_i64", immNone},
+	OpI32TruncF32S:       {"i32.trunc_f32_s", immNone},
+	OpI32TruncF32U:       {"i32.trunc_f32_u", immNone},
+	OpI32TruncF64S:       {"i32.trunc_f64_s", immNone},
+	OpI32TruncF64U:       {"i32.trunc_f64_u", immNone},
+	OpI64ExtendI32S:      {"i64.extend_i32_s", immNone},
+	OpI64ExtendI32U:      {"i64.extend_i32_u", immNone},
+	OpI64TruncF32S:       {"i64.trunc_f32_s", immNone},
+	OpI64TruncF32U:       {"i64.trunc_f32_u", immNone},
+	OpI64TruncF64S:       {"i64.trunc_f64_s", immNone},
+	OpI64TruncF64U:       {"i64.trunc_f64_u", immNone},
+	OpF32ConvertI32S:     {"f32.convert_i32_s", immNone},
+	OpF32ConvertI32U:     {"f32.convert_i32_u", immNone},
+	OpF32ConvertI64S:     {"f32.convert_i64_s", immNone},
+	OpF32ConvertI64U:     {"f32.convert_i64_u", immNone},
+	OpF32DemoteF64:       {"f32.demote_f64", immNone},
+	OpF64ConvertI32S:     {"f64.convert_i32_s", immNone},
+	OpF64ConvertI32U:     {"f64.convert_i32_u", immNone},
+	OpF64ConvertI64S:     {"f64.convert_i64_s", immNone},
+	OpF64ConvertI64U:     {"f64.convert_i64_u", immNone},
+	OpF64PromoteF32:      {"f64.promote_f32", immNone},
+	OpI32ReinterpretF32:  {"i32.reinterpret_f32", immNone},
+	OpI64ReinterpretF64:  {"i64.reinterpret_f64", immNone},
+	OpF32ReinterpretI32:  {"f32.reinterpret_i32", immNone},
+	OpF64ReinterpretI64:  {"f64.reinterpret_i64", immNone},
+	OpI32Extend8S:        {"i32.extend8_s", immNone},
+	OpI32Extend16S:       {"i32.extend16_s", immNone},
+	OpI64Extend8S:        {"i64.extend8_s", immNone},
+	OpI64Extend16S:       {"i64.extend16_s", immNone},
+	OpI64Extend32S:       {"i64.extend32_s", immNone},
+	OpRefNull:            {"ref.null", immRefType},
+	OpRefIsNull:          {"ref.is_null", immNone},
+	OpRefFunc:            {"ref.func", immIndex},
+	OpI32TruncSatF32S:    {"i32.trunc_sat_f32_s", immNone},
+	OpI32TruncSatF32U:    {"i32.trunc_sat_f32_u", immNone},
+	OpI32TruncSatF64S:    {"i32.trunc_sat_f64_s", immNone},
+	OpI32TruncSatF64U:    {"i32.trunc_sat_f64_u", immNone},
+	OpI64TruncSatF32S:    {"i64.trunc_sat_f32_s", immNone},
+	OpI64TruncSatF32U:    {"i64.trunc_sat_f32_u", immNone},
+	OpI64TruncSatF64S:    {"i64.trunc_sat_f64_s", immNone},
+	OpI64TruncSatF64U:    {"i64.trunc_sat_f64_u", immNone},
+	OpMemoryInit:         {"memory.init", immIndex2},
+	OpDataDrop:           {"data.drop", immIndex},
+	OpMemoryCopy:         {"memory.copy", immIndex2},
+	OpMemoryFill:         {"memory.fill", immIndex},
+	OpTableInit:          {"table.init", immIndex2},
+	OpElemDrop:           {"elem.drop", immIndex},
+	OpTableCopy:          {"table.copy", immIndex2},
+	OpTableGrow:          {"table.grow", immIndex},
+	OpTableSize:          {"table.size", immIndex},
+	OpTableFill:          {"table.fill", immIndex},
+}
@@ -0,0 +1,649 @@
+package wasm
+
+import (
+	"fmt"
+	"slices"
+)
+
+// The algorithm here is the one given in the validation appendix of the specification. Values
+// of unknown type appear on the stack in unreachable code, where they stand in for any type.
+
+type ctrlFrame struct {
+	op          Opcode
+	in, out     []valType
+	height      int
+	unreachable bool
+}
+
+func (f *ctrlFrame) labelTypes() []valType {
+	if f.op == OpLoop {
+		return f.in
+	}
+	return f.out
+}
+
+type typeChecker struct {
+	v      *validator
+	fn     Index
+	pc     int
+	op     Opcode
+	locals []valType
+	vals   []valType
+	ctrls  []ctrlFrame
+	err    error
+}
+
+func (v *validator) code(c *Code, t FuncType) {
+	instrs, err := c.Decode()
+	if err != nil {
+		v.fail("function %d: %s", c.Func, err)
+		return
+	}
+
+	tc := &typeChecker{v: v, fn: c.Func, locals: valTypes(t.In)}
+	for _, l := range c.Locals {
+		lt := v.valueType(l.Type)
+		for i := uint32(0); i < l.Count; i++ {
+			tc.locals = append(tc.locals, lt)
+		}
+	}
+
+	tc.pushCtrl(OpBlock, nil, valTypes(t.Out))
+	for i, x := range instrs {
+		if len(tc.ctrls) == 0 {
+			tc.pc = i
+			tc.fail("instructions after end of function")
+			break
+		}
+		tc.pc, tc.op = i, x.Op
+		tc.instruction(x)
+		if tc.err != nil {
+			break
+		}
+	}
+	if tc.err == nil && len(tc.ctrls) != 0 {
+		tc.fail("missing end")
+	}
+	if tc.err != nil && v.err == nil {
+		v.err = tc.err
+	}
+}
+
+func (tc *typeChecker) fail(format string, args ...any) {
+	if tc.err == nil {
+		tc.err = fmt.Errorf("function %d: instruction %d (%s): %s: %w", tc.fn, tc.pc, tc.op, fmt.Sprintf(format, args...), ErrInvalid)
+	}
+}
+
+func (tc *typeChecker) push(t valType) {
+	tc.vals = append(tc.vals, t)
+}
+
+func (tc *typeChecker) pushAll(ts []valType) {
+	tc.vals = append(tc.vals, ts...)
+}
+
+func (tc *typeChecker) pop() valType {
+	frame := &tc.ctrls[len(tc.ctrls)-1]
+	if len(tc.vals) == frame.height {
+		if !frame.unreachable {
+			tc.fail("operand stack underflow")
+		}
+		return typeUnknown
+	}
+	t := tc.vals[len(tc.vals)-1]
+	tc.vals = tc.vals[:len(tc.vals)-1]
+	return t
+}
+
+func (tc *typeChecker) popExpect(expected valType) valType {
+	actual := tc.pop()
+	if actual != expected && actual != typeUnknown && expected != typeUnknown {
+		tc.fail("expected %s, found %s", expected, actual)
+	}
+	if actual == typeUnknown {
+		return expected
+	}
+	return actual
+}
+
+func (tc *typeChecker) popAll(ts []valType) []valType {
+	res := make([]valType, len(ts))
+	for i := len(ts) - 1; i >= 0; i-- {
+		res[i] = tc.popExpect(ts[i])
+	}
+	return res
+}
+
+func (tc *typeChecker) popRef() valType {
+	t := tc.pop()
+	if t != typeUnknown && !t.isRef() {
+		tc.fail("expected reference, found %s", t)
+	}
+	return t
+}
+
+func (tc *typeChecker) pushCtrl(op Opcode, in, out []valType) {
+	tc.ctrls = append(tc.ctrls, ctrlFrame{op: op, in: in, out: out, height: len(tc.vals)})
+	tc.pushAll(in)
+}
+
+func (tc *typeChecker) popCtrl() ctrlFrame {
+	frame := tc.ctrls[len(tc.ctrls)-1]
+	tc.popAll(frame.out)
+	if len(tc.vals) != frame.height {
+		tc.fail("%d values left on the stack at end of block", len(tc.vals)-frame.height)
+	}
+	tc.ctrls = tc.ctrls[:len(tc.ctrls)-1]
+	return frame
+}
+
+func (tc *typeChecker) setUnreachable() {
+	frame := &tc.ctrls[len(tc.ctrls)-1]
+	tc.vals = tc.vals[:frame.height]
+	frame.unreachable = true
+}
+
+func (tc *typeChecker) label(depth uint64) *ctrlFrame {
+	if depth >= uint64(len(tc.ctrls)) {
+		tc.fail("label %d out of range", depth)
+		return &ctrlFrame{}
+	}
+	return &tc.ctrls[len(tc.ctrls)-1-int(depth)]
+}
+
+func (tc *typeChecker) blockType(bt uint64) (in, out []valType) {
+	v := int64(bt)
+	switch {
+	case v == int64(Void):
+		return nil, nil
+	case v < 0:
+		t := valType(v + 0x80)
+		if !t.isValid() {
+			tc.fail("bad block type %d", v)
+		}
+		return nil, []valType{t}
+	}
+	ft := tc.v.funcType(Index(v))
+	return valTypes(ft.In), valTypes(ft.Out)
+}
+
+func (tc *typeChecker) local(idx uint64) valType {
+	if idx >= uint64(len(tc.locals)) {
+		tc.fail("local %d out of range", idx)
+		return typeUnknown
+	}
+	return tc.locals[idx]
+}
+
+func (tc *typeChecker) global(idx uint64) globalType {
+	if idx >= uint64(len(tc.v.globals)) {
+		tc.fail("global %d out of range", idx)
+		return globalType{}
+	}
+	return tc.v.globals[idx]
+}
+
+func (tc *typeChecker) function(idx uint64) FuncType {
+	if idx >= uint64(len(tc.v.funcs)) {
+		tc.fail("function %d out of range", idx)
+		return FuncType{}
+	}
+	return tc.v.funcs[idx]
+}
+
+func (tc *typeChecker) table(idx uint64) valType {
+	if idx >= uint64(len(tc.v.tables)) {
+		tc.fail("table %d out of range", idx)
+		return typeUnknown
+	}
+	return tc.v.tables[idx]
+}
+
+func (tc *typeChecker) element(idx uint64) valType {
+	if idx >= uint64(len(tc.v.elements)) {
+		tc.fail("element segment %d out of range", idx)
+		return typeUnknown
+	}
+	return tc.v.elements[idx]
+}
+
+func (tc *typeChecker) memory(idx uint64) {
+	if idx >= uint64(tc.v.memories) {
+		tc.fail("memory %d out of range", idx)
+	}
+}
+
+// Engines must know how many data segments there are before they see the code section in order to
+// check instructions that refer to them. The encoder only provides this count when there are
+// passive segments.
+func (tc *typeChecker) data(idx uint64) {
+	if idx >= uint64(len(tc.v.m.Data)) {
+		tc.fail("data segment %d out of range", idx)
+	} else if !hasPassiveData(tc.v.m.Data) {
+		tc.fail("data count required")
+	}
+}
+
+func (tc *typeChecker) call(t FuncType) {
+	tc.popAll(valTypes(t.In))
+	tc.pushAll(valTypes(t.Out))
+}
+
+// tailCall checks a call that returns the callee's results from the current function.
+func (tc *typeChecker) tailCall(t FuncType) {
+	if !slices.Equal(valTypes(t.Out), tc.ctrls[0].out) {
+		tc.fail("tail call results do not match the function's")
+	}
+	tc.popAll(valTypes(t.In))
+	tc.setUnreachable()
+}
+
+func (tc *typeChecker) instruction(x Instruction) {
+	switch x.Op {
+
+	// control
+
+	case OpUnreachable:
+		tc.setUnreachable()
+
+	case OpNop:
+
+	case OpBlock, OpLoop:
+		in, out := tc.blockType(x.Args[0])
+		tc.popAll(in)
+		tc.pushCtrl(x.Op, in, out)
+
+	case OpIf:
+		in, out := tc.blockType(x.Args[0])
+		tc.popExpect(typeI32)
+		tc.popAll(in)
+		tc.pushCtrl(x.Op, in, out)
+
+	case OpElse:
+		frame := tc.popCtrl()
+		if frame.op != OpIf {
+			tc.fail("else outside of if")
+		}
+		tc.pushCtrl(OpElse, frame.in, frame.out)
+
+	case OpEnd:
+		frame := tc.popCtrl()
+		if frame.op == OpIf && !sameTypes(frame.in, frame.out) {
+			tc.fail("if without else must leave its parameters")
+		}
+		tc.pushAll(frame.out)
+
+	case OpBr:
+		tc.popAll(tc.label(x.Args[0]).labelTypes())
+		tc.setUnreachable()
+
+	case OpBrIf:
+		tc.popExpect(typeI32)
+		ts := tc.label(x.Args[0]).labelTypes()
+		tc.pushAll(tc.popAll(ts))
+
+	case OpBrTable:
+		tc.popExpect(typeI32)
+		def := tc.label(x.Args[len(x.Args)-1]).labelTypes()
+		for _, l := range x.Args[:len(x.Args)-1] {
+			ts := tc.label(l).labelTypes()
+			if len(ts) != len(def) {
+				tc.fail("branch targets have different arities")
+				return
+			}
+			tc.pushAll(tc.popAll(ts))
+		}
+		tc.popAll(def)
+		tc.setUnreachable()
+
+	case OpReturn:
+		tc.popAll(tc.ctrls[0].out)
+		tc.setUnreachable()
+
+	case OpCall:
+		tc.call(tc.function(x.Args[0]))
+
+	case OpCallIndirect:
+		if tc.table(x.Args[1]) != typeFuncRef {
+			tc.fail("table %d does not hold functions", x.Args[1])
+		}
+		tc.popExpect(typeI32)
+		tc.call(tc.v.funcType(Index(x.Args[0])))
+
+	case OpReturnCall:
+		tc.tailCall(tc.function(x.Args[0]))
+
+	case OpReturnCallIndirect:
+		if tc.table(x.Args[1]) != typeFuncRef {
+			tc.fail("table %d does not hold functions", x.Args[1])
+		}
+		tc.popExpect(typeI32)
+		tc.tailCall(tc.v.funcType(Index(x.Args[0])))
+
+	// parametric
+
+	case OpDrop:
+		tc.pop()
+
+	case OpSelect:
+		tc.popExpect(typeI32)
+		t1 := tc.pop()
+		t2 := tc.pop()
+		if t1.isRef() || t2.isRef() {
+			tc.fail("untyped select of references")
+		}
+		if t1 != t2 && t1 != typeUnknown && t2 != typeUnknown {
+			tc.fail("select of %s and %s", t1, t2)
+		}
+		if t1 == typeUnknown {
+			t1 = t2
+		}
+		tc.push(t1)
+
+	case OpSelectT:
+		if len(x.Args) != 1 {
+			tc.fail("select must have one result type")
+			return
+		}
+		t := valType(x.Args[0])
+		tc.popExpect(typeI32)
+		tc.popExpect(t)
+		tc.popExpect(t)
+		tc.push(t)
+
+	// variables
+
+	case OpLocalGet:
+		tc.push(tc.local(x.Args[0]))
+
+	case OpLocalSet:
+		tc.popExpect(tc.local(x.Args[0]))
+
+	case OpLocalTee:
+		t := tc.local(x.Args[0])
+		tc.popExpect(t)
+		tc.push(t)
+
+	case OpGlobalGet:
+		tc.push(tc.global(x.Args[0]).typ)
+
+	case OpGlobalSet:
+		g := tc.global(x.Args[0])
+		if !g.mutable {
+			tc.fail("global %d is immutable", x.Args[0])
+		}
+		tc.popExpect(g.typ)
+
+	// references and tables
+
+	case OpRefNull:
+		t := valType(x.Args[0])
+		if !t.isRef() {
+			tc.fail("bad reference type %#x", byte(t))
+		}
+		tc.push(t)
+
+	case OpRefIsNull:
+		tc.popRef()
+		tc.push(typeI32)
+
+	case OpRefFunc:
+		tc.function(x.Args[0])
+		if !tc.v.refs[Index(x.Args[0])] {
+			tc.fail("function %d is not declared for reference", x.Args[0])
+		}
+		tc.push(typeFuncRef)
+
+	case OpTableGet:
+		t := tc.table(x.Args[0])
+		tc.popExpect(typeI32)
+		tc.push(t)
+
+	case OpTableSet:
+		t := tc.table(x.Args[0])
+		tc.popExpect(t)
+		tc.popExpect(typeI32)
+
+	case OpTableSize:
+		tc.table(x.Args[0])
+		tc.push(typeI32)
+
+	case OpTableGrow:
+		t := tc.table(x.Args[0])
+		tc.popExpect(typeI32)
+		tc.popExpect(t)
+		tc.push(typeI32)
+
+	case OpTableFill:
+		t := tc.table(x.Args[0])
+		tc.popExpect(typeI32)
+		tc.popExpect(t)
+		tc.popExpect(typeI32)
+
+	case OpTableInit:
+		if tc.element(x.Args[0]) != tc.table(x.Args[1]) {
+			tc.fail("element segment %d does not match table %d", x.Args[0], x.Args[1])
+		}
+		tc.popAll([]valType{typeI32, typeI32, typeI32})
+
+	case OpElemDrop:
+		tc.element(x.Args[0])
+
+	case OpTableCopy:
+		if tc.table(x.Args[0]) != tc.table(x.Args[1]) {
+			tc.fail("tables %d and %d hold different types", x.Args[0], x.Args[1])
+		}
+		tc.popAll([]valType{typeI32, typeI32, typeI32})
+
+	// memory
+
+	case OpMemorySize:
+		tc.memory(x.Args[0])
+		tc.push(typeI32)
+
+	case OpMemoryGrow:
+		tc.memory(x.Args[0])
+		tc.popExpect(typeI32)
+		tc.push(typeI32)
+
+	case OpMemoryInit:
+		tc.data(x.Args[0])
+		tc.memory(x.Args[1])
+		tc.popAll([]valType{typeI32, typeI32, typeI32})
+
+	case OpDataDrop:
+		tc.data(x.Args[0])
+
+	case OpMemoryCopy:
+		tc.memory(x.Args[0])
+		tc.memory(x.Args[1])
+		tc.popAll([]valType{typeI32, typeI32, typeI32})
+
+	case OpMemoryFill:
+		tc.memory(x.Args[0])
+		tc.popAll([]valType{typeI32, typeI32, typeI32})
+
+	// constants
+
+	case OpI32Const:
+		tc.push(typeI32)
+	case OpI64Const:
+		tc.push(typeI64)
+	case OpF32Const:
+		tc.push(typeF32)
+	case OpF64Const:
+		tc.push(typeF64)
+
+	default:
+		if acc, ok := memoryAccess(x.Op); ok {
+			tc.memoryAccess(x, acc)
+			return
+		}
+		in, out, ok := numericType(x.Op)
+		if !ok {
+			tc.fail("unknown instruction")
+			return
+		}
+		tc.popAll(in)
+		tc.push(out)
+	}
+}
+
+func sameTypes(a, b []valType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type access struct {
+	typ   valType
+	size  uint64
+	store bool
+}
+
+func (tc *typeChecker) memoryAccess(x Instruction, acc access) {
+	tc.memory(0)
+	if 1<<x.Args[0] > acc.size {
+		tc.fail("alignment 2**%d is larger than the access", x.Args[0])
+	}
+	if acc.store {
+		tc.popExpect(acc.typ)
+		tc.popExpect(typeI32)
+		return
+	}
+	tc.popExpect(typeI32)
+	tc.push(acc.typ)
+}
+
+func memoryAccess(op Opcode) (access, bool) {
+	switch op {
+	case OpI32Load:
+		return access{typeI32, 4, false}, true
+	case OpI64Load:
+		return access{typeI64, 8, false}, true
+	case OpF32Load:
+		return access{typeF32, 4, false}, true
+	case OpF64Load:
+		return access{typeF64, 8, false}, true
+	case OpI32Load8S, OpI32Load8U:
+		return access{typeI32, 1, false}, true
+	case OpI32Load16S, OpI32Load16U:
+		return access{typeI32, 2, false}, true
+	case OpI64Load8S, OpI64Load8U:
+		return access{typeI64, 1, false}, true
+	case OpI64Load16S, OpI64Load16U:
+		return access{typeI64, 2, false}, true
+	case OpI64Load32S, OpI64Load32U:
+		return access{typeI64, 4, false}, true
+	case OpI32Store:
+		return access{typeI32, 4, true}, true
+	case OpI64Store:
+		return access{typeI64, 8, true}, true
+	case OpF32Store:
+		return access{typeF32, 4, true}, true
+	case OpF64Store:
+		return access{typeF64, 8, true}, true
+	case OpI32Store8:
+		return access{typeI32, 1, true}, true
+	case OpI32Store16:
+		return access{typeI32, 2, true}, true
+	case OpI64Store8:
+		return access{typeI64, 1, true}, true
+	case OpI64Store16:
+		return access{typeI64, 2, true}, true
+	case OpI64Store32:
+		return access{typeI64, 4, true}, true
+	}
+	return access{}, false
+}
+
+// numericType gives the operand and result types of instructions that only operate on values on
+// the stack.
+func numericType(op Opcode) (in []valType, out valType, ok bool) {
+	unary := func(a, r valType) ([]valType, valType, bool) { return []valType{a}, r, true }
+	binary := func(a, r valType) ([]valType, valType, bool) { return []valType{a, a}, r, true }
+
+	switch {
+	case op == OpI32Eqz:
+		return unary(typeI32, typeI32)
+	case op >= OpI32Eq && op <= OpI32GeU:
+		return binary(typeI32, typeI32)
+	case op == OpI64Eqz:
+		return unary(typeI64, typeI32)
+	case op >= OpI64Eq && op <= OpI64GeU:
+		return binary(typeI64, typeI32)
+	case op >= OpF32Eq && op <= OpF32Ge:
+		return binary(typeF32, typeI32)
+	case op >= OpF64Eq && op <= OpF64Ge:
+		return binary(typeF64, typeI32)
+	case op >= OpI32Clz && op <= OpI32Popcnt:
+		return unary(typeI32, typeI32)
+	case op >= OpI32Add && op <= OpI32Rotr:
+		return binary(typeI32, typeI32)
+	case op >= OpI64Clz && op <= OpI64Popcnt:
+		return unary(typeI64, typeI64)
+	case op >= OpI64Add && op <= OpI64Rotr:
+		return binary(typeI64, typeI64)
+	case op >= OpF32Abs && op <= OpF32Sqrt:
+		return unary(typeF32, typeF32)
+	case op >= OpF32Add && op <= OpF32Copysign:
+		return binary(typeF32, typeF32)
+	case op >= OpF64Abs && op <= OpF64Sqrt:
+		return unary(typeF64, typeF64)
+	case op >= OpF64Add && op <= OpF64Copysign:
+		return binary(typeF64, typeF64)
+	case op == OpI32Extend8S || op == OpI32Extend16S:
+		return unary(typeI32, typeI32)
+	case op >= OpI64Extend8S && op <= OpI64Extend32S:
+		return unary(typeI64, typeI64)
+	}
+
+	if c, ok := conversions[op]; ok {
+		return unary(c[0], c[1])
+	}
+	return nil, typeUnknown, false
+}
+
+// conversions holds the operand and result type of each conversion instruction.
+var conversions = map[Opcode][2]valType{
+	OpI32WrapI64:        {typeI64, typeI32},
+	OpI32TruncF32S:      {typeF32, typeI32},
+	OpI32TruncF32U:      {typeF32, typeI32},
+	OpI32TruncF64S:      {typeF64, typeI32},
+	OpI32TruncF64U:      {typeF64, typeI32},
+	OpI64ExtendI32S:     {typeI32, typeI64},
+	OpI64ExtendI32U:     {typeI32, typeI64},
+	OpI64TruncF32S:      {typeF32, typeI64},
+	OpI64TruncF32U:      {typeF32, typeI64},
+	OpI64TruncF64S:      {typeF64, typeI64},
+	OpI64TruncF64U:      {typeF64, typeI64},
+	OpF32ConvertI32S:    {typeI32, typeF32},
+	OpF32ConvertI32U:    {typeI32, typeF32},
+	OpF32ConvertI64S:    {typeI64, typeF32},
+	OpF32ConvertI64U:    {typeI64, typeF32},
+	OpF32DemoteF64:      {typeF64, typeF32},
+	OpF64ConvertI32S:    {typeI32, typeF64},
+	OpF64ConvertI32U:    {typeI32, typeF64},
+	OpF64ConvertI64S:    {typeI64, typeF64},
+	OpF64ConvertI64U:    {typeI64, typeF64},
+	OpF64PromoteF32:     {typeF32, typeF64},
+	OpI32ReinterpretF32: {typeF32, typeI32},
+	OpI64ReinterpretF64: {typeF64, typeI64},
+	OpF32ReinterpretI32: {typeI32, typeF32},
+	OpF64ReinterpretI64: {typeI64, typeF64},
+	OpI32TruncSatF32S:   {typeF32, typeI32},
+	OpI32TruncSatF32U:   {typeF32, typeI32},
+	OpI32TruncSatF64S:   {typeF64, typeI32},
+	OpI32TruncSatF64U:   {typeF64, typeI32},
+	OpI64TruncSatF32S:   {typeF32, typeI64},
+	OpI64TruncSatF32U:   {typeF32, typeI64},
+	OpI64TruncSatF64S:   {typeF64, typeI64},
+	OpI64TruncSatF64U:   {typeF64, typeI64},
+}
@@ -0,0 +1,375 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalid = errors.New("invalid module")
+
+// valType is the type of a value on the operand stack. Numbers use the same encoding as
+// NumberType and references use the same encoding as Table.
+type valType byte
+
+const (
+	typeUnknown valType = 0
+
+	typeI32 = valType(Int32)
+	typeI64 = valType(Int64)
+	typeF32 = valType(Float32)
+	typeF64 = valType(Float64)
+
+	typeFuncRef   = valType(FuncTable)
+	typeExternRef = valType(ExternTable)
+)
+
+func (t valType) String() string {
+	switch t {
+	case typeI32:
+		return "i32"
+	case typeI64:
+		return "i64"
+	case typeF32:
+		return "f32"
+	case typeF64:
+		return "f64"
+	case typeFuncRef:
+		return "funcref"
+	case typeExternRef:
+		return "externref"
+	case typeUnknown:
+		return "any"
+	}
+	return fmt.Sprintf("<type %#x>", byte(t))
+}
+
+func (t valType) isRef() bool {
+	return t == typeFuncRef || t == typeExternRef
+}
+
+func (t valType) isValid() bool {
+	switch t {
+	case typeI32, typeI64, typeF32, typeF64, typeFuncRef, typeExternRef:
+		return true
+	}
+	return false
+}
+
+func valTypes(ts []Type) []valType {
+	res := make([]valType, len(ts))
+	for i, t := range ts {
+		n, _ := t.(NumberType)
+		res[i] = valType(n)
+	}
+	return res
+}
+
+type globalType struct {
+	typ     valType
+	mutable bool
+}
+
+// Validate checks that the module is well-formed according to the WebAssembly specification, so
+// that any engine will accept it. This covers the indices used throughout the module and the
+// typing of each function body.
+func (m *Module) Validate() error {
+	v := &validator{m: m, refs: map[Index]bool{}}
+	v.validate()
+	return v.err
+}
+
+// validator holds the index spaces of the module being validated. Imports come before the
+// definitions in each space.
+type validator struct {
+	m   *Module
+	err error
+
+	types    []FuncType
+	funcs    []FuncType
+	tables   []valType
+	memories int
+	globals  []globalType
+	elements []valType
+
+	// functions that can be referenced by ref.func
+	refs map[Index]bool
+
+	imported struct {
+		funcs, globals int
+	}
+}
+
+func (v *validator) fail(format string, args ...any) {
+	if v.err == nil {
+		v.err = fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), ErrInvalid)
+	}
+}
+
+func (v *validator) validate() {
+	v.validateTypes()
+	v.validateImports()
+	v.validateFuncs()
+	for _, t := range v.m.Tables {
+		v.tables = append(v.tables, v.table(t))
+	}
+	for _, mem := range v.m.Memories {
+		v.memory(mem)
+		v.memories++
+	}
+	if v.memories > 1 {
+		v.fail("%d memories defined", v.memories)
+	}
+	v.collectRefs()
+	v.validateGlobals()
+	v.validateExports()
+	v.validateStart()
+	v.validateElements()
+	v.validateData()
+	v.validateCodes()
+}
+
+func (v *validator) validateTypes() {
+	for i, t := range v.m.Types {
+		ft, ok := t.(FuncType)
+		if !ok {
+			v.fail("type %d: not a function type", i)
+			return
+		}
+		for _, t := range ft.In {
+			v.valueType(t)
+		}
+		for _, t := range ft.Out {
+			v.valueType(t)
+		}
+		v.types = append(v.types, ft)
+	}
+}
+
+func (v *validator) funcType(idx Index) FuncType {
+	if int(idx) >= len(v.types) {
+		v.fail("type %d out of range", idx)
+		return FuncType{}
+	}
+	return v.types[idx]
+}
+
+func (v *validator) table(t Table) valType {
+	r := valType(t)
+	if !r.isRef() {
+		v.fail("bad table type %#x", byte(t))
+	}
+	return r
+}
+
+func (v *validator) memory(m Memory) {
+	if mm, ok := m.(MinMemory); ok && mm.Min > 1<<16 {
+		v.fail("memory of %d pages is too large", mm.Min)
+	}
+}
+
+func (v *validator) valueType(t Type) valType {
+	n, ok := t.(NumberType)
+	if !ok || !valType(n).isValid() {
+		v.fail("bad value type")
+		return typeUnknown
+	}
+	return valType(n)
+}
+
+func (v *validator) validateImports() {
+	for _, imp := range v.m.Imports {
+		switch imp := imp.(type) {
+		case FuncImport:
+			v.funcs = append(v.funcs, v.funcType(imp.Type))
+			v.imported.funcs++
+		case TableImport:
+			v.tables = append(v.tables, typeFuncRef)
+		case MemoryImport:
+			v.memory(imp.Type)
+			v.memories++
+		case GlobalImport:
+			v.globals = append(v.globals, globalType{v.valueType(imp.Type), imp.Mutable})
+			v.imported.globals++
+		}
+	}
+}
+
+func (v *validator) validateFuncs() {
+	if len(v.m.Funcs) != len(v.m.Codes) {
+		v.fail("%d functions declared but %d bodies defined", len(v.m.Funcs), len(v.m.Codes))
+	}
+	for _, t := range v.m.Funcs {
+		v.funcs = append(v.funcs, v.funcType(t))
+	}
+}
+
+func (v *validator) function(idx Index) FuncType {
+	if int(idx) >= len(v.funcs) {
+		v.fail("function %d out of range", idx)
+		return FuncType{}
+	}
+	return v.funcs[idx]
+}
+
+func (v *validator) global(idx Index) globalType {
+	if int(idx) >= len(v.globals) {
+		v.fail("global %d out of range", idx)
+		return globalType{}
+	}
+	return v.globals[idx]
+}
+
+// collectRefs finds the functions that are referred to outside of function bodies, which are the
+// only ones that ref.func may refer to.
+func (v *validator) collectRefs() {
+	for _, e := range v.m.Exports {
+		if e, ok := e.(FuncExport); ok {
+			v.refs[e.Func] = true
+		}
+	}
+	for _, e := range v.m.Elements {
+		if e, ok := e.(*FuncElement); ok {
+			for _, f := range e.Funcs {
+				v.refs[f] = true
+			}
+		}
+	}
+	for _, g := range v.m.Globals {
+		instrs, _ := g.Init.Decode()
+		for _, x := range instrs {
+			if x.Op == OpRefFunc {
+				v.refs[Index(x.Args[0])] = true
+			}
+		}
+	}
+}
+
+func (v *validator) validateGlobals() {
+	for i, g := range v.m.Globals {
+		t := v.valueType(g.Type)
+		v.constExpr(fmt.Sprintf("global %d", v.imported.globals+i), g.Init.Instructions, t)
+		v.globals = append(v.globals, globalType{t, g.Mutable})
+	}
+}
+
+// constExpr checks an initializer expression. These may only refer to imported globals.
+func (v *validator) constExpr(context string, expr []byte, t valType) {
+	instrs, err := DecodeInstructions(expr)
+	if err != nil {
+		v.fail("%s: %s", context, err)
+		return
+	}
+	var stack []valType
+	for i, x := range instrs {
+		switch x.Op {
+		case OpI32Const:
+			stack = append(stack, typeI32)
+		case OpI64Const:
+			stack = append(stack, typeI64)
+		case OpF32Const:
+			stack = append(stack, typeF32)
+		case OpF64Const:
+			stack = append(stack, typeF64)
+		case OpRefNull:
+			stack = append(stack, valType(x.Args[0]))
+		case OpRefFunc:
+			v.function(Index(x.Args[0]))
+			stack = append(stack, typeFuncRef)
+		case OpGlobalGet:
+			g := v.global(Index(x.Args[0]))
+			if int(x.Args[0]) >= v.imported.globals || g.mutable {
+				v.fail("%s: initializer refers to global %d", context, x.Args[0])
+			}
+			stack = append(stack, g.typ)
+		case OpEnd:
+			if i != len(instrs)-1 {
+				v.fail("%s: instructions after end of initializer", context)
+			}
+		default:
+			v.fail("%s: %s is not constant", context, x.Op)
+		}
+	}
+	if len(instrs) == 0 || instrs[len(instrs)-1].Op != OpEnd {
+		v.fail("%s: initializer has no end", context)
+	}
+	if len(stack) != 1 || stack[0] != t {
+		v.fail("%s: initializer has type %v, expected %v", context, stack, t)
+	}
+}
+
+func (v *validator) validateExports() {
+	names := map[string]bool{}
+	for _, e := range v.m.Exports {
+		var name string
+		switch e := e.(type) {
+		case FuncExport:
+			name = e.Name
+			v.function(e.Func)
+		case TableExport:
+			name = e.Name
+			if int(e.Table) >= len(v.tables) {
+				v.fail("export %q: table %d out of range", e.Name, e.Table)
+			}
+		case MemoryExport:
+			name = e.Name
+			if int(e.Mem) >= v.memories {
+				v.fail("export %q: memory %d out of range", e.Name, e.Mem)
+			}
+		case GlobalExport:
+			name = e.Name
+			v.global(e.Global)
+		}
+		if names[name] {
+			v.fail("duplicate export %q", name)
+		}
+		names[name] = true
+	}
+}
+
+func (v *validator) validateStart() {
+	if v.m.Start == nil {
+		return
+	}
+	t := v.function(*v.m.Start)
+	if len(t.In) != 0 || len(t.Out) != 0 {
+		v.fail("start function %d takes or returns values", *v.m.Start)
+	}
+}
+
+func (v *validator) validateElements() {
+	for _, e := range v.m.Elements {
+		switch e := e.(type) {
+		case *FuncElement:
+			for _, f := range e.Funcs {
+				v.function(f)
+			}
+		}
+		v.elements = append(v.elements, typeFuncRef)
+	}
+}
+
+func (v *validator) validateData() {
+	for i, d := range v.m.Data {
+		if d, ok := d.(ActiveData); ok && int(d.Memory) >= v.memories {
+			v.fail("data %d: memory %d out of range", i, d.Memory)
+		}
+	}
+}
+
+func (v *validator) validateCodes() {
+	for i, c := range v.m.Codes {
+		if v.err != nil {
+			return
+		}
+		idx := Index(v.imported.funcs + i)
+		if c.Func != idx {
+			v.fail("function %d: body is numbered %d", idx, c.Func)
+			return
+		}
+		if c.err != nil {
+			v.fail("function %d: %s", idx, c.err)
+			return
+		}
+		v.code(c, v.funcs[idx])
+	}
+}
@@ -0,0 +1,231 @@
+package wasm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		build func(m *Module)
+		err   string
+	}{
+		{
+			name: "Valid",
+			build: func(m *Module) {
+				m.Imports = []Import{FuncImport{Module: "m", Name: "f", Type: m.EnsureType(FuncType{In: []Type{Int64}})}}
+				f := m.AddExportedFunc("test", []Type{Int64}, []Type{Int64})
+				f.LocalGet(0)
+				f.Call(0)
+				f.LocalGet(0)
+				f.End()
+			},
+		},
+		{
+			name: "TypeMismatch",
+			build: func(m *Module) {
+				f := m.AddFunc([]Type{Int64}, []Type{Int32})
+				f.LocalGet(0)
+				f.I32Const(1)
+				f.I32Add()
+				f.End()
+			},
+			err: "function 0: instruction 2 (i32.add): expected i32, found i64",
+		},
+		{
+			name: "StackUnderflow",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, nil)
+				f.Drop()
+				f.End()
+			},
+			err: "operand stack underflow",
+		},
+		{
+			name: "ValuesLeftOver",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, nil)
+				f.I32Const(1)
+				f.End()
+			},
+			err: "1 values left on the stack",
+		},
+		{
+			name: "MissingResult",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, []Type{Int32})
+				f.Block(Result(Int32))
+				f.End()
+				f.End()
+			},
+			err: "instruction 1 (end): operand stack underflow",
+		},
+		{
+			name: "UnreachableCode",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, []Type{Int64})
+				f.I64Const(1)
+				f.Return()
+				f.I64Add()
+				f.End()
+			},
+		},
+		{
+			name: "BranchArity",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, nil)
+				l := f.Block(Result(Int32))
+				f.Branch(l)
+				f.End()
+				f.Drop()
+				f.End()
+			},
+			err: "instruction 1 (br): operand stack underflow",
+		},
+		{
+			name: "TailCallResult",
+			build: func(m *Module) {
+				g := m.AddFunc(nil, []Type{Int32})
+				g.I32Const(1)
+				g.End()
+				f := m.AddFunc(nil, []Type{Int64})
+				f.ReturnCall(uint32(g.Func))
+				f.End()
+			},
+			err: "function 1: instruction 0 (return_call): tail call results do not match the function's",
+		},
+		{
+			name: "IfWithoutElse",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, []Type{Int32})
+				f.I32Const(1)
+				f.If(Result(Int32))
+				f.I32Const(2)
+				f.End()
+				f.End()
+			},
+			err: "if without else",
+		},
+		{
+			name: "ImportedFunctionIndex",
+			build: func(m *Module) {
+				m.Imports = []Import{FuncImport{Module: "m", Name: "f", Type: m.EnsureType(FuncType{})}}
+				f := m.AddFunc(nil, nil)
+				f.Call(2)
+				f.End()
+			},
+			err: "function 1: instruction 0 (call): function 2 out of range",
+		},
+		{
+			name: "BodyNumbering",
+			build: func(m *Module) {
+				m.Imports = []Import{FuncImport{Module: "m", Name: "f", Type: m.EnsureType(FuncType{})}}
+				f := m.AddFunc(nil, nil)
+				f.End()
+				f.Func = 0
+			},
+			err: "function 1: body is numbered 0",
+		},
+		{
+			name: "ImmutableGlobal",
+			build: func(m *Module) {
+				var init Code
+				init.I32Const(0)
+				init.End()
+				g := m.AddGlobal(Global{Type: Int32, Init: init})
+
+				f := m.AddFunc(nil, nil)
+				f.I32Const(1)
+				f.GlobalSet(uint32(g))
+				f.End()
+			},
+			err: "global 0 is immutable",
+		},
+		{
+			name: "NonConstantInit",
+			build: func(m *Module) {
+				var init Code
+				init.I32Const(0)
+				init.I32Eqz()
+				init.End()
+				m.AddGlobal(Global{Type: Int32, Init: init})
+			},
+			err: "global 0: i32.eqz is not constant",
+		},
+		{
+			name: "MissingMemory",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, []Type{Int32})
+				f.I32Const(0)
+				f.I32Load(2, 0)
+				f.End()
+			},
+			err: "memory 0 out of range",
+		},
+		{
+			name: "Alignment",
+			build: func(m *Module) {
+				m.Memories = []Memory{MinMemory{Min: 1}}
+				f := m.AddFunc(nil, []Type{Int32})
+				f.I32Const(0)
+				f.I32Load(3, 0)
+				f.End()
+			},
+			err: "alignment 2**3 is larger than the access",
+		},
+		{
+			name: "DataCount",
+			build: func(m *Module) {
+				m.Memories = []Memory{MinMemory{Min: 1}}
+				m.Data = []Data{ActiveData{Bytes: []byte{1}}}
+				f := m.AddFunc(nil, nil)
+				f.DataDrop(0)
+				f.End()
+			},
+			err: "data count required",
+		},
+		{
+			name: "DuplicateExport",
+			build: func(m *Module) {
+				f := m.AddExportedFunc("f", nil, nil)
+				f.End()
+				m.Exports = append(m.Exports, FuncExport{Name: "f", Func: f.Func})
+			},
+			err: `duplicate export "f"`,
+		},
+		{
+			name: "BadLabel",
+			build: func(m *Module) {
+				f := m.AddFunc(nil, nil)
+				l := f.Block(Void)
+				f.End()
+				f.Branch(l)
+				f.End()
+			},
+			err: "label not in scope",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var m Module
+			test.build(&m)
+			err := m.Validate()
+			if test.err == "" {
+				assert.Nil(t, err)
+				return
+			}
+			if err == nil {
+				t.Errorf("expected %q", test.err)
+				return
+			}
+			assert.True(t, strings.Contains(err.Error(), test.err))
+			assert.True(t, errors.Is(err, ErrInvalid))
+			if t.Failed() {
+				t.Log(err)
+			}
+		})
+	}
+}
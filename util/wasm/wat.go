@@ -0,0 +1,443 @@
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WriteWat writes the module in the WebAssembly text format. Functions and locals are referred to
+// by the names given in the module's name section, if it has one.
+func (m *Module) WriteWat(w io.Writer) error {
+	names, err := m.Names()
+	if err != nil {
+		return err
+	}
+	p := &watPrinter{m: m, names: names}
+	p.module()
+	if p.err != nil {
+		return p.err
+	}
+	_, err = io.WriteString(w, p.sb.String())
+	return err
+}
+
+type watPrinter struct {
+	m     *Module
+	names *Names
+	sb    strings.Builder
+	err   error
+
+	funcIDs map[Index]string
+}
+
+func (p *watPrinter) printf(format string, args ...any) {
+	fmt.Fprintf(&p.sb, format, args...)
+}
+
+func (p *watPrinter) module() {
+	p.funcIDs = map[Index]string{}
+	p.sb.WriteString("(module")
+	if p.names != nil {
+		if p.names.Module != "" {
+			p.printf(" $%s", watID(p.names.Module))
+		}
+		p.funcIDs = uniqueIDs(p.names.Funcs)
+	}
+	p.sb.WriteString("\n")
+
+	for i, t := range p.m.Types {
+		p.printf("  (type (;%d;) ", i)
+		if ft, ok := t.(FuncType); ok {
+			p.sb.WriteString("(func")
+			p.params(ft, nil)
+			p.sb.WriteString(")")
+		}
+		p.sb.WriteString(")\n")
+	}
+
+	var funcs, tables, memories, globals int
+	for _, imp := range p.m.Imports {
+		module, name := importName(imp)
+		p.printf("  (import %s %s ", watString([]byte(module)), watString([]byte(name)))
+		switch imp := imp.(type) {
+		case FuncImport:
+			p.printf("(func%s (;%d;) (type %d))", p.funcID(Index(funcs)), funcs, imp.Type)
+			funcs++
+		case TableImport:
+			p.printf("(table (;%d;) 0 funcref)", tables)
+			tables++
+		case MemoryImport:
+			p.printf("(memory (;%d;) %s)", memories, watMemory(imp.Type))
+			memories++
+		case GlobalImport:
+			p.printf("(global (;%d;) %s)", globals, watGlobalType(imp.Type, imp.Mutable))
+			globals++
+		}
+		p.sb.WriteString(")\n")
+	}
+
+	for i, c := range p.m.Codes {
+		if i < len(p.m.Funcs) {
+			p.function(Index(funcs+i), p.m.Funcs[i], c)
+		}
+	}
+	for i, t := range p.m.Tables {
+		p.printf("  (table (;%d;) 0 %s)\n", tables+i, refTypeName(byte(t)))
+	}
+	for i, mem := range p.m.Memories {
+		p.printf("  (memory (;%d;) %s)\n", memories+i, watMemory(mem))
+	}
+	for i, g := range p.m.Globals {
+		p.printf("  (global (;%d;) %s ", globals+i, watGlobalType(g.Type, g.Mutable))
+		p.constExpr(g.Init.Instructions)
+		p.sb.WriteString(")\n")
+	}
+	for _, e := range p.m.Exports {
+		p.export(e)
+	}
+	if p.m.Start != nil {
+		p.printf("  (start %s)\n", p.funcRef(*p.m.Start))
+	}
+	for i, e := range p.m.Elements {
+		if e, ok := e.(*FuncElement); ok {
+			p.printf("  (elem (;%d;) func", i)
+			for _, f := range e.Funcs {
+				p.printf(" %s", p.funcRef(f))
+			}
+			p.sb.WriteString(")\n")
+		}
+	}
+	for i, d := range p.m.Data {
+		p.printf("  (data (;%d;) ", i)
+		switch d := d.(type) {
+		case ActiveData:
+			if d.Memory != 0 {
+				p.printf("(memory %d) ", d.Memory)
+			}
+			p.printf("(i32.const %d) %s", int32(d.Offset), watString(d.Bytes))
+		case PassiveData:
+			p.sb.WriteString(watString(d.Bytes))
+		}
+		p.sb.WriteString(")\n")
+	}
+	for _, s := range p.m.Customs {
+		if s.Name != nameSection {
+			p.printf("  ;; custom section %s, %d bytes\n", watString([]byte(s.Name)), len(s.Bytes))
+		}
+	}
+	p.sb.WriteString(")\n")
+}
+
+func (p *watPrinter) funcID(f Index) string {
+	if id, ok := p.funcIDs[f]; ok {
+		return " $" + id
+	}
+	return ""
+}
+
+func (p *watPrinter) funcRef(f Index) string {
+	if id, ok := p.funcIDs[f]; ok {
+		return "$" + id
+	}
+	return strconv.Itoa(int(f))
+}
+
+func (p *watPrinter) localIDs(f Index) map[uint32]string {
+	if p.names == nil {
+		return nil
+	}
+	return uniqueIDs(p.names.Locals[f])
+}
+
+// uniqueIDs converts names into identifiers. Identifiers have to be unique, so only the first
+// item with a given name is referred to by it.
+func uniqueIDs[K Index | uint32](names map[K]string) map[K]string {
+	keys := make([]K, 0, len(names))
+	for k := range names {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	res := map[K]string{}
+	used := map[string]bool{}
+	for _, k := range keys {
+		id := watID(names[k])
+		if used[id] {
+			continue
+		}
+		used[id] = true
+		res[k] = id
+	}
+	return res
+}
+
+// params writes the parameters and results of a function type. Named parameters have to be
+// declared individually.
+func (p *watPrinter) params(t FuncType, locals map[uint32]string) {
+	if len(t.In) != 0 && len(locals) == 0 {
+		p.sb.WriteString(" (param")
+		for _, in := range t.In {
+			p.printf(" %s", valType(in.(NumberType)))
+		}
+		p.sb.WriteString(")")
+	} else {
+		for i, in := range t.In {
+			p.printf(" (param%s %s)", localID(locals, uint32(i)), valType(in.(NumberType)))
+		}
+	}
+	if len(t.Out) != 0 {
+		p.sb.WriteString(" (result")
+		for _, out := range t.Out {
+			p.printf(" %s", valType(out.(NumberType)))
+		}
+		p.sb.WriteString(")")
+	}
+}
+
+func localID(locals map[uint32]string, idx uint32) string {
+	if id, ok := locals[idx]; ok {
+		return " $" + id
+	}
+	return ""
+}
+
+func (p *watPrinter) function(f Index, typ Index, c *Code) {
+	p.printf("  (func%s (;%d;) (type %d)", p.funcID(f), f, typ)
+	locals := p.localIDs(f)
+	var params uint32
+	if int(typ) < len(p.m.Types) {
+		if t, ok := p.m.Types[typ].(FuncType); ok {
+			p.params(t, locals)
+			params = uint32(len(t.In))
+		}
+	}
+	p.sb.WriteString("\n")
+
+	idx := params
+	for _, l := range c.Locals {
+		for i := uint32(0); i < l.Count; i++ {
+			p.printf("    (local%s %s)\n", localID(locals, idx), valType(l.Type.(NumberType)))
+			idx++
+		}
+	}
+
+	instrs, err := c.Decode()
+	if err != nil {
+		p.err = fmt.Errorf("function %d: %w", f, err)
+		return
+	}
+
+	depth := 2
+	for i, x := range instrs {
+		switch x.Op {
+		case OpEnd, OpElse:
+			depth--
+		}
+		if i == len(instrs)-1 && x.Op == OpEnd {
+			break
+		}
+		p.sb.WriteString(strings.Repeat("  ", depth))
+		p.instruction(x, locals)
+		p.sb.WriteString("\n")
+		switch x.Op {
+		case OpBlock, OpLoop, OpIf, OpElse:
+			depth++
+		}
+	}
+	p.sb.WriteString("  )\n")
+}
+
+func (p *watPrinter) constExpr(expr []byte) {
+	instrs, err := DecodeInstructions(expr)
+	if err != nil {
+		p.err = err
+		return
+	}
+	for i, x := range instrs {
+		if x.Op == OpEnd {
+			break
+		}
+		if i > 0 {
+			p.sb.WriteString(" ")
+		}
+		p.sb.WriteString("(")
+		p.instruction(x, nil)
+		p.sb.WriteString(")")
+	}
+}
+
+func (p *watPrinter) export(e Export) {
+	switch e := e.(type) {
+	case FuncExport:
+		p.printf("  (export %s (func %s))\n", watString([]byte(e.Name)), p.funcRef(e.Func))
+	case TableExport:
+		p.printf("  (export %s (table %d))\n", watString([]byte(e.Name)), e.Table)
+	case MemoryExport:
+		p.printf("  (export %s (memory %d))\n", watString([]byte(e.Name)), e.Mem)
+	case GlobalExport:
+		p.printf("  (export %s (global %d))\n", watString([]byte(e.Name)), e.Global)
+	}
+}
+
+func (p *watPrinter) instruction(x Instruction, locals map[uint32]string) {
+	p.sb.WriteString(x.Op.String())
+
+	switch x.Op {
+	case OpCall, OpReturnCall, OpRefFunc:
+		p.printf(" %s", p.funcRef(Index(x.Args[0])))
+
+	case OpLocalGet, OpLocalSet, OpLocalTee:
+		if id, ok := locals[uint32(x.Args[0])]; ok {
+			p.printf(" $%s", id)
+		} else {
+			p.printf(" %d", x.Args[0])
+		}
+
+	case OpCallIndirect, OpReturnCallIndirect:
+		if x.Args[1] != 0 {
+			p.printf(" %d", x.Args[1])
+		}
+		p.printf(" (type %d)", x.Args[0])
+
+	case OpTableInit:
+		if x.Args[1] != 0 {
+			p.printf(" %d", x.Args[1])
+		}
+		p.printf(" %d", x.Args[0])
+
+	case OpTableCopy:
+		if x.Args[0] != 0 || x.Args[1] != 0 {
+			p.printf(" %d %d", x.Args[0], x.Args[1])
+		}
+
+	case OpMemorySize, OpMemoryGrow, OpMemoryCopy, OpMemoryFill:
+		// there is only one memory
+
+	case OpMemoryInit:
+		p.printf(" %d", x.Args[0])
+
+	case OpBlock, OpLoop, OpIf:
+		bt := int64(x.Args[0])
+		switch {
+		case bt == int64(Void):
+		case bt < 0:
+			p.printf(" (result %s)", valType(bt+0x80))
+		default:
+			p.printf(" (type %d)", bt)
+		}
+
+	case OpSelectT:
+		p.sb.WriteString(" (result")
+		for _, t := range x.Args {
+			p.printf(" %s", valType(t))
+		}
+		p.sb.WriteString(")")
+
+	case OpRefNull:
+		p.printf(" %s", strings.TrimSuffix(refTypeName(byte(x.Args[0])), "ref"))
+
+	case OpI32Const:
+		p.printf(" %d", int32(x.Args[0]))
+
+	case OpI64Const:
+		p.printf(" %d", int64(x.Args[0]))
+
+	case OpF32Const:
+		p.printf(" %s", watFloat(float64(math.Float32frombits(uint32(x.Args[0]))), 32, x.Args[0], 1<<22, 1<<31))
+
+	case OpF64Const:
+		p.printf(" %s", watFloat(math.Float64frombits(x.Args[0]), 64, x.Args[0], 1<<51, 1<<63))
+
+	default:
+		if acc, ok := memoryAccess(x.Op); ok {
+			if x.Args[1] != 0 {
+				p.printf(" offset=%d", x.Args[1])
+			}
+			if align := uint64(1) << x.Args[0]; align != acc.size {
+				p.printf(" align=%d", align)
+			}
+			return
+		}
+		for _, a := range x.Args {
+			p.printf(" %d", a)
+		}
+	}
+}
+
+// watFloat formats a float so that it reads back as the same bits. NaNs other than the canonical
+// one keep their payload.
+func watFloat(x float64, size int, bits, quiet, sign uint64) string {
+	switch {
+	case math.IsInf(x, 1):
+		return "inf"
+	case math.IsInf(x, -1):
+		return "-inf"
+	case !math.IsNaN(x):
+		return strconv.FormatFloat(x, 'g', -1, size)
+	}
+	res := "nan"
+	if bits&sign != 0 {
+		res = "-nan"
+	}
+	payload := bits & (quiet<<1 - 1)
+	if payload != quiet {
+		res += fmt.Sprintf(":%#x", payload)
+	}
+	return res
+}
+
+func watMemory(m Memory) string {
+	if m, ok := m.(MinMemory); ok {
+		return strconv.Itoa(int(m.Min))
+	}
+	return "0"
+}
+
+func watGlobalType(t Type, mutable bool) string {
+	name := valType(t.(NumberType)).String()
+	if mutable {
+		return "(mut " + name + ")"
+	}
+	return name
+}
+
+func refTypeName(t byte) string {
+	return valType(t).String()
+}
+
+// watString quotes bytes as a string literal, escaping anything that isn't printable ASCII.
+func watString(bs []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, b := range bs {
+		switch {
+		case b == '"' || b == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case b < 0x20 || b >= 0x7f:
+			fmt.Fprintf(&sb, "\\%02x", b)
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// watID turns a name into an identifier by replacing the characters that identifiers may not
+// contain.
+func watID(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune(`"',;()[]{}`, r) {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,208 @@
+package wasm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bobappleyard/lync/util/assert"
+)
+
+const factorialWat = `
+(module $fact
+  ;; computes n! in both recursive and iterative styles
+  (func $rec (export "rec") (param $n i64) (result i64)
+    (if (result i64) (i64.eqz (local.get $n))
+      (then (i64.const 1))
+      (else
+        (i64.mul (local.get $n)
+                 (call $rec (i64.sub (local.get $n) (i64.const 1)))))))
+
+  (func $iter (export "iter") (param $n i64) (result i64)
+    (local $acc i64)
+    i64.const 1
+    local.set $acc
+    block $done
+      loop $next
+        local.get $n
+        i64.eqz
+        br_if $done
+        (local.set $acc (i64.mul (local.get $acc) (local.get $n)))
+        (local.set $n (i64.sub (local.get $n) (i64.const 1)))
+        br $next
+      end
+    end
+    local.get $acc)
+)
+`
+
+func TestParseWat(t *testing.T) {
+	m, err := ParseWat([]byte(factorialWat))
+	assert.Nil(t, err)
+	assert.Nil(t, m.Validate())
+
+	names, err := m.Names()
+	assert.Nil(t, err)
+	assert.Equal(t, names.Module, "fact")
+	assert.Equal(t, names.Funcs, map[Index]string{0: "rec", 1: "iter"})
+	assert.Equal(t, names.Locals[1], map[uint32]string{0: "n", 1: "acc"})
+
+	inst, err := Instantiate(m, nil)
+	assert.Nil(t, err)
+
+	for _, name := range []string{"rec", "iter"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := inst.Func(name)
+			assert.Nil(t, err)
+			res, err := f.Call(10)
+			assert.Nil(t, err)
+			assert.Equal(t, res, []uint64{3628800})
+		})
+	}
+}
+
+func TestWatRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "Factorial",
+			src:  factorialWat,
+		},
+		{
+			name: "Imports",
+			src: `(module
+				(type $binop (func (param i64 i64) (result i64)))
+				(import "runtime" "lookup" (func $lookup (type $binop)))
+				(import "m" "table" (table 0 funcref))
+				(import "m" "memory" (memory 1))
+				(import "m" "global" (global $g (mut i32)))
+				(func (export "test") (param i32) (result i64)
+					(call $lookup (i64.extend_i32_u (local.get 0)) (i64.const -1))
+					(global.set $g (i32.const 0x7fff_ffff))))`,
+		},
+		{
+			name: "Tables",
+			src: `(module
+				(type (func (result i32)))
+				(table $t 0 funcref)
+				(table 0 externref)
+				(elem $e func $a $b)
+				(func $a (result i32) (i32.const 1))
+				(func $b (result i32) (i32.const 2))
+				(func (param i32) (result i32)
+					(table.grow $t (ref.null func) (i32.const 2))
+					drop
+					(table.init $t $e (i32.const 0) (i32.const 0) (i32.const 2))
+					(call_indirect $t (type 0) (local.get 0)))
+				(export "t" (table 1)))`,
+		},
+		{
+			name: "Memory",
+			src: `(module
+				(memory $mem 1)
+				(data (i32.const 16) "hello, " "world\n")
+				(data $p "\00\01\u{e9}")
+				(global (mut f64) (f64.const -0x1.8p3))
+				(global f32 (f32.const nan:0x200))
+				(func (param i32) (result f32)
+					(i32.store8 offset=3 (local.get 0) (i32.const 255))
+					(memory.init $p (i32.const 0) (i32.const 0) (i32.const 3))
+					(data.drop $p)
+					(f32.load align=1 (local.get 0)))
+				(start 1)
+				(func))`,
+		},
+		{
+			name: "TailCalls",
+			src: `(module
+				(type $f (func (param i64) (result i64)))
+				(table 0 funcref)
+				(func $even (param i64) (result i64)
+					(if (i64.eqz (local.get 0)) (then (return (i64.const 1))))
+					(return_call $odd (i64.sub (local.get 0) (i64.const 1))))
+				(func $odd (param i64) (result i64)
+					(if (i64.eqz (local.get 0)) (then (return (i64.const 0))))
+					(return_call_indirect (type $f) (i64.sub (local.get 0) (i64.const 1)) (i32.const 0))))`,
+		},
+		{
+			name: "Blocks",
+			src: `(module
+				(func (param i32) (result i32)
+					(block $a
+						(block $b
+							(block $c
+								(br_table $a $b $c (local.get 0)))
+							(return (i32.const 1)))
+						(return (i32.const 2)))
+					(select (result i32) (i32.const 3) (i32.const 4) (local.get 0))))`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ParseWat([]byte(test.src))
+			assert.Nil(t, err)
+			assert.Nil(t, m.Validate())
+
+			var first strings.Builder
+			assert.Nil(t, m.WriteWat(&first))
+
+			m2, err := ParseWat([]byte(first.String()))
+			assert.Nil(t, err)
+			assert.Equal(t, m2.AppendWasm(nil), m.AppendWasm(nil))
+
+			var second strings.Builder
+			assert.Nil(t, m2.WriteWat(&second))
+			assert.Equal(t, second.String(), first.String())
+		})
+	}
+}
+
+func TestWatNames(t *testing.T) {
+	var m Module
+	f := m.AddExportedFunc("test", []Type{Int32}, []Type{Int32})
+	f.LocalGet(0)
+	f.Call(uint32(f.Func))
+	f.End()
+
+	m.SetNames(&Names{
+		Module: "demo",
+		Funcs:  map[Index]string{0: "loop"},
+		Locals: map[Index]map[uint32]string{0: {0: "x"}},
+	})
+
+	var out strings.Builder
+	assert.Nil(t, m.WriteWat(&out))
+	text := out.String()
+	for _, want := range []string{"(module $demo", "(func $loop (;0;)", "(param $x i32)", "local.get $x", "call $loop"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+}
+
+func TestParseWatErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		src  string
+	}{
+		{"Unbalanced", `(module (func)`},
+		{"UnterminatedString", `(module (export "a`},
+		{"UnknownInstruction", `(module (func i32.frobnicate))`},
+		{"UnknownLocal", `(module (func (local.get $x)))`},
+		{"UnknownLabel", `(module (func (block (br $l))))`},
+		{"UnknownFunction", `(module (func (call 3)))`},
+		{"DuplicateID", `(module (func $f) (func $f))`},
+		{"BadNumber", `(module (func (i32.const 1__0)))`},
+		{"OutOfRange", `(module (func (i32.const 0x1_0000_0000)))`},
+		{"ActiveElements", `(module (table 0 funcref) (elem (i32.const 0) func))`},
+		{"LateImport", `(module (func) (import "a" "b" (func)))`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseWat([]byte(test.src))
+			if !errors.Is(err, ErrSyntax) {
+				t.Errorf("expected syntax error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1366 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrSyntax = errors.New("syntax error")
+
+// ParseWat builds a module from its description in the WebAssembly text format. Instructions may be
+// written in either the flat or the folded form, and anything may be referred to by identifier.
+//
+// Identifiers given to the module, functions and locals are kept in the name section.
+//
+// Only modules that this package can represent are accepted, so tables must start out empty,
+// memories cannot have a maximum size and element segments must be passive.
+func ParseWat(src []byte) (*Module, error) {
+	l := &watLexer{src: src, line: 1}
+	var fields []*sexpr
+	for {
+		x, err := l.sexpr()
+		if err != nil {
+			return nil, err
+		}
+		if x == nil {
+			break
+		}
+		fields = append(fields, x)
+	}
+
+	var name string
+	if len(fields) == 1 && fields[0].head() == "module" {
+		fields = fields[0].list[1:]
+		if len(fields) != 0 && fields[0].isID() {
+			name = fields[0].atom[1:]
+			fields = fields[1:]
+		}
+	}
+
+	p := &watParser{
+		m:        &Module{},
+		types:    map[string]Index{},
+		funcs:    map[string]Index{},
+		tables:   map[string]Index{},
+		memories: map[string]Index{},
+		globals:  map[string]Index{},
+		elems:    map[string]Index{},
+		datas:    map[string]Index{},
+		locals:   map[Index]map[string]uint32{},
+	}
+	if err := p.module(fields); err != nil {
+		return nil, err
+	}
+	p.names(name)
+	return p.m, nil
+}
+
+// sexpr is an atom, a string or a list.
+type sexpr struct {
+	line   int
+	atom   string
+	str    []byte
+	isStr  bool
+	list   []*sexpr
+	isList bool
+}
+
+func (x *sexpr) head() string {
+	if !x.isList || len(x.list) == 0 {
+		return ""
+	}
+	return x.list[0].atom
+}
+
+func (x *sexpr) isID() bool {
+	return !x.isList && !x.isStr && strings.HasPrefix(x.atom, "$")
+}
+
+func (x *sexpr) isAtom() bool {
+	return !x.isList && !x.isStr
+}
+
+func (x *sexpr) String() string {
+	switch {
+	case x.isList:
+		return "(" + x.head() + " ...)"
+	case x.isStr:
+		return watString(x.str)
+	}
+	return x.atom
+}
+
+type watLexer struct {
+	src  []byte
+	pos  int
+	line int
+}
+
+func (l *watLexer) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s: %w", l.line, fmt.Sprintf(format, args...), ErrSyntax)
+}
+
+// space skips whitespace and comments. Block comments nest.
+func (l *watLexer) space() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(string(l.src[l.pos:min(l.pos+2, len(l.src))]), ";;"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(string(l.src[l.pos:min(l.pos+2, len(l.src))]), "(;"):
+			depth := 0
+			for {
+				if l.pos+1 >= len(l.src) {
+					return l.errorf("unterminated comment")
+				}
+				switch string(l.src[l.pos : l.pos+2]) {
+				case "(;":
+					depth++
+					l.pos += 2
+				case ";)":
+					depth--
+					l.pos += 2
+				default:
+					if l.src[l.pos] == '\n' {
+						l.line++
+					}
+					l.pos++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// sexpr reads the next expression, returning nil at the end of the input.
+func (l *watLexer) sexpr() (*sexpr, error) {
+	if err := l.space(); err != nil {
+		return nil, err
+	}
+	if l.pos >= len(l.src) {
+		return nil, nil
+	}
+	res := &sexpr{line: l.line}
+
+	switch l.src[l.pos] {
+	case '(':
+		l.pos++
+		res.isList = true
+		for {
+			if err := l.space(); err != nil {
+				return nil, err
+			}
+			if l.pos >= len(l.src) {
+				return nil, l.errorf("missing )")
+			}
+			if l.src[l.pos] == ')' {
+				l.pos++
+				return res, nil
+			}
+			x, err := l.sexpr()
+			if err != nil {
+				return nil, err
+			}
+			res.list = append(res.list, x)
+		}
+
+	case ')':
+		return nil, l.errorf("unexpected )")
+
+	case '"':
+		str, err := l.string()
+		if err != nil {
+			return nil, err
+		}
+		res.isStr = true
+		res.str = str
+		return res, nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.src) && !strings.ContainsRune(" \t\r\n();\"", rune(l.src[l.pos])) {
+		l.pos++
+	}
+	res.atom = string(l.src[start:l.pos])
+	return res, nil
+}
+
+func (l *watLexer) string() ([]byte, error) {
+	var res []byte
+	l.pos++
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return nil, l.errorf("unterminated string")
+		}
+		c := l.src[l.pos]
+		l.pos++
+		switch c {
+		case '"':
+			return res, nil
+		case '\\':
+			esc, err := l.escape()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, esc...)
+		default:
+			res = append(res, c)
+		}
+	}
+}
+
+func (l *watLexer) escape() ([]byte, error) {
+	if l.pos >= len(l.src) {
+		return nil, l.errorf("unterminated string")
+	}
+	c := l.src[l.pos]
+	l.pos++
+	switch c {
+	case 'n':
+		return []byte{'\n'}, nil
+	case 't':
+		return []byte{'\t'}, nil
+	case 'r':
+		return []byte{'\r'}, nil
+	case '"', '\'', '\\':
+		return []byte{c}, nil
+	case 'u':
+		end := strings.IndexByte(string(l.src[l.pos:]), '}')
+		if l.pos >= len(l.src) || l.src[l.pos] != '{' || end == -1 {
+			return nil, l.errorf("bad unicode escape")
+		}
+		r, err := strconv.ParseUint(string(l.src[l.pos+1:l.pos+end]), 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return nil, l.errorf("bad unicode escape")
+		}
+		l.pos += end + 1
+		return utf8.AppendRune(nil, rune(r)), nil
+	}
+	if l.pos >= len(l.src) {
+		return nil, l.errorf("bad escape")
+	}
+	b, err := strconv.ParseUint(string(l.src[l.pos-1:l.pos+1]), 16, 8)
+	if err != nil {
+		return nil, l.errorf("bad escape")
+	}
+	l.pos++
+	return []byte{byte(b)}, nil
+}
+
+type watParser struct {
+	m *Module
+
+	types, funcs, tables, memories, globals, elems, datas map[string]Index
+	counts                                                struct {
+		funcs, tables, memories, globals, elems, datas int
+	}
+
+	// the identifiers of each function's locals
+	locals map[Index]map[string]uint32
+}
+
+func (p *watParser) names(module string) {
+	n := &Names{
+		Module: module,
+		Funcs:  map[Index]string{},
+		Locals: map[Index]map[uint32]string{},
+	}
+	for id, idx := range p.funcs {
+		n.Funcs[idx] = id[1:]
+	}
+	for f, ids := range p.locals {
+		if len(ids) == 0 {
+			continue
+		}
+		n.Locals[f] = map[uint32]string{}
+		for id, idx := range ids {
+			n.Locals[f][idx] = id[1:]
+		}
+	}
+	if n.Module != "" || len(n.Funcs) != 0 || len(n.Locals) != 0 {
+		p.m.SetNames(n)
+	}
+}
+
+func watErrorf(x *sexpr, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s: %w", x.line, fmt.Sprintf(format, args...), ErrSyntax)
+}
+
+// define records an identifier for the next index in a space.
+func define(space map[string]Index, x *sexpr, next *int) error {
+	idx := Index(*next)
+	*next++
+	if len(x.list) < 2 || !x.list[1].isID() {
+		return nil
+	}
+	id := x.list[1].atom
+	if _, ok := space[id]; ok {
+		return watErrorf(x, "duplicate identifier %s", id)
+	}
+	space[id] = idx
+	return nil
+}
+
+func (p *watParser) module(fields []*sexpr) error {
+	// assign indices first so that fields can refer to ones that come later
+	var types int
+	for _, f := range fields {
+		var err error
+		switch f.head() {
+		case "type":
+			err = define(p.types, f, &types)
+		case "import":
+			if len(f.list) == 4 && f.list[3].isList {
+				err = p.defineImport(f.list[3])
+			}
+		case "func":
+			err = define(p.funcs, f, &p.counts.funcs)
+		case "table":
+			err = define(p.tables, f, &p.counts.tables)
+		case "memory":
+			err = define(p.memories, f, &p.counts.memories)
+		case "global":
+			err = define(p.globals, f, &p.counts.globals)
+		case "elem":
+			err = define(p.elems, f, &p.counts.elems)
+		case "data":
+			err = define(p.datas, f, &p.counts.datas)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range fields {
+		if f.head() != "type" {
+			continue
+		}
+		if err := p.typeDef(f); err != nil {
+			return err
+		}
+	}
+
+	var bodies []func() error
+	defined := false
+	for _, f := range fields {
+		var err error
+		switch f.head() {
+		case "type":
+		case "import":
+			if defined {
+				return watErrorf(f, "imports must come before definitions")
+			}
+			err = p.importDef(f)
+		case "func":
+			defined = true
+			var body func() error
+			body, err = p.funcDef(f)
+			bodies = append(bodies, body)
+		case "table":
+			defined = true
+			err = p.tableDef(f)
+		case "memory":
+			defined = true
+			err = p.memoryDef(f)
+		case "global":
+			defined = true
+			err = p.globalDef(f)
+		case "export":
+			err = p.exportDef(f)
+		case "start":
+			err = p.startDef(f)
+		case "elem":
+			err = p.elemDef(f)
+		case "data":
+			err = p.dataDef(f)
+		default:
+			err = watErrorf(f, "unexpected %s", f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// function bodies can refer to any other part of the module, including types that are only
+	// introduced by other functions' signatures
+	for _, body := range bodies {
+		if err := body(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *watParser) defineImport(desc *sexpr) error {
+	switch desc.head() {
+	case "func":
+		return define(p.funcs, desc, &p.counts.funcs)
+	case "table":
+		return define(p.tables, desc, &p.counts.tables)
+	case "memory":
+		return define(p.memories, desc, &p.counts.memories)
+	case "global":
+		return define(p.globals, desc, &p.counts.globals)
+	}
+	return watErrorf(desc, "unexpected %s", desc)
+}
+
+// fieldArgs skips over the keyword and identifier at the start of a field.
+func fieldArgs(x *sexpr) []*sexpr {
+	args := x.list[1:]
+	if len(args) != 0 && args[0].isID() {
+		args = args[1:]
+	}
+	return args
+}
+
+func (p *watParser) typeDef(f *sexpr) error {
+	args := fieldArgs(f)
+	if len(args) != 1 || args[0].head() != "func" {
+		return watErrorf(f, "expected function type")
+	}
+	t, rest, err := p.signature(args[0].list[1:], nil)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return watErrorf(rest[0], "unexpected %s", rest[0])
+	}
+	// types are kept in the order they are written, even if they repeat
+	p.m.Types = append(p.m.Types, t)
+	return nil
+}
+
+// signature reads parameter and result declarations, recording the identifiers given to
+// parameters if ids is not nil.
+func (p *watParser) signature(xs []*sexpr, ids map[string]uint32) (FuncType, []*sexpr, error) {
+	var t FuncType
+	for len(xs) != 0 {
+		x := xs[0]
+		switch x.head() {
+		case "param":
+			args := x.list[1:]
+			if len(args) != 0 && args[0].isID() {
+				if len(args) != 2 {
+					return t, nil, watErrorf(x, "named parameters must be declared individually")
+				}
+				if ids != nil {
+					ids[args[0].atom] = uint32(len(t.In))
+				}
+				args = args[1:]
+			}
+			for _, a := range args {
+				vt, err := parseValType(a)
+				if err != nil {
+					return t, nil, err
+				}
+				t.In = append(t.In, vt)
+			}
+		case "result":
+			for _, a := range x.list[1:] {
+				vt, err := parseValType(a)
+				if err != nil {
+					return t, nil, err
+				}
+				t.Out = append(t.Out, vt)
+			}
+		default:
+			return t, xs, nil
+		}
+		xs = xs[1:]
+	}
+	return t, xs, nil
+}
+
+func parseValType(x *sexpr) (Type, error) {
+	switch x.atom {
+	case "i32":
+		return Int32, nil
+	case "i64":
+		return Int64, nil
+	case "f32":
+		return Float32, nil
+	case "f64":
+		return Float64, nil
+	}
+	return nil, watErrorf(x, "unknown value type %s", x)
+}
+
+// typeUse reads a reference to a type, optionally followed by its signature, or just a signature
+// for a type that is added to the module if needed.
+func (p *watParser) typeUse(xs []*sexpr, ids map[string]uint32) (Index, FuncType, []*sexpr, error) {
+	if len(xs) != 0 && xs[0].head() == "type" {
+		if len(xs[0].list) != 2 {
+			return 0, FuncType{}, nil, watErrorf(xs[0], "expected type index")
+		}
+		idx, err := p.ref(p.types, xs[0].list[1], len(p.m.Types))
+		if err != nil {
+			return 0, FuncType{}, nil, err
+		}
+		_, rest, err := p.signature(xs[1:], ids)
+		if err != nil {
+			return 0, FuncType{}, nil, err
+		}
+		t, _ := p.m.Types[idx].(FuncType)
+		return idx, t, rest, nil
+	}
+	t, rest, err := p.signature(xs, ids)
+	if err != nil {
+		return 0, FuncType{}, nil, err
+	}
+	return p.m.EnsureType(t), t, rest, nil
+}
+
+// ref resolves a reference to an index, given either as a number or an identifier.
+func (p *watParser) ref(space map[string]Index, x *sexpr, count int) (Index, error) {
+	if x.isID() {
+		idx, ok := space[x.atom]
+		if !ok {
+			return 0, watErrorf(x, "unknown identifier %s", x.atom)
+		}
+		return idx, nil
+	}
+	n, err := parseUint(x, 32)
+	if err != nil {
+		return 0, err
+	}
+	if int(n) >= count {
+		return 0, watErrorf(x, "index %d out of range", n)
+	}
+	return Index(n), nil
+}
+
+func isRef(x *sexpr) bool {
+	if !x.isAtom() || x.atom == "" {
+		return false
+	}
+	return x.isID() || (x.atom[0] >= '0' && x.atom[0] <= '9')
+}
+
+func (p *watParser) importDef(f *sexpr) error {
+	if len(f.list) != 4 || !f.list[1].isStr || !f.list[2].isStr || !f.list[3].isList {
+		return watErrorf(f, "malformed import")
+	}
+	module, name := string(f.list[1].str), string(f.list[2].str)
+	desc := f.list[3]
+	args := fieldArgs(desc)
+
+	switch desc.head() {
+	case "func":
+		idx, _, rest, err := p.typeUse(args, nil)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return watErrorf(rest[0], "unexpected %s", rest[0])
+		}
+		p.m.Imports = append(p.m.Imports, FuncImport{Module: module, Name: name, Type: idx})
+
+	case "table":
+		if _, err := tableType(desc, args); err != nil {
+			return err
+		}
+		p.m.Imports = append(p.m.Imports, TableImport{Module: module, Name: name})
+
+	case "memory":
+		mem, err := memoryType(desc, args)
+		if err != nil {
+			return err
+		}
+		p.m.Imports = append(p.m.Imports, MemoryImport{Module: module, Name: name, Type: mem})
+
+	case "global":
+		if len(args) != 1 {
+			return watErrorf(desc, "expected global type")
+		}
+		t, mutable, err := parseGlobalType(args[0])
+		if err != nil {
+			return err
+		}
+		p.m.Imports = append(p.m.Imports, GlobalImport{Module: module, Name: name, Type: t, Mutable: mutable})
+	}
+	return nil
+}
+
+func tableType(f *sexpr, args []*sexpr) (Table, error) {
+	if len(args) != 2 || args[0].atom != "0" {
+		return 0, watErrorf(f, "only empty tables are supported")
+	}
+	switch args[1].atom {
+	case "funcref":
+		return FuncTable, nil
+	case "externref":
+		return ExternTable, nil
+	}
+	return 0, watErrorf(args[1], "unknown reference type %s", args[1])
+}
+
+func memoryType(f *sexpr, args []*sexpr) (Memory, error) {
+	if len(args) != 1 {
+		return nil, watErrorf(f, "only memories without a maximum size are supported")
+	}
+	n, err := parseUint(args[0], 32)
+	if err != nil {
+		return nil, err
+	}
+	return MinMemory{Min: uint32(n)}, nil
+}
+
+func parseGlobalType(x *sexpr) (Type, bool, error) {
+	if x.head() == "mut" {
+		if len(x.list) != 2 {
+			return nil, false, watErrorf(x, "expected value type")
+		}
+		t, err := parseValType(x.list[1])
+		return t, true, err
+	}
+	t, err := parseValType(x)
+	return t, false, err
+}
+
+// inlineExports reads the exports declared as part of a definition.
+func (p *watParser) inlineExports(args []*sexpr, export func(name string) Export) []*sexpr {
+	for len(args) != 0 && args[0].head() == "export" && len(args[0].list) == 2 && args[0].list[1].isStr {
+		p.m.Exports = append(p.m.Exports, export(string(args[0].list[1].str)))
+		args = args[1:]
+	}
+	return args
+}
+
+func (p *watParser) funcDef(f *sexpr) (func() error, error) {
+	idx := Index(importCount[FuncImport](p.m.Imports) + len(p.m.Funcs))
+	args := p.inlineExports(fieldArgs(f), func(name string) Export {
+		return FuncExport{Name: name, Func: idx}
+	})
+	if len(args) != 0 && args[0].head() == "import" {
+		return nil, watErrorf(f, "imports must be declared separately")
+	}
+
+	locals := map[string]uint32{}
+	p.locals[idx] = locals
+	typ, t, rest, err := p.typeUse(args, locals)
+	if err != nil {
+		return nil, err
+	}
+	c := &Code{Func: idx, params: uint32(len(t.In))}
+	p.m.Funcs = append(p.m.Funcs, typ)
+	p.m.Codes = append(p.m.Codes, c)
+
+	for len(rest) != 0 && rest[0].head() == "local" {
+		x := rest[0]
+		decl := x.list[1:]
+		rest = rest[1:]
+		if len(decl) != 0 && decl[0].isID() {
+			if len(decl) != 2 {
+				return nil, watErrorf(x, "named locals must be declared individually")
+			}
+			t, err := parseValType(decl[1])
+			if err != nil {
+				return nil, err
+			}
+			locals[decl[0].atom] = c.AddLocal(t)
+			continue
+		}
+		for _, d := range decl {
+			t, err := parseValType(d)
+			if err != nil {
+				return nil, err
+			}
+			c.AddLocal(t)
+		}
+	}
+
+	return func() error {
+		b := &watBody{p: p, c: c, locals: locals}
+		if err := b.instrs(rest); err != nil {
+			return err
+		}
+		c.End()
+		return nil
+	}, nil
+}
+
+func (p *watParser) tableDef(f *sexpr) error {
+	idx := Index(importCount[TableImport](p.m.Imports) + len(p.m.Tables))
+	args := p.inlineExports(fieldArgs(f), func(name string) Export {
+		return TableExport{Name: name, Table: idx}
+	})
+	t, err := tableType(f, args)
+	if err != nil {
+		return err
+	}
+	p.m.Tables = append(p.m.Tables, t)
+	return nil
+}
+
+func (p *watParser) memoryDef(f *sexpr) error {
+	idx := Index(importCount[MemoryImport](p.m.Imports) + len(p.m.Memories))
+	args := p.inlineExports(fieldArgs(f), func(name string) Export {
+		return MemoryExport{Name: name, Mem: idx}
+	})
+	mem, err := memoryType(f, args)
+	if err != nil {
+		return err
+	}
+	p.m.Memories = append(p.m.Memories, mem)
+	return nil
+}
+
+func (p *watParser) globalDef(f *sexpr) error {
+	idx := Index(importCount[GlobalImport](p.m.Imports) + len(p.m.Globals))
+	args := p.inlineExports(fieldArgs(f), func(name string) Export {
+		return GlobalExport{Name: name, Global: idx}
+	})
+	if len(args) == 0 {
+		return watErrorf(f, "expected global type")
+	}
+	t, mutable, err := parseGlobalType(args[0])
+	if err != nil {
+		return err
+	}
+	init, err := p.constExpr(args[1:])
+	if err != nil {
+		return err
+	}
+	p.m.AddGlobal(Global{Type: t, Mutable: mutable, Init: init})
+	return nil
+}
+
+func (p *watParser) constExpr(xs []*sexpr) (Code, error) {
+	var c Code
+	b := &watBody{p: p, c: &c}
+	if err := b.instrs(xs); err != nil {
+		return Code{}, err
+	}
+	c.End()
+	return c, nil
+}
+
+func (p *watParser) exportDef(f *sexpr) error {
+	if len(f.list) != 3 || !f.list[1].isStr || len(f.list[2].list) != 2 {
+		return watErrorf(f, "malformed export")
+	}
+	name := string(f.list[1].str)
+	desc := f.list[2]
+
+	var err error
+	var idx Index
+	switch desc.head() {
+	case "func":
+		idx, err = p.ref(p.funcs, desc.list[1], p.counts.funcs)
+		p.m.Exports = append(p.m.Exports, FuncExport{Name: name, Func: idx})
+	case "table":
+		idx, err = p.ref(p.tables, desc.list[1], p.counts.tables)
+		p.m.Exports = append(p.m.Exports, TableExport{Name: name, Table: idx})
+	case "memory":
+		idx, err = p.ref(p.memories, desc.list[1], p.counts.memories)
+		p.m.Exports = append(p.m.Exports, MemoryExport{Name: name, Mem: idx})
+	case "global":
+		idx, err = p.ref(p.globals, desc.list[1], p.counts.globals)
+		p.m.Exports = append(p.m.Exports, GlobalExport{Name: name, Global: idx})
+	default:
+		return watErrorf(desc, "unexpected %s", desc)
+	}
+	return err
+}
+
+func (p *watParser) startDef(f *sexpr) error {
+	if len(f.list) != 2 {
+		return watErrorf(f, "expected function")
+	}
+	idx, err := p.ref(p.funcs, f.list[1], p.counts.funcs)
+	if err != nil {
+		return err
+	}
+	p.m.Start = &idx
+	return nil
+}
+
+func (p *watParser) elemDef(f *sexpr) error {
+	args := fieldArgs(f)
+	if len(args) == 0 || args[0].atom != "func" {
+		return watErrorf(f, "only passive function element segments are supported")
+	}
+	e := &FuncElement{}
+	for _, x := range args[1:] {
+		idx, err := p.ref(p.funcs, x, p.counts.funcs)
+		if err != nil {
+			return err
+		}
+		e.Funcs = append(e.Funcs, idx)
+	}
+	p.m.Elements = append(p.m.Elements, e)
+	return nil
+}
+
+func (p *watParser) dataDef(f *sexpr) error {
+	args := fieldArgs(f)
+	var bytes []byte
+	for len(args) != 0 && args[len(args)-1].isStr {
+		bytes = append(args[len(args)-1].str, bytes...)
+		args = args[:len(args)-1]
+	}
+	if len(args) == 0 {
+		p.m.Data = append(p.m.Data, PassiveData{Bytes: bytes})
+		return nil
+	}
+
+	var mem Index
+	if args[0].head() == "memory" && len(args[0].list) == 2 {
+		var err error
+		mem, err = p.ref(p.memories, args[0].list[1], p.counts.memories)
+		if err != nil {
+			return err
+		}
+		args = args[1:]
+	}
+	if len(args) == 1 && args[0].head() == "offset" {
+		args = args[0].list[1:]
+	}
+	offset, err := p.constExpr(args)
+	if err != nil {
+		return err
+	}
+	instrs, err := offset.Decode()
+	if err != nil || len(instrs) != 2 || instrs[0].Op != OpI32Const {
+		return watErrorf(f, "data offsets must be constant")
+	}
+	p.m.Data = append(p.m.Data, ActiveData{Memory: mem, Offset: uint32(instrs[0].Args[0]), Bytes: bytes})
+	return nil
+}
+
+// watBody assembles the instructions of a function body or a constant expression.
+type watBody struct {
+	p      *watParser
+	c      *Code
+	locals map[string]uint32
+	labels []string
+}
+
+var opcodesByName = func() map[string]Opcode {
+	res := map[string]Opcode{}
+	for op, info := range opcodes {
+		if op == OpSelectT {
+			continue
+		}
+		res[info.name] = op
+	}
+	return res
+}()
+
+func (b *watBody) instrs(xs []*sexpr) error {
+	for len(xs) != 0 {
+		var err error
+		if xs[0].isList {
+			err = b.folded(xs[0])
+			xs = xs[1:]
+		} else {
+			xs, err = b.plain(xs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plain reads an instruction in the flat form, returning the rest of the input.
+func (b *watBody) plain(xs []*sexpr) ([]*sexpr, error) {
+	x := xs[0]
+	switch x.atom {
+	case "block", "loop", "if":
+		rest, err := b.open(x, xs[1:])
+		return rest, err
+
+	case "else", "end":
+		if len(b.labels) == 0 {
+			return nil, watErrorf(x, "%s outside of block", x.atom)
+		}
+		rest := xs[1:]
+		if len(rest) != 0 && rest[0].isID() {
+			if rest[0].atom != b.labels[len(b.labels)-1] {
+				return nil, watErrorf(rest[0], "mismatched label %s", rest[0].atom)
+			}
+			rest = rest[1:]
+		}
+		if x.atom == "else" {
+			b.c.Else()
+		} else {
+			b.labels = b.labels[:len(b.labels)-1]
+			b.c.End()
+		}
+		return rest, nil
+	}
+
+	op, ok := opcodesByName[x.atom]
+	if !ok {
+		return nil, watErrorf(x, "unknown instruction %s", x)
+	}
+	ins, rest, err := b.immediates(x, op, xs[1:])
+	if err != nil {
+		return nil, err
+	}
+	b.c.Emit(ins)
+	return rest, nil
+}
+
+// open starts a block, loop or if, reading its label and type.
+func (b *watBody) open(x *sexpr, xs []*sexpr) ([]*sexpr, error) {
+	label := ""
+	if len(xs) != 0 && xs[0].isID() {
+		label = xs[0].atom
+		xs = xs[1:]
+	}
+	bt, rest, err := b.blockType(xs)
+	if err != nil {
+		return nil, err
+	}
+	b.labels = append(b.labels, label)
+	switch x.atom {
+	case "block":
+		b.c.Block(bt)
+	case "loop":
+		b.c.Loop(bt)
+	default:
+		b.c.If(bt)
+	}
+	return rest, nil
+}
+
+func (b *watBody) blockType(xs []*sexpr) (BlockType, []*sexpr, error) {
+	if len(xs) != 0 && xs[0].head() == "type" {
+		idx, _, rest, err := b.p.typeUse(xs, nil)
+		return BlockType(idx), rest, err
+	}
+	t, rest, err := b.p.signature(xs, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	return b.p.m.BlockType(t.In, t.Out), rest, nil
+}
+
+func (b *watBody) folded(x *sexpr) error {
+	if len(x.list) == 0 || !x.list[0].isAtom() {
+		return watErrorf(x, "expected instruction")
+	}
+	head := x.list[0]
+
+	switch head.atom {
+	case "block", "loop":
+		rest, err := b.open(head, x.list[1:])
+		if err != nil {
+			return err
+		}
+		if err := b.instrs(rest); err != nil {
+			return err
+		}
+		b.labels = b.labels[:len(b.labels)-1]
+		b.c.End()
+		return nil
+
+	case "if":
+		xs := x.list[1:]
+		label := ""
+		if len(xs) != 0 && xs[0].isID() {
+			label = xs[0].atom
+			xs = xs[1:]
+		}
+		bt, xs, err := b.blockType(xs)
+		if err != nil {
+			return err
+		}
+		for len(xs) != 0 && xs[0].head() != "then" {
+			if err := b.folded(xs[0]); err != nil {
+				return err
+			}
+			xs = xs[1:]
+		}
+		if len(xs) == 0 {
+			return watErrorf(x, "if without then")
+		}
+		b.labels = append(b.labels, label)
+		b.c.If(bt)
+		if err := b.instrs(xs[0].list[1:]); err != nil {
+			return err
+		}
+		if len(xs) > 1 {
+			if xs[1].head() != "else" || len(xs) > 2 {
+				return watErrorf(xs[1], "unexpected %s", xs[1])
+			}
+			b.c.Else()
+			if err := b.instrs(xs[1].list[1:]); err != nil {
+				return err
+			}
+		}
+		b.labels = b.labels[:len(b.labels)-1]
+		b.c.End()
+		return nil
+	}
+
+	op, ok := opcodesByName[head.atom]
+	if !ok {
+		return watErrorf(head, "unknown instruction %s", head)
+	}
+	ins, rest, err := b.immediates(head, op, x.list[1:])
+	if err != nil {
+		return err
+	}
+	for _, operand := range rest {
+		if !operand.isList {
+			return watErrorf(operand, "unexpected %s", operand)
+		}
+		if err := b.folded(operand); err != nil {
+			return err
+		}
+	}
+	b.c.Emit(ins)
+	return nil
+}
+
+func (b *watBody) label(x *sexpr) (uint64, error) {
+	if x.isID() {
+		for i := len(b.labels) - 1; i >= 0; i-- {
+			if b.labels[i] == x.atom {
+				return uint64(len(b.labels) - 1 - i), nil
+			}
+		}
+		return 0, watErrorf(x, "unknown label %s", x.atom)
+	}
+	return parseUint(x, 32)
+}
+
+func (b *watBody) local(x *sexpr) (uint64, error) {
+	if x.isID() {
+		idx, ok := b.locals[x.atom]
+		if !ok {
+			return 0, watErrorf(x, "unknown local %s", x.atom)
+		}
+		return uint64(idx), nil
+	}
+	return parseUint(x, 32)
+}
+
+// immediates reads the arguments that follow an instruction's name.
+func (b *watBody) immediates(head *sexpr, op Opcode, xs []*sexpr) (Instruction, []*sexpr, error) {
+	ins := Instruction{Op: op}
+	p := b.p
+
+	// refs reads up to n index arguments from the space
+	refs := func(space map[string]Index, count, n int) error {
+		for n > 0 && len(xs) != 0 && isRef(xs[0]) {
+			idx, err := p.ref(space, xs[0], count)
+			if err != nil {
+				return err
+			}
+			ins.Args = append(ins.Args, uint64(idx))
+			xs = xs[1:]
+			n--
+		}
+		return nil
+	}
+	need := func(n int) error {
+		if len(ins.Args) != n {
+			return watErrorf(head, "%s expects %d arguments", op, n)
+		}
+		return nil
+	}
+
+	var err error
+	switch op {
+	case OpBr, OpBrIf:
+		if len(xs) == 0 {
+			return ins, nil, watErrorf(head, "expected label")
+		}
+		var l uint64
+		l, err = b.label(xs[0])
+		ins.Args = []uint64{l}
+		xs = xs[1:]
+
+	case OpBrTable:
+		for len(xs) != 0 && isRef(xs[0]) {
+			var l uint64
+			if l, err = b.label(xs[0]); err != nil {
+				break
+			}
+			ins.Args = append(ins.Args, l)
+			xs = xs[1:]
+		}
+		if err == nil && len(ins.Args) == 0 {
+			err = watErrorf(head, "expected label")
+		}
+
+	case OpCall, OpReturnCall, OpRefFunc:
+		if err = refs(p.funcs, p.counts.funcs, 1); err == nil {
+			err = need(1)
+		}
+
+	case OpLocalGet, OpLocalSet, OpLocalTee:
+		if len(xs) == 0 {
+			return ins, nil, watErrorf(head, "expected local")
+		}
+		var l uint64
+		l, err = b.local(xs[0])
+		ins.Args = []uint64{l}
+		xs = xs[1:]
+
+	case OpGlobalGet, OpGlobalSet:
+		if err = refs(p.globals, p.counts.globals, 1); err == nil {
+			err = need(1)
+		}
+
+	case OpTableGet, OpTableSet, OpTableSize, OpTableGrow, OpTableFill:
+		err = refs(p.tables, p.counts.tables, 1)
+		if len(ins.Args) == 0 {
+			ins.Args = []uint64{0}
+		}
+
+	case OpTableCopy:
+		err = refs(p.tables, p.counts.tables, 2)
+		if len(ins.Args) == 0 {
+			ins.Args = []uint64{0, 0}
+		}
+		if err == nil {
+			err = need(2)
+		}
+
+	case OpTableInit:
+		if len(xs) > 1 && isRef(xs[0]) && isRef(xs[1]) {
+			var table, elem Index
+			if table, err = p.ref(p.tables, xs[0], p.counts.tables); err == nil {
+				elem, err = p.ref(p.elems, xs[1], p.counts.elems)
+			}
+			ins.Args = []uint64{uint64(elem), uint64(table)}
+			xs = xs[2:]
+		} else if err = refs(p.elems, p.counts.elems, 1); err == nil {
+			err = need(1)
+			ins.Args = append(ins.Args, 0)
+		}
+
+	case OpElemDrop:
+		if err = refs(p.elems, p.counts.elems, 1); err == nil {
+			err = need(1)
+		}
+
+	case OpDataDrop:
+		if err = refs(p.datas, p.counts.datas, 1); err == nil {
+			err = need(1)
+		}
+
+	case OpMemoryInit:
+		if err = refs(p.datas, p.counts.datas, 1); err == nil {
+			err = need(1)
+			ins.Args = append(ins.Args, 0)
+		}
+
+	case OpMemorySize, OpMemoryGrow, OpMemoryFill:
+		ins.Args = []uint64{0}
+
+	case OpMemoryCopy:
+		ins.Args = []uint64{0, 0}
+
+	case OpCallIndirect, OpReturnCallIndirect:
+		var table Index
+		if len(xs) != 0 && isRef(xs[0]) {
+			if table, err = p.ref(p.tables, xs[0], p.counts.tables); err != nil {
+				break
+			}
+			xs = xs[1:]
+		}
+		var typ Index
+		typ, _, xs, err = p.typeUse(xs, nil)
+		ins.Args = []uint64{uint64(typ), uint64(table)}
+
+	case OpSelect:
+		if len(xs) != 0 && xs[0].head() == "result" {
+			ins.Op = OpSelectT
+			for _, a := range xs[0].list[1:] {
+				var t Type
+				if t, err = parseValType(a); err != nil {
+					break
+				}
+				ins.Args = append(ins.Args, uint64(t.(NumberType)))
+			}
+			xs = xs[1:]
+		}
+
+	case OpRefNull:
+		if len(xs) == 0 {
+			return ins, nil, watErrorf(head, "expected reference type")
+		}
+		switch xs[0].atom {
+		case "func":
+			ins.Args = []uint64{uint64(FuncTable)}
+		case "extern":
+			ins.Args = []uint64{uint64(ExternTable)}
+		default:
+			err = watErrorf(xs[0], "unknown reference type %s", xs[0])
+		}
+		xs = xs[1:]
+
+	case OpI32Const, OpI64Const, OpF32Const, OpF64Const:
+		if len(xs) == 0 {
+			return ins, nil, watErrorf(head, "expected number")
+		}
+		var v uint64
+		switch op {
+		case OpI32Const:
+			v, err = parseInt(xs[0], 32)
+		case OpI64Const:
+			v, err = parseInt(xs[0], 64)
+		case OpF32Const:
+			v, err = parseFloat(xs[0], 32)
+		default:
+			v, err = parseFloat(xs[0], 64)
+		}
+		ins.Args = []uint64{v}
+		xs = xs[1:]
+
+	default:
+		acc, ok := memoryAccess(op)
+		if !ok {
+			break
+		}
+		align, offset := uint64(0), uint64(0)
+		for align = 0; 1<<align < acc.size; align++ {
+		}
+		for len(xs) != 0 && xs[0].isAtom() {
+			key, value, ok := strings.Cut(xs[0].atom, "=")
+			if !ok || (key != "offset" && key != "align") {
+				break
+			}
+			n, perr := parseUint(&sexpr{line: xs[0].line, atom: value}, 32)
+			if perr != nil {
+				err = perr
+				break
+			}
+			if key == "offset" {
+				offset = n
+			} else {
+				if n == 0 || n&(n-1) != 0 {
+					err = watErrorf(xs[0], "alignment must be a power of two")
+					break
+				}
+				for align = 0; 1<<align < n; align++ {
+				}
+			}
+			xs = xs[1:]
+		}
+		ins.Args = []uint64{align, offset}
+	}
+
+	if err != nil {
+		return ins, nil, err
+	}
+	return ins, xs, nil
+}
+
+func cleanNumber(x *sexpr) (string, bool, error) {
+	if !x.isAtom() {
+		return "", false, watErrorf(x, "expected number")
+	}
+	s := x.atom
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	if strings.Contains(s, "__") || strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") {
+		return "", false, watErrorf(x, "bad number %s", x.atom)
+	}
+	return strings.ReplaceAll(s, "_", ""), neg, nil
+}
+
+func parseUint(x *sexpr, bits int) (uint64, error) {
+	s, neg, err := cleanNumber(x)
+	if err != nil {
+		return 0, err
+	}
+	n, perr := strconv.ParseUint(s, 0, bits)
+	if perr != nil || neg || strings.HasPrefix(s, "0") && len(s) > 1 && !strings.HasPrefix(s, "0x") {
+		return 0, watErrorf(x, "bad number %s", x.atom)
+	}
+	return n, nil
+}
+
+// parseInt reads an integer that may be written as signed or unsigned, giving its bits sign
+// extended to 64 bits.
+func parseInt(x *sexpr, bits int) (uint64, error) {
+	s, neg, err := cleanNumber(x)
+	if err != nil {
+		return 0, err
+	}
+	n, perr := strconv.ParseUint(s, 0, bits)
+	if perr != nil {
+		return 0, watErrorf(x, "bad number %s", x.atom)
+	}
+	if neg {
+		if n > 1<<(bits-1) {
+			return 0, watErrorf(x, "%s out of range", x.atom)
+		}
+		n = -n
+	}
+	if bits == 32 {
+		return uint64(int64(int32(n))), nil
+	}
+	return n, nil
+}
+
+func parseFloat(x *sexpr, bits int) (uint64, error) {
+	s, neg, err := cleanNumber(x)
+	if err != nil {
+		return 0, err
+	}
+
+	var sign, exp, quiet uint64 = 1 << 63, 0x7ff << 52, 1 << 51
+	if bits == 32 {
+		sign, exp, quiet = 1<<31, 0xff<<23, 1<<22
+	}
+	if !neg {
+		sign = 0
+	}
+
+	switch {
+	case s == "inf":
+		return sign | exp, nil
+	case s == "nan":
+		return sign | exp | quiet, nil
+	case strings.HasPrefix(s, "nan:0x"):
+		payload, perr := strconv.ParseUint(s[6:], 16, bits)
+		if perr != nil || payload == 0 || payload >= quiet<<1 {
+			return 0, watErrorf(x, "bad NaN payload %s", x.atom)
+		}
+		return sign | exp | payload, nil
+	}
+
+	if strings.HasPrefix(s, "0x") && !strings.ContainsAny(s, "pP") {
+		s += "p0"
+	}
+	f, perr := strconv.ParseFloat(s, bits)
+	if perr != nil {
+		return 0, watErrorf(x, "bad number %s", x.atom)
+	}
+	if neg {
+		f = -f
+	}
+	if bits == 32 {
+		return uint64(math.Float32bits(float32(f))), nil
+	}
+	return math.Float64bits(f), nil
+}